@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+)
+
+// Server is the proxy's admin HTTP interface. Features register their own
+// endpoints on it; it listens on localhost only. With a token, requests to
+// every endpoint but /healthz must bear it.
+type Server struct {
+	mux    *http.ServeMux
+	logger *logging.Logger
+	port   int
+	token  string
+}
+
+// New creates an admin server for the given port and token, which may be
+// empty to require none
+func New(port int, token string, logger *logging.Logger) *Server {
+	s := &Server{
+		mux:    http.NewServeMux(),
+		logger: logger,
+		port:   port,
+		token:  token,
+	}
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return s
+}
+
+// HandleFunc registers a handler for a pattern such as "POST /chaos/enable"
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			s.logger.Warnf("Rejected admin request without a valid token: %s %s", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			WriteError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+		s.logger.Infof("Admin request: %s %s", r.Method, r.URL.Path)
+		handler(w, r)
+	})
+}
+
+// authorized reports whether a request bears the admin token, if one is set
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return false
+	}
+	given := []byte(strings.TrimSpace(auth[7:]))
+	return subtle.ConstantTimeCompare(given, []byte(s.token)) == 1
+}
+
+// Handler returns the admin interface as an http.Handler
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start listens on localhost and serves the admin interface in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", s.port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on admin port %d: %w", s.port, err)
+	}
+	s.logger.Infof("Admin interface listening on http://%s", listener.Addr())
+
+	go func() {
+		if err := http.Serve(listener, s.mux); err != nil {
+			s.logger.Errorf("Admin interface stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// WriteJSON writes v as a JSON response with the given status
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// WriteError writes an error response of the form {"error": "..."}
+func WriteError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	WriteJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func TestHealthz(t *testing.T) {
+	s := New(0, "", newTestLogger(t))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestHandleFuncAndErrors(t *testing.T) {
+	s := New(0, "", newTestLogger(t))
+	s.HandleFunc("POST /things/{name}", func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, http.StatusNotFound, "no thing named '%s'", r.PathValue("name"))
+	})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/things/widget", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error":"no thing named 'widget'"}`, rec.Body.String())
+
+	// Method mismatches are rejected by the mux
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/things/widget", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestToken(t *testing.T) {
+	s := New(0, "s3cret", newTestLogger(t))
+	s.HandleFunc("GET /things", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, []string{})
+	})
+
+	for _, auth := range []string{"", "Bearer guess", "s3cret"} {
+		req := httptest.NewRequest(http.MethodGet, "/things", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "%q", auth)
+		assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+		assert.JSONEq(t, `{"error":"missing or invalid admin token"}`, rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/things", nil)
+	req.Header.Set("Authorization", "bearer s3cret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "health checks need no token")
+}
+
+func TestStart(t *testing.T) {
+	s := New(0, "", newTestLogger(t))
+	require.NoError(t, s.Start())
+}
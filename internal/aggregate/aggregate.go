@@ -0,0 +1,637 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// ServerName is the name the aggregate gives in its initialize result
+const ServerName = "mcp_sqlpp_proxy"
+
+// Connect opens the connection to a backend, delivering the messages the
+// backend sends on its own initiative to handler
+type Connect func(handler upstream.MessageHandler) (upstream.Upstream, error)
+
+// backend is one of the upstreams behind an aggregate
+type backend struct {
+	name     string
+	prefix   string
+	upstream upstream.Upstream
+}
+
+// serverRequest is a request a backend sent to the client, under the id the
+// aggregate gave it
+type serverRequest struct {
+	backend *backend
+	id      json.RawMessage
+}
+
+// Aggregate fronts several upstreams as a single one. The tools, prompts and
+// resources of the backends are merged, with tool and prompt names prefixed
+// per backend and resource URIs written <name>+<uri>; calls are routed to the
+// backend a name or URI belongs to and other requests go to every backend.
+// Server-initiated requests get ids of the aggregate's own, so that those of
+// different backends cannot collide.
+type Aggregate struct {
+	handler upstream.MessageHandler
+	logger  *logging.Logger
+
+	mu       sync.Mutex
+	backends []*backend
+	nextID   int64
+	requests map[string]serverRequest // Server-initiated requests by the id the client saw
+	inflight map[string][]*backend    // Backends serving client requests, by request id
+	done     chan struct{}
+}
+
+// New creates an aggregate without backends. Messages the backends send on
+// their own initiative are passed to handler.
+func New(handler upstream.MessageHandler, logger *logging.Logger) *Aggregate {
+	return &Aggregate{
+		handler:  handler,
+		logger:   logger,
+		requests: make(map[string]serverRequest),
+		inflight: make(map[string][]*backend),
+	}
+}
+
+// Start connects to the configured upstreams: executables are spawned and
+// URLs are reached over Streamable HTTP
+func Start(upstreams []config.UpstreamConfig, handler upstream.MessageHandler, logger *logging.Logger) (*Aggregate, error) {
+	a := New(handler, logger)
+	for _, cfg := range upstreams {
+		cfg := cfg
+		connect := func(handler upstream.MessageHandler) (upstream.Upstream, error) {
+			if cfg.URL != "" {
+				return upstream.NewHTTP(cfg.URL, handler), nil
+			}
+			return upstream.StartStdio(upstream.StdioOptions{
+				ExePath: cfg.ExePath,
+				Args:    cfg.Args,
+				Env:     cfg.Env,
+				Handler: handler,
+				Logger:  logger,
+			})
+		}
+		if err := a.Add(cfg.Name, cfg.NamePrefix(), connect); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Add connects to a backend and adds it under a name and name prefix
+func (a *Aggregate) Add(name, prefix string, connect Connect) error {
+	b := &backend{name: name, prefix: prefix}
+	up, err := connect(func(msg *mcp.Message) { a.fromBackend(b, msg) })
+	if err != nil {
+		return fmt.Errorf("failed to connect to upstream '%s': %w", name, err)
+	}
+	b.upstream = up
+	a.mu.Lock()
+	a.backends = append(a.backends, b)
+	a.mu.Unlock()
+	return nil
+}
+
+// Done is closed once every backend process has exited. It is never closed
+// when a backend is reached over HTTP.
+func (a *Aggregate) Done() <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.done != nil {
+		return a.done
+	}
+	var ends []<-chan struct{}
+	for _, b := range a.backends {
+		process, ok := b.upstream.(interface{ Done() <-chan struct{} })
+		if !ok {
+			return nil
+		}
+		ends = append(ends, process.Done())
+	}
+	a.done = make(chan struct{})
+	go func() {
+		for _, end := range ends {
+			<-end
+		}
+		close(a.done)
+	}()
+	return a.done
+}
+
+// Call implements upstream.Upstream
+func (a *Aggregate) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	switch req.Method {
+	case "initialize":
+		return a.initialize(ctx, req)
+	case "tools/list":
+		return a.list(ctx, req, "tools")
+	case "prompts/list":
+		return a.list(ctx, req, "prompts")
+	case "resources/list":
+		return a.list(ctx, req, "resources")
+	case "resources/templates/list":
+		return a.list(ctx, req, "resourceTemplates")
+	case "tools/call":
+		return a.route(ctx, req, "name", "tool")
+	case "prompts/get":
+		return a.route(ctx, req, "name", "prompt")
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+		return a.route(ctx, req, "uri", "resource")
+	case "completion/complete":
+		return a.complete(ctx, req)
+	}
+	return a.broadcast(ctx, req)
+}
+
+// Send implements upstream.Upstream. Responses go to the backend whose request
+// they answer, cancellations to the backends serving the request and other
+// notifications to every backend.
+func (a *Aggregate) Send(ctx context.Context, msg *mcp.Message) error {
+	if msg.IsResponse() {
+		a.mu.Lock()
+		sr, ok := a.requests[msg.IDKey()]
+		delete(a.requests, msg.IDKey())
+		a.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("no upstream request with id %s", msg.IDKey())
+		}
+		out := msg.Clone()
+		out.ID = sr.id
+		return sr.backend.upstream.Send(ctx, out)
+	}
+
+	targets := a.all()
+	if msg.Method == "notifications/cancelled" {
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		a.mu.Lock()
+		targets = append([]*backend(nil), a.inflight[mcp.IDKey(params.RequestID)]...)
+		a.mu.Unlock()
+	}
+	var errs []error
+	for _, b := range targets {
+		if err := b.upstream.Send(ctx, msg); err != nil {
+			errs = append(errs, fmt.Errorf("upstream '%s': %w", b.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close implements upstream.Upstream
+func (a *Aggregate) Close() error {
+	backends := a.all()
+	errs := make([]error, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			if err := b.upstream.Close(); err != nil {
+				errs[i] = fmt.Errorf("upstream '%s': %w", b.name, err)
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (a *Aggregate) all() []*backend {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*backend(nil), a.backends...)
+}
+
+// call sends a request to one backend, remembering which backend serves it
+// so that a cancellation can follow
+func (a *Aggregate) call(ctx context.Context, b *backend, req *mcp.Message) (*mcp.Message, error) {
+	key := req.IDKey()
+	a.mu.Lock()
+	a.inflight[key] = append(a.inflight[key], b)
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		var serving []*backend
+		for _, other := range a.inflight[key] {
+			if other != b {
+				serving = append(serving, other)
+			}
+		}
+		if len(serving) == 0 {
+			delete(a.inflight, key)
+		} else {
+			a.inflight[key] = serving
+		}
+	}()
+
+	resp, err := b.upstream.Call(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream '%s': %w", b.name, err)
+	}
+	return resp, nil
+}
+
+// result is the outcome of a request sent to one backend
+type result struct {
+	backend *backend
+	resp    *mcp.Message
+	err     error
+}
+
+// callAll sends a request to every backend concurrently
+func (a *Aggregate) callAll(ctx context.Context, req *mcp.Message) []result {
+	backends := a.all()
+	results := make([]result, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			resp, err := a.call(ctx, b, req)
+			results[i] = result{backend: b, resp: resp, err: err}
+		}(i, b)
+	}
+	wg.Wait()
+	return results
+}
+
+// broadcast sends a request to every backend. The first backend's response is
+// returned unless any backend failed.
+func (a *Aggregate) broadcast(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	results := a.callAll(ctx, req)
+	if len(results) == 0 {
+		return nil, mcp.NewError(mcp.InternalError, "no upstream available")
+	}
+	for _, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	for _, r := range results {
+		if r.resp.Error != nil {
+			return r.resp, nil
+		}
+	}
+	return results[0].resp, nil
+}
+
+// initialize initializes every backend and merges their capabilities. The
+// protocol version is the first backend's; the server version lists the
+// backends' versions as <name>=<version>.
+func (a *Aggregate) initialize(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	results := a.callAll(ctx, req)
+	if len(results) == 0 {
+		return nil, mcp.NewError(mcp.InternalError, "no upstream available")
+	}
+
+	var version string
+	capabilities := make(map[string]interface{})
+	var instructions, servers []string
+	for _, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.resp.Error != nil {
+			a.logger.Errorf("Upstream '%s' failed to initialize: %s", r.backend.name, r.resp.Error.Message)
+			return r.resp, nil
+		}
+		var init struct {
+			ProtocolVersion string                 `json:"protocolVersion"`
+			Capabilities    map[string]interface{} `json:"capabilities"`
+			Instructions    string                 `json:"instructions"`
+			ServerInfo      struct {
+				Version string `json:"version"`
+			} `json:"serverInfo"`
+		}
+		if err := json.Unmarshal(r.resp.Result, &init); err != nil {
+			return nil, fmt.Errorf("upstream '%s': invalid initialize result: %w", r.backend.name, err)
+		}
+		if version == "" {
+			version = init.ProtocolVersion
+		} else if init.ProtocolVersion != version {
+			a.logger.Infof("Upstream '%s' negotiated protocol version %s rather than %s", r.backend.name, init.ProtocolVersion, version)
+		}
+		mergeCapabilities(capabilities, init.Capabilities)
+		servers = append(servers, r.backend.name+"="+init.ServerInfo.Version)
+		if init.Instructions != "" {
+			instructions = append(instructions, fmt.Sprintf("%s (tools prefixed %s):\n%s", r.backend.name, r.backend.prefix, init.Instructions))
+		}
+	}
+
+	merged := map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities":    capabilities,
+		"serverInfo":      map[string]string{"name": ServerName, "version": strings.Join(servers, " ")},
+	}
+	if len(instructions) > 0 {
+		merged["instructions"] = strings.Join(instructions, "\n\n")
+	}
+	return mcp.NewResult(req.ID, merged)
+}
+
+// mergeCapabilities adds the capabilities in src to dst. Nested objects are
+// merged and a flag is set if any backend sets it.
+func mergeCapabilities(dst, src map[string]interface{}) {
+	for key, value := range src {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			nested, ok := dst[key].(map[string]interface{})
+			if !ok {
+				nested = make(map[string]interface{})
+				dst[key] = nested
+			}
+			mergeCapabilities(nested, v)
+		case bool:
+			set, _ := dst[key].(bool)
+			dst[key] = set || v
+		default:
+			if _, ok := dst[key]; !ok {
+				dst[key] = value
+			}
+		}
+	}
+}
+
+// list merges the items under key of a list method from every backend. All
+// pages are fetched, so the merged list is never paginated. Backends that
+// fail are left out, unless all fail.
+func (a *Aggregate) list(ctx context.Context, req *mcp.Message, key string) (*mcp.Message, error) {
+	backends := a.all()
+	items := make([][]map[string]json.RawMessage, len(backends))
+	errs := make([]error, len(backends))
+	responses := make([]*mcp.Message, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			items[i], responses[i], errs[i] = a.fetchAll(ctx, b, req, key)
+		}(i, b)
+	}
+	wg.Wait()
+
+	merged := []map[string]json.RawMessage{}
+	failed := 0
+	for i, b := range backends {
+		switch {
+		case errs[i] != nil:
+			a.logger.Errorf("Left upstream '%s' out of %s: %v", b.name, req.Method, errs[i])
+			failed++
+		case responses[i] != nil:
+			// An error response, typically a method the backend does not offer
+			a.logger.Debugf("Upstream '%s' answered %s with error: %s", b.name, req.Method, responses[i].Error.Message)
+			failed++
+		default:
+			for _, item := range items[i] {
+				a.present(b, item)
+			}
+			merged = append(merged, items[i]...)
+		}
+	}
+	if failed == len(backends) && failed > 0 {
+		if errs[0] != nil {
+			return nil, errs[0]
+		}
+		return responses[0], nil
+	}
+	return mcp.NewResult(req.ID, map[string]interface{}{key: merged})
+}
+
+// fetchAll returns the items of every page of a list from one backend, or the
+// backend's error response
+func (a *Aggregate) fetchAll(ctx context.Context, b *backend, req *mcp.Message, key string) ([]map[string]json.RawMessage, *mcp.Message, error) {
+	var params map[string]json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, nil, err
+		}
+	}
+	if params == nil {
+		params = make(map[string]json.RawMessage)
+	}
+	delete(params, "cursor")
+
+	var all []map[string]json.RawMessage
+	for {
+		page := req.Clone()
+		var err error
+		if page.Params, err = json.Marshal(params); err != nil {
+			return nil, nil, err
+		}
+		resp, err := a.call(ctx, b, page)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.Error != nil {
+			return nil, resp, nil
+		}
+		var result map[string]json.RawMessage
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s result: %w", req.Method, err)
+		}
+		if raw, ok := result[key]; ok {
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s result: %w", req.Method, err)
+			}
+		}
+		all = append(all, items...)
+		cursor := result["nextCursor"]
+		if len(cursor) == 0 || string(cursor) == "null" {
+			return all, nil, nil
+		}
+		params["cursor"] = cursor
+	}
+}
+
+// present renames a listed tool, prompt, resource or resource template the
+// way clients see it
+func (a *Aggregate) present(b *backend, item map[string]json.RawMessage) {
+	rewrite := func(field string, fn func(string) string) {
+		var value string
+		if err := json.Unmarshal(item[field], &value); err != nil {
+			return
+		}
+		item[field], _ = json.Marshal(fn(value))
+	}
+	rewrite("name", func(name string) string { return b.prefix + name })
+	rewrite("uri", b.clientURI)
+	rewrite("uriTemplate", b.clientURI)
+}
+
+// clientURI marks a URI as the backend's: file:///x becomes <name>+file:///x
+func (b *backend) clientURI(uri string) string {
+	return b.name + "+" + uri
+}
+
+// byName returns the backend with the longest prefix of a name, and the name
+// without it
+func (a *Aggregate) byName(name string) (*backend, string) {
+	var found *backend
+	for _, b := range a.all() {
+		if strings.HasPrefix(name, b.prefix) && (found == nil || len(b.prefix) > len(found.prefix)) {
+			found = b
+		}
+	}
+	if found == nil {
+		return nil, ""
+	}
+	return found, strings.TrimPrefix(name, found.prefix)
+}
+
+// byURI returns the backend a client URI belongs to, and the backend's URI
+func (a *Aggregate) byURI(uri string) (*backend, string) {
+	name, rest, ok := strings.Cut(uri, "+")
+	if !ok {
+		return nil, ""
+	}
+	for _, b := range a.all() {
+		if b.name == name {
+			return b, rest
+		}
+	}
+	return nil, ""
+}
+
+// lookup finds the backend for a name ("name" field) or URI ("uri" field)
+func (a *Aggregate) lookup(field, value string) (*backend, string) {
+	if field == "uri" {
+		return a.byURI(value)
+	}
+	return a.byName(value)
+}
+
+// route sends a request naming a tool, prompt or resource in field to the
+// backend it belongs to, under the backend's name for it
+func (a *Aggregate) route(ctx context.Context, req *mcp.Message, field, kind string) (*mcp.Message, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, mcp.NewError(mcp.InvalidParams, "Invalid params: %v", err)
+	}
+	var value string
+	json.Unmarshal(params[field], &value)
+	b, own := a.lookup(field, value)
+	if b == nil {
+		return nil, mcp.NewError(mcp.InvalidParams, "Unknown %s: %s", kind, value)
+	}
+
+	var err error
+	if params[field], err = json.Marshal(own); err != nil {
+		return nil, err
+	}
+	out := req.Clone()
+	if out.Params, err = json.Marshal(params); err != nil {
+		return nil, err
+	}
+	return a.call(ctx, b, out)
+}
+
+// complete routes a completion request by the prompt or resource it refers to
+func (a *Aggregate) complete(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, mcp.NewError(mcp.InvalidParams, "Invalid params: %v", err)
+	}
+	var ref map[string]json.RawMessage
+	if err := json.Unmarshal(params["ref"], &ref); err != nil {
+		return nil, mcp.NewError(mcp.InvalidParams, "Invalid completion reference")
+	}
+	field := "name"
+	if _, ok := ref["uri"]; ok {
+		field = "uri"
+	}
+	var value string
+	json.Unmarshal(ref[field], &value)
+	b, own := a.lookup(field, value)
+	if b == nil {
+		return nil, mcp.NewError(mcp.InvalidParams, "Unknown completion reference: %s", value)
+	}
+
+	var err error
+	if ref[field], err = json.Marshal(own); err != nil {
+		return nil, err
+	}
+	if params["ref"], err = json.Marshal(ref); err != nil {
+		return nil, err
+	}
+	out := req.Clone()
+	if out.Params, err = json.Marshal(params); err != nil {
+		return nil, err
+	}
+	return a.call(ctx, b, out)
+}
+
+// fromBackend passes a message a backend sent on its own initiative to the
+// handler. Requests get an id of the aggregate's own, and resource URIs are
+// shown the way clients know them.
+func (a *Aggregate) fromBackend(b *backend, msg *mcp.Message) {
+	if a.handler == nil {
+		return
+	}
+	out := msg.Clone()
+	switch {
+	case msg.IsRequest():
+		a.mu.Lock()
+		a.nextID++
+		out.ID = json.RawMessage(strconv.FormatInt(a.nextID, 10))
+		a.requests[out.IDKey()] = serverRequest{backend: b, id: msg.ID}
+		a.mu.Unlock()
+	case msg.Method == "notifications/resources/updated":
+		out.Params = rewriteParam(msg.Params, "uri", func(raw json.RawMessage) json.RawMessage {
+			var uri string
+			if json.Unmarshal(raw, &uri) != nil {
+				return raw
+			}
+			data, _ := json.Marshal(b.clientURI(uri))
+			return data
+		})
+	case msg.Method == "notifications/cancelled":
+		// The backend cancels one of its own requests to the client
+		out.Params = rewriteParam(msg.Params, "requestId", func(raw json.RawMessage) json.RawMessage {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			for key, sr := range a.requests {
+				if sr.backend == b && mcp.IDKey(sr.id) == mcp.IDKey(raw) {
+					delete(a.requests, key)
+					return json.RawMessage(key)
+				}
+			}
+			return raw
+		})
+	}
+	a.handler(out)
+}
+
+// rewriteParam returns params with one field replaced, or params unchanged if
+// they are not an object holding the field
+func rewriteParam(params json.RawMessage, field string, fn func(json.RawMessage) json.RawMessage) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return params
+	}
+	value, ok := fields[field]
+	if !ok {
+		return params
+	}
+	fields[field] = fn(value)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return params
+	}
+	return data
+}
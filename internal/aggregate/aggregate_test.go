@@ -0,0 +1,238 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// fake is a backend answering from a table of results by method. Requests are
+// recorded, and results of "tools/call" echo the params received.
+type fake struct {
+	results map[string]string
+	handler upstream.MessageHandler
+
+	mu       sync.Mutex
+	requests []*mcp.Message
+	sent     []*mcp.Message
+	closed   bool
+}
+
+func (f *fake) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	if req.Method == "tools/call" || req.Method == "resources/read" || req.Method == "completion/complete" {
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: req.Params}, nil
+	}
+	key := req.Method
+	var params struct {
+		Cursor string `json:"cursor"`
+	}
+	json.Unmarshal(req.Params, &params)
+	if params.Cursor != "" {
+		key += "#" + params.Cursor
+	}
+	result, ok := f.results[key]
+	if !ok {
+		return mcp.NewErrorResponse(req.ID, mcp.NewError(mcp.MethodNotFound, "Method not found: %s", req.Method)), nil
+	}
+	return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}, nil
+}
+
+func (f *fake) Send(ctx context.Context, msg *mcp.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fake) last() *mcp.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1]
+}
+
+func (f *fake) connect(handler upstream.MessageHandler) (upstream.Upstream, error) {
+	f.handler = handler
+	return f, nil
+}
+
+// newAggregate fronts the sales and hr fakes, prefixed sales_ and hr_
+func newAggregate(t *testing.T, handler upstream.MessageHandler) (*Aggregate, *fake, *fake) {
+	sales := &fake{results: map[string]string{
+		"initialize":     `{"protocolVersion":"2025-06-18","capabilities":{"tools":{"listChanged":false},"logging":{}},"serverInfo":{"name":"mcp_sqlpp","version":"1.2"},"instructions":"Sales data"}`,
+		"tools/list":     `{"tools":[{"name":"execute","inputSchema":{"type":"object"}}],"nextCursor":"2"}`,
+		"tools/list#2":   `{"tools":[{"name":"list_tables","inputSchema":{"type":"object"}}]}`,
+		"resources/list": `{"resources":[{"uri":"sqlpp://tables/orders","name":"orders"}]}`,
+		"ping":           `{}`,
+	}}
+	hr := &fake{results: map[string]string{
+		"initialize":   `{"protocolVersion":"2025-06-18","capabilities":{"tools":{"listChanged":true},"prompts":{}},"serverInfo":{"name":"mcp_sqlpp","version":"1.3"}}`,
+		"tools/list":   `{"tools":[{"name":"execute","inputSchema":{"type":"object"}}]}`,
+		"prompts/list": `{"prompts":[{"name":"summarize"}]}`,
+		"ping":         `{}`,
+	}}
+	a := New(handler, newTestLogger(t))
+	require.NoError(t, a.Add("sales", "sales_", sales.connect))
+	require.NoError(t, a.Add("hr", "hr_", hr.connect))
+	return a, sales, hr
+}
+
+func request(t *testing.T, id int, method string, params interface{}) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage(fmt.Sprint(id)), method, params)
+	require.NoError(t, err)
+	return req
+}
+
+func TestInitialize(t *testing.T) {
+	a, _, _ := newAggregate(t, nil)
+
+	resp, err := a.Call(context.Background(), request(t, 1, "initialize", map[string]interface{}{"protocolVersion": "2025-06-18"}))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(resp.ID))
+	assert.JSONEq(t, `{
+		"protocolVersion": "2025-06-18",
+		"capabilities": {"tools": {"listChanged": true}, "logging": {}, "prompts": {}},
+		"serverInfo": {"name": "mcp_sqlpp_proxy", "version": "sales=1.2 hr=1.3"},
+		"instructions": "sales (tools prefixed sales_):\nSales data"
+	}`, string(resp.Result))
+}
+
+func TestLists(t *testing.T) {
+	a, sales, _ := newAggregate(t, nil)
+	ctx := context.Background()
+
+	resp, err := a.Call(ctx, request(t, 1, "tools/list", nil))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"tools":[
+		{"name":"sales_execute","inputSchema":{"type":"object"}},
+		{"name":"sales_list_tables","inputSchema":{"type":"object"}},
+		{"name":"hr_execute","inputSchema":{"type":"object"}}
+	]}`, string(resp.Result), "all pages are fetched")
+	assert.JSONEq(t, `{"cursor":"2"}`, string(sales.last().Params))
+
+	resp, err = a.Call(ctx, request(t, 2, "resources/list", nil))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"resources":[{"uri":"sales+sqlpp://tables/orders","name":"sales_orders"}]}`, string(resp.Result),
+		"backends without the method are left out")
+
+	resp, err = a.Call(ctx, request(t, 3, "prompts/list", nil))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"prompts":[{"name":"hr_summarize"}]}`, string(resp.Result))
+
+	// When no backend offers the method, its error is returned
+	resp, err = a.Call(ctx, request(t, 4, "resources/templates/list", nil))
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, mcp.MethodNotFound, resp.Error.Code)
+}
+
+func TestRouting(t *testing.T) {
+	a, sales, hr := newAggregate(t, nil)
+	ctx := context.Background()
+
+	resp, err := a.Call(ctx, request(t, 1, "tools/call", map[string]interface{}{"name": "hr_execute", "arguments": map[string]string{"sql": "SELECT 1"}}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"execute","arguments":{"sql":"SELECT 1"}}`, string(resp.Result))
+	assert.Equal(t, "1", string(resp.ID))
+	assert.Equal(t, "tools/call", hr.last().Method)
+
+	resp, err = a.Call(ctx, request(t, 2, "resources/read", map[string]string{"uri": "sales+sqlpp://tables/orders"}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"uri":"sqlpp://tables/orders"}`, string(resp.Result))
+
+	resp, err = a.Call(ctx, request(t, 3, "completion/complete", map[string]interface{}{"ref": map[string]string{"type": "ref/prompt", "name": "sales_report"}, "argument": map[string]string{"name": "q", "value": "a"}}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ref":{"type":"ref/prompt","name":"report"},"argument":{"name":"q","value":"a"}}`, string(resp.Result))
+	assert.Equal(t, "completion/complete", sales.last().Method)
+
+	for _, req := range []*mcp.Message{
+		request(t, 4, "tools/call", map[string]string{"name": "execute"}),
+		request(t, 5, "resources/read", map[string]string{"uri": "sqlpp://tables/orders"}),
+		request(t, 6, "resources/read", map[string]string{"uri": "billing+sqlpp://tables/orders"}),
+	} {
+		_, err := a.Call(ctx, req)
+		var rpcErr *mcp.Error
+		require.ErrorAs(t, err, &rpcErr, req.String())
+		assert.Equal(t, mcp.InvalidParams, rpcErr.Code)
+	}
+
+	// Other requests go to every backend
+	resp, err = a.Call(ctx, request(t, 7, "ping", nil))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(resp.Result))
+	assert.Equal(t, "ping", sales.last().Method)
+	assert.Equal(t, "ping", hr.last().Method)
+}
+
+func TestServerMessages(t *testing.T) {
+	var received []*mcp.Message
+	a, sales, hr := newAggregate(t, func(msg *mcp.Message) { received = append(received, msg) })
+	ctx := context.Background()
+
+	// Requests from both backends with the same id reach the client with distinct ids
+	sales.handler(request(t, 1, "roots/list", nil))
+	hr.handler(request(t, 1, "sampling/createMessage", nil))
+	note, err := mcp.NewNotification("notifications/resources/updated", map[string]string{"uri": "sqlpp://tables/orders"})
+	require.NoError(t, err)
+	hr.handler(note)
+
+	require.Len(t, received, 3)
+	assert.NotEqual(t, received[0].IDKey(), received[1].IDKey())
+	assert.JSONEq(t, `{"uri":"hr+sqlpp://tables/orders"}`, string(received[2].Params))
+
+	// Responses go back to the backend that asked, under its own id
+	reply := &mcp.Message{JSONRPC: "2.0", ID: received[1].ID, Result: json.RawMessage(`{"role":"assistant"}`)}
+	require.NoError(t, a.Send(ctx, reply))
+	require.Len(t, hr.sent, 1)
+	assert.Equal(t, "1", string(hr.sent[0].ID))
+	assert.Empty(t, sales.sent)
+	assert.Error(t, a.Send(ctx, reply), "each request is answered once")
+
+	// Notifications go to every backend
+	initialized, err := mcp.NewNotification("notifications/initialized", nil)
+	require.NoError(t, err)
+	require.NoError(t, a.Send(ctx, initialized))
+	assert.Len(t, sales.sent, 1)
+	assert.Len(t, hr.sent, 2)
+
+	// Cancellations of requests no backend serves go nowhere
+	cancel, err := mcp.NewNotification("notifications/cancelled", map[string]int{"requestId": 9})
+	require.NoError(t, err)
+	require.NoError(t, a.Send(ctx, cancel))
+	assert.Len(t, sales.sent, 1)
+
+	require.NoError(t, a.Close())
+	assert.True(t, sales.closed)
+	assert.True(t, hr.closed)
+	assert.Nil(t, a.Done(), "fakes are not processes")
+}
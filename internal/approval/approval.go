@@ -0,0 +1,297 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+)
+
+// ErrNotPending is returned when deciding a call that is not held, or no
+// longer: it was decided already, expired or its client gave up
+var ErrNotPending = errors.New("no such pending call")
+
+// Gate holds tools/call requests whose SQL has statements of the configured
+// classes until an approver approves or rejects them. Approved calls go on to
+// the upstream; rejected and expired ones are answered with a PolicyDenied error.
+type Gate struct {
+	classes          map[sqlpolicy.Class]bool
+	extractor        *sqlpolicy.Extractor
+	timeout          time.Duration
+	progressInterval time.Duration
+	logger           *logging.Logger
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]*Pending
+}
+
+// Pending is a held call as shown to approvers
+type Pending struct {
+	ID        string    `json:"id"`
+	Session   string    `json:"session,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Principal string    `json:"principal,omitempty"`
+	Tool      string    `json:"tool"`
+	Classes   []string  `json:"classes"`
+	SQL       []string  `json:"sql"`
+	Received  time.Time `json:"received"`
+	Expires   time.Time `json:"expires"`
+
+	decision chan decision
+}
+
+// decision is an approver's verdict on a held call
+type decision struct {
+	approved bool
+	reason   string
+}
+
+// New creates a gate holding calls with SQL of the configured classes. SQL is
+// found in the arguments named by tools, as for the SQL policy.
+func New(cfg config.ApprovalConfig, tools []config.SQLToolConfig, logger *logging.Logger) *Gate {
+	g := &Gate{
+		classes:          make(map[sqlpolicy.Class]bool),
+		extractor:        sqlpolicy.NewExtractor(tools),
+		timeout:          cfg.Timeout,
+		progressInterval: cfg.ProgressInterval,
+		logger:           logger,
+		pending:          make(map[string]*Pending),
+	}
+	for _, class := range cfg.Classes {
+		g.classes[sqlpolicy.Class(class)] = true
+	}
+	return g
+}
+
+// Middleware returns the gate as proxy middleware
+func (g *Gate) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method != "tools/call" {
+				return next(ctx, s, req)
+			}
+			call, err := mcp.ParseToolCall(req)
+			if err != nil {
+				return next(ctx, s, req)
+			}
+			sql, classes := g.held(call)
+			if len(classes) == 0 {
+				return next(ctx, s, req)
+			}
+
+			p := g.hold(s, call.Name, sql, classes)
+			defer g.withdraw(p.ID)
+			if err := g.wait(ctx, p, progressToken(req)); err != nil {
+				return nil, err
+			}
+			return next(ctx, s, req)
+		}
+	}
+}
+
+// held returns the SQL of a call and the classes of its statements that need
+// approval, if any
+func (g *Gate) held(call *mcp.ToolCall) ([]string, []string) {
+	var sql, classes []string
+	seen := make(map[sqlpolicy.Class]bool)
+	for _, text := range g.extractor.Find(call) {
+		sql = append(sql, text.SQL)
+		for _, stmt := range sqlpolicy.Parse(text.SQL) {
+			if g.classes[stmt.Class] && !seen[stmt.Class] {
+				seen[stmt.Class] = true
+				classes = append(classes, string(stmt.Class))
+			}
+		}
+	}
+	return sql, classes
+}
+
+// hold adds a call to the pending list
+func (g *Gate) hold(s *proxy.Session, tool string, sql, classes []string) *Pending {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nextID++
+	now := time.Now()
+	p := &Pending{
+		ID:        fmt.Sprintf("%d", g.nextID),
+		Session:   s.ID,
+		Client:    s.Client(),
+		Principal: s.Principal(),
+		Tool:      tool,
+		Classes:   classes,
+		SQL:       sql,
+		Received:  now,
+		Expires:   now.Add(g.timeout),
+		decision:  make(chan decision, 1),
+	}
+	g.pending[p.ID] = p
+	g.logger.Infof("Holding %s call %s from client '%s' for approval (%v)", tool, p.ID, p.Client, classes)
+	return p
+}
+
+// withdraw removes a call from the pending list, reporting whether it was
+// still there, undecided
+func (g *Gate) withdraw(id string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.pending[id]
+	delete(g.pending, id)
+	return ok
+}
+
+// wait blocks until a held call is approved, rejected or expires, reporting
+// progress meanwhile to clients that asked for it. It returns nil once the
+// call is approved.
+func (g *Gate) wait(ctx context.Context, p *Pending, token json.RawMessage) error {
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+	var ticks <-chan time.Time
+	if token != nil && g.progressInterval > 0 {
+		ticker := time.NewTicker(g.progressInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	progress := 0
+	for {
+		select {
+		case d := <-p.decision:
+			if d.approved {
+				proxy.Note(ctx, "approval", "approved")
+				return nil
+			}
+			proxy.Note(ctx, "approval", "rejected")
+			return denied(p, "Rejected by an approver", d.reason)
+		case <-timer.C:
+			if !g.withdraw(p.ID) {
+				continue // Decided just now; the decision is waiting
+			}
+			g.logger.Auditf("Approval: call %s (%s) expired after %s", p.ID, p.Tool, g.timeout)
+			proxy.Note(ctx, "approval", "expired")
+			return denied(p, fmt.Sprintf("Not approved within %s", g.timeout), "")
+		case <-ticks:
+			progress++
+			notification, err := mcp.NewNotification("notifications/progress", map[string]interface{}{
+				"progressToken": token,
+				"progress":      progress,
+				"message":       fmt.Sprintf("Waiting for approval of call %s", p.ID),
+			})
+			if err == nil {
+				if err := proxy.Notify(ctx, notification); err != nil {
+					g.logger.Debugf("Dropped progress of held call %s: %v", p.ID, err)
+				}
+			}
+		case <-ctx.Done():
+			g.logger.Infof("Client gave up on held call %s", p.ID)
+			return ctx.Err()
+		}
+	}
+}
+
+// Pending returns the held calls, oldest first
+func (g *Gate) Pending() []*Pending {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	pending := make([]*Pending, 0, len(g.pending))
+	for _, p := range g.pending {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Received.Before(pending[j].Received) })
+	return pending
+}
+
+// Decide approves or rejects a held call
+func (g *Gate) Decide(id string, approved bool, reason string) error {
+	g.mu.Lock()
+	p, ok := g.pending[id]
+	delete(g.pending, id)
+	g.mu.Unlock()
+	if !ok {
+		return ErrNotPending
+	}
+
+	verdict := "rejected"
+	if approved {
+		verdict = "approved"
+	}
+	g.logger.Auditf("Approval: call %s (%s from client '%s') %s%s", id, p.Tool, p.Client, verdict, describeReason(reason))
+	p.decision <- decision{approved: approved, reason: reason}
+	return nil
+}
+
+// Register adds the approval endpoints to the admin interface
+func (g *Gate) Register(srv *admin.Server) {
+	srv.HandleFunc("GET /approvals", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, g.Pending())
+	})
+	srv.HandleFunc("POST /approvals/{id}/approve", func(w http.ResponseWriter, r *http.Request) {
+		g.decide(w, r, true)
+	})
+	srv.HandleFunc("POST /approvals/{id}/reject", func(w http.ResponseWriter, r *http.Request) {
+		g.decide(w, r, false)
+	})
+}
+
+// decide serves an approver's verdict. Rejections may give a reason as
+// {"reason": "..."}.
+func (g *Gate) decide(w http.ResponseWriter, r *http.Request, approved bool) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, "invalid body: %v", err)
+			return
+		}
+	}
+	id := r.PathValue("id")
+	if err := g.Decide(id, approved, body.Reason); err != nil {
+		admin.WriteError(w, http.StatusNotFound, "%v: %s", err, id)
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, map[string]interface{}{"id": id, "approved": approved})
+}
+
+// denied builds the error a client receives for a call that was not approved
+func denied(p *Pending, message, reason string) *mcp.Error {
+	err := mcp.NewError(mcp.PolicyDenied, "%s: %s call held for approval as %v%s", message, p.Tool, p.Classes, describeReason(reason))
+	data := map[string]interface{}{"approval": p.ID}
+	if reason != "" {
+		data["reason"] = reason
+	}
+	err.Data, _ = json.Marshal(data)
+	return err
+}
+
+func describeReason(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return " (" + reason + ")"
+}
+
+// progressToken returns the progress token a request asked for, if any
+func progressToken(req *mcp.Message) json.RawMessage {
+	var params struct {
+		Meta struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	json.Unmarshal(req.Params, &params)
+	if string(params.Meta.ProgressToken) == "null" {
+		return nil
+	}
+	return params.Meta.ProgressToken
+}
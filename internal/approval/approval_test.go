@@ -0,0 +1,221 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func newGate(t *testing.T, timeout time.Duration) (*Gate, proxy.Handler) {
+	g := New(config.ApprovalConfig{Classes: []string{"dml", "ddl"}, Timeout: timeout, ProgressInterval: 5 * time.Millisecond}, nil, newTestLogger(t))
+	handler := g.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return mcp.NewResult(req.ID, map[string]bool{"executed": true})
+	})
+	return g, handler
+}
+
+func toolCall(t *testing.T, sql string, meta map[string]interface{}) *mcp.Message {
+	params := map[string]interface{}{"name": "execute_sql", "arguments": map[string]string{"sql": sql}}
+	if meta != nil {
+		params["_meta"] = meta
+	}
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", params)
+	require.NoError(t, err)
+	return req
+}
+
+// outcome is what a held call returned
+type outcome struct {
+	resp *mcp.Message
+	err  error
+}
+
+// start runs a call in the background and waits until it is held
+func start(t *testing.T, g *Gate, handler proxy.Handler, ctx context.Context, req *mcp.Message) (*Pending, <-chan outcome) {
+	done := make(chan outcome, 1)
+	held := len(g.Pending())
+	go func() {
+		s := proxy.NewSession("s1", nil)
+		s.SetClient(proxy.ClientInfo{Name: "claude"})
+		resp, err := handler(ctx, s, req)
+		done <- outcome{resp, err}
+	}()
+	require.Eventually(t, func() bool { return len(g.Pending()) > held }, 5*time.Second, time.Millisecond)
+	pending := g.Pending()
+	return pending[len(pending)-1], done
+}
+
+func TestNotHeld(t *testing.T) {
+	g, handler := newGate(t, time.Minute)
+	for _, sql := range []string{"SELECT * FROM orders", "BEGIN", ""} {
+		resp, err := handler(context.Background(), proxy.NewSession("s1", nil), toolCall(t, sql, nil))
+		require.NoError(t, err, sql)
+		assert.JSONEq(t, `{"executed":true}`, string(resp.Result))
+	}
+	assert.Empty(t, g.Pending())
+}
+
+func TestApprove(t *testing.T) {
+	g, handler := newGate(t, time.Minute)
+	p, done := start(t, g, handler, context.Background(), toolCall(t, "DELETE FROM orders; DROP TABLE orders; DELETE FROM t", nil))
+
+	assert.Equal(t, "1", p.ID)
+	assert.Equal(t, "s1", p.Session)
+	assert.Equal(t, "claude", p.Client)
+	assert.Equal(t, "execute_sql", p.Tool)
+	assert.Equal(t, []string{"dml", "ddl"}, p.Classes)
+	assert.Equal(t, []string{"DELETE FROM orders; DROP TABLE orders; DELETE FROM t"}, p.SQL)
+	assert.WithinDuration(t, p.Received.Add(time.Minute), p.Expires, 0)
+
+	require.NoError(t, g.Decide("1", true, ""))
+	result := <-done
+	require.NoError(t, result.err)
+	assert.JSONEq(t, `{"executed":true}`, string(result.resp.Result))
+	assert.Empty(t, g.Pending())
+	assert.ErrorIs(t, g.Decide("1", false, ""), ErrNotPending, "calls are decided once")
+}
+
+func TestReject(t *testing.T) {
+	g, handler := newGate(t, time.Minute)
+	_, done := start(t, g, handler, context.Background(), toolCall(t, "UPDATE orders SET paid = true", nil))
+
+	require.NoError(t, g.Decide("1", false, "not during business hours"))
+	result := <-done
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, result.err, &rpcErr)
+	assert.Equal(t, mcp.PolicyDenied, rpcErr.Code)
+	assert.Equal(t, "Rejected by an approver: execute_sql call held for approval as [dml] (not during business hours)", rpcErr.Message)
+	assert.JSONEq(t, `{"approval":"1","reason":"not during business hours"}`, string(rpcErr.Data))
+}
+
+func TestExpire(t *testing.T) {
+	g, handler := newGate(t, 20*time.Millisecond)
+	_, done := start(t, g, handler, context.Background(), toolCall(t, "TRUNCATE orders", nil))
+
+	result := <-done
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, result.err, &rpcErr)
+	assert.Contains(t, rpcErr.Message, "Not approved within 20ms")
+	assert.Empty(t, g.Pending())
+	assert.ErrorIs(t, g.Decide("1", true, ""), ErrNotPending)
+}
+
+func TestClientGivesUp(t *testing.T) {
+	g, handler := newGate(t, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, done := start(t, g, handler, ctx, toolCall(t, "DELETE FROM orders", nil))
+
+	cancel()
+	assert.ErrorIs(t, (<-done).err, context.Canceled)
+	assert.Empty(t, g.Pending())
+}
+
+func TestProgress(t *testing.T) {
+	g, handler := newGate(t, time.Minute)
+	var mu sync.Mutex
+	var notified []*mcp.Message
+	ctx := proxy.WithSender(context.Background(), func(msg *mcp.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, msg)
+		return nil
+	})
+	p, done := start(t, g, handler, ctx, toolCall(t, "INSERT INTO t VALUES (1)", map[string]interface{}{"progressToken": "tok"}))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(notified) >= 2
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, g.Decide(p.ID, true, ""))
+	require.NoError(t, (<-done).err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "notifications/progress", notified[0].Method)
+	assert.JSONEq(t, `{"progressToken":"tok","progress":1,"message":"Waiting for approval of call 1"}`, string(notified[0].Params))
+	assert.JSONEq(t, `{"progressToken":"tok","progress":2,"message":"Waiting for approval of call 1"}`, string(notified[1].Params))
+}
+
+func TestAdminAndCLI(t *testing.T) {
+	g, handler := newGate(t, time.Minute)
+	srv := admin.New(0, "s3cret", newTestLogger(t))
+	g.Register(srv)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, RunCLI(port, "s3cret", nil, &out))
+	assert.Equal(t, "No calls are waiting for approval\n", out.String())
+
+	_, first := start(t, g, handler, context.Background(), toolCall(t, "DELETE FROM orders", nil))
+	_, second := start(t, g, handler, context.Background(), toolCall(t, "DROP TABLE orders", nil))
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/approvals", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var listed []*Pending
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	resp.Body.Close()
+	require.Len(t, listed, 2)
+	assert.Equal(t, "1", listed[0].ID)
+
+	out.Reset()
+	require.NoError(t, RunCLI(port, "s3cret", []string{"list"}, &out))
+	assert.Contains(t, out.String(), "ID  WAITING")
+	assert.Contains(t, out.String(), "DELETE FROM orders")
+	assert.Contains(t, out.String(), "DROP TABLE orders")
+
+	out.Reset()
+	require.NoError(t, RunCLI(port, "s3cret", []string{"approve", "1"}, &out))
+	assert.Equal(t, "Call 1 approved\n", out.String())
+	require.NoError(t, (<-first).err)
+
+	out.Reset()
+	require.NoError(t, RunCLI(port, "s3cret", []string{"reject", "2", "too", "risky"}, &out))
+	assert.Equal(t, "Call 2 rejected\n", out.String())
+	assert.Contains(t, (<-second).err.Error(), "(too risky)")
+
+	assert.EqualError(t, RunCLI(port, "s3cret", []string{"approve", "7"}, &out), "no such pending call: 7")
+	assert.ErrorContains(t, RunCLI(port, "s3cret", []string{"approve"}, &out), "usage:")
+
+	_, third := start(t, g, handler, context.Background(), toolCall(t, "DELETE FROM orders", nil))
+	assert.EqualError(t, RunCLI(port, "", []string{"approve", "3"}, &out), "missing or invalid admin token")
+	assert.EqualError(t, RunCLI(port, "guess", []string{"approve", "3"}, &out), "missing or invalid admin token")
+	assert.Len(t, g.Pending(), 1, "calls cannot be approved without the token")
+	require.NoError(t, RunCLI(port, "s3cret", []string{"reject", "3"}, &out))
+	assert.Error(t, (<-third).err)
+}
@@ -0,0 +1,112 @@
+package approval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CLIUsage describes the approvals command
+const CLIUsage = `usage: mcp_sqlpp_proxy [--admin-port N] approvals [list]
+       mcp_sqlpp_proxy [--admin-port N] approvals approve <id>
+       mcp_sqlpp_proxy [--admin-port N] approvals reject <id> [reason...]`
+
+// RunCLI lists, approves or rejects held calls through the admin interface of
+// a running proxy on the given port, authenticating with the admin token.
+// args follow the approvals command.
+func RunCLI(port int, token string, args []string, out io.Writer) error {
+	base := fmt.Sprintf("http://localhost:%d/approvals", port)
+	client := &http.Client{Timeout: 10 * time.Second}
+	do := func(method, url string, body io.Reader) (*http.Response, error) {
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return client.Do(req)
+	}
+
+	if len(args) == 0 || (args[0] == "list" && len(args) == 1) {
+		resp, err := do(http.MethodGet, base, nil)
+		if err != nil {
+			return err
+		}
+		var pending []*Pending
+		if err := readResponse(resp, &pending); err != nil {
+			return err
+		}
+		printPending(out, pending)
+		return nil
+	}
+
+	if len(args) < 2 || (args[0] != "approve" && args[0] != "reject") || (args[0] == "approve" && len(args) > 2) {
+		return fmt.Errorf("%s", CLIUsage)
+	}
+	body, err := json.Marshal(map[string]string{"reason": strings.Join(args[2:], " ")})
+	if err != nil {
+		return err
+	}
+	resp, err := do(http.MethodPost, fmt.Sprintf("%s/%s/%s", base, args[1], args[0]), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if err := readResponse(resp, nil); err != nil {
+		return err
+	}
+	verdict := "approved"
+	if args[0] == "reject" {
+		verdict = "rejected"
+	}
+	fmt.Fprintf(out, "Call %s %s\n", args[1], verdict)
+	return nil
+}
+
+// readResponse decodes an admin response into v, turning error responses
+// into errors
+func readResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error != "" {
+			return fmt.Errorf("%s", failure.Error)
+		}
+		return fmt.Errorf("admin interface answered %s", resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// printPending writes held calls as a table, with their SQL on one line
+func printPending(out io.Writer, pending []*Pending) {
+	if len(pending) == 0 {
+		fmt.Fprintln(out, "No calls are waiting for approval")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tWAITING\tEXPIRES IN\tCLIENT\tTOOL\tCLASSES\tSQL")
+	now := time.Now()
+	for _, p := range pending {
+		sql := strings.Join(strings.Fields(strings.Join(p.SQL, "; ")), " ")
+		if len(sql) > 80 {
+			sql = sql[:77] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.ID,
+			now.Sub(p.Received).Round(time.Second), p.Expires.Sub(now).Round(time.Second),
+			p.Client, p.Tool, strings.Join(p.Classes, ","), sql)
+	}
+	w.Flush()
+}
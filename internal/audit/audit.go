@@ -0,0 +1,221 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// Outcomes of an audited call
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+)
+
+// Record describes one SQL execution: the SQL in one argument of a tools/call
+// request and the outcome of the call
+type Record struct {
+	Timestamp  time.Time    `json:"timestamp"`
+	Principal  string       `json:"principal,omitempty"`
+	Session    string       `json:"session,omitempty"`
+	Client     Client       `json:"client"`
+	Tool       string       `json:"tool"`
+	Database   string       `json:"database,omitempty"`
+	Argument   string       `json:"argument"`
+	SQL        string       `json:"sql"`
+	Classes    []string     `json:"classes"` // Class of each statement, e.g. ["select", "dml"]
+	Tables     []string     `json:"tables"`
+	DurationMS float64      `json:"duration_ms"`
+	Rows       *int64       `json:"rows,omitempty"`
+	Status     string       `json:"status"`
+	Error      *RecordError `json:"error,omitempty"`
+
+	// Notes recorded by other middleware, e.g. "limits" hit by the result
+	Notes map[string]interface{} `json:"notes,omitempty"`
+}
+
+// Client is the clientInfo the client initialized with
+type Client struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// RecordError describes why a call failed
+type RecordError struct {
+	Code    int             `json:"code,omitempty"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Log appends audit records to a file as JSON lines
+type Log struct {
+	file      *os.File
+	extractor *sqlpolicy.Extractor
+	databases []string
+	logger    *logging.Logger
+	now       func() time.Time
+
+	mu sync.Mutex
+}
+
+// Open opens the audit log file for appending, creating it if needed. SQL is
+// looked for in the tool arguments configured for the SQL policy.
+func Open(cfg config.AuditConfig, tools []config.SQLToolConfig, logger *logging.Logger) (*Log, error) {
+	file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{
+		file:      file,
+		extractor: sqlpolicy.NewExtractor(tools),
+		databases: cfg.DatabaseArguments,
+		logger:    logger,
+		now:       time.Now,
+	}, nil
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Write appends a record to the log
+func (l *Log) Write(r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// Middleware returns proxy middleware that writes a record for every SQL
+// argument of every tools/call request, including calls rejected by later
+// middleware
+func (l *Log) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method != "tools/call" {
+				return next(ctx, s, req)
+			}
+			call, err := mcp.ParseToolCall(req)
+			if err != nil {
+				return next(ctx, s, req)
+			}
+			texts := l.extractor.Find(call)
+			if len(texts) == 0 {
+				return next(ctx, s, req)
+			}
+
+			ctx, notes := proxy.WithNotes(ctx)
+			start := l.now()
+			resp, err := next(ctx, s, req)
+			duration := l.now().Sub(start)
+
+			records := l.records(s, call, texts)
+			for _, r := range records {
+				r.Timestamp = start.UTC()
+				r.DurationMS = float64(duration.Microseconds()) / 1000
+				r.Notes = notes.All()
+				outcome(r, resp, err)
+				if werr := l.Write(r); werr != nil {
+					l.logger.Errorf("Failed to write audit record: %v", werr)
+				}
+			}
+			return resp, err
+		}
+	}
+}
+
+// records builds the records of a call, one per SQL argument, without outcome
+func (l *Log) records(s *proxy.Session, call *mcp.ToolCall, texts []sqlpolicy.SQLText) []*Record {
+	info := s.ClientInfo()
+	database := l.database(call)
+	records := make([]*Record, 0, len(texts))
+	for _, text := range texts {
+		r := &Record{
+			Principal: s.Principal(),
+			Session:   s.ID,
+			Client:    Client{Name: info.Name, Version: info.Version},
+			Tool:      call.Name,
+			Database:  database,
+			Argument:  text.Path,
+			SQL:       text.SQL,
+			Classes:   []string{},
+			Tables:    []string{},
+		}
+		seen := make(map[string]bool)
+		for _, stmt := range sqlpolicy.Parse(text.SQL) {
+			r.Classes = append(r.Classes, string(stmt.Class))
+			for _, table := range stmt.Tables() {
+				if !seen[table] {
+					seen[table] = true
+					r.Tables = append(r.Tables, table)
+				}
+			}
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// database returns the first configured database argument given as a string
+func (l *Log) database(call *mcp.ToolCall) string {
+	var args map[string]interface{}
+	if json.Unmarshal(call.Arguments, &args) != nil {
+		return ""
+	}
+	for _, name := range l.databases {
+		if v, ok := args[name].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// outcome fills in the status, error and row count of a record from the result of a call
+func outcome(r *Record, resp *mcp.Message, err error) {
+	var rpcErr *mcp.Error
+	var status *upstream.StatusError
+	switch {
+	case errors.As(err, &rpcErr):
+		r.Status = StatusError
+		r.Error = &RecordError{Code: rpcErr.Code, Message: rpcErr.Message, Data: rpcErr.Data}
+	case errors.As(err, &status):
+		r.Status = StatusError
+		r.Error = &RecordError{Message: fmt.Sprintf("upstream returned HTTP status %d", status.StatusCode)}
+	case err != nil:
+		r.Status = StatusError
+		r.Error = &RecordError{Message: err.Error()}
+	case resp == nil:
+		r.Status = StatusError
+		r.Error = &RecordError{Message: "no response"}
+	case resp.Error != nil:
+		r.Status = StatusError
+		r.Error = &RecordError{Code: resp.Error.Code, Message: resp.Error.Message, Data: resp.Error.Data}
+	default:
+		result := parseResult(resp.Result)
+		if result.IsError {
+			r.Status = StatusError
+			r.Error = &RecordError{Message: result.text()}
+		} else {
+			r.Status = StatusSuccess
+		}
+		if rows, ok := result.rowCount(); ok {
+			r.Rows = &rows
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// newTestLog opens an audit log in a temporary directory with a clock that
+// advances 5ms per reading
+func newTestLog(t *testing.T, tools []config.SQLToolConfig) (*Log, string) {
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(config.AuditConfig{File: file, DatabaseArguments: []string{"database", "connection"}}, tools, newTestLogger(t))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	now := time.Date(2025, 6, 18, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time {
+		now = now.Add(5 * time.Millisecond)
+		return now
+	}
+	return l, file
+}
+
+func readRecords(t *testing.T, file string) []Record {
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	return records
+}
+
+func newSession() *proxy.Session {
+	s := proxy.NewSession("abc", nil)
+	s.SetClient(proxy.ClientInfo{Name: "report-bot", Version: "1.2"})
+	s.SetPrincipal("alice")
+	return s
+}
+
+func callRequest(t *testing.T, args string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call",
+		map[string]interface{}{"name": "execute_sql", "arguments": json.RawMessage(args)})
+	require.NoError(t, err)
+	return req
+}
+
+func respond(result string) proxy.Handler {
+	return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}, nil
+	}
+}
+
+func fail(err error) proxy.Handler {
+	return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return nil, err
+	}
+}
+
+func TestRecordSuccess(t *testing.T) {
+	l, file := newTestLog(t, nil)
+	handler := l.Middleware()(respond(`{"content":[{"type":"text","text":"id | name\n1 | a\n(2 rows)"}]}`))
+
+	_, err := handler(context.Background(), newSession(),
+		callRequest(t, `{"database":"sales","sql":"SELECT * FROM orders o JOIN customers c ON o.cid = c.id; SELECT * FROM orders"}`))
+	require.NoError(t, err)
+
+	records := readRecords(t, file)
+	require.Len(t, records, 1)
+	rows := int64(2)
+	assert.Equal(t, Record{
+		Timestamp:  time.Date(2025, 6, 18, 12, 0, 0, 5000000, time.UTC),
+		Principal:  "alice",
+		Session:    "abc",
+		Client:     Client{Name: "report-bot", Version: "1.2"},
+		Tool:       "execute_sql",
+		Database:   "sales",
+		Argument:   "sql",
+		SQL:        "SELECT * FROM orders o JOIN customers c ON o.cid = c.id; SELECT * FROM orders",
+		Classes:    []string{"select", "select"},
+		Tables:     []string{"orders", "customers"},
+		DurationMS: 5,
+		Rows:       &rows,
+		Status:     StatusSuccess,
+	}, records[0])
+}
+
+func TestRecordPerArgument(t *testing.T) {
+	l, file := newTestLog(t, []config.SQLToolConfig{{Name: "*", Arguments: []string{"statements"}}})
+	handler := l.Middleware()(respond(`{"content":[]}`))
+
+	_, err := handler(context.Background(), newSession(),
+		callRequest(t, `{"connection":"pg1","statements":["INSERT INTO t VALUES (1)","DROP TABLE u"]}`))
+	require.NoError(t, err)
+
+	records := readRecords(t, file)
+	require.Len(t, records, 2)
+	assert.Equal(t, "statements.0", records[0].Argument)
+	assert.Equal(t, []string{"dml"}, records[0].Classes)
+	assert.Equal(t, []string{"t"}, records[0].Tables)
+	assert.Equal(t, "statements.1", records[1].Argument)
+	assert.Equal(t, []string{"ddl"}, records[1].Classes)
+	assert.Equal(t, "pg1", records[1].Database)
+	assert.Nil(t, records[1].Rows)
+}
+
+func TestRecordErrors(t *testing.T) {
+	l, file := newTestLog(t, nil)
+	s := newSession()
+
+	denied := mcp.NewError(mcp.PolicyDenied, "Read-only policy")
+	denied.Data = json.RawMessage(`{"policy":"read-only"}`)
+	_, err := l.Middleware()(fail(denied))(context.Background(), s, callRequest(t, `{"sql":"DELETE FROM t"}`))
+	assert.Equal(t, denied, err)
+
+	l.Middleware()(respond(`{"content":[{"type":"text","text":"relation \"x\" does not exist"}],"isError":true}`))(
+		context.Background(), s, callRequest(t, `{"sql":"SELECT * FROM x"}`))
+	l.Middleware()(fail(&upstream.StatusError{StatusCode: 502}))(context.Background(), s, callRequest(t, `{"sql":"SELECT 1"}`))
+	l.Middleware()(fail(errors.New("upstream closed")))(context.Background(), s, callRequest(t, `{"sql":"SELECT 1"}`))
+
+	records := readRecords(t, file)
+	require.Len(t, records, 4)
+	for _, r := range records {
+		assert.Equal(t, StatusError, r.Status)
+	}
+	assert.Equal(t, &RecordError{Code: mcp.PolicyDenied, Message: "Read-only policy", Data: json.RawMessage(`{"policy":"read-only"}`)}, records[0].Error)
+	assert.Equal(t, &RecordError{Message: `relation "x" does not exist`}, records[1].Error)
+	assert.Equal(t, &RecordError{Message: "upstream returned HTTP status 502"}, records[2].Error)
+	assert.Equal(t, &RecordError{Message: "upstream closed"}, records[3].Error)
+}
+
+func TestRecordNotes(t *testing.T) {
+	l, file := newTestLog(t, nil)
+	handler := l.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		proxy.Note(ctx, "limits", []map[string]interface{}{{"limit": "max-rows", "max": 100, "actual": 1500}})
+		return respond(`{"content":[]}`)(ctx, s, req)
+	})
+
+	_, err := handler(context.Background(), newSession(), callRequest(t, `{"sql":"SELECT * FROM t"}`))
+	require.NoError(t, err)
+
+	records := readRecords(t, file)
+	require.Len(t, records, 1)
+	assert.Equal(t, map[string]interface{}{
+		"limits": []interface{}{map[string]interface{}{"limit": "max-rows", "max": float64(100), "actual": float64(1500)}},
+	}, records[0].Notes)
+}
+
+func TestNoRecordWithoutSQL(t *testing.T) {
+	l, file := newTestLog(t, nil)
+	s := newSession()
+
+	l.Middleware()(respond(`{"content":[]}`))(context.Background(), s, callRequest(t, `{"table":"t"}`))
+	req, err := mcp.NewRequest(json.RawMessage("2"), "tools/list", nil)
+	require.NoError(t, err)
+	l.Middleware()(respond(`{"tools":[]}`))(context.Background(), s, req)
+
+	assert.Empty(t, readRecords(t, file))
+}
+
+func TestAppends(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(file, []byte(`{"sql":"earlier"}`+"\n"), 0600))
+
+	l, err := Open(config.AuditConfig{File: file}, nil, newTestLogger(t))
+	require.NoError(t, err)
+	require.NoError(t, l.Write(&Record{SQL: "later"}))
+	require.NoError(t, l.Close())
+
+	records := readRecords(t, file)
+	require.Len(t, records, 2)
+	assert.Equal(t, "earlier", records[0].SQL)
+	assert.Equal(t, "later", records[1].SQL)
+
+	_, err = Open(config.AuditConfig{File: filepath.Join(t.TempDir(), "missing", "audit.jsonl")}, nil, newTestLogger(t))
+	assert.ErrorContains(t, err, "failed to open audit log")
+}
+
+func TestRowCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		rows   int64
+		ok     bool
+	}{
+		{"structured count", `{"structuredContent":{"rowCount":7}}`, 7, true},
+		{"structured rows", `{"structuredContent":{"rows":[{},{},{}]}}`, 3, true},
+		{"structured array", `{"structuredContent":[1,2]}`, 2, true},
+		{"json text", `{"content":[{"type":"text","text":"{\"rows_affected\":4}"}]}`, 4, true},
+		{"json array text", `{"content":[{"type":"text","text":"[{\"id\":1}]"}]}`, 1, true},
+		{"psql footer", `{"content":[{"type":"text","text":" id \n----\n  1\n(1 row)"}]}`, 1, true},
+		{"affected", `{"content":[{"type":"text","text":"Rows affected: 12"}]}`, 12, true},
+		{"no count", `{"content":[{"type":"text","text":"OK"}]}`, 0, false},
+		{"not a result", `"x"`, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, ok := parseResult(json.RawMessage(tt.result)).rowCount()
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.rows, rows)
+		})
+	}
+}
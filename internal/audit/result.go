@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// toolResult holds the parts of a tools/call result the audit log looks at
+type toolResult struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent"`
+	IsError           bool            `json:"isError"`
+}
+
+func parseResult(data json.RawMessage) *toolResult {
+	var result toolResult
+	json.Unmarshal(data, &result)
+	return &result
+}
+
+// text joins the text content blocks of a result
+func (r *toolResult) text() string {
+	var parts []string
+	for _, c := range r.Content {
+		if c.Type == "text" {
+			parts = append(parts, c.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// countKeys are the fields of a JSON result that hold a row count
+var countKeys = []string{
+	"rowCount", "row_count", "rowsAffected", "rows_affected", "affectedRows", "affected_rows",
+}
+
+// rowCountPatterns find a row count in plain text, e.g. "(3 rows)" or "Rows affected: 2"
+var rowCountPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(\d+)\s+rows?\b`),
+	regexp.MustCompile(`(?i)\brows?(?:\s+affected)?\s*:\s*(\d+)`),
+}
+
+// rowCount returns the number of rows a result reports, looking at the
+// structured content first and then at each text block
+func (r *toolResult) rowCount() (int64, bool) {
+	if n, ok := jsonRowCount(r.StructuredContent); ok {
+		return n, true
+	}
+	for _, c := range r.Content {
+		if c.Type != "text" {
+			continue
+		}
+		if n, ok := jsonRowCount(json.RawMessage(c.Text)); ok {
+			return n, true
+		}
+		for _, re := range rowCountPatterns {
+			if m := re.FindStringSubmatch(c.Text); m != nil {
+				if n, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+					return n, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// jsonRowCount reads a row count from a JSON value: a count field of an
+// object, the length of its "rows" array, or the length of a top-level array
+func jsonRowCount(data json.RawMessage) (int64, bool) {
+	data = json.RawMessage(strings.TrimSpace(string(data)))
+	if len(data) == 0 {
+		return 0, false
+	}
+	var rows []json.RawMessage
+	if data[0] == '[' {
+		if json.Unmarshal(data, &rows) == nil {
+			return int64(len(rows)), true
+		}
+		return 0, false
+	}
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(data, &obj) != nil {
+		return 0, false
+	}
+	for _, key := range countKeys {
+		var n int64
+		if v, ok := obj[key]; ok && json.Unmarshal(v, &n) == nil {
+			return n, true
+		}
+	}
+	if v, ok := obj["rows"]; ok && json.Unmarshal(v, &rows) == nil {
+		return int64(len(rows)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,327 @@
+package balance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// healthProtocolVersion is the protocol version health-check sessions request
+const healthProtocolVersion = "2025-06-18"
+
+// maxExpiryInterval bounds the time between sweeps for idle sessions
+const maxExpiryInterval = time.Minute
+
+// Pool balances HTTP exchanges across equivalent upstream instances. It
+// implements proxy.Targets.
+type Pool struct {
+	strategy        string
+	affinity        bool
+	affinityTimeout time.Duration
+	check           config.HealthCheckConfig
+	logger          *logging.Logger
+	targets         []*target
+	pingID          atomic.Int64
+
+	mu       sync.Mutex
+	next     int                 // Where the next round-robin turn starts
+	sessions map[string]*binding // Instances holding sessions, by Mcp-Session-Id
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// binding is the instance holding a session and when the session last used it
+type binding struct {
+	target   *target
+	lastUsed time.Time
+}
+
+// target is one instance of a pool
+type target struct {
+	url string
+
+	// Guarded by the pool's mutex
+	healthy   bool
+	inflight  int
+	failures  int // Consecutive failed health checks
+	successes int // Consecutive successful health checks
+
+	checker *upstream.HTTP // Health-check session; used by the check loop only
+}
+
+// New creates a pool of the configured targets, all initially healthy
+func New(cfg config.PoolConfig, logger *logging.Logger) *Pool {
+	p := &Pool{
+		strategy:        cfg.Strategy,
+		affinity:        cfg.Affinity,
+		affinityTimeout: cfg.AffinityTimeout,
+		check:           cfg.HealthCheck,
+		logger:          logger,
+		sessions:        make(map[string]*binding),
+		stop:            make(chan struct{}),
+	}
+	for _, url := range cfg.Targets {
+		p.targets = append(p.targets, &target{url: strings.TrimSuffix(url, "/"), healthy: true})
+	}
+	return p
+}
+
+// Pick implements proxy.Targets
+func (p *Pool) Pick(session string) (string, func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if session != "" && p.affinity {
+		if b, ok := p.sessions[session]; ok {
+			if !b.target.healthy {
+				delete(p.sessions, session)
+				p.logger.Infof("Session %s was held by ejected upstream %s", session, b.target.url)
+				return "", nil, proxy.ErrSessionLost
+			}
+			b.lastUsed = time.Now()
+			return b.target.url, p.acquire(b.target), nil
+		}
+	}
+
+	t := p.choose()
+	if t == nil {
+		return "", nil, proxy.ErrNoTarget
+	}
+	return t.url, p.acquire(t), nil
+}
+
+// choose returns the healthy instance whose turn it is, or with the least
+// exchanges in progress. Ties go to the instance whose turn it is.
+func (p *Pool) choose() *target {
+	var best *target
+	bestIndex := 0
+	for i := range p.targets {
+		index := (p.next + i) % len(p.targets)
+		t := p.targets[index]
+		if !t.healthy {
+			continue
+		}
+		if best == nil || (p.strategy == config.BalanceLeastInFlight && t.inflight < best.inflight) {
+			best, bestIndex = t, index
+		}
+		if p.strategy != config.BalanceLeastInFlight {
+			break
+		}
+	}
+	if best != nil {
+		p.next = bestIndex + 1
+	}
+	return best
+}
+
+// acquire counts an exchange with an instance until the returned function is called
+func (p *Pool) acquire(t *target) func() {
+	t.inflight++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			t.inflight--
+			p.mu.Unlock()
+		})
+	}
+}
+
+// Bind implements proxy.Targets
+func (p *Pool) Bind(session, url string) {
+	if !p.affinity {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.targets {
+		if t.url == url {
+			p.sessions[session] = &binding{target: t, lastUsed: time.Now()}
+			return
+		}
+	}
+}
+
+// Unbind implements proxy.Targets
+func (p *Pool) Unbind(session string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sessions, session)
+}
+
+// Expire forgets the instances of sessions without requests for longer than
+// the affinity timeout. Clients that end sessions without a DELETE, or not at
+// all, would otherwise leave them bound for the life of the proxy.
+func (p *Pool) Expire() {
+	if !p.affinity || p.affinityTimeout <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expired := 0
+	for session, b := range p.sessions {
+		if time.Since(b.lastUsed) >= p.affinityTimeout {
+			delete(p.sessions, session)
+			expired++
+		}
+	}
+	if expired > 0 {
+		p.logger.Infof("Forgot the upstreams of %d sessions idle for %s", expired, p.affinityTimeout)
+	}
+}
+
+// Start runs health checks in the background until Close, checking every
+// instance right away, and forgets idle sessions. Health checks are skipped
+// when disabled.
+func (p *Pool) Start() {
+	if p.affinity && p.affinityTimeout > 0 {
+		interval := min(p.affinityTimeout, maxExpiryInterval)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					p.Expire()
+				case <-p.stop:
+					return
+				}
+			}
+		}()
+	}
+
+	if p.check.Interval <= 0 {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.check.Interval)
+		defer ticker.Stop()
+		for {
+			p.CheckAll()
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the health checks and ends their upstream sessions
+func (p *Pool) Close() error {
+	close(p.stop)
+	p.wg.Wait()
+	for _, t := range p.targets {
+		if t.checker != nil {
+			t.checker.Close()
+			t.checker = nil
+		}
+	}
+	return nil
+}
+
+// CheckAll pings every instance concurrently, ejecting or readmitting
+// instances that reach the thresholds
+func (p *Pool) CheckAll() {
+	var wg sync.WaitGroup
+	for _, t := range p.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.check.Timeout)
+			defer cancel()
+			p.record(t, p.ping(ctx, t))
+		}(t)
+	}
+	wg.Wait()
+}
+
+// record counts the outcome of a health check
+func (p *Pool) record(t *target, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		t.successes = 0
+		t.failures++
+		p.logger.Debugf("Health check of upstream %s failed: %v", t.url, err)
+		if t.healthy && t.failures >= p.check.UnhealthyThreshold {
+			t.healthy = false
+			p.logger.Errorf("Ejected upstream %s after %d failed health checks: %v", t.url, t.failures, err)
+		}
+		return
+	}
+	t.failures = 0
+	t.successes++
+	if !t.healthy && t.successes >= p.check.HealthyThreshold {
+		t.healthy = true
+		p.logger.Infof("Readmitted upstream %s after %d successful health checks", t.url, t.successes)
+	}
+}
+
+// ping sends an MCP ping to an instance, first opening a session on it. A
+// session whose ping fails is dropped, so the next check opens a new one; it
+// is closed in the background, as the instance may not answer.
+func (p *Pool) ping(ctx context.Context, t *target) error {
+	if t.checker == nil {
+		checker := upstream.NewHTTP(t.url+p.check.Path, nil)
+		if err := p.initialize(ctx, checker); err != nil {
+			go checker.Close()
+			return err
+		}
+		t.checker = checker
+	}
+	if _, err := p.call(ctx, t.checker, "ping", nil); err != nil {
+		go t.checker.Close()
+		t.checker = nil
+		return err
+	}
+	return nil
+}
+
+func (p *Pool) initialize(ctx context.Context, checker *upstream.HTTP) error {
+	params := map[string]interface{}{
+		"protocolVersion": healthProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "mcp_sqlpp_proxy-health", "version": "1.0"},
+	}
+	if _, err := p.call(ctx, checker, "initialize", params); err != nil {
+		return err
+	}
+	initialized, err := mcp.NewNotification("notifications/initialized", nil)
+	if err != nil {
+		return err
+	}
+	return checker.Send(ctx, initialized)
+}
+
+// call sends a request and turns error responses into errors
+func (p *Pool) call(ctx context.Context, checker *upstream.HTTP, method string, params interface{}) (*mcp.Message, error) {
+	id := json.RawMessage(fmt.Sprintf(`"health-%d"`, p.pingID.Add(1)))
+	req, err := mcp.NewRequest(id, method, params)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := checker.Call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s failed: %s", method, resp.Error.Message)
+	}
+	return resp, nil
+}
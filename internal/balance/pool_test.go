@@ -0,0 +1,209 @@
+package balance
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func newPool(t *testing.T, strategy string, targets ...string) *Pool {
+	cfg := config.DefaultConfig().Pool
+	cfg.Targets = targets
+	cfg.Strategy = strategy
+	cfg.HealthCheck.UnhealthyThreshold = 2
+	cfg.HealthCheck.HealthyThreshold = 1
+	return New(cfg, newTestLogger(t))
+}
+
+func pick(t *testing.T, p *Pool, session string) string {
+	target, release, err := p.Pick(session)
+	require.NoError(t, err)
+	release()
+	return target
+}
+
+func TestRoundRobin(t *testing.T) {
+	p := newPool(t, config.BalanceRoundRobin, "http://a", "http://b/", "http://c")
+
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, pick(t, p, ""))
+	}
+	assert.Equal(t, []string{"http://a", "http://b", "http://c", "http://a"}, picked)
+}
+
+func TestLeastInFlight(t *testing.T) {
+	p := newPool(t, config.BalanceLeastInFlight, "http://a", "http://b")
+
+	first, releaseFirst, err := p.Pick("")
+	require.NoError(t, err)
+	second, releaseSecond, err := p.Pick("")
+	require.NoError(t, err)
+	assert.Equal(t, "http://a", first)
+	assert.Equal(t, "http://b", second)
+
+	releaseFirst()
+	releaseFirst()
+	assert.Equal(t, "http://a", pick(t, p, ""), "a has nothing in flight")
+	assert.Equal(t, "http://a", pick(t, p, ""), "b still has an exchange in flight")
+	releaseSecond()
+}
+
+func TestAffinity(t *testing.T) {
+	p := newPool(t, config.BalanceRoundRobin, "http://a", "http://b")
+
+	p.Bind("s1", "http://b")
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "http://b", pick(t, p, "s1"))
+	}
+	assert.Equal(t, "http://a", pick(t, p, "unknown"), "unknown sessions are balanced")
+
+	p.Unbind("s1")
+	assert.Equal(t, "http://b", pick(t, p, "s1"))
+	assert.Equal(t, "http://a", pick(t, p, "s1"))
+
+	p.affinity = false
+	p.Bind("s2", "http://b")
+	assert.Equal(t, "http://b", pick(t, p, "s2"))
+	assert.Equal(t, "http://a", pick(t, p, "s2"), "without affinity every exchange is balanced")
+}
+
+func TestAffinityExpiry(t *testing.T) {
+	p := newPool(t, config.BalanceRoundRobin, "http://a", "http://b")
+
+	p.Bind("s1", "http://b")
+	p.Bind("s2", "http://b")
+	p.mu.Lock()
+	p.sessions["s1"].lastUsed = time.Now().Add(-2 * time.Hour)
+	p.sessions["s2"].lastUsed = time.Now().Add(-2 * time.Hour)
+	p.mu.Unlock()
+	assert.Equal(t, "http://b", pick(t, p, "s2"), "requests keep a session bound")
+
+	p.Expire()
+	assert.Len(t, p.sessions, 1, "sessions idle past the affinity timeout are forgotten")
+	assert.Equal(t, "http://a", pick(t, p, "s1"))
+	assert.Equal(t, "http://b", pick(t, p, "s2"))
+}
+
+// instance is an MCP server answering initialize and ping until it is failed
+type instance struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	failing bool
+	methods []string
+}
+
+func newInstance(t *testing.T) *instance {
+	in := &instance{}
+	in.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in.mu.Lock()
+		failing := in.failing
+		in.mu.Unlock()
+		if failing || r.URL.Path != "/mcp" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPost {
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		msg, err := mcp.Parse(body)
+		require.NoError(t, err)
+		in.mu.Lock()
+		in.methods = append(in.methods, msg.Method)
+		in.mu.Unlock()
+		if !msg.IsRequest() {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if msg.Method != "initialize" && r.Header.Get("Mcp-Session-Id") != "health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resp, _ := mcp.NewResult(msg.ID, map[string]string{})
+		w.Header().Set("Mcp-Session-Id", "health")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(resp.String()))
+	}))
+	t.Cleanup(in.Close)
+	return in
+}
+
+func (in *instance) fail(failing bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.failing = failing
+}
+
+func TestHealthChecks(t *testing.T) {
+	a, b := newInstance(t), newInstance(t)
+	p := newPool(t, config.BalanceRoundRobin, a.URL, b.URL)
+	defer p.Close()
+
+	p.CheckAll()
+	p.CheckAll()
+	assert.Equal(t, []string{"initialize", "notifications/initialized", "ping", "ping"}, b.methods,
+		"the health-check session is reused")
+
+	p.Bind("s1", b.URL)
+	b.fail(true)
+	p.CheckAll()
+	assert.Equal(t, b.URL, pick(t, p, "s1"), "one failure is below the threshold")
+	p.CheckAll()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, a.URL, pick(t, p, ""), "ejected instances get no new sessions")
+	}
+	_, _, err := p.Pick("s1")
+	assert.ErrorIs(t, err, proxy.ErrSessionLost)
+	assert.Equal(t, a.URL, pick(t, p, "s1"), "the lost session is forgotten")
+
+	a.fail(true)
+	p.CheckAll()
+	p.CheckAll()
+	_, _, err = p.Pick("")
+	assert.ErrorIs(t, err, proxy.ErrNoTarget)
+
+	b.fail(false)
+	p.CheckAll()
+	assert.Equal(t, b.URL, pick(t, p, ""), "recovered instances are readmitted")
+	assert.Equal(t, b.URL, pick(t, p, ""))
+}
+
+func TestStart(t *testing.T) {
+	a := newInstance(t)
+	cfg := config.DefaultConfig().Pool
+	cfg.Targets = []string{a.URL}
+	cfg.HealthCheck.Interval = 10 * time.Millisecond
+	p := New(cfg, newTestLogger(t))
+
+	p.Start()
+	assert.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return len(a.methods) >= 4
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, p.Close())
+}
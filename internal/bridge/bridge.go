@@ -0,0 +1,125 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+)
+
+// versions are the protocol versions the bridge can translate between
+var versions = map[string]bool{
+	"2024-11-05": true,
+	"2025-03-26": true,
+	"2025-06-18": true,
+}
+
+// Bridge lets a client and an upstream that negotiate different protocol
+// versions talk to each other. The client is told the version it asked for,
+// and results from a newer upstream are translated down to what the client's
+// version knows. Splitting batches for upstreams without them is left to the
+// proxy's transports, which learn the upstream's version from the session.
+type Bridge struct {
+	logger *logging.Logger
+}
+
+// New creates a protocol bridge
+func New(logger *logging.Logger) *Bridge {
+	return &Bridge{logger: logger}
+}
+
+// Middleware returns the bridge as proxy middleware
+func (b *Bridge) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			resp, err := next(ctx, s, req)
+			if err != nil || resp == nil || resp.Result == nil {
+				return resp, err
+			}
+			if req.Method == "initialize" {
+				return b.initialize(s, req, resp), nil
+			}
+
+			client, upstream := s.ProtocolVersion(), s.UpstreamVersion()
+			if upstream == "" || client >= upstream {
+				return resp, nil
+			}
+			var translate func(json.RawMessage, string) (json.RawMessage, []string, error)
+			switch req.Method {
+			case "tools/list":
+				translate = toolList
+			case "tools/call":
+				translate = toolResult
+			default:
+				return resp, nil
+			}
+			result, lost, err := translate(resp.Result, client)
+			if err != nil {
+				b.logger.Errorf("Failed to translate %s result from protocol version %s to %s: %v", req.Method, upstream, client, err)
+				return resp, nil
+			}
+			for _, what := range lost {
+				b.logger.Warnf("Cannot translate %s of %s result from protocol version %s to %s; it was replaced by a text notice", what, req.Method, upstream, client)
+			}
+			translated := resp.Clone()
+			translated.Result = result
+			return translated, nil
+		}
+	}
+}
+
+// initialize records the version the upstream negotiated when it differs from
+// the one the client asked for, and answers the client with its own version
+func (b *Bridge) initialize(s *proxy.Session, req, resp *mcp.Message) *mcp.Message {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(req.Params, &params)
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return resp
+	}
+	var upstream string
+	json.Unmarshal(result["protocolVersion"], &upstream)
+
+	client := params.ProtocolVersion
+	if client == "" || upstream == "" || client == upstream {
+		return resp
+	}
+	if !versions[client] || !versions[upstream] {
+		b.logger.Warnf("Cannot bridge protocol version %s of client %s to %s of the upstream; the session uses %s", client, clientName(req), upstream, upstream)
+		return resp
+	}
+
+	version, err := json.Marshal(client)
+	if err != nil {
+		return resp
+	}
+	result["protocolVersion"] = version
+	data, err := json.Marshal(result)
+	if err != nil {
+		b.logger.Errorf("Failed to encode bridged initialize result: %v", err)
+		return resp
+	}
+	s.SetUpstreamVersion(upstream)
+	b.logger.Infof("Bridging protocol version %s of client %s to %s of the upstream", client, clientName(req), upstream)
+	bridged := resp.Clone()
+	bridged.Result = data
+	return bridged
+}
+
+// clientName returns the clientInfo name an initialize request declares
+func clientName(req *mcp.Message) string {
+	var params struct {
+		ClientInfo struct {
+			Name string `json:"name"`
+		} `json:"clientInfo"`
+	}
+	json.Unmarshal(req.Params, &params)
+	if params.ClientInfo.Name == "" {
+		return "(unnamed)"
+	}
+	return params.ClientInfo.Name
+}
@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// upstream answers initialize with version and everything else with result
+func upstream(version, result string) proxy.Handler {
+	return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		if req.Method == "initialize" {
+			return mcp.NewResult(req.ID, map[string]interface{}{"protocolVersion": version, "capabilities": map[string]interface{}{}})
+		}
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}, nil
+	}
+}
+
+func initialize(t *testing.T, handler proxy.Handler, s *proxy.Session, version string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage("1"), "initialize", map[string]interface{}{
+		"protocolVersion": version,
+		"clientInfo":      map[string]string{"name": "test-client"},
+	})
+	require.NoError(t, err)
+	resp, err := handler(context.Background(), s, req)
+	require.NoError(t, err)
+	s.SetProtocolVersion(version)
+	return resp
+}
+
+func call(t *testing.T, handler proxy.Handler, s *proxy.Session, method string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage("2"), method, nil)
+	require.NoError(t, err)
+	resp, err := handler(context.Background(), s, req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestInitialize(t *testing.T) {
+	handler := New(newTestLogger(t)).Middleware()(upstream("2025-06-18", `{}`))
+
+	s := proxy.NewSession("test", nil)
+	resp := initialize(t, handler, s, "2024-11-05")
+	assert.JSONEq(t, `{"protocolVersion":"2024-11-05","capabilities":{}}`, string(resp.Result), "the client is told its own version")
+	assert.Equal(t, "2025-06-18", s.UpstreamVersion())
+
+	s = proxy.NewSession("test", nil)
+	resp = initialize(t, handler, s, "2025-06-18")
+	assert.JSONEq(t, `{"protocolVersion":"2025-06-18","capabilities":{}}`, string(resp.Result))
+	assert.Empty(t, s.UpstreamVersion(), "nothing is bridged between equal versions")
+
+	s = proxy.NewSession("test", nil)
+	resp = initialize(t, handler, s, "2023-01-01")
+	assert.JSONEq(t, `{"protocolVersion":"2025-06-18","capabilities":{}}`, string(resp.Result), "unknown versions are passed through")
+	assert.Empty(t, s.UpstreamVersion())
+}
+
+func TestToolList(t *testing.T) {
+	list := `{"tools":[{"name":"execute_sql","title":"Execute SQL","inputSchema":{"type":"object"},"outputSchema":{"type":"object"},"annotations":{"readOnlyHint":false},"x-extra":1}],"nextCursor":"c"}`
+	handler := New(newTestLogger(t)).Middleware()(upstream("2025-06-18", list))
+
+	s := proxy.NewSession("test", nil)
+	initialize(t, handler, s, "2025-03-26")
+	resp := call(t, handler, s, "tools/list")
+	assert.JSONEq(t, `{"tools":[{"name":"execute_sql","inputSchema":{"type":"object"},"annotations":{"readOnlyHint":false},"x-extra":1}],"nextCursor":"c"}`, string(resp.Result))
+
+	s = proxy.NewSession("test", nil)
+	initialize(t, handler, s, "2024-11-05")
+	resp = call(t, handler, s, "tools/list")
+	assert.JSONEq(t, `{"tools":[{"name":"execute_sql","inputSchema":{"type":"object"},"x-extra":1}],"nextCursor":"c"}`, string(resp.Result))
+
+	s = proxy.NewSession("test", nil)
+	initialize(t, handler, s, "2025-06-18")
+	resp = call(t, handler, s, "tools/list")
+	assert.JSONEq(t, list, string(resp.Result), "results for clients on the upstream's version are left alone")
+}
+
+func TestToolResult(t *testing.T) {
+	result := `{
+		"content": [
+			{"type":"text","text":"3 rows","annotations":{"audience":["user"],"lastModified":"2025-01-01T00:00:00Z"}},
+			{"type":"resource_link","uri":"file:///tmp/out.csv","name":"out.csv"},
+			{"type":"audio","data":"AAAA","mimeType":"audio/wav"}
+		],
+		"structuredContent": {"rows":[]},
+		"isError": false
+	}`
+	handler := New(newTestLogger(t)).Middleware()(upstream("2025-06-18", result))
+
+	s := proxy.NewSession("test", nil)
+	initialize(t, handler, s, "2025-03-26")
+	resp := call(t, handler, s, "tools/call")
+	assert.JSONEq(t, `{
+		"content": [
+			{"type":"text","text":"3 rows","annotations":{"audience":["user"]}},
+			{"type":"text","text":"out.csv: file:///tmp/out.csv"},
+			{"type":"audio","data":"AAAA","mimeType":"audio/wav"}
+		],
+		"isError": false
+	}`, string(resp.Result))
+
+	s = proxy.NewSession("test", nil)
+	initialize(t, handler, s, "2024-11-05")
+	resp = call(t, handler, s, "tools/call")
+	var r struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Result, &r))
+	require.Len(t, r.Content, 3)
+	assert.Equal(t, "text", r.Content[2].Type)
+	assert.Contains(t, r.Content[2].Text, "Audio content (audio/wav) removed by the proxy")
+}
+
+func TestStructuredOnly(t *testing.T) {
+	raw, lost, err := toolResult(json.RawMessage(`{"content":[],"structuredContent":{"rows":[1,2]}}`), "2025-03-26")
+	require.NoError(t, err)
+	assert.Empty(t, lost)
+	assert.JSONEq(t, `{"content":[{"type":"text","text":"{\"rows\":[1,2]}"}]}`, string(raw), "structured content without text is passed on as text")
+
+	raw, _, err = toolResult(json.RawMessage(`{"content":[],"structuredContent":{"rows":[1,2]}}`), "2025-06-18")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"content":[],"structuredContent":{"rows":[1,2]}}`, string(raw))
+}
+
+func TestOlderUpstream(t *testing.T) {
+	result := `{"content":[{"type":"text","text":"ok"}]}`
+	handler := New(newTestLogger(t)).Middleware()(upstream("2024-11-05", result))
+
+	s := proxy.NewSession("test", nil)
+	resp := initialize(t, handler, s, "2025-06-18")
+	assert.JSONEq(t, `{"protocolVersion":"2025-06-18","capabilities":{}}`, string(resp.Result))
+	assert.Equal(t, "2024-11-05", s.UpstreamVersion())
+	resp = call(t, handler, s, "tools/call")
+	assert.Equal(t, result, string(resp.Result), "results of older upstreams need no translation")
+}
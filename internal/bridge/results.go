@@ -0,0 +1,167 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// toolList translates a tools/list result for a client on an older protocol
+// version. Unknown fields are kept as is.
+func toolList(raw json.RawMessage, version string) (json.RawMessage, []string, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, nil, err
+	}
+	var tools []map[string]json.RawMessage
+	if err := json.Unmarshal(result["tools"], &tools); err != nil {
+		return nil, nil, err
+	}
+	for _, tool := range tools {
+		if version < "2025-06-18" {
+			delete(tool, "outputSchema")
+			delete(tool, "title")
+		}
+		if version < "2025-03-26" {
+			delete(tool, "annotations")
+		}
+	}
+	data, err := json.Marshal(tools)
+	if err != nil {
+		return nil, nil, err
+	}
+	result["tools"] = data
+	data, err = json.Marshal(result)
+	return data, nil, err
+}
+
+// toolResult translates a tools/call result for a client on an older protocol
+// version. It returns what could not be translated, which is replaced by a text
+// notice. Unknown fields are kept as is.
+func toolResult(raw json.RawMessage, version string) (json.RawMessage, []string, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, nil, err
+	}
+	var content []map[string]json.RawMessage
+	if data, ok := result["content"]; ok {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var lost []string
+	for i, block := range content {
+		translated, what, err := contentBlock(block, version)
+		if err != nil {
+			return nil, nil, err
+		}
+		content[i] = translated
+		if what != "" {
+			lost = append(lost, what)
+		}
+	}
+
+	// Older clients only read content, so structured content without a
+	// textual form is passed on as its JSON
+	if structured, ok := result["structuredContent"]; ok && version < "2025-06-18" {
+		if len(content) == 0 {
+			block, err := textBlock(string(structured))
+			if err != nil {
+				return nil, nil, err
+			}
+			content = append(content, block)
+		}
+		delete(result, "structuredContent")
+	}
+
+	if _, ok := result["content"]; ok || content != nil {
+		if content == nil {
+			content = []map[string]json.RawMessage{}
+		}
+		data, err := json.Marshal(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		result["content"] = data
+	}
+	data, err := json.Marshal(result)
+	return data, lost, err
+}
+
+// contentBlock translates a content block. It returns what could not be
+// translated, if anything.
+func contentBlock(block map[string]json.RawMessage, version string) (map[string]json.RawMessage, string, error) {
+	var fields struct {
+		Type     string `json:"type"`
+		URI      string `json:"uri"`
+		Name     string `json:"name"`
+		MimeType string `json:"mimeType"`
+	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		return nil, "", err
+	}
+	json.Unmarshal(data, &fields)
+	switch {
+	case fields.Type == "resource_link" && version < "2025-06-18":
+		// A link reads as well as text
+		text := fields.URI
+		if fields.Name != "" {
+			text = fields.Name + ": " + fields.URI
+		}
+		translated, err := replace(block, text, version)
+		return translated, "", err
+	case fields.Type == "audio" && version < "2025-03-26":
+		notice := fmt.Sprintf("[Audio content (%s) removed by the proxy: protocol version %s has no audio content]", fields.MimeType, version)
+		translated, err := replace(block, notice, version)
+		return translated, "audio content", err
+	}
+	return block, "", annotations(block, version)
+}
+
+// replace returns a text block to stand in for a block, with its annotations
+func replace(block map[string]json.RawMessage, text, version string) (map[string]json.RawMessage, error) {
+	replacement, err := textBlock(text)
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := block["annotations"]; ok {
+		replacement["annotations"] = data
+	}
+	return replacement, annotations(replacement, version)
+}
+
+// annotations drops the content annotations an older protocol version does
+// not know
+func annotations(block map[string]json.RawMessage, version string) error {
+	data, ok := block["annotations"]
+	if !ok || version >= "2025-06-18" {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	if _, ok := fields["lastModified"]; !ok {
+		return nil
+	}
+	delete(fields, "lastModified")
+	if len(fields) == 0 {
+		delete(block, "annotations")
+		return nil
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	block["annotations"] = data
+	return nil
+}
+
+func textBlock(text string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(text)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]json.RawMessage{"type": json.RawMessage(`"text"`), "text": data}, nil
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+)
+
+// ToolStats counts the lookups of a tool
+type ToolStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Stats reports the cache's state and counters
+type Stats struct {
+	Entries    int                   `json:"entries"`
+	MaxEntries int                   `json:"max_entries"`
+	Hits       int64                 `json:"hits"`
+	Misses     int64                 `json:"misses"`
+	Stores     int64                 `json:"stores"`
+	Evictions  int64                 `json:"evictions"`
+	Expired    int64                 `json:"expired"`
+	Purged     int64                 `json:"purged"`
+	Tools      map[string]*ToolStats `json:"tools"`
+}
+
+// Cache is middleware that answers repeated tools/call requests of read tools
+// from an in-memory LRU cache. Calls are keyed by tool name and canonicalized
+// arguments, and only cached when the tool is configured with a TTL and all
+// SQL in the arguments is read statements.
+type Cache struct {
+	cfg       config.CacheConfig
+	extractor *sqlpolicy.Extractor
+	logger    *logging.Logger
+	now       func() time.Time
+
+	mu      sync.Mutex
+	entries *lru
+	stats   Stats
+}
+
+// New creates a cache. SQL is looked for in the tool arguments configured
+// for the SQL policy.
+func New(cfg config.CacheConfig, tools []config.SQLToolConfig, logger *logging.Logger) *Cache {
+	return &Cache{
+		cfg:       cfg,
+		extractor: sqlpolicy.NewExtractor(tools),
+		logger:    logger,
+		now:       time.Now,
+		entries:   newLRU(cfg.MaxEntries),
+		stats:     Stats{MaxEntries: cfg.MaxEntries, Tools: make(map[string]*ToolStats)},
+	}
+}
+
+// Middleware returns the cache as proxy middleware. Lookups are recorded as
+// the "cache" note: "hit" or "miss".
+func (c *Cache) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method != "tools/call" {
+				return next(ctx, s, req)
+			}
+			call, err := mcp.ParseToolCall(req)
+			if err != nil {
+				return next(ctx, s, req)
+			}
+
+			if !c.reads(call) {
+				resp, err := next(ctx, s, req)
+				if c.cfg.PurgeOnWrite {
+					if n := c.Purge(""); n > 0 {
+						c.logger.Infof("Purged %d cache entries after a write by tool %s", n, call.Name)
+					}
+				}
+				return resp, err
+			}
+			ttl, ok := c.ttl(call.Name)
+			if !ok {
+				return next(ctx, s, req)
+			}
+			key, err := c.key(ctx, s, call)
+			if err != nil {
+				return next(ctx, s, req)
+			}
+
+			if result, ok := c.get(key, call.Name); ok {
+				proxy.Note(ctx, "cache", "hit")
+				c.logger.Debugf("Cache hit for tool %s", call.Name)
+				return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: result}, nil
+			}
+			proxy.Note(ctx, "cache", "miss")
+
+			resp, err := next(ctx, s, req)
+			if err == nil && cacheable(resp) {
+				c.put(key, call.Name, resp.Result, ttl)
+			}
+			return resp, err
+		}
+	}
+}
+
+// Register adds the cache endpoints to the admin interface: GET /cache for
+// the counters and POST /cache/purge to drop entries, optionally only those
+// of tools matching ?tool=<glob>
+func (c *Cache) Register(srv *admin.Server) {
+	srv.HandleFunc("GET /cache", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, c.Stats())
+	})
+	srv.HandleFunc("POST /cache/purge", func(w http.ResponseWriter, r *http.Request) {
+		tool := r.URL.Query().Get("tool")
+		if _, err := path.Match(tool, ""); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, "invalid tool pattern '%s'", tool)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, map[string]int{"purged": c.Purge(tool)})
+	})
+}
+
+// Stats returns a snapshot of the counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.entries.len()
+	stats.Tools = make(map[string]*ToolStats, len(c.stats.Tools))
+	for name, t := range c.stats.Tools {
+		copied := *t
+		stats.Tools[name] = &copied
+	}
+	return stats
+}
+
+// Purge drops the entries of tools matching a glob, or all entries for "",
+// and returns their number
+func (c *Cache) Purge(tool string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.entries.removeIf(func(e *entry) bool {
+		if tool == "" {
+			return true
+		}
+		ok, _ := path.Match(tool, e.tool)
+		return ok
+	})
+	c.stats.Purged += int64(n)
+	return n
+}
+
+func (c *Cache) get(key, tool string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.toolStats(tool)
+	e, ok := c.entries.get(key)
+	if ok && !c.now().Before(e.expires) {
+		c.entries.delete(key)
+		c.stats.Expired++
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	stats.Hits++
+	return append(json.RawMessage(nil), e.result...), true
+}
+
+func (c *Cache) put(key, tool string, result json.RawMessage, ttl time.Duration) {
+	if c.cfg.MaxEntryBytes > 0 && len(result) > c.cfg.MaxEntryBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Evictions += int64(c.entries.put(&entry{key: key, tool: tool, result: result, expires: c.now().Add(ttl)}))
+	c.stats.Stores++
+}
+
+func (c *Cache) toolStats(tool string) *ToolStats {
+	stats, ok := c.stats.Tools[tool]
+	if !ok {
+		stats = &ToolStats{}
+		c.stats.Tools[tool] = stats
+	}
+	return stats
+}
+
+// ttl returns the TTL of the first cache tool matching a tool name
+func (c *Cache) ttl(tool string) (time.Duration, bool) {
+	for _, t := range c.cfg.Tools {
+		if ok, _ := path.Match(t.Name, tool); ok {
+			return t.TTL, true
+		}
+	}
+	return 0, false
+}
+
+// reads reports whether all SQL statements in the arguments of a call are
+// reads; calls without SQL count as reads
+func (c *Cache) reads(call *mcp.ToolCall) bool {
+	for _, text := range c.extractor.Find(call) {
+		for _, stmt := range sqlpolicy.Parse(text.SQL) {
+			if stmt.Class != sqlpolicy.ClassSelect {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// key identifies a call by endpoint, tool name and canonical arguments, and by
+// session in the session scope
+func (c *Cache) key(ctx context.Context, s *proxy.Session, call *mcp.ToolCall) (string, error) {
+	args, err := mcp.Canonicalize(call.Arguments)
+	if err != nil {
+		return "", err
+	}
+	key, err := json.Marshal([]string{proxy.Endpoint(ctx), call.Name, args})
+	if err != nil {
+		return "", err
+	}
+	if c.cfg.Scope == config.CacheSession {
+		return s.ID + "\x00" + string(key), nil
+	}
+	return string(key), nil
+}
+
+// cacheable reports whether a response is a successful tool result
+func cacheable(resp *mcp.Message) bool {
+	if resp == nil || resp.Error != nil || resp.Result == nil {
+		return false
+	}
+	var result struct {
+		IsError bool `json:"isError"`
+	}
+	return json.Unmarshal(resp.Result, &result) == nil && !result.IsError
+}
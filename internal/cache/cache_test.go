@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// upstream counts the calls that reach it and answers each with its number
+type upstream struct {
+	calls   int
+	isError bool
+}
+
+func (u *upstream) handle(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+	u.calls++
+	result := fmt.Sprintf(`{"content":[{"type":"text","text":"call %d"}],"isError":%t}`, u.calls, u.isError)
+	return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}, nil
+}
+
+func callRequest(t *testing.T, id int, tool, args string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage(fmt.Sprint(id)), "tools/call",
+		map[string]interface{}{"name": tool, "arguments": json.RawMessage(args)})
+	require.NoError(t, err)
+	return req
+}
+
+// newTestCache creates a cache with a clock the test moves forward
+func newTestCache(t *testing.T, cfg config.CacheConfig) (*Cache, *time.Time) {
+	c := New(cfg, nil, newTestLogger(t))
+	now := time.Date(2025, 6, 18, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+var defaultTools = []config.CacheTool{{Name: "list_*", TTL: time.Minute}, {Name: "execute_sql", TTL: 10 * time.Second}}
+
+func TestHitsAndMisses(t *testing.T) {
+	c, now := newTestCache(t, config.CacheConfig{MaxEntries: 10, Tools: defaultTools})
+	up := &upstream{}
+	handler := c.Middleware()(up.handle)
+	s := proxy.NewSession("a", nil)
+
+	resp, err := handler(context.Background(), s, callRequest(t, 1, "list_tables", `{"schema":"public","limit":10}`))
+	require.NoError(t, err)
+	assert.Contains(t, string(resp.Result), "call 1")
+
+	// Same arguments in a different order and layout
+	ctx, notes := proxy.WithNotes(context.Background())
+	resp, err = handler(ctx, s, callRequest(t, 2, "list_tables", `{ "limit": 10, "schema": "public" }`))
+	require.NoError(t, err)
+	assert.Equal(t, json.RawMessage("2"), resp.ID, "cached responses carry the request's id")
+	assert.Contains(t, string(resp.Result), "call 1")
+	assert.Equal(t, map[string]interface{}{"cache": "hit"}, notes.All())
+
+	handler(context.Background(), s, callRequest(t, 3, "list_tables", `{"schema":"other"}`))
+	assert.Equal(t, 2, up.calls)
+
+	// Entries expire after the tool's TTL
+	*now = now.Add(time.Minute)
+	resp, _ = handler(context.Background(), s, callRequest(t, 4, "list_tables", `{"schema":"public","limit":10}`))
+	assert.Contains(t, string(resp.Result), "call 3")
+
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(3), stats.Misses)
+	assert.Equal(t, int64(1), stats.Expired)
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, &ToolStats{Hits: 1, Misses: 3}, stats.Tools["list_tables"])
+}
+
+func TestOnlyReadsAreCached(t *testing.T) {
+	c, _ := newTestCache(t, config.CacheConfig{MaxEntries: 10, Tools: defaultTools, PurgeOnWrite: true})
+	up := &upstream{}
+	handler := c.Middleware()(up.handle)
+	s := proxy.NewSession("a", nil)
+
+	read := func() {
+		handler(context.Background(), s, callRequest(t, 1, "execute_sql", `{"sql":"SELECT * FROM t"}`))
+	}
+	read()
+	read()
+	assert.Equal(t, 1, up.calls)
+
+	// Writes and tools without a TTL are never cached
+	for i := 0; i < 2; i++ {
+		handler(context.Background(), s, callRequest(t, 2, "execute_sql", `{"sql":"SELECT 1; DELETE FROM t"}`))
+		handler(context.Background(), s, callRequest(t, 3, "drop_table", `{"table":"t"}`))
+	}
+	assert.Equal(t, 5, up.calls)
+
+	// The write purged the cache
+	assert.Equal(t, 0, c.Stats().Entries)
+	assert.Equal(t, int64(1), c.Stats().Purged)
+	read()
+	assert.Equal(t, 6, up.calls)
+}
+
+func TestErrorsAreNotCached(t *testing.T) {
+	c, _ := newTestCache(t, config.CacheConfig{MaxEntries: 10, Tools: defaultTools})
+	up := &upstream{isError: true}
+	handler := c.Middleware()(up.handle)
+	s := proxy.NewSession("a", nil)
+
+	handler(context.Background(), s, callRequest(t, 1, "list_tables", `{}`))
+	handler(context.Background(), s, callRequest(t, 2, "list_tables", `{}`))
+	assert.Equal(t, 2, up.calls)
+
+	failing := c.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return mcp.NewErrorResponse(req.ID, mcp.NewError(mcp.InternalError, "boom")), nil
+	})
+	failing(context.Background(), s, callRequest(t, 3, "list_schemas", `{}`))
+	assert.Equal(t, 0, c.Stats().Entries)
+}
+
+func TestLimits(t *testing.T) {
+	c, _ := newTestCache(t, config.CacheConfig{MaxEntries: 2, MaxEntryBytes: 1000, Tools: defaultTools})
+	up := &upstream{}
+	handler := c.Middleware()(up.handle)
+	s := proxy.NewSession("a", nil)
+
+	for _, table := range []string{"a", "b", "a", "c", "a", "b"} {
+		handler(context.Background(), s, callRequest(t, 1, "list_columns", `{"table":"`+table+`"}`))
+	}
+	// a, b stored; a hit; c evicts b; a hit; b evicts c
+	assert.Equal(t, 4, up.calls)
+	assert.Equal(t, int64(2), c.Stats().Evictions)
+
+	big := c.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{"content":[],"pad":"` + string(make([]byte, 1000)) + `"}`)}, nil
+	})
+	big(context.Background(), s, callRequest(t, 1, "list_big", `{}`))
+	assert.Equal(t, int64(4), c.Stats().Stores, "results over max-entry-bytes are not stored")
+}
+
+func TestSessionScope(t *testing.T) {
+	c, _ := newTestCache(t, config.CacheConfig{MaxEntries: 10, Scope: config.CacheSession, Tools: defaultTools})
+	up := &upstream{}
+	handler := c.Middleware()(up.handle)
+
+	handler(context.Background(), proxy.NewSession("a", nil), callRequest(t, 1, "list_tables", `{}`))
+	handler(context.Background(), proxy.NewSession("b", nil), callRequest(t, 1, "list_tables", `{}`))
+	handler(context.Background(), proxy.NewSession("a", nil), callRequest(t, 1, "list_tables", `{}`))
+	assert.Equal(t, 2, up.calls)
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	c, _ := newTestCache(t, config.CacheConfig{MaxEntries: 10, Tools: defaultTools})
+	up := &upstream{}
+	handler := c.Middleware()(up.handle)
+	s := proxy.NewSession("a", nil)
+	handler(context.Background(), s, callRequest(t, 1, "list_tables", `{}`))
+	handler(context.Background(), s, callRequest(t, 2, "list_schemas", `{}`))
+	handler(context.Background(), s, callRequest(t, 3, "list_tables", `{}`))
+
+	srv := admin.New(0, "", newTestLogger(t))
+	c.Register(srv)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats Stats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.Equal(t, 2, stats.Entries)
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/purge?tool=list_t*", nil))
+	assert.JSONEq(t, `{"purged":1}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/purge", nil))
+	assert.JSONEq(t, `{"purged":1}`, rec.Body.String())
+	assert.Equal(t, 0, c.Stats().Entries)
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cache/purge?tool=[", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"time"
+)
+
+// entry is a cached tools/call result
+type entry struct {
+	key     string
+	tool    string
+	result  json.RawMessage
+	expires time.Time
+}
+
+// lru holds at most max entries, dropping the least recently used first.
+// It is not safe for concurrent use.
+type lru struct {
+	max   int
+	order *list.List // Front is the most recently used
+	items map[string]*list.Element
+}
+
+func newLRU(max int) *lru {
+	return &lru{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the entry for key and marks it as recently used
+func (l *lru) get(key string) (*entry, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*entry), true
+}
+
+// put adds or replaces an entry and returns the number of entries evicted
+func (l *lru) put(e *entry) int {
+	if el, ok := l.items[e.key]; ok {
+		el.Value = e
+		l.order.MoveToFront(el)
+		return 0
+	}
+	l.items[e.key] = l.order.PushFront(e)
+	evicted := 0
+	for l.order.Len() > l.max {
+		l.remove(l.order.Back())
+		evicted++
+	}
+	return evicted
+}
+
+// delete removes the entry for key
+func (l *lru) delete(key string) {
+	if el, ok := l.items[key]; ok {
+		l.remove(el)
+	}
+}
+
+func (l *lru) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.items, el.Value.(*entry).key)
+}
+
+// removeIf removes the entries match returns true for and returns their number
+func (l *lru) removeIf(match func(*entry) bool) int {
+	removed := 0
+	for el := l.order.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*entry)) {
+			l.remove(el)
+			removed++
+		}
+		el = next
+	}
+	return removed
+}
+
+func (l *lru) len() int {
+	return l.order.Len()
+}
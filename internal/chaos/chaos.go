@@ -0,0 +1,267 @@
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// killer is implemented by upstreams whose process can be killed
+type killer interface {
+	Kill() error
+}
+
+// rule is a configured fault with its runtime state
+type rule struct {
+	config.ChaosRule
+	enabled  bool
+	matched  int64
+	injected int64
+}
+
+// RuleStatus reports a rule's state and counters
+type RuleStatus struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Enabled     bool    `json:"enabled"`
+	Probability float64 `json:"probability"`
+	Matched     int64   `json:"matched"`
+	Injected    int64   `json:"injected"`
+}
+
+// Status reports the injector's state
+type Status struct {
+	Enabled bool         `json:"enabled"`
+	Rules   []RuleStatus `json:"rules"`
+}
+
+// Injector is middleware that injects faults into matching requests
+type Injector struct {
+	logger *logging.Logger
+
+	mu      sync.Mutex
+	enabled bool
+	rules   []*rule
+	rand    *rand.Rand
+}
+
+// New creates an injector from the chaos configuration. Rules without a name
+// are named after their position.
+func New(cfg config.ChaosConfig, logger *logging.Logger) *Injector {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	in := &Injector{
+		logger:  logger,
+		enabled: cfg.Enabled,
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if r.Type == config.ChaosError && r.ErrorCode == 0 {
+			r.ErrorCode = mcp.InternalError
+		}
+		if r.Type == config.ChaosError && r.ErrorMessage == "" {
+			r.ErrorMessage = "Injected fault"
+		}
+		in.rules = append(in.rules, &rule{ChaosRule: r, enabled: !r.Disabled})
+	}
+	return in
+}
+
+// Middleware returns the injector as proxy middleware
+func (in *Injector) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			r := in.pick(req)
+			if r == nil {
+				return next(ctx, s, req)
+			}
+			in.logger.Infof("Injecting %s fault (rule %s) into %s", r.Type, r.Name, req.Method)
+			return in.inject(ctx, r, next, s, req)
+		}
+	}
+}
+
+// pick returns the first enabled rule that matches req and fires, updating counters
+func (in *Injector) pick(req *mcp.Message) *rule {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if !in.enabled {
+		return nil
+	}
+	tool := mcp.ToolName(req)
+	for _, r := range in.rules {
+		if !r.enabled || !r.matches(req.Method, tool) {
+			continue
+		}
+		r.matched++
+		if r.Type == config.ChaosKill {
+			// Kill fires exactly once, on the request after the first `after` matches
+			if r.matched != int64(r.After)+1 {
+				continue
+			}
+		} else if r.Probability > 0 && in.rand.Float64() >= r.Probability {
+			continue
+		}
+		r.injected++
+		return r
+	}
+	return nil
+}
+
+func (in *Injector) inject(ctx context.Context, r *rule, next proxy.Handler, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+	switch r.Type {
+	case config.ChaosLatency:
+		delay := r.Latency
+		if r.Jitter > 0 {
+			in.mu.Lock()
+			delay += time.Duration(in.rand.Int63n(int64(2*r.Jitter)+1)) - r.Jitter
+			in.mu.Unlock()
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return next(ctx, s, req)
+
+	case config.ChaosDrop:
+		// The upstream still sees the request; the client never gets an answer
+		next(ctx, s, req)
+		return nil, nil
+
+	case config.ChaosError:
+		return nil, &mcp.Error{Code: r.ErrorCode, Message: r.ErrorMessage}
+
+	case config.ChaosTruncate:
+		resp, err := next(ctx, s, req)
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		data, err := resp.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		n := r.TruncateBytes
+		if n <= 0 || n >= len(data) {
+			n = len(data) / 2
+		}
+		return nil, &proxy.RawResponse{Data: data[:n]}
+
+	case config.ChaosKill:
+		if k, ok := s.Upstream.(killer); ok {
+			if err := k.Kill(); err != nil {
+				in.logger.Errorf("Failed to kill upstream: %v", err)
+			}
+		} else {
+			in.logger.Warnf("Chaos rule %s cannot kill the upstream of session %s: it has no process of its own", r.Name, s.ID)
+		}
+		return next(ctx, s, req)
+
+	case config.ChaosHTTPError:
+		return nil, &upstream.StatusError{StatusCode: r.Status, Body: http.StatusText(r.Status)}
+	}
+	return next(ctx, s, req)
+}
+
+// matches reports whether the rule applies to a method and, for tools/call, a tool name
+func (r *rule) matches(method, tool string) bool {
+	if len(r.Methods) > 0 && !matchAny(r.Methods, method) {
+		return false
+	}
+	if len(r.Tools) > 0 && (tool == "" || !matchAny(r.Tools, tool)) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetEnabled turns fault injection on or off as a whole
+func (in *Injector) SetEnabled(enabled bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.enabled = enabled
+}
+
+// SetRuleEnabled turns a single rule on or off
+func (in *Injector) SetRuleEnabled(name string, enabled bool) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, r := range in.rules {
+		if r.Name == name {
+			r.enabled = enabled
+			return nil
+		}
+	}
+	return fmt.Errorf("no chaos rule named '%s'", name)
+}
+
+// Status returns the injector's state and per-rule counters
+func (in *Injector) Status() Status {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	status := Status{Enabled: in.enabled, Rules: []RuleStatus{}}
+	for _, r := range in.rules {
+		status.Rules = append(status.Rules, RuleStatus{
+			Name:        r.Name,
+			Type:        r.Type,
+			Enabled:     r.enabled,
+			Probability: r.Probability,
+			Matched:     r.matched,
+			Injected:    r.injected,
+		})
+	}
+	return status
+}
+
+// Register adds the chaos endpoints to the admin interface
+func (in *Injector) Register(srv *admin.Server) {
+	srv.HandleFunc("GET /chaos", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, in.Status())
+	})
+	srv.HandleFunc("POST /chaos/enable", func(w http.ResponseWriter, r *http.Request) {
+		in.SetEnabled(true)
+		admin.WriteJSON(w, http.StatusOK, in.Status())
+	})
+	srv.HandleFunc("POST /chaos/disable", func(w http.ResponseWriter, r *http.Request) {
+		in.SetEnabled(false)
+		admin.WriteJSON(w, http.StatusOK, in.Status())
+	})
+	srv.HandleFunc("POST /chaos/rules/{name}/enable", func(w http.ResponseWriter, r *http.Request) {
+		in.setRule(w, r.PathValue("name"), true)
+	})
+	srv.HandleFunc("POST /chaos/rules/{name}/disable", func(w http.ResponseWriter, r *http.Request) {
+		in.setRule(w, r.PathValue("name"), false)
+	})
+}
+
+func (in *Injector) setRule(w http.ResponseWriter, name string, enabled bool) {
+	if err := in.SetRuleEnabled(name, enabled); err != nil {
+		admin.WriteError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	admin.WriteJSON(w, http.StatusOK, in.Status())
+}
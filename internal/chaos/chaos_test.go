@@ -0,0 +1,257 @@
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// fakeUpstream answers every request with {"ok":true} and counts calls
+type fakeUpstream struct {
+	calls  int
+	killed bool
+}
+
+func (f *fakeUpstream) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	f.calls++
+	if f.killed {
+		return nil, upstream.ErrClosed
+	}
+	return mcp.NewResult(req.ID, map[string]bool{"ok": true})
+}
+
+func (f *fakeUpstream) Send(ctx context.Context, msg *mcp.Message) error { return nil }
+func (f *fakeUpstream) Close() error                                     { return nil }
+func (f *fakeUpstream) Kill() error                                      { f.killed = true; return nil }
+
+func run(t *testing.T, in *Injector, up *fakeUpstream, req *mcp.Message) (*mcp.Message, error) {
+	p := proxy.New(newTestLogger(t))
+	p.Use(in.Middleware())
+	return p.Chain(proxy.Forward)(context.Background(), proxy.NewSession("test", up), req)
+}
+
+func toolCall(t *testing.T, name string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{"name": name})
+	require.NoError(t, err)
+	return req
+}
+
+func TestErrorRuleMatchesToolGlob(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Name: "fail-exec", Type: config.ChaosError, Tools: []string{"execute_*"}, ErrorCode: -32000, ErrorMessage: "boom"},
+	}}, newTestLogger(t))
+	up := &fakeUpstream{}
+
+	_, err := run(t, in, up, toolCall(t, "execute_sql"))
+	var rpcErr *mcp.Error
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, -32000, rpcErr.Code)
+	assert.Equal(t, "boom", rpcErr.Message)
+	assert.Equal(t, 0, up.calls, "error faults are not forwarded")
+
+	resp, err := run(t, in, up, toolCall(t, "list_tables"))
+	require.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, 1, up.calls)
+
+	status := in.Status()
+	assert.Equal(t, int64(1), status.Rules[0].Matched)
+	assert.Equal(t, int64(1), status.Rules[0].Injected)
+}
+
+func TestMethodGlobAndDefaults(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Type: config.ChaosError, Methods: []string{"resources/*"}},
+	}}, newTestLogger(t))
+
+	req, err := mcp.NewRequest(json.RawMessage("1"), "resources/list", nil)
+	require.NoError(t, err)
+	_, err = run(t, in, &fakeUpstream{}, req)
+	var rpcErr *mcp.Error
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, mcp.InternalError, rpcErr.Code)
+	assert.Equal(t, "rule-1", in.Status().Rules[0].Name)
+}
+
+func TestLatencyRule(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Type: config.ChaosLatency, Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond},
+	}}, newTestLogger(t))
+
+	start := time.Now()
+	resp, err := run(t, in, &fakeUpstream{}, toolCall(t, "x"))
+	require.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestDropRule(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Type: config.ChaosDrop},
+	}}, newTestLogger(t))
+	up := &fakeUpstream{}
+
+	resp, err := run(t, in, up, toolCall(t, "x"))
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, 1, up.calls, "dropped requests still reach the upstream")
+}
+
+func TestTruncateRule(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Type: config.ChaosTruncate, TruncateBytes: 10},
+	}}, newTestLogger(t))
+
+	_, err := run(t, in, &fakeUpstream{}, toolCall(t, "x"))
+	var raw *proxy.RawResponse
+	require.True(t, errors.As(err, &raw))
+	assert.Equal(t, `{"jsonrpc"`, string(raw.Data))
+}
+
+func TestKillRule(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Type: config.ChaosKill, After: 1},
+	}}, newTestLogger(t))
+	up := &fakeUpstream{}
+
+	_, err := run(t, in, up, toolCall(t, "x"))
+	require.NoError(t, err)
+	assert.False(t, up.killed)
+
+	_, err = run(t, in, up, toolCall(t, "x"))
+	assert.ErrorIs(t, err, upstream.ErrClosed)
+	assert.True(t, up.killed)
+	assert.Equal(t, int64(1), in.Status().Rules[0].Injected)
+}
+
+// processless is an upstream without a process of its own to kill
+type processless struct{}
+
+func (processless) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	return mcp.NewResult(req.ID, map[string]bool{"ok": true})
+}
+
+func (processless) Send(ctx context.Context, msg *mcp.Message) error { return nil }
+func (processless) Close() error                                     { return nil }
+
+func TestKillRuleWithoutProcess(t *testing.T) {
+	logger := newTestLogger(t)
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Name: "crash", Type: config.ChaosKill},
+	}}, logger)
+	p := proxy.New(logger)
+	p.Use(in.Middleware())
+
+	resp, err := p.Chain(proxy.Forward)(context.Background(), proxy.NewSession("test", processless{}), toolCall(t, "x"))
+	require.NoError(t, err, "the call goes through")
+	assert.JSONEq(t, `{"ok":true}`, string(resp.Result))
+
+	content, err := os.ReadFile(logger.GetFilePath())
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "Chaos rule crash cannot kill the upstream of session test: it has no process of its own")
+}
+
+func TestHTTPErrorRule(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Type: config.ChaosHTTPError, Status: 503},
+	}}, newTestLogger(t))
+
+	_, err := run(t, in, &fakeUpstream{}, toolCall(t, "x"))
+	var status *upstream.StatusError
+	require.True(t, errors.As(err, &status))
+	assert.Equal(t, 503, status.StatusCode)
+}
+
+func TestProbability(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: true, Seed: 42, Rules: []config.ChaosRule{
+		{Type: config.ChaosError, Probability: 0.5},
+	}}, newTestLogger(t))
+
+	for i := 0; i < 200; i++ {
+		run(t, in, &fakeUpstream{}, toolCall(t, "x"))
+	}
+	status := in.Status().Rules[0]
+	assert.Equal(t, int64(200), status.Matched)
+	assert.Greater(t, status.Injected, int64(50))
+	assert.Less(t, status.Injected, int64(150))
+}
+
+func TestToggles(t *testing.T) {
+	in := New(config.ChaosConfig{Enabled: false, Rules: []config.ChaosRule{
+		{Name: "a", Type: config.ChaosError, Disabled: true},
+	}}, newTestLogger(t))
+
+	_, err := run(t, in, &fakeUpstream{}, toolCall(t, "x"))
+	assert.NoError(t, err)
+
+	in.SetEnabled(true)
+	_, err = run(t, in, &fakeUpstream{}, toolCall(t, "x"))
+	assert.NoError(t, err, "rule is still disabled")
+
+	require.NoError(t, in.SetRuleEnabled("a", true))
+	_, err = run(t, in, &fakeUpstream{}, toolCall(t, "x"))
+	assert.Error(t, err)
+
+	assert.Error(t, in.SetRuleEnabled("missing", true))
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	logger := newTestLogger(t)
+	in := New(config.ChaosConfig{Enabled: true, Rules: []config.ChaosRule{
+		{Name: "slow", Type: config.ChaosLatency, Latency: time.Second},
+	}}, logger)
+	srv := admin.New(0, "", logger)
+	in.Register(srv)
+
+	do := func(method, path string) (int, Status) {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		var status Status
+		json.Unmarshal(rec.Body.Bytes(), &status)
+		return rec.Code, status
+	}
+
+	code, status := do(http.MethodGet, "/chaos")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Enabled)
+	require.Len(t, status.Rules, 1)
+	assert.True(t, status.Rules[0].Enabled)
+
+	_, status = do(http.MethodPost, "/chaos/disable")
+	assert.False(t, status.Enabled)
+	_, status = do(http.MethodPost, "/chaos/enable")
+	assert.True(t, status.Enabled)
+
+	_, status = do(http.MethodPost, "/chaos/rules/slow/disable")
+	assert.False(t, status.Rules[0].Enabled)
+	_, status = do(http.MethodPost, "/chaos/rules/slow/enable")
+	assert.True(t, status.Rules[0].Enabled)
+
+	code, _ = do(http.MethodPost, "/chaos/rules/missing/enable")
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,388 @@
+package childpool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// defaultInitialize warms children before any client has initialized
+// through the proxy
+const defaultInitialize = `{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"mcp_sqlpp_proxy","version":"1.0"}}`
+
+// maxReapInterval bounds the time between reaper runs, which also replace
+// children that failed to spawn or exited while idle
+const maxReapInterval = 30 * time.Second
+
+// Pool keeps stdio children of mcp_sqlpp spawned and initialized ahead of
+// the HTTP sessions that will use them, so that a new session does not wait
+// for a child to start and connect to its database. A child serves one
+// session at a time and returns to the pool when the session ends.
+type Pool struct {
+	cfg    config.ChildrenConfig
+	spawn  func(handler upstream.MessageHandler) (upstream.Upstream, error)
+	logger *logging.Logger
+
+	mu       sync.Mutex
+	idle     []*child // Oldest idle first
+	spawning int      // Children being warmed for the idle list
+	params   json.RawMessage
+	closed   bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// child is one mcp_sqlpp process of the pool
+type child struct {
+	up      upstream.Upstream
+	done    <-chan struct{} // Closed when the process exits; nil for upstreams that are not processes
+	handler atomic.Pointer[upstream.MessageHandler]
+
+	// Owned by the pool while idle and by the lease while leased
+	result    json.RawMessage // Result of the child's initialize; nil until initialized
+	version   string          // Protocol version the child was initialized with
+	sessions  int             // Sessions served
+	idleSince time.Time
+}
+
+// New creates a pool of children spawned from exePath. Params of the latest
+// client initialize warm the children spawned after it.
+func New(cfg config.ChildrenConfig, exePath string, logger *logging.Logger) *Pool {
+	return &Pool{
+		cfg: cfg,
+		spawn: func(handler upstream.MessageHandler) (upstream.Upstream, error) {
+			return upstream.StartStdio(upstream.StdioOptions{
+				ExePath: exePath,
+				Handler: handler,
+				Logger:  logger,
+			})
+		},
+		logger: logger,
+		params: json.RawMessage(defaultInitialize),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start warms min-idle children and runs the idle reaper until Close
+func (p *Pool) Start() {
+	p.replenish()
+	interval := maxReapInterval
+	if p.cfg.IdleTimeout > 0 && p.cfg.IdleTimeout < interval {
+		interval = p.cfg.IdleTimeout
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.Reap()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the reaper and closes the idle children. Children held by
+// sessions are closed as their sessions end.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	p.wg.Wait()
+	for _, c := range idle {
+		c.up.Close()
+	}
+	return nil
+}
+
+// Connect implements proxy.Connect, leasing the most recently idle child,
+// or spawning one when none is idle
+func (p *Pool) Connect(handler upstream.MessageHandler) (upstream.Upstream, error) {
+	p.mu.Lock()
+	var c *child
+	for c == nil && len(p.idle) > 0 {
+		c = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !c.alive() {
+			c = nil
+		}
+	}
+	p.mu.Unlock()
+	p.replenish()
+
+	if c == nil {
+		var err error
+		if c, err = p.newChild(); err != nil {
+			return nil, err
+		}
+	}
+	c.handler.Store(&handler)
+	return &lease{pool: p, child: c, handler: handler}, nil
+}
+
+// Reap closes children that have been idle longer than idle-timeout, keeping
+// min-idle, forgets children that exited, and warms children to make up for
+// them
+func (p *Pool) Reap() {
+	p.mu.Lock()
+	var reaped []*child
+	alive := p.idle[:0]
+	for _, c := range p.idle {
+		if c.alive() {
+			alive = append(alive, c)
+		}
+	}
+	p.idle = alive
+	if p.cfg.IdleTimeout > 0 {
+		for len(p.idle) > p.cfg.MinIdle && time.Since(p.idle[0].idleSince) >= p.cfg.IdleTimeout {
+			reaped = append(reaped, p.idle[0])
+			p.idle = p.idle[1:]
+		}
+	}
+	p.mu.Unlock()
+
+	for _, c := range reaped {
+		c.up.Close()
+	}
+	if len(reaped) > 0 {
+		p.logger.Infof("Closed %d children idle for %s", len(reaped), p.cfg.IdleTimeout)
+	}
+	p.replenish()
+}
+
+// replenish warms children in the background until min-idle are idle or warming
+func (p *Pool) replenish() {
+	p.mu.Lock()
+	missing := p.cfg.MinIdle - len(p.idle) - p.spawning
+	if p.closed || missing <= 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.spawning += missing
+	params := p.params
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			c, err := p.warm(params)
+			p.mu.Lock()
+			p.spawning--
+			p.mu.Unlock()
+			if err != nil {
+				p.logger.Errorf("Failed to warm a child: %v", err)
+				return
+			}
+			p.park(c)
+		}()
+	}
+}
+
+// newChild spawns a child, uninitialized
+func (p *Pool) newChild() (*child, error) {
+	c := &child{}
+	up, err := p.spawn(c.deliver)
+	if err != nil {
+		return nil, err
+	}
+	c.up = up
+	if process, ok := up.(interface{ Done() <-chan struct{} }); ok {
+		c.done = process.Done()
+	}
+	return c, nil
+}
+
+// warm spawns a child and initializes it with params
+func (p *Pool) warm(params json.RawMessage) (*child, error) {
+	c, err := p.newChild()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	req := &mcp.Message{JSONRPC: "2.0", ID: json.RawMessage(`"initialize"`), Method: "initialize", Params: params}
+	resp, err := c.up.Call(ctx, req)
+	if err == nil && resp.Error != nil {
+		err = fmt.Errorf("initialize failed: %s", resp.Error.Message)
+	}
+	if err == nil {
+		var initialized *mcp.Message
+		if initialized, err = mcp.NewNotification("notifications/initialized", nil); err == nil {
+			err = c.up.Send(ctx, initialized)
+		}
+	}
+	if err != nil {
+		c.up.Close()
+		return nil, err
+	}
+	c.result = resp.Result
+	c.version = protocolVersion(params)
+	return c, nil
+}
+
+// park makes a child idle, unless enough are idle already
+func (p *Pool) park(c *child) {
+	p.mu.Lock()
+	if p.closed || !c.alive() || len(p.idle) >= p.cfg.MaxIdle {
+		p.mu.Unlock()
+		c.up.Close()
+		return
+	}
+	c.idleSince = time.Now()
+	p.idle = append(p.idle, c)
+	p.mu.Unlock()
+}
+
+// release takes back a child whose session ended. Children that are spent,
+// never initialized or gone are closed instead.
+func (p *Pool) release(c *child) {
+	c.handler.Store(nil)
+	switch {
+	case c.result == nil || !c.alive():
+		c.up.Close()
+	case p.cfg.MaxSessions > 0 && c.sessions >= p.cfg.MaxSessions:
+		p.logger.Infof("Recycling a child after %d sessions", c.sessions)
+		c.up.Close()
+		p.replenish()
+	default:
+		p.park(c)
+	}
+}
+
+// remember keeps the params of a client initialize for warming children
+func (p *Pool) remember(params json.RawMessage) {
+	if len(params) == 0 {
+		return
+	}
+	p.mu.Lock()
+	p.params = params
+	p.mu.Unlock()
+}
+
+// alive reports whether the child's process is still running
+func (c *child) alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// deliver passes a message the child sends on its own initiative to the
+// session holding the child. Idle children have no one to send to.
+func (c *child) deliver(msg *mcp.Message) {
+	if handler := c.handler.Load(); handler != nil {
+		(*handler)(msg)
+	}
+}
+
+// lease is a child held by one session
+type lease struct {
+	pool    *Pool
+	handler upstream.MessageHandler
+
+	mu     sync.Mutex
+	child  *child
+	warm   bool // The session's initialize was answered with the child's own
+	closed bool
+}
+
+// Call implements upstream.Upstream
+func (l *lease) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	if req.Method == "initialize" {
+		return l.initialize(ctx, req)
+	}
+	return l.current().up.Call(ctx, req)
+}
+
+// Send implements upstream.Upstream. A warm child has been told it is
+// initialized already.
+func (l *lease) Send(ctx context.Context, msg *mcp.Message) error {
+	l.mu.Lock()
+	warm, c := l.warm, l.child
+	l.mu.Unlock()
+	if warm && msg.Method == "notifications/initialized" {
+		return nil
+	}
+	return c.up.Send(ctx, msg)
+}
+
+// Close implements upstream.Upstream, returning the child to the pool
+func (l *lease) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	l.pool.release(l.child)
+	return nil
+}
+
+func (l *lease) current() *child {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.child
+}
+
+// initialize answers the session's initialize from a child initialized with
+// the same protocol version. Any other child is swapped for a fresh one,
+// which the client initializes itself.
+func (l *lease) initialize(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	p := l.pool
+	p.remember(req.Params)
+	version := protocolVersion(req.Params)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.child
+	if c.result != nil {
+		if c.version == version {
+			l.warm = true
+			c.sessions++
+			return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: c.result}, nil
+		}
+		fresh, err := p.newChild()
+		if err != nil {
+			return nil, err
+		}
+		fresh.handler.Store(&l.handler)
+		p.release(c)
+		l.child, c = fresh, fresh
+	}
+
+	resp, err := c.up.Call(ctx, req)
+	if err == nil && resp.Error == nil {
+		c.result = resp.Result
+		c.version = version
+		c.sessions++
+	}
+	return resp, err
+}
+
+// protocolVersion returns the protocol version initialize params request
+func protocolVersion(params json.RawMessage) string {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(params, &p)
+	return p.ProtocolVersion
+}
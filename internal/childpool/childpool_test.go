@@ -0,0 +1,228 @@
+package childpool
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// fake is a child that records what it receives and answers initialize with
+// the requested version and tools/call with its number
+type fake struct {
+	n    int
+	done chan struct{}
+
+	mu       sync.Mutex
+	messages []*mcp.Message
+	closed   bool
+}
+
+func (f *fake) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	f.mu.Lock()
+	f.messages = append(f.messages, req)
+	f.mu.Unlock()
+	if req.Method == "initialize" {
+		return mcp.NewResult(req.ID, map[string]string{"protocolVersion": protocolVersion(req.Params)})
+	}
+	return mcp.NewResult(req.ID, map[string]int{"child": f.n})
+}
+
+func (f *fake) Send(ctx context.Context, msg *mcp.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+func (f *fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fake) Done() <-chan struct{} { return f.done }
+
+func (f *fake) methods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var methods []string
+	for _, msg := range f.messages {
+		methods = append(methods, msg.Method)
+	}
+	return methods
+}
+
+func (f *fake) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// newPool creates a pool spawning fakes, recorded in order
+func newPool(t *testing.T, cfg config.ChildrenConfig) (*Pool, func() []*fake) {
+	p := New(cfg, "./mcp_sqlpp", newTestLogger(t))
+	var mu sync.Mutex
+	var fakes []*fake
+	p.spawn = func(handler upstream.MessageHandler) (upstream.Upstream, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		f := &fake{n: len(fakes) + 1, done: make(chan struct{})}
+		fakes = append(fakes, f)
+		return f, nil
+	}
+	return p, func() []*fake {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*fake(nil), fakes...)
+	}
+}
+
+func (p *Pool) idleCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// session connects to the pool and initializes with version
+func session(t *testing.T, p *Pool, version string) (upstream.Upstream, *mcp.Message) {
+	up, err := p.Connect(func(*mcp.Message) {})
+	require.NoError(t, err)
+	req, err := mcp.NewRequest(json.RawMessage("1"), "initialize", map[string]interface{}{"protocolVersion": version, "capabilities": map[string]interface{}{}})
+	require.NoError(t, err)
+	resp, err := up.Call(context.Background(), req)
+	require.NoError(t, err)
+	initialized, err := mcp.NewNotification("notifications/initialized", nil)
+	require.NoError(t, err)
+	require.NoError(t, up.Send(context.Background(), initialized))
+	return up, resp
+}
+
+func call(t *testing.T, up upstream.Upstream) string {
+	req, err := mcp.NewRequest(json.RawMessage("2"), "tools/call", map[string]interface{}{"name": "execute"})
+	require.NoError(t, err)
+	resp, err := up.Call(context.Background(), req)
+	require.NoError(t, err)
+	return string(resp.Result)
+}
+
+func TestWarmSessions(t *testing.T) {
+	p, fakes := newPool(t, config.ChildrenConfig{MinIdle: 2, MaxIdle: 2})
+	p.Start()
+	defer p.Close()
+	require.Eventually(t, func() bool { return p.idleCount() == 2 }, 5*time.Second, time.Millisecond)
+
+	up, resp := session(t, p, "2025-06-18")
+	assert.Equal(t, "1", string(resp.ID))
+	assert.JSONEq(t, `{"protocolVersion":"2025-06-18"}`, string(resp.Result))
+	leased := p.idleCount()
+	var result struct{ Child int }
+	require.NoError(t, json.Unmarshal([]byte(call(t, up)), &result))
+
+	require.Eventually(t, func() bool { return len(fakes()) == 3 && p.idleCount() == 2 }, 5*time.Second, time.Millisecond,
+		"the leased child is replaced in the pool")
+	assert.Equal(t, 1, leased)
+	assert.Equal(t, []string{"initialize", "notifications/initialized", "tools/call"}, fakes()[result.Child-1].methods(),
+		"the client's initialize is answered by the warm child")
+
+	require.NoError(t, up.Close())
+	assert.Equal(t, 2, p.idleCount(), "beyond max-idle, children ending a session are closed")
+	closed := 0
+	for _, f := range fakes() {
+		if f.isClosed() {
+			closed++
+		}
+	}
+	assert.Equal(t, 1, closed)
+}
+
+func TestOtherVersion(t *testing.T) {
+	p, fakes := newPool(t, config.ChildrenConfig{MinIdle: 1, MaxIdle: 2})
+	p.Start()
+	defer p.Close()
+	require.Eventually(t, func() bool { return p.idleCount() == 1 }, 5*time.Second, time.Millisecond)
+
+	up, resp := session(t, p, "2025-03-26")
+	assert.JSONEq(t, `{"protocolVersion":"2025-03-26"}`, string(resp.Result))
+	assert.Equal(t, `{"child":2}`, call(t, up), "a fresh child replaces the warm one")
+	assert.Equal(t, []string{"initialize", "notifications/initialized", "tools/call"}, fakes()[1].methods())
+	assert.Equal(t, []string{"initialize", "notifications/initialized"}, fakes()[0].methods())
+	assert.False(t, fakes()[0].isClosed(), "the warm child goes back to the pool")
+	up.Close()
+
+	p.mu.Lock()
+	assert.Equal(t, "2025-03-26", protocolVersion(p.params), "children are warmed like the latest client initialized")
+	p.mu.Unlock()
+}
+
+func TestMaxSessions(t *testing.T) {
+	p, fakes := newPool(t, config.ChildrenConfig{MinIdle: 0, MaxIdle: 1, MaxSessions: 2})
+	defer p.Close()
+
+	up, _ := session(t, p, "2025-06-18")
+	assert.Equal(t, `{"child":1}`, call(t, up), "a child is spawned when none is idle")
+	up.Close()
+	assert.Equal(t, 1, p.idleCount())
+
+	up, _ = session(t, p, "2025-06-18")
+	assert.Equal(t, `{"child":1}`, call(t, up), "the child serves the next session")
+	up.Close()
+	assert.True(t, fakes()[0].isClosed(), "the child is recycled after max-sessions")
+	assert.Equal(t, 0, p.idleCount())
+	assert.Len(t, fakes(), 1)
+}
+
+func TestReap(t *testing.T) {
+	p, fakes := newPool(t, config.ChildrenConfig{MinIdle: 1, MaxIdle: 3, IdleTimeout: time.Minute})
+	defer p.Close()
+
+	var ups []upstream.Upstream
+	for i := 0; i < 3; i++ {
+		up, _ := session(t, p, "2025-06-18")
+		ups = append(ups, up)
+	}
+	for _, up := range ups {
+		up.Close()
+	}
+	require.Equal(t, 3, p.idleCount())
+
+	p.Reap()
+	assert.Equal(t, 3, p.idleCount(), "children idle for less than idle-timeout are kept")
+
+	p.mu.Lock()
+	for _, c := range p.idle {
+		c.idleSince = time.Now().Add(-time.Hour)
+	}
+	p.mu.Unlock()
+	close(fakes()[2].done)
+	p.Reap()
+	assert.Equal(t, 1, p.idleCount(), "min-idle children are kept")
+	assert.True(t, fakes()[0].isClosed(), "the oldest idle children are closed first")
+	assert.False(t, fakes()[1].isClosed())
+
+	close(fakes()[1].done)
+	up, _ := session(t, p, "2025-06-18")
+	assert.NotEqual(t, `{"child":2}`, call(t, up), "exited children are not leased")
+	up.Close()
+}
@@ -0,0 +1,223 @@
+package coalesce
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+)
+
+// flight is a call in progress upstream that identical calls wait for
+type flight struct {
+	key     string
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters []*waiter // Callers still waiting, guarded by Coalescer.mu
+	resp    *mcp.Message
+	err     error
+}
+
+// waiter is a caller waiting for a flight
+type waiter struct {
+	ctx   context.Context // Carries the caller's connection, see proxy.Notify
+	token json.RawMessage // Progress token of the caller's request; nil without one
+}
+
+// Coalescer is middleware that sends identical concurrent tools/call requests
+// of the configured tools upstream once and hands the result to every caller,
+// each under its own request id. Messages the upstream sends ahead of the
+// result reach every caller still waiting, progress under each caller's own
+// token. Calls whose SQL is not all reads are never coalesced.
+type Coalescer struct {
+	tools     []string
+	extractor *sqlpolicy.Extractor
+	logger    *logging.Logger
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// New creates a coalescer. SQL is looked for in the tool arguments configured
+// for the SQL policy.
+func New(cfg config.CoalesceConfig, tools []config.SQLToolConfig, logger *logging.Logger) *Coalescer {
+	return &Coalescer{
+		tools:     cfg.Tools,
+		extractor: sqlpolicy.NewExtractor(tools),
+		logger:    logger,
+		flights:   make(map[string]*flight),
+	}
+}
+
+// Middleware returns the coalescer as proxy middleware. Calls that joined
+// another caller's request are recorded with the "coalesced" note.
+func (c *Coalescer) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method != "tools/call" {
+				return next(ctx, s, req)
+			}
+			call, err := mcp.ParseToolCall(req)
+			if err != nil || !c.coalesces(call) {
+				return next(ctx, s, req)
+			}
+			args, err := mcp.Canonicalize(call.Arguments)
+			if err != nil {
+				return next(ctx, s, req)
+			}
+			// Endpoints reach upstreams of their own, so their calls never meet
+			key := proxy.Endpoint(ctx) + "\x00" + call.Name + "\x00" + args
+
+			w := &waiter{ctx: ctx, token: progressToken(req)}
+			c.mu.Lock()
+			f, joined := c.flights[key]
+			if !joined {
+				// The upstream call outlives the caller that started it as long
+				// as anyone is still waiting for it, and what the upstream sends
+				// ahead of the result goes to all of them
+				flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+				f = &flight{key: key, done: make(chan struct{}), cancel: cancel}
+				c.flights[key] = f
+				go c.run(proxy.WithSender(flightCtx, c.fanOut(f)), f, next, s, req)
+			} else {
+				c.logger.Debugf("Coalesced call to tool %s with a call in progress", call.Name)
+				proxy.Note(ctx, "coalesced", true)
+			}
+			f.waiters = append(f.waiters, w)
+			c.mu.Unlock()
+
+			select {
+			case <-f.done:
+				return respond(f, req)
+			case <-ctx.Done():
+				c.leave(f, w)
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
+
+// run makes the upstream call of a flight and releases its waiters
+func (c *Coalescer) run(ctx context.Context, f *flight, next proxy.Handler, s *proxy.Session, req *mcp.Message) {
+	f.resp, f.err = next(ctx, s, req)
+	c.mu.Lock()
+	c.forget(f)
+	c.mu.Unlock()
+	f.cancel()
+	close(f.done)
+}
+
+// leave gives up waiting for a flight and cancels it when nobody else waits,
+// so that later calls start a new one
+func (c *Coalescer) leave(f *flight, w *waiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+	if len(f.waiters) == 0 {
+		c.forget(f)
+		f.cancel()
+	}
+}
+
+// fanOut returns a sender that passes a message the upstream sends ahead of
+// the result of a flight on to every caller still waiting. Progress goes to
+// the callers that asked for it, under their own tokens.
+func (c *Coalescer) fanOut(f *flight) proxy.Sender {
+	return func(msg *mcp.Message) error {
+		c.mu.Lock()
+		waiters := append([]*waiter(nil), f.waiters...)
+		c.mu.Unlock()
+		for _, w := range waiters {
+			out := msg
+			if msg.Method == "notifications/progress" {
+				if w.token == nil {
+					continue
+				}
+				out = withProgressToken(msg, w.token)
+			}
+			if err := proxy.Notify(w.ctx, out); err != nil {
+				c.logger.Debugf("Dropped %s for a coalesced call: %v", msg.Method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// forget stops new calls from joining a flight. The caller holds c.mu.
+func (c *Coalescer) forget(f *flight) {
+	if c.flights[f.key] == f {
+		delete(c.flights, f.key)
+	}
+}
+
+// respond returns the result of a flight under the id of one of its requests
+func respond(f *flight, req *mcp.Message) (*mcp.Message, error) {
+	if f.err != nil || f.resp == nil {
+		return f.resp, f.err
+	}
+	resp := f.resp.Clone()
+	resp.ID = append(json.RawMessage(nil), req.ID...)
+	return resp, nil
+}
+
+// progressToken returns the progress token of a request, or nil
+func progressToken(req *mcp.Message) json.RawMessage {
+	var params struct {
+		Meta struct {
+			Token json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal(req.Params, &params) != nil {
+		return nil
+	}
+	return params.Meta.Token
+}
+
+// withProgressToken returns a progress notification under another token
+func withProgressToken(msg *mcp.Message, token json.RawMessage) *mcp.Message {
+	var params map[string]json.RawMessage
+	if json.Unmarshal(msg.Params, &params) != nil {
+		return msg
+	}
+	params["progressToken"] = token
+	data, err := json.Marshal(params)
+	if err != nil {
+		return msg
+	}
+	out := msg.Clone()
+	out.Params = data
+	return out
+}
+
+// coalesces reports whether calls like this one may share a request: the tool
+// is configured and all SQL in the arguments is reads
+func (c *Coalescer) coalesces(call *mcp.ToolCall) bool {
+	matched := false
+	for _, pattern := range c.tools {
+		if ok, _ := path.Match(pattern, call.Name); ok {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	for _, text := range c.extractor.Find(call) {
+		for _, stmt := range sqlpolicy.Parse(text.SQL) {
+			if stmt.Class != sqlpolicy.ClassSelect {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,303 @@
+package coalesce
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func newCoalescer(t *testing.T, tools ...string) *Coalescer {
+	sqlTools := []config.SQLToolConfig{{Name: "execute_sql", Arguments: []string{"sql"}}}
+	return New(config.CoalesceConfig{Tools: tools}, sqlTools, newTestLogger(t))
+}
+
+// upstream answers tools/call requests once released, counting the calls
+type upstream struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func newUpstream() *upstream {
+	return &upstream{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (u *upstream) handle(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+	n := u.calls.Add(1)
+	u.started <- struct{}{}
+	select {
+	case <-u.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	result := fmt.Sprintf(`{"content":[{"type":"text","text":"call %d"}]}`, n)
+	return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(result)}, nil
+}
+
+func toolCall(t *testing.T, id int, name string, args map[string]interface{}) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage(fmt.Sprint(id)), "tools/call", map[string]interface{}{"name": name, "arguments": args})
+	require.NoError(t, err)
+	return req
+}
+
+type outcome struct {
+	resp *mcp.Message
+	err  error
+}
+
+// start sends a call through the handler in the background
+func start(ctx context.Context, handler proxy.Handler, req *mcp.Message) <-chan outcome {
+	done := make(chan outcome, 1)
+	go func() {
+		resp, err := handler(ctx, proxy.NewSession("test", nil), req)
+		done <- outcome{resp, err}
+	}()
+	return done
+}
+
+// waitFor waits until n callers wait for a flight
+func waitFor(t *testing.T, c *Coalescer, n int) {
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		total := 0
+		for _, f := range c.flights {
+			total += len(f.waiters)
+		}
+		return total == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestIdenticalCallsShareARequest(t *testing.T) {
+	c := newCoalescer(t, "execute_*")
+	u := newUpstream()
+	handler := c.Middleware()(u.handle)
+
+	first := start(context.Background(), handler, toolCall(t, 1, "execute_sql", map[string]interface{}{"sql": "SELECT 1", "limit": 5}))
+	<-u.started
+	ctx, notes := proxy.WithNotes(context.Background())
+	second := start(ctx, handler, toolCall(t, 2, "execute_sql", map[string]interface{}{"limit": 5, "sql": "SELECT 1"}))
+	waitFor(t, c, 2)
+	close(u.release)
+
+	a, b := <-first, <-second
+	require.NoError(t, a.err)
+	require.NoError(t, b.err)
+	assert.Equal(t, int32(1), u.calls.Load())
+	assert.Equal(t, "1", string(a.resp.ID))
+	assert.Equal(t, "2", string(b.resp.ID), "each caller gets its own id")
+	assert.JSONEq(t, string(a.resp.Result), string(b.resp.Result))
+	assert.Equal(t, map[string]interface{}{"coalesced": true}, notes.All())
+
+	// Once answered, the same call goes upstream again
+	third := start(context.Background(), handler, toolCall(t, 3, "execute_sql", map[string]interface{}{"sql": "SELECT 1", "limit": 5}))
+	<-u.started
+	require.NoError(t, (<-third).err)
+	assert.Equal(t, int32(2), u.calls.Load())
+}
+
+func TestCallsNotCoalesced(t *testing.T) {
+	c := newCoalescer(t, "execute_sql", "list_*")
+	u := newUpstream()
+	close(u.release)
+	handler := c.Middleware()(u.handle)
+
+	calls := []*mcp.Message{
+		toolCall(t, 1, "execute_sql", map[string]interface{}{"sql": "DELETE FROM t"}),
+		toolCall(t, 2, "execute_sql", map[string]interface{}{"sql": "DELETE FROM t"}),
+		toolCall(t, 3, "describe_table", map[string]interface{}{"table": "t"}),
+		toolCall(t, 4, "describe_table", map[string]interface{}{"table": "t"}),
+		toolCall(t, 5, "list_tables", map[string]interface{}{"schema": "a"}),
+		toolCall(t, 6, "list_tables", map[string]interface{}{"schema": "b"}),
+	}
+	for _, call := range calls {
+		resp, err := handler(context.Background(), proxy.NewSession("test", nil), call)
+		require.NoError(t, err)
+		assert.Equal(t, string(call.ID), string(resp.ID))
+	}
+	assert.Equal(t, int32(len(calls)), u.calls.Load())
+	assert.Empty(t, c.flights)
+
+	// Writes, other tools and other arguments never wait for a call in progress
+	u = newUpstream()
+	handler = c.Middleware()(u.handle)
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		wg.Add(1)
+		go func(call *mcp.Message) {
+			defer wg.Done()
+			_, err := handler(context.Background(), proxy.NewSession("test", nil), call)
+			assert.NoError(t, err)
+		}(call)
+	}
+	for range calls {
+		<-u.started
+	}
+	close(u.release)
+	wg.Wait()
+	assert.Equal(t, int32(len(calls)), u.calls.Load())
+}
+
+func TestCancellation(t *testing.T) {
+	c := newCoalescer(t, "list_tables")
+	u := newUpstream()
+	handler := c.Middleware()(u.handle)
+	call := func(id int) *mcp.Message { return toolCall(t, id, "list_tables", nil) }
+
+	// The caller that started the request leaves; the other still gets the result
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	first := start(leaderCtx, handler, call(1))
+	<-u.started
+	second := start(context.Background(), handler, call(2))
+	waitFor(t, c, 2)
+	cancelLeader()
+	assert.ErrorIs(t, (<-first).err, context.Canceled)
+	close(u.release)
+	b := <-second
+	require.NoError(t, b.err)
+	assert.Equal(t, "2", string(b.resp.ID))
+	assert.Equal(t, int32(1), u.calls.Load())
+
+	// When every caller leaves, the upstream request is cancelled and later
+	// calls start a new one
+	u = newUpstream()
+	handler = c.Middleware()(u.handle)
+	ctx, cancel := context.WithCancel(context.Background())
+	third := start(ctx, handler, call(3))
+	<-u.started
+	cancel()
+	assert.ErrorIs(t, (<-third).err, context.Canceled)
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.flights) == 0
+	}, time.Second, time.Millisecond)
+
+	close(u.release)
+	resp, err := handler(context.Background(), proxy.NewSession("test", nil), call(4))
+	require.NoError(t, err)
+	assert.Equal(t, "4", string(resp.ID))
+	assert.Equal(t, int32(2), u.calls.Load())
+}
+
+func TestErrorsAreShared(t *testing.T) {
+	c := newCoalescer(t, "list_tables")
+	release := make(chan struct{})
+	var calls atomic.Int32
+	handler := c.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		calls.Add(1)
+		<-release
+		return nil, mcp.NewError(mcp.InternalError, "upstream failed")
+	})
+
+	first := start(context.Background(), handler, toolCall(t, 1, "list_tables", nil))
+	waitFor(t, c, 1)
+	second := start(context.Background(), handler, toolCall(t, 2, "list_tables", nil))
+	waitFor(t, c, 2)
+	close(release)
+
+	for _, o := range []outcome{<-first, <-second} {
+		var rpcErr *mcp.Error
+		require.ErrorAs(t, o.err, &rpcErr)
+		assert.Equal(t, mcp.InternalError, rpcErr.Code)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestHTTPLeaderLeaves(t *testing.T) {
+	// The upstream reports progress, then again with the result once released
+	release := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Params struct {
+				Meta struct {
+					Token json.RawMessage `json:"progressToken"`
+				} `json:"_meta"`
+			} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "text/event-stream")
+		progress := fmt.Sprintf(`{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":%s,"progress":%%d}}`, req.Params.Meta.Token)
+		fmt.Fprintf(w, "data: "+progress+"\n\n", 1)
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprintf(w, "data: "+progress+"\n\n", 2)
+		fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"id\":%s,\"result\":{\"content\":[]}}\n\n", req.ID)
+	}))
+	defer up.Close()
+	c := newCoalescer(t, "list_tables")
+	p := proxy.New(newTestLogger(t))
+	p.Use(c.Middleware())
+	front := httptest.NewServer(proxy.NewHTTPServer(p, up.URL))
+	defer front.Close()
+
+	call := func(ctx context.Context, id int, token string) *http.Response {
+		body := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"list_tables","_meta":{"progressToken":%q}}}`, id, token)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, front.URL+"/mcp", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := call(leaderCtx, 1, "a")
+	var first *mcp.Event
+	mcp.ReadEvents(bufio.NewReader(leader.Body), func(e *mcp.Event) error {
+		first = e
+		return context.Canceled
+	})
+	require.NotNil(t, first)
+	assert.Contains(t, first.Data, `"progressToken":"a"`)
+
+	second := make(chan []*mcp.Event, 1)
+	go func() {
+		resp := call(context.Background(), 2, "b")
+		defer resp.Body.Close()
+		var events []*mcp.Event
+		mcp.ReadEvents(resp.Body, func(e *mcp.Event) error {
+			events = append(events, e)
+			return nil
+		})
+		second <- events
+	}()
+	waitFor(t, c, 2)
+	cancelLeader()
+	leader.Body.Close()
+	waitFor(t, c, 1)
+	close(release)
+
+	events := <-second
+	require.Len(t, events, 2)
+	assert.Contains(t, events[0].Data, `"progressToken":"b"`, "progress reaches the caller still waiting, under its token")
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":2,"result":{"content":[]}}`, events[1].Data)
+}
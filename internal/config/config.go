@@ -2,7 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
 
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -14,15 +19,493 @@ type Config struct {
 	Port      int    `mapstructure:"port" yaml:"port" json:"port" toml:"port"`
 	XferPort  int    `mapstructure:"xfer-port" yaml:"xfer-port" json:"xfer-port" toml:"xfer-port"`
 	ExePath   string `mapstructure:"exe-path" yaml:"exe-path" json:"exe-path" toml:"exe-path"`
+
+	// Several upstreams fronted as one, replacing exe-path and xfer-port
+	Upstreams []UpstreamConfig `mapstructure:"upstreams" yaml:"upstreams" json:"upstreams" toml:"upstreams"`
+	// Equivalent HTTP upstreams sharing the load, replacing xfer-port
+	Pool PoolConfig `mapstructure:"pool" yaml:"pool" json:"pool" toml:"pool"`
+	// HTTP endpoints served under path prefixes, replacing xfer-port
+	Endpoints []EndpointConfig `mapstructure:"endpoints" yaml:"endpoints" json:"endpoints" toml:"endpoints"`
+	// HTTP sessions held by the proxy, each on a stdio child of exe-path, replacing xfer-port
+	Children ChildrenConfig `mapstructure:"children" yaml:"children" json:"children" toml:"children"`
+	// Tracking of the sessions relayed to xfer-port or the pool in http mode
+	Sessions SessionsConfig `mapstructure:"sessions" yaml:"sessions" json:"sessions" toml:"sessions"`
+	// Resumption of client event streams in http mode
+	Resumption ResumptionConfig `mapstructure:"resumption" yaml:"resumption" json:"resumption" toml:"resumption"`
+
+	Replay ReplayConfig `mapstructure:"replay" yaml:"replay" json:"replay" toml:"replay"`
+	Admin  AdminConfig  `mapstructure:"admin" yaml:"admin" json:"admin" toml:"admin"`
+	Chaos  ChaosConfig  `mapstructure:"chaos" yaml:"chaos" json:"chaos" toml:"chaos"`
+
+	Validation   ValidationConfig   `mapstructure:"validation" yaml:"validation" json:"validation" toml:"validation"`
+	Bridge       BridgeConfig       `mapstructure:"protocol-bridge" yaml:"protocol-bridge" json:"protocol-bridge" toml:"protocol-bridge"`
+	ToolFilter   ToolFilterConfig   `mapstructure:"tool-filter" yaml:"tool-filter" json:"tool-filter" toml:"tool-filter"`
+	ToolRewrite  ToolRewriteConfig  `mapstructure:"tool-rewrite" yaml:"tool-rewrite" json:"tool-rewrite" toml:"tool-rewrite"`
+	SQLPolicy    SQLPolicyConfig    `mapstructure:"sql-policy" yaml:"sql-policy" json:"sql-policy" toml:"sql-policy"`
+	Approval     ApprovalConfig     `mapstructure:"approval" yaml:"approval" json:"approval" toml:"approval"`
+	Confirmation ConfirmationConfig `mapstructure:"confirmation" yaml:"confirmation" json:"confirmation" toml:"confirmation"`
+	Audit        AuditConfig        `mapstructure:"audit" yaml:"audit" json:"audit" toml:"audit"`
+	Limits       LimitsConfig       `mapstructure:"limits" yaml:"limits" json:"limits" toml:"limits"`
+	Redaction    RedactionConfig    `mapstructure:"redaction" yaml:"redaction" json:"redaction" toml:"redaction"`
+	Masking      MaskingConfig      `mapstructure:"masking" yaml:"masking" json:"masking" toml:"masking"`
+	Cache        CacheConfig        `mapstructure:"cache" yaml:"cache" json:"cache" toml:"cache"`
+	Coalesce     CoalesceConfig     `mapstructure:"coalesce" yaml:"coalesce" json:"coalesce" toml:"coalesce"`
+	Routing      RoutingConfig      `mapstructure:"routing" yaml:"routing" json:"routing" toml:"routing"`
+	RWSplit      RWSplitConfig      `mapstructure:"read-write-split" yaml:"read-write-split" json:"read-write-split" toml:"read-write-split"`
+}
+
+// UpstreamConfig is one of several MCP servers behind the proxy, either spawned
+// over stdio or reached over Streamable HTTP
+type UpstreamConfig struct {
+	Name    string   `mapstructure:"name" yaml:"name" json:"name" toml:"name"`                 // Also marks the upstream's resource URIs as <name>+<uri>
+	Prefix  string   `mapstructure:"prefix" yaml:"prefix" json:"prefix" toml:"prefix"`         // Prepended to tool and prompt names; defaults to <name>_
+	ExePath string   `mapstructure:"exe-path" yaml:"exe-path" json:"exe-path" toml:"exe-path"` // Executable to spawn in stdio mode
+	Args    []string `mapstructure:"args" yaml:"args" json:"args" toml:"args"`                 // Defaults to -t stdio
+	Env     []string `mapstructure:"env" yaml:"env" json:"env" toml:"env"`                     // Extra environment variables (KEY=value)
+	URL     string   `mapstructure:"url" yaml:"url" json:"url" toml:"url"`                     // Streamable HTTP endpoint, instead of exe-path
+}
+
+// NamePrefix returns the prefix of the upstream's tool and prompt names
+func (u UpstreamConfig) NamePrefix() string {
+	if u.Prefix != "" {
+		return u.Prefix
+	}
+	return u.Name + "_"
+}
+
+// ReplayConfig holds settings for the replay-server and replay-client modes
+type ReplayConfig struct {
+	File string `mapstructure:"file" yaml:"file" json:"file" toml:"file"`
+
+	// replay-server settings
+	Match  string        `mapstructure:"match" yaml:"match" json:"match" toml:"match"`
+	Timing string        `mapstructure:"timing" yaml:"timing" json:"timing" toml:"timing"`
+	Delay  time.Duration `mapstructure:"delay" yaml:"delay" json:"delay" toml:"delay"`
+
+	// replay-client settings
+	URL            string        `mapstructure:"url" yaml:"url" json:"url" toml:"url"`
+	Report         string        `mapstructure:"report" yaml:"report" json:"report" toml:"report"`
+	Ignore         []string      `mapstructure:"ignore" yaml:"ignore" json:"ignore" toml:"ignore"`
+	IgnorePatterns []string      `mapstructure:"ignore-patterns" yaml:"ignore-patterns" json:"ignore-patterns" toml:"ignore-patterns"`
+	Timeout        time.Duration `mapstructure:"timeout" yaml:"timeout" json:"timeout" toml:"timeout"`
+}
+
+// AdminConfig holds settings for the admin HTTP interface
+type AdminConfig struct {
+	Port  int    `mapstructure:"port" yaml:"port" json:"port" toml:"port"`     // 0 disables the admin interface
+	Token string `mapstructure:"token" yaml:"token" json:"token" toml:"token"` // Bearer token admin requests must send; empty requires none
+}
+
+// ValidationConfig holds settings for passive protocol validation
+type ValidationConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled" json:"enabled" toml:"enabled"`
+}
+
+// BridgeConfig holds settings for bridging clients and upstreams that
+// negotiate different protocol versions
+type BridgeConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled" json:"enabled" toml:"enabled"`
+}
+
+// ToolFilterConfig holds the rules that decide which tools each principal may see and call
+type ToolFilterConfig struct {
+	Default string           `mapstructure:"default" yaml:"default" json:"default" toml:"default"` // Access of principals no rule matches; empty denies
+	Rules   []ToolFilterRule `mapstructure:"rules" yaml:"rules" json:"rules" toml:"rules"`
+}
+
+// ToolFilterRule restricts the tools available to the principals it matches.
+// A tool is available if it matches an allow pattern (or allow is empty) and
+// matches no deny pattern. All patterns are globs.
+type ToolFilterRule struct {
+	Principals []string `mapstructure:"principals" yaml:"principals" json:"principals" toml:"principals"` // Empty matches every principal
+	Clients    []string `mapstructure:"clients" yaml:"clients" json:"clients" toml:"clients"`             // No longer supported, as clients name themselves
+	Allow      []string `mapstructure:"allow" yaml:"allow" json:"allow" toml:"allow"`
+	Deny       []string `mapstructure:"deny" yaml:"deny" json:"deny" toml:"deny"`
+}
+
+// ToolRewriteConfig changes how upstream tools are presented to clients
+type ToolRewriteConfig struct {
+	Prefix string         `mapstructure:"prefix" yaml:"prefix" json:"prefix" toml:"prefix"` // Prepended to every tool name clients see
+	Tools  []ToolOverride `mapstructure:"tools" yaml:"tools" json:"tools" toml:"tools"`
+}
+
+// ToolOverride changes the name and descriptions of one upstream tool
+type ToolOverride struct {
+	Name        string         `mapstructure:"name" yaml:"name" json:"name" toml:"name"`                             // Upstream tool name
+	Rename      string         `mapstructure:"rename" yaml:"rename" json:"rename" toml:"rename"`                     // Name clients see, before the prefix
+	Description string         `mapstructure:"description" yaml:"description" json:"description" toml:"description"` // Replaces the tool description
+	Arguments   []ToolArgument `mapstructure:"arguments" yaml:"arguments" json:"arguments" toml:"arguments"`
+}
+
+// ToolArgument replaces the description of a tool argument in the input schema
+type ToolArgument struct {
+	Name        string `mapstructure:"name" yaml:"name" json:"name" toml:"name"`
+	Description string `mapstructure:"description" yaml:"description" json:"description" toml:"description"`
+}
+
+// Enabled reports whether any tool is presented differently
+func (c ToolRewriteConfig) Enabled() bool {
+	return c.Prefix != "" || len(c.Tools) > 0
+}
+
+// SQLPolicyConfig holds the rules applied to SQL found in tools/call arguments
+type SQLPolicyConfig struct {
+	ReadOnly bool            `mapstructure:"read-only" yaml:"read-only" json:"read-only" toml:"read-only"`
+	Tools    []SQLToolConfig `mapstructure:"tools" yaml:"tools" json:"tools" toml:"tools"` // Empty searches "sql" and "query" of every tool
+	ACL      SQLACLConfig    `mapstructure:"acl" yaml:"acl" json:"acl" toml:"acl"`
+}
+
+// SQLACLConfig restricts the tables and schemas each principal may reference
+type SQLACLConfig struct {
+	DefaultSchema string       `mapstructure:"default-schema" yaml:"default-schema" json:"default-schema" toml:"default-schema"` // Schema assumed for unqualified table names
+	Default       string       `mapstructure:"default" yaml:"default" json:"default" toml:"default"`                             // Access of principals no rule matches; empty denies
+	Rules         []SQLACLRule `mapstructure:"rules" yaml:"rules" json:"rules" toml:"rules"`
+}
+
+// SQLACLRule restricts the tables available to the principals it matches. A
+// table is available if it matches an allow pattern (or allow is empty) and
+// matches no deny pattern. Patterns are globs over lower-case schema.table names.
+type SQLACLRule struct {
+	Name       string   `mapstructure:"name" yaml:"name" json:"name" toml:"name"`
+	Principals []string `mapstructure:"principals" yaml:"principals" json:"principals" toml:"principals"` // Empty matches every principal
+	Clients    []string `mapstructure:"clients" yaml:"clients" json:"clients" toml:"clients"`             // No longer supported, as clients name themselves
+	Allow      []string `mapstructure:"allow" yaml:"allow" json:"allow" toml:"allow"`
+	Deny       []string `mapstructure:"deny" yaml:"deny" json:"deny" toml:"deny"`
+}
+
+// Access of principals that no access rule matches
+const (
+	AccessDeny  = "deny"
+	AccessAllow = "allow"
+)
+
+// Enabled reports whether any SQL policy is configured
+func (c SQLPolicyConfig) Enabled() bool {
+	return c.ReadOnly || len(c.ACL.Rules) > 0
+}
+
+// SQLToolConfig names the arguments of the tools matching a glob that hold SQL.
+// Arguments are dotted paths in which * matches any key or array index.
+type SQLToolConfig struct {
+	Name      string   `mapstructure:"name" yaml:"name" json:"name" toml:"name"`
+	Arguments []string `mapstructure:"arguments" yaml:"arguments" json:"arguments" toml:"arguments"`
+}
+
+// ApprovalConfig holds tools/call requests with SQL of the listed statement
+// classes until an approver approves or rejects them through the admin
+// interface
+type ApprovalConfig struct {
+	Classes          []string      `mapstructure:"classes" yaml:"classes" json:"classes" toml:"classes"`                                         // dml, ddl, dcl, tcl or other; empty disables the gate
+	Timeout          time.Duration `mapstructure:"timeout" yaml:"timeout" json:"timeout" toml:"timeout"`                                         // Held calls are rejected after this long
+	ProgressInterval time.Duration `mapstructure:"progress-interval" yaml:"progress-interval" json:"progress-interval" toml:"progress-interval"` // Between progress notifications to clients that asked for them; 0 sends none
+}
+
+// Enabled reports whether any calls are held for approval
+func (c ApprovalConfig) Enabled() bool {
+	return len(c.Classes) > 0
+}
+
+// ConfirmationConfig asks the user, through the client's elicitation support,
+// to confirm tools/call requests with SQL of the listed statement classes
+type ConfirmationConfig struct {
+	Classes []string      `mapstructure:"classes" yaml:"classes" json:"classes" toml:"classes"` // dml, ddl, dcl, tcl or other; empty disables confirmation
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout" json:"timeout" toml:"timeout"` // Unanswered calls are rejected after this long
+}
+
+// Enabled reports whether any calls need confirmation
+func (c ConfirmationConfig) Enabled() bool {
+	return len(c.Classes) > 0
+}
+
+// AuditConfig holds the settings of the SQL audit log
+type AuditConfig struct {
+	File              string   `mapstructure:"file" yaml:"file" json:"file" toml:"file"`                                                         // JSON lines file; empty disables auditing
+	DatabaseArguments []string `mapstructure:"database-arguments" yaml:"database-arguments" json:"database-arguments" toml:"database-arguments"` // Arguments naming the database or connection
+}
+
+// Actions taken when a tools/call result exceeds a limit
+const (
+	LimitTruncate = "truncate" // Cut the result down and add a notice to its content
+	LimitError    = "error"    // Replace the result with a tool error
+)
+
+// LimitsConfig bounds the size of tools/call results returned to clients. Zero disables a limit.
+type LimitsConfig struct {
+	MaxBytes int    `mapstructure:"max-bytes" yaml:"max-bytes" json:"max-bytes" toml:"max-bytes"` // Bytes of the encoded result
+	MaxRows  int    `mapstructure:"max-rows" yaml:"max-rows" json:"max-rows" toml:"max-rows"`     // Rows of each JSON row set in the result
+	MaxItems int    `mapstructure:"max-items" yaml:"max-items" json:"max-items" toml:"max-items"` // Content blocks
+	Action   string `mapstructure:"action" yaml:"action" json:"action" toml:"action"`
+}
+
+// Enabled reports whether any limit is set
+func (c LimitsConfig) Enabled() bool {
+	return c.MaxBytes > 0 || c.MaxRows > 0 || c.MaxItems > 0
+}
+
+// EndpointConfig serves one upstream under a path prefix in HTTP mode, with
+// its own clients and policies
+type EndpointConfig struct {
+	Prefix     string           `mapstructure:"prefix" yaml:"prefix" json:"prefix" toml:"prefix"`                     // e.g. /analytics
+	Target     string           `mapstructure:"target" yaml:"target" json:"target" toml:"target"`                     // Base URL of the upstream, e.g. http://localhost:8891
+	KeepPrefix bool             `mapstructure:"keep-prefix" yaml:"keep-prefix" json:"keep-prefix" toml:"keep-prefix"` // Forward paths with the prefix instead of stripping it
+	Tokens     []EndpointToken  `mapstructure:"tokens" yaml:"tokens" json:"tokens" toml:"tokens"`                     // Bearer tokens accepted; empty requires none
+	ToolFilter ToolFilterConfig `mapstructure:"tool-filter" yaml:"tool-filter" json:"tool-filter" toml:"tool-filter"`
+	SQLPolicy  SQLPolicyConfig  `mapstructure:"sql-policy" yaml:"sql-policy" json:"sql-policy" toml:"sql-policy"`
+}
+
+// EndpointToken is a bearer token accepted by an endpoint. The name identifies
+// its holder as the principal of their sessions.
+type EndpointToken struct {
+	Name  string `mapstructure:"name" yaml:"name" json:"name" toml:"name"`
+	Token string `mapstructure:"token" yaml:"token" json:"token" toml:"token"`
+}
+
+// Load balancing strategies
+const (
+	BalanceRoundRobin    = "round-robin"     // Instances take turns
+	BalanceLeastInFlight = "least-in-flight" // The instance with the fewest exchanges in progress
+)
+
+// PoolConfig configures a pool of equivalent mcp_sqlpp instances that HTTP
+// mode balances new sessions across
+type PoolConfig struct {
+	Targets         []string          `mapstructure:"targets" yaml:"targets" json:"targets" toml:"targets"` // Base URLs, e.g. http://db1:8891
+	Strategy        string            `mapstructure:"strategy" yaml:"strategy" json:"strategy" toml:"strategy"`
+	Affinity        bool              `mapstructure:"affinity" yaml:"affinity" json:"affinity" toml:"affinity"`                                 // Keep each MCP session on the instance that assigned it
+	AffinityTimeout time.Duration     `mapstructure:"affinity-timeout" yaml:"affinity-timeout" json:"affinity-timeout" toml:"affinity-timeout"` // Forget the instance of a session without requests for this long
+	HealthCheck     HealthCheckConfig `mapstructure:"health-check" yaml:"health-check" json:"health-check" toml:"health-check"`
+}
+
+// HealthCheckConfig configures the MCP pings that eject failing pool
+// instances and readmit them once they recover
+type HealthCheckConfig struct {
+	Path               string        `mapstructure:"path" yaml:"path" json:"path" toml:"path"`                                                             // MCP endpoint path on each instance
+	Interval           time.Duration `mapstructure:"interval" yaml:"interval" json:"interval" toml:"interval"`                                             // 0 disables health checks
+	Timeout            time.Duration `mapstructure:"timeout" yaml:"timeout" json:"timeout" toml:"timeout"`                                                 // Per ping
+	UnhealthyThreshold int           `mapstructure:"unhealthy-threshold" yaml:"unhealthy-threshold" json:"unhealthy-threshold" toml:"unhealthy-threshold"` // Consecutive failures that eject an instance
+	HealthyThreshold   int           `mapstructure:"healthy-threshold" yaml:"healthy-threshold" json:"healthy-threshold" toml:"healthy-threshold"`         // Consecutive successes that readmit it
+}
+
+// Enabled reports whether HTTP mode balances across a pool
+func (c PoolConfig) Enabled() bool {
+	return len(c.Targets) > 0
+}
+
+// ChildrenConfig makes http mode hold MCP sessions itself, each backed by a
+// stdio child spawned from exe-path. Children are kept warm in a pool and
+// serve several sessions in turn.
+type ChildrenConfig struct {
+	Enabled     bool          `mapstructure:"enabled" yaml:"enabled" json:"enabled" toml:"enabled"`
+	MinIdle     int           `mapstructure:"min-idle" yaml:"min-idle" json:"min-idle" toml:"min-idle"`                 // Initialized children kept ready for new sessions
+	MaxIdle     int           `mapstructure:"max-idle" yaml:"max-idle" json:"max-idle" toml:"max-idle"`                 // Children ending a session beyond this many idle ones are closed
+	IdleTimeout time.Duration `mapstructure:"idle-timeout" yaml:"idle-timeout" json:"idle-timeout" toml:"idle-timeout"` // Idle children beyond min-idle are closed after this long; 0 never
+	MaxSessions int           `mapstructure:"max-sessions" yaml:"max-sessions" json:"max-sessions" toml:"max-sessions"` // Sessions a child serves before it is replaced; 0 means no limit
+}
+
+// SessionsConfig controls how http mode keeps the MCP sessions it relays,
+// known by their Mcp-Session-Id. The idle timeout applies to every http mode,
+// including the sessions the proxy holds itself; per-session logs only to
+// xfer-port and the pool.
+type SessionsConfig struct {
+	IdleTimeout time.Duration `mapstructure:"idle-timeout" yaml:"idle-timeout" json:"idle-timeout" toml:"idle-timeout"` // Sessions without traffic are ended after this long; 0 never
+	LogDir      string        `mapstructure:"log-dir" yaml:"log-dir" json:"log-dir" toml:"log-dir"`                     // Directory for a log file per session; empty logs sessions to the main log
+}
+
+// ResumptionConfig makes http mode give the server-sent events it streams to
+// clients ids of its own and keep them, so that a client reconnecting with
+// Last-Event-ID is sent the events it missed
+type ResumptionConfig struct {
+	Enabled   bool          `mapstructure:"enabled" yaml:"enabled" json:"enabled" toml:"enabled"`
+	MaxBytes  int           `mapstructure:"max-bytes" yaml:"max-bytes" json:"max-bytes" toml:"max-bytes"` // Event data kept in memory per stream; older events are spilled or dropped
+	SpillDir  string        `mapstructure:"spill-dir" yaml:"spill-dir" json:"spill-dir" toml:"spill-dir"` // Directory older events are written to instead of being dropped; empty drops them
+	Retention time.Duration `mapstructure:"retention" yaml:"retention" json:"retention" toml:"retention"` // How long a stream is kept after it ends
+}
+
+// Cache scopes
+const (
+	CacheGlobal  = "global"  // Entries are shared by all sessions
+	CacheSession = "session" // Entries are kept per session
+)
+
+// CacheConfig configures the cache of tools/call results of read tools
+type CacheConfig struct {
+	MaxEntries    int         `mapstructure:"max-entries" yaml:"max-entries" json:"max-entries" toml:"max-entries"`                 // 0 disables the cache
+	MaxEntryBytes int         `mapstructure:"max-entry-bytes" yaml:"max-entry-bytes" json:"max-entry-bytes" toml:"max-entry-bytes"` // Larger results are not cached; 0 means no limit
+	Scope         string      `mapstructure:"scope" yaml:"scope" json:"scope" toml:"scope"`
+	PurgeOnWrite  bool        `mapstructure:"purge-on-write" yaml:"purge-on-write" json:"purge-on-write" toml:"purge-on-write"` // Purge the cache when SQL that is not a read passes
+	Tools         []CacheTool `mapstructure:"tools" yaml:"tools" json:"tools" toml:"tools"`
+}
+
+// CacheTool makes the results of matching tools cacheable for a time
+type CacheTool struct {
+	Name string        `mapstructure:"name" yaml:"name" json:"name" toml:"name"` // Tool name glob
+	TTL  time.Duration `mapstructure:"ttl" yaml:"ttl" json:"ttl" toml:"ttl"`
+}
+
+// Enabled reports whether any results can be cached
+func (c CacheConfig) Enabled() bool {
+	return c.MaxEntries > 0 && len(c.Tools) > 0
+}
+
+// CoalesceConfig configures the coalescing of identical concurrent tools/call
+// requests into a single upstream request
+type CoalesceConfig struct {
+	Tools []string `mapstructure:"tools" yaml:"tools" json:"tools" toml:"tools"` // Tool name globs; empty disables coalescing
+}
+
+// RoutingConfig configures the routing of tools/call requests to upstreams
+// selected by an argument of the call, such as the connection
+type RoutingConfig struct {
+	Arguments   []string      `mapstructure:"arguments" yaml:"arguments" json:"arguments" toml:"arguments"` // Arguments that select a route; the first present applies
+	Routes      []RouteConfig `mapstructure:"routes" yaml:"routes" json:"routes" toml:"routes"`
+	MaxBackends int           `mapstructure:"max-backends" yaml:"max-backends" json:"max-backends" toml:"max-backends"` // Upstreams open at once over all routes; 0 means no limit
+	IdleTimeout time.Duration `mapstructure:"idle-timeout" yaml:"idle-timeout" json:"idle-timeout" toml:"idle-timeout"` // Upstreams without calls for this long are closed; 0 keeps them
+	InitTimeout time.Duration `mapstructure:"init-timeout" yaml:"init-timeout" json:"init-timeout" toml:"init-timeout"` // Limit on connecting and initializing an upstream
+}
+
+// RouteConfig sends calls whose selecting argument matches to an upstream
+// spawned or connected on first use. In args, env and url, {value} stands
+// for the argument's value, so that one route can serve several databases,
+// each from its own upstream.
+type RouteConfig struct {
+	Name    string   `mapstructure:"name" yaml:"name" json:"name" toml:"name"`
+	Match   []string `mapstructure:"match" yaml:"match" json:"match" toml:"match"`             // Globs of the argument value
+	ExePath string   `mapstructure:"exe-path" yaml:"exe-path" json:"exe-path" toml:"exe-path"` // Executable spawned over stdio
+	Args    []string `mapstructure:"args" yaml:"args" json:"args" toml:"args"`                 // Defaults to -t stdio
+	Env     []string `mapstructure:"env" yaml:"env" json:"env" toml:"env"`                     // Extra environment variables (KEY=value)
+	URL     string   `mapstructure:"url" yaml:"url" json:"url" toml:"url"`                     // Streamable HTTP endpoint, instead of exe-path
+}
+
+// RWSplitConfig sends tools/call requests whose SQL only reads to an upstream
+// pointed at a read replica. Writes and everything else go to the usual
+// upstream, the primary, as do reads while the replica is unhealthy.
+type RWSplitConfig struct {
+	Replica           ReplicaConfig     `mapstructure:"replica" yaml:"replica" json:"replica" toml:"replica"`
+	PrimaryAfterWrite time.Duration     `mapstructure:"primary-after-write" yaml:"primary-after-write" json:"primary-after-write" toml:"primary-after-write"` // Reads of a session stay on the primary this long after it writes
+	HealthCheck       HealthCheckConfig `mapstructure:"health-check" yaml:"health-check" json:"health-check" toml:"health-check"`                             // path is not used
+}
+
+// ReplicaConfig is the upstream serving reads, spawned over stdio or reached
+// over Streamable HTTP
+type ReplicaConfig struct {
+	ExePath string   `mapstructure:"exe-path" yaml:"exe-path" json:"exe-path" toml:"exe-path"` // Executable spawned over stdio
+	Args    []string `mapstructure:"args" yaml:"args" json:"args" toml:"args"`                 // Defaults to -t stdio
+	Env     []string `mapstructure:"env" yaml:"env" json:"env" toml:"env"`                     // Extra environment variables (KEY=value)
+	URL     string   `mapstructure:"url" yaml:"url" json:"url" toml:"url"`                     // Streamable HTTP endpoint, instead of exe-path
+}
+
+// Enabled reports whether reads are split off to a replica
+func (c RWSplitConfig) Enabled() bool {
+	return c.Replica.ExePath != "" || c.Replica.URL != ""
+}
+
+// Masking strategies for sensitive values in tool results
+const (
+	MaskHash    = "hash"    // Replace with a keyed hash, so equal values stay equal
+	MaskPartial = "partial" // Keep the last few letters and digits, star the rest
+	MaskNull    = "null"    // Replace with null
+)
+
+// DetectSSN detects US social security numbers written as 123-45-6789
+const DetectSSN = "ssn"
+
+// MaskDetectors lists the value detectors available to masking rules
+var MaskDetectors = []string{DetectSSN, DetectEmail, DetectCard}
+
+// MaskingConfig masks sensitive values in the tools/call results returned to clients
+type MaskingConfig struct {
+	Salt  string        `mapstructure:"salt" yaml:"salt" json:"salt" toml:"salt"` // Key of the hash strategy
+	Rules []MaskingRule `mapstructure:"rules" yaml:"rules" json:"rules" toml:"rules"`
+}
+
+// MaskingRule masks the values of matching columns, or values a detector recognizes
+type MaskingRule struct {
+	Name      string   `mapstructure:"name" yaml:"name" json:"name" toml:"name"`
+	Tools     []string `mapstructure:"tools" yaml:"tools" json:"tools" toml:"tools"`                 // Tool name globs; empty matches every tool
+	Columns   []string `mapstructure:"columns" yaml:"columns" json:"columns" toml:"columns"`         // Column name globs, case-insensitive
+	Detectors []string `mapstructure:"detectors" yaml:"detectors" json:"detectors" toml:"detectors"` // Value detectors
+	Strategy  string   `mapstructure:"strategy" yaml:"strategy" json:"strategy" toml:"strategy"`
+	Reveal    int      `mapstructure:"reveal" yaml:"reveal" json:"reveal" toml:"reveal"` // Characters left visible by the partial strategy
+}
+
+// Built-in detectors of sensitive values in logged traffic
+const (
+	DetectEmail       = "email"        // Email addresses
+	DetectCard        = "card"         // Payment card numbers that pass the Luhn check
+	DetectBearer      = "bearer"       // Bearer and Basic authorization credentials
+	DetectDSNPassword = "dsn-password" // Passwords in connection URLs and key=value connection strings
+)
+
+// Detectors lists the built-in detectors
+var Detectors = []string{DetectEmail, DetectCard, DetectBearer, DetectDSNPassword}
+
+// RedactionConfig controls what traffic reaches the log file
+type RedactionConfig struct {
+	Enabled      bool     `mapstructure:"enabled" yaml:"enabled" json:"enabled" toml:"enabled"`
+	MetadataOnly bool     `mapstructure:"metadata-only" yaml:"metadata-only" json:"metadata-only" toml:"metadata-only"` // Log message ids, methods and sizes instead of bodies
+	Detectors    []string `mapstructure:"detectors" yaml:"detectors" json:"detectors" toml:"detectors"`                 // Built-in detectors to apply
+	Paths        []string `mapstructure:"paths" yaml:"paths" json:"paths" toml:"paths"`                                 // JSON paths whose values are masked
+	Patterns     []string `mapstructure:"patterns" yaml:"patterns" json:"patterns" toml:"patterns"`                     // Regular expressions whose matches are masked
+	Mask         string   `mapstructure:"mask" yaml:"mask" json:"mask" toml:"mask"`
 }
 
+// Active reports whether logged traffic is rewritten at all
+func (c RedactionConfig) Active() bool {
+	return c.Enabled || c.MetadataOnly
+}
+
+// ChaosConfig holds fault injection settings
+type ChaosConfig struct {
+	Enabled bool        `mapstructure:"enabled" yaml:"enabled" json:"enabled" toml:"enabled"`
+	Seed    int64       `mapstructure:"seed" yaml:"seed" json:"seed" toml:"seed"`
+	Rules   []ChaosRule `mapstructure:"rules" yaml:"rules" json:"rules" toml:"rules"`
+}
+
+// ChaosRule describes a fault injected into matching requests
+type ChaosRule struct {
+	Name        string   `mapstructure:"name" yaml:"name" json:"name" toml:"name"`
+	Type        string   `mapstructure:"type" yaml:"type" json:"type" toml:"type"`
+	Methods     []string `mapstructure:"methods" yaml:"methods" json:"methods" toml:"methods"`
+	Tools       []string `mapstructure:"tools" yaml:"tools" json:"tools" toml:"tools"`
+	Probability float64  `mapstructure:"probability" yaml:"probability" json:"probability" toml:"probability"`
+	Disabled    bool     `mapstructure:"disabled" yaml:"disabled" json:"disabled" toml:"disabled"`
+
+	Latency       time.Duration `mapstructure:"latency" yaml:"latency" json:"latency" toml:"latency"`
+	Jitter        time.Duration `mapstructure:"jitter" yaml:"jitter" json:"jitter" toml:"jitter"`
+	ErrorCode     int           `mapstructure:"error-code" yaml:"error-code" json:"error-code" toml:"error-code"`
+	ErrorMessage  string        `mapstructure:"error-message" yaml:"error-message" json:"error-message" toml:"error-message"`
+	TruncateBytes int           `mapstructure:"truncate-bytes" yaml:"truncate-bytes" json:"truncate-bytes" toml:"truncate-bytes"`
+	After         int           `mapstructure:"after" yaml:"after" json:"after" toml:"after"`
+	Status        int           `mapstructure:"status" yaml:"status" json:"status" toml:"status"`
+}
+
+// Chaos rule types
+const (
+	ChaosLatency   = "latency"
+	ChaosDrop      = "drop"
+	ChaosError     = "error"
+	ChaosTruncate  = "truncate"
+	ChaosKill      = "kill"
+	ChaosHTTPError = "http-error"
+)
+
 // Flags represents command-line flags
 type Flags struct {
-	ConfigFile *string
-	Transport  *string
-	Port       *int
-	XferPort   *int
-	ExePath    *string
+	ConfigFile      *string
+	Transport       *string
+	Port            *int
+	XferPort        *int
+	ExePath         *string
+	ReplayFile      *string
+	AdminPort       *int
+	Validate        *bool
+	ReadOnly        *bool
+	AuditFile       *string
+	MaxBytes        *int
+	MaxRows         *int
+	Redact          *bool
+	LogMetadataOnly *bool
+	Args            []string // Positional arguments, e.g. approvals list
 }
 
 // DefaultConfig returns a Config struct with default values
@@ -32,19 +515,105 @@ func DefaultConfig() *Config {
 		Port:      8099,
 		XferPort:  8891,
 		ExePath:   "./mcp_sqlpp",
+		Replay: ReplayConfig{
+			Match:   "normalized",
+			Timing:  "none",
+			Timeout: 30 * time.Second,
+		},
+		Chaos: ChaosConfig{
+			Enabled: true,
+		},
+		Approval: ApprovalConfig{
+			Timeout:          5 * time.Minute,
+			ProgressInterval: 10 * time.Second,
+		},
+		Confirmation: ConfirmationConfig{
+			Timeout: 2 * time.Minute,
+		},
+		ToolFilter: ToolFilterConfig{
+			Default: AccessDeny,
+		},
+		SQLPolicy: SQLPolicyConfig{
+			ACL: SQLACLConfig{Default: AccessDeny},
+		},
+		Audit: AuditConfig{
+			DatabaseArguments: []string{"database", "connection"},
+		},
+		Limits: LimitsConfig{
+			Action: LimitTruncate,
+		},
+		Routing: RoutingConfig{
+			Arguments:   []string{"connection", "database"},
+			MaxBackends: 16,
+			IdleTimeout: 10 * time.Minute,
+			InitTimeout: 30 * time.Second,
+		},
+		Children: ChildrenConfig{
+			MinIdle:     2,
+			MaxIdle:     4,
+			IdleTimeout: 5 * time.Minute,
+			MaxSessions: 100,
+		},
+		Sessions: SessionsConfig{
+			IdleTimeout: 30 * time.Minute,
+		},
+		Resumption: ResumptionConfig{
+			MaxBytes:  1 << 20,
+			Retention: 5 * time.Minute,
+		},
+		RWSplit: RWSplitConfig{
+			PrimaryAfterWrite: 5 * time.Second,
+			HealthCheck: HealthCheckConfig{
+				Interval:           10 * time.Second,
+				Timeout:            2 * time.Second,
+				UnhealthyThreshold: 3,
+				HealthyThreshold:   2,
+			},
+		},
+		Pool: PoolConfig{
+			Strategy:        BalanceRoundRobin,
+			Affinity:        true,
+			AffinityTimeout: time.Hour,
+			HealthCheck: HealthCheckConfig{
+				Path:               "/mcp",
+				Interval:           10 * time.Second,
+				Timeout:            2 * time.Second,
+				UnhealthyThreshold: 3,
+				HealthyThreshold:   2,
+			},
+		},
+		Cache: CacheConfig{
+			MaxEntryBytes: 1 << 20,
+			Scope:         CacheGlobal,
+			PurgeOnWrite:  true,
+		},
+		Redaction: RedactionConfig{
+			Detectors: Detectors,
+			Mask:      "[REDACTED]",
+		},
 	}
 }
 
 // ParseFlags parses command-line flags and returns a Flags struct
 func ParseFlags() *Flags {
 	flags := &Flags{
-		ConfigFile: flag.String("config", "", "Path to config file (yaml/json/toml)"),
-		Transport:  flag.StringP("transport", "t", "", "Transport mode: stdio or http"),
-		Port:       flag.IntP("port", "p", 0, "Port to listen on (HTTP mode)"),
-		XferPort:   flag.IntP("xfer-port", "x", 0, "Port where mcp_sqlpp is running (HTTP mode)"),
-		ExePath:    flag.StringP("exe-path", "e", "", "Path to the mcp_sqlpp executable"),
+		ConfigFile:      flag.String("config", "", "Path to config file (yaml/json/toml)"),
+		Transport:       flag.StringP("transport", "t", "", "Transport mode: stdio, http, replay-server or replay-client"),
+		Port:            flag.IntP("port", "p", 0, "Port to listen on (HTTP mode)"),
+		XferPort:        flag.IntP("xfer-port", "x", 0, "Port where mcp_sqlpp is running (HTTP mode)"),
+		ExePath:         flag.StringP("exe-path", "e", "", "Path to the mcp_sqlpp executable"),
+		ReplayFile:      flag.String("replay-file", "", "Path to a recorded traffic log (replay modes)"),
+		AdminPort:       flag.Int("admin-port", 0, "Port for the admin interface on localhost (0 disables it)"),
+		Validate:        flag.Bool("validate", false, "Validate traffic against the MCP schema and log violations"),
+		ReadOnly:        flag.Bool("read-only", false, "Reject tool calls whose SQL is not read-only"),
+		AuditFile:       flag.String("audit-file", "", "Append a JSON lines audit record for every SQL tool call to this file"),
+		MaxBytes:        flag.Int("max-result-bytes", 0, "Limit tools/call results to this many bytes (0 disables the limit)"),
+		MaxRows:         flag.Int("max-result-rows", 0, "Limit each row set in tools/call results to this many rows (0 disables the limit)"),
+		Redact:          flag.Bool("redact", false, "Mask emails, card numbers, credentials and connection string passwords in logged traffic"),
+		LogMetadataOnly: flag.Bool("log-metadata-only", false, "Log message ids, methods and sizes instead of traffic bodies"),
 	}
 	flag.Parse()
+	flags.Args = flag.Args()
 	return flags
 }
 
@@ -60,6 +629,52 @@ func LoadConfig(flags *Flags) (*Config, error) {
 	viper.SetDefault("port", defaults.Port)
 	viper.SetDefault("xfer-port", defaults.XferPort)
 	viper.SetDefault("exe-path", defaults.ExePath)
+	viper.SetDefault("replay.match", defaults.Replay.Match)
+	viper.SetDefault("replay.timing", defaults.Replay.Timing)
+	viper.SetDefault("replay.delay", defaults.Replay.Delay)
+	viper.SetDefault("replay.timeout", defaults.Replay.Timeout)
+	viper.SetDefault("admin.port", defaults.Admin.Port)
+	viper.SetDefault("chaos.enabled", defaults.Chaos.Enabled)
+	viper.SetDefault("validation.enabled", defaults.Validation.Enabled)
+	viper.SetDefault("protocol-bridge.enabled", defaults.Bridge.Enabled)
+	viper.SetDefault("tool-filter.default", defaults.ToolFilter.Default)
+	viper.SetDefault("sql-policy.read-only", defaults.SQLPolicy.ReadOnly)
+	viper.SetDefault("sql-policy.acl.default", defaults.SQLPolicy.ACL.Default)
+	viper.SetDefault("approval.timeout", defaults.Approval.Timeout)
+	viper.SetDefault("approval.progress-interval", defaults.Approval.ProgressInterval)
+	viper.SetDefault("confirmation.timeout", defaults.Confirmation.Timeout)
+	viper.SetDefault("audit.database-arguments", defaults.Audit.DatabaseArguments)
+	viper.SetDefault("limits.action", defaults.Limits.Action)
+	viper.SetDefault("redaction.detectors", defaults.Redaction.Detectors)
+	viper.SetDefault("routing.arguments", defaults.Routing.Arguments)
+	viper.SetDefault("routing.max-backends", defaults.Routing.MaxBackends)
+	viper.SetDefault("routing.idle-timeout", defaults.Routing.IdleTimeout)
+	viper.SetDefault("routing.init-timeout", defaults.Routing.InitTimeout)
+	viper.SetDefault("read-write-split.primary-after-write", defaults.RWSplit.PrimaryAfterWrite)
+	viper.SetDefault("read-write-split.health-check.interval", defaults.RWSplit.HealthCheck.Interval)
+	viper.SetDefault("read-write-split.health-check.timeout", defaults.RWSplit.HealthCheck.Timeout)
+	viper.SetDefault("read-write-split.health-check.unhealthy-threshold", defaults.RWSplit.HealthCheck.UnhealthyThreshold)
+	viper.SetDefault("read-write-split.health-check.healthy-threshold", defaults.RWSplit.HealthCheck.HealthyThreshold)
+	viper.SetDefault("pool.strategy", defaults.Pool.Strategy)
+	viper.SetDefault("pool.affinity", defaults.Pool.Affinity)
+	viper.SetDefault("pool.affinity-timeout", defaults.Pool.AffinityTimeout)
+	viper.SetDefault("pool.health-check.path", defaults.Pool.HealthCheck.Path)
+	viper.SetDefault("pool.health-check.interval", defaults.Pool.HealthCheck.Interval)
+	viper.SetDefault("pool.health-check.timeout", defaults.Pool.HealthCheck.Timeout)
+	viper.SetDefault("pool.health-check.unhealthy-threshold", defaults.Pool.HealthCheck.UnhealthyThreshold)
+	viper.SetDefault("pool.health-check.healthy-threshold", defaults.Pool.HealthCheck.HealthyThreshold)
+	viper.SetDefault("children.min-idle", defaults.Children.MinIdle)
+	viper.SetDefault("children.max-idle", defaults.Children.MaxIdle)
+	viper.SetDefault("children.idle-timeout", defaults.Children.IdleTimeout)
+	viper.SetDefault("children.max-sessions", defaults.Children.MaxSessions)
+	viper.SetDefault("sessions.idle-timeout", defaults.Sessions.IdleTimeout)
+	viper.SetDefault("resumption.max-bytes", defaults.Resumption.MaxBytes)
+	viper.SetDefault("resumption.retention", defaults.Resumption.Retention)
+	viper.SetDefault("sessions.log-dir", defaults.Sessions.LogDir)
+	viper.SetDefault("cache.max-entry-bytes", defaults.Cache.MaxEntryBytes)
+	viper.SetDefault("cache.scope", defaults.Cache.Scope)
+	viper.SetDefault("cache.purge-on-write", defaults.Cache.PurgeOnWrite)
+	viper.SetDefault("redaction.mask", defaults.Redaction.Mask)
 
 	// Bind environment variables with automatic env var name mapping
 	viper.SetEnvPrefix("MCP_PROXY")
@@ -70,6 +685,26 @@ func LoadConfig(flags *Flags) (*Config, error) {
 	viper.BindEnv("port", "MCP_PROXY_PORT")
 	viper.BindEnv("xfer-port", "MCP_PROXY_XFER_PORT")
 	viper.BindEnv("exe-path", "MCP_PROXY_EXE_PATH")
+	viper.BindEnv("replay.file", "MCP_PROXY_REPLAY_FILE")
+	viper.BindEnv("replay.match", "MCP_PROXY_REPLAY_MATCH")
+	viper.BindEnv("replay.timing", "MCP_PROXY_REPLAY_TIMING")
+	viper.BindEnv("replay.url", "MCP_PROXY_REPLAY_URL")
+	viper.BindEnv("replay.report", "MCP_PROXY_REPLAY_REPORT")
+	viper.BindEnv("admin.port", "MCP_PROXY_ADMIN_PORT")
+	viper.BindEnv("admin.token", "MCP_PROXY_ADMIN_TOKEN")
+	viper.BindEnv("chaos.enabled", "MCP_PROXY_CHAOS_ENABLED")
+	viper.BindEnv("validation.enabled", "MCP_PROXY_VALIDATION_ENABLED")
+	viper.BindEnv("sql-policy.read-only", "MCP_PROXY_SQL_POLICY_READ_ONLY")
+	viper.BindEnv("audit.file", "MCP_PROXY_AUDIT_FILE")
+	viper.BindEnv("limits.max-bytes", "MCP_PROXY_LIMITS_MAX_BYTES")
+	viper.BindEnv("limits.max-rows", "MCP_PROXY_LIMITS_MAX_ROWS")
+	viper.BindEnv("limits.max-items", "MCP_PROXY_LIMITS_MAX_ITEMS")
+	viper.BindEnv("limits.action", "MCP_PROXY_LIMITS_ACTION")
+	viper.BindEnv("redaction.enabled", "MCP_PROXY_REDACTION_ENABLED")
+	viper.BindEnv("redaction.metadata-only", "MCP_PROXY_REDACTION_METADATA_ONLY")
+	viper.BindEnv("masking.salt", "MCP_PROXY_MASKING_SALT")
+	viper.BindEnv("cache.max-entries", "MCP_PROXY_CACHE_MAX_ENTRIES")
+	viper.BindEnv("cache.scope", "MCP_PROXY_CACHE_SCOPE")
 
 	// Load config file if provided
 	if *flags.ConfigFile != "" {
@@ -106,6 +741,33 @@ func LoadConfig(flags *Flags) (*Config, error) {
 	if *flags.ExePath != "" {
 		viper.Set("exe-path", *flags.ExePath)
 	}
+	if flags.ReplayFile != nil && *flags.ReplayFile != "" {
+		viper.Set("replay.file", *flags.ReplayFile)
+	}
+	if flags.AdminPort != nil && *flags.AdminPort != 0 {
+		viper.Set("admin.port", *flags.AdminPort)
+	}
+	if flags.Validate != nil && *flags.Validate {
+		viper.Set("validation.enabled", true)
+	}
+	if flags.ReadOnly != nil && *flags.ReadOnly {
+		viper.Set("sql-policy.read-only", true)
+	}
+	if flags.AuditFile != nil && *flags.AuditFile != "" {
+		viper.Set("audit.file", *flags.AuditFile)
+	}
+	if flags.MaxBytes != nil && *flags.MaxBytes != 0 {
+		viper.Set("limits.max-bytes", *flags.MaxBytes)
+	}
+	if flags.MaxRows != nil && *flags.MaxRows != 0 {
+		viper.Set("limits.max-rows", *flags.MaxRows)
+	}
+	if flags.Redact != nil && *flags.Redact {
+		viper.Set("redaction.enabled", true)
+	}
+	if flags.LogMetadataOnly != nil && *flags.LogMetadataOnly {
+		viper.Set("redaction.metadata-only", true)
+	}
 
 	// Unmarshal configuration into struct
 	var config Config
@@ -124,8 +786,10 @@ func LoadConfig(flags *Flags) (*Config, error) {
 // ValidateConfig validates the configuration values
 func ValidateConfig(config *Config) error {
 	// Validate transport mode
-	if config.Transport != "stdio" && config.Transport != "http" {
-		return fmt.Errorf("invalid transport mode '%s': must be 'stdio' or 'http'", config.Transport)
+	switch config.Transport {
+	case "stdio", "http", "replay-server", "replay-client":
+	default:
+		return fmt.Errorf("invalid transport mode '%s': must be 'stdio', 'http', 'replay-server' or 'replay-client'", config.Transport)
 	}
 
 	// Validate ports for HTTP mode
@@ -133,18 +797,60 @@ func ValidateConfig(config *Config) error {
 		if config.Port <= 0 || config.Port > 65535 {
 			return fmt.Errorf("invalid port %d: must be between 1 and 65535", config.Port)
 		}
-		if config.XferPort <= 0 || config.XferPort > 65535 {
-			return fmt.Errorf("invalid xfer-port %d: must be between 1 and 65535", config.XferPort)
-		}
-		if config.Port == config.XferPort {
-			return fmt.Errorf("port (%d) and xfer-port (%d) cannot be the same", config.Port, config.XferPort)
+		if len(config.Upstreams) == 0 && !config.Pool.Enabled() && len(config.Endpoints) == 0 && !config.Children.Enabled {
+			if config.XferPort <= 0 || config.XferPort > 65535 {
+				return fmt.Errorf("invalid xfer-port %d: must be between 1 and 65535", config.XferPort)
+			}
+			if config.Port == config.XferPort {
+				return fmt.Errorf("port (%d) and xfer-port (%d) cannot be the same", config.Port, config.XferPort)
+			}
 		}
 	}
 
-	// Validate executable path exists for stdio mode
-	if config.Transport == "stdio" {
+	if err := validateUpstreams(config.Upstreams); err != nil {
+		return err
+	}
+
+	if err := validatePoolConfig(&config.Pool); err != nil {
+		return err
+	}
+	if config.Pool.Enabled() && len(config.Upstreams) > 0 {
+		return fmt.Errorf("pool and upstreams cannot both be set")
+	}
+
+	if err := validateEndpoints(config.Endpoints); err != nil {
+		return err
+	}
+	if len(config.Endpoints) > 0 && (config.Pool.Enabled() || len(config.Upstreams) > 0) {
+		return fmt.Errorf("endpoints cannot be combined with pool or upstreams")
+	}
+
+	if err := validateChildrenConfig(&config.Children); err != nil {
+		return err
+	}
+	if config.Children.Enabled && (config.Pool.Enabled() || len(config.Upstreams) > 0 || len(config.Endpoints) > 0) {
+		return fmt.Errorf("children cannot be combined with pool, upstreams or endpoints")
+	}
+
+	if err := validateSessionsConfig(&config.Sessions); err != nil {
+		return err
+	}
+	if config.Sessions.LogDir != "" && (config.Children.Enabled || len(config.Upstreams) > 0 || len(config.Endpoints) > 0) {
+		return fmt.Errorf("sessions.log-dir applies to sessions relayed to xfer-port or the pool, not to children, upstreams or endpoints")
+	}
+
+	if err := validateResumptionConfig(&config.Resumption); err != nil {
+		return err
+	}
+	if config.Resumption.Enabled && len(config.Endpoints) > 0 {
+		return fmt.Errorf("resumption cannot be combined with endpoints")
+	}
+
+	// Validate executable path exists for stdio mode, and for http mode when
+	// it spawns children
+	if (config.Transport == "stdio" && len(config.Upstreams) == 0) || (config.Transport == "http" && config.Children.Enabled) {
 		if config.ExePath == "" {
-			return fmt.Errorf("exe-path cannot be empty for stdio transport mode")
+			return fmt.Errorf("exe-path cannot be empty for %s transport mode", config.Transport)
 		}
 		// Check if executable exists and is executable
 		if _, err := os.Stat(config.ExePath); os.IsNotExist(err) {
@@ -152,6 +858,742 @@ func ValidateConfig(config *Config) error {
 		}
 	}
 
+	// Validate recording and replay options for the replay modes
+	if config.Transport == "replay-server" {
+		if err := validateReplayServerConfig(&config.Replay); err != nil {
+			return err
+		}
+	}
+	if config.Transport == "replay-client" {
+		if err := validateReplayClientConfig(config); err != nil {
+			return err
+		}
+	}
+
+	// Validate the admin interface port
+	if config.Admin.Port < 0 || config.Admin.Port > 65535 {
+		return fmt.Errorf("invalid admin.port %d: must be between 0 and 65535", config.Admin.Port)
+	}
+	if config.Admin.Port != 0 && config.Transport == "http" && config.Admin.Port == config.Port {
+		return fmt.Errorf("admin.port (%d) and port (%d) cannot be the same", config.Admin.Port, config.Port)
+	}
+
+	if err := validateChaosConfig(&config.Chaos, config.Transport); err != nil {
+		return err
+	}
+
+	if err := validateToolFilterConfig(&config.ToolFilter); err != nil {
+		return err
+	}
+
+	if err := validateToolRewriteConfig(&config.ToolRewrite); err != nil {
+		return err
+	}
+
+	if err := validateSQLPolicyConfig(&config.SQLPolicy); err != nil {
+		return err
+	}
+
+	if err := validateApprovalConfig(&config.Approval); err != nil {
+		return err
+	}
+	if config.Approval.Enabled() && config.Admin.Port == 0 {
+		return fmt.Errorf("approval requires admin.port, as calls are approved through the admin interface")
+	}
+	if config.Approval.Enabled() && config.Admin.Token == "" {
+		return fmt.Errorf("approval requires admin.token, as any local process could otherwise approve calls, the client's included")
+	}
+
+	if err := validateConfirmationConfig(&config.Confirmation); err != nil {
+		return err
+	}
+
+	if err := validateLimitsConfig(&config.Limits); err != nil {
+		return err
+	}
+
+	if err := validateRedactionConfig(&config.Redaction); err != nil {
+		return err
+	}
+
+	if err := validateMaskingConfig(&config.Masking); err != nil {
+		return err
+	}
+
+	if err := validateCacheConfig(&config.Cache); err != nil {
+		return err
+	}
+
+	if err := validateGlobs(config.Coalesce.Tools); err != nil {
+		return fmt.Errorf("coalesce: %w", err)
+	}
+
+	if err := validateRoutingConfig(&config.Routing); err != nil {
+		return err
+	}
+
+	if err := validateRWSplitConfig(&config.RWSplit); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateToolFilterConfig checks the default access and that every tool
+// filter pattern is a valid glob
+func validateToolFilterConfig(filter *ToolFilterConfig) error {
+	switch filter.Default {
+	case "", AccessDeny, AccessAllow:
+	default:
+		return fmt.Errorf("invalid tool-filter.default '%s': must be one of deny, allow", filter.Default)
+	}
+	for i, rule := range filter.Rules {
+		if len(rule.Clients) > 0 {
+			return fmt.Errorf("tool-filter rule #%d: clients is no longer supported, match principals instead", i+1)
+		}
+		if err := validateGlobs(rule.Principals, rule.Allow, rule.Deny); err != nil {
+			return fmt.Errorf("tool-filter rule #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// toolNamePattern matches the characters allowed in tool names
+var toolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+
+// validateToolRewriteConfig checks the prefix and that the tool overrides
+// leave every tool with a distinct name
+func validateToolRewriteConfig(rewrite *ToolRewriteConfig) error {
+	if rewrite.Prefix != "" && !toolNamePattern.MatchString(rewrite.Prefix) {
+		return fmt.Errorf("invalid tool-rewrite.prefix '%s': only letters, digits, '_', '-', '.' and '/' are allowed", rewrite.Prefix)
+	}
+	names := make(map[string]bool)
+	renames := make(map[string]string)
+	for i, tool := range rewrite.Tools {
+		if tool.Name == "" {
+			return fmt.Errorf("tool-rewrite tool #%d: name cannot be empty", i+1)
+		}
+		if names[tool.Name] {
+			return fmt.Errorf("duplicate tool-rewrite tool '%s'", tool.Name)
+		}
+		names[tool.Name] = true
+		for j, arg := range tool.Arguments {
+			if arg.Name == "" {
+				return fmt.Errorf("tool-rewrite tool '%s': argument #%d: name cannot be empty", tool.Name, j+1)
+			}
+		}
+		if tool.Rename == "" {
+			continue
+		}
+		if !toolNamePattern.MatchString(tool.Rename) {
+			return fmt.Errorf("tool-rewrite tool '%s': invalid rename '%s'", tool.Name, tool.Rename)
+		}
+		if other, ok := renames[tool.Rename]; ok {
+			return fmt.Errorf("tool-rewrite tools '%s' and '%s' are both renamed to '%s'", other, tool.Name, tool.Rename)
+		}
+		renames[tool.Rename] = tool.Name
+	}
+	for _, tool := range rewrite.Tools {
+		if other, ok := renames[tool.Name]; ok && tool.Rename == "" {
+			return fmt.Errorf("tool-rewrite tool '%s' is renamed to '%s', which tool '%s' keeps", other, tool.Name, tool.Name)
+		}
+	}
+	return nil
+}
+
+// validateSQLPolicyConfig checks the tools whose arguments hold SQL
+func validateSQLPolicyConfig(policy *SQLPolicyConfig) error {
+	for i, tool := range policy.Tools {
+		if tool.Name == "" {
+			return fmt.Errorf("sql-policy tool #%d: name cannot be empty", i+1)
+		}
+		if err := validateGlobs([]string{tool.Name}); err != nil {
+			return fmt.Errorf("sql-policy tool #%d: %w", i+1, err)
+		}
+		if len(tool.Arguments) == 0 {
+			return fmt.Errorf("sql-policy tool '%s': arguments cannot be empty", tool.Name)
+		}
+		for _, arg := range tool.Arguments {
+			if arg == "" || strings.HasPrefix(arg, ".") || strings.HasSuffix(arg, ".") || strings.Contains(arg, "..") {
+				return fmt.Errorf("sql-policy tool '%s': invalid argument path '%s'", tool.Name, arg)
+			}
+		}
+	}
+
+	switch policy.ACL.Default {
+	case "", AccessDeny, AccessAllow:
+	default:
+		return fmt.Errorf("invalid sql-policy.acl.default '%s': must be one of deny, allow", policy.ACL.Default)
+	}
+	names := make(map[string]bool)
+	for i, rule := range policy.ACL.Rules {
+		label := rule.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		} else if names[rule.Name] {
+			return fmt.Errorf("duplicate sql-policy acl rule name '%s'", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Clients) > 0 {
+			return fmt.Errorf("sql-policy acl rule %s: clients is no longer supported, match principals instead", label)
+		}
+		if err := validateGlobs(rule.Principals, rule.Allow, rule.Deny); err != nil {
+			return fmt.Errorf("sql-policy acl rule %s: %w", label, err)
+		}
+	}
+	return nil
+}
+
+// minResultBytes leaves room for the truncation notice within a byte limit
+const minResultBytes = 1024
+
+// validateLimitsConfig validates the result size limits
+func validateLimitsConfig(limits *LimitsConfig) error {
+	if limits.MaxBytes < 0 || limits.MaxRows < 0 || limits.MaxItems < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	if limits.MaxBytes > 0 && limits.MaxBytes < minResultBytes {
+		return fmt.Errorf("limits.max-bytes %d is too small: must be 0 or at least %d", limits.MaxBytes, minResultBytes)
+	}
+	switch limits.Action {
+	case "", LimitTruncate, LimitError:
+	default:
+		return fmt.Errorf("invalid limits.action '%s': must be one of truncate, error", limits.Action)
+	}
+	return nil
+}
+
+// validateRedactionConfig checks the detector names, JSON paths and patterns
+func validateRedactionConfig(redaction *RedactionConfig) error {
+	for _, name := range redaction.Detectors {
+		known := false
+		for _, d := range Detectors {
+			known = known || name == d
+		}
+		if !known {
+			return fmt.Errorf("invalid redaction detector '%s': must be one of %s", name, strings.Join(Detectors, ", "))
+		}
+	}
+	for _, p := range redaction.Paths {
+		if p == "" || strings.HasPrefix(p, ".") || strings.HasSuffix(p, ".") || strings.Contains(p, "..") {
+			return fmt.Errorf("invalid redaction path '%s'", p)
+		}
+	}
+	for _, pattern := range redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid redaction pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// validateCacheConfig checks the cache size, scope and tools
+func validateCacheConfig(cache *CacheConfig) error {
+	if cache.MaxEntries < 0 || cache.MaxEntryBytes < 0 {
+		return fmt.Errorf("cache sizes cannot be negative")
+	}
+	switch cache.Scope {
+	case "", CacheGlobal, CacheSession:
+	default:
+		return fmt.Errorf("invalid cache.scope '%s': must be one of global, session", cache.Scope)
+	}
+	for i, tool := range cache.Tools {
+		if tool.Name == "" {
+			return fmt.Errorf("cache tool #%d: name cannot be empty", i+1)
+		}
+		if err := validateGlobs([]string{tool.Name}); err != nil {
+			return fmt.Errorf("cache tool #%d: %w", i+1, err)
+		}
+		if tool.TTL <= 0 {
+			return fmt.Errorf("cache tool '%s': ttl must be positive", tool.Name)
+		}
+	}
+	return nil
+}
+
+// validateMaskingConfig checks the masking rules
+func validateMaskingConfig(masking *MaskingConfig) error {
+	names := make(map[string]bool)
+	for i, rule := range masking.Rules {
+		label := rule.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		} else if names[rule.Name] {
+			return fmt.Errorf("duplicate masking rule name '%s'", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if len(rule.Columns) == 0 && len(rule.Detectors) == 0 {
+			return fmt.Errorf("masking rule %s: needs columns or detectors", label)
+		}
+		if err := validateGlobs(rule.Tools, rule.Columns); err != nil {
+			return fmt.Errorf("masking rule %s: %w", label, err)
+		}
+		for _, name := range rule.Detectors {
+			known := false
+			for _, d := range MaskDetectors {
+				known = known || name == d
+			}
+			if !known {
+				return fmt.Errorf("masking rule %s: invalid detector '%s': must be one of %s", label, name, strings.Join(MaskDetectors, ", "))
+			}
+		}
+		switch rule.Strategy {
+		case MaskHash, MaskPartial, MaskNull:
+		default:
+			return fmt.Errorf("masking rule %s: invalid strategy '%s': must be one of hash, partial, null", label, rule.Strategy)
+		}
+		if rule.Reveal < 0 {
+			return fmt.Errorf("masking rule %s: reveal cannot be negative", label)
+		}
+	}
+	return nil
+}
+
+// validateGlobs checks glob patterns as used by path.Match
+func validateGlobs(lists ...[]string) error {
+	for _, patterns := range lists {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateChaosConfig validates the fault injection rules. Kill rules need a
+// process to kill, which only stdio mode spawns.
+func validateChaosConfig(chaos *ChaosConfig, transport string) error {
+	names := make(map[string]bool)
+	for i, rule := range chaos.Rules {
+		label := rule.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		} else if names[rule.Name] {
+			return fmt.Errorf("duplicate chaos rule name '%s'", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Probability < 0 || rule.Probability > 1 {
+			return fmt.Errorf("chaos rule %s: probability %v must be between 0 and 1", label, rule.Probability)
+		}
+		if rule.After < 0 {
+			return fmt.Errorf("chaos rule %s: after must not be negative", label)
+		}
+		if err := validateGlobs(rule.Methods, rule.Tools); err != nil {
+			return fmt.Errorf("chaos rule %s: %w", label, err)
+		}
+
+		switch rule.Type {
+		case ChaosLatency:
+			if rule.Latency <= 0 {
+				return fmt.Errorf("chaos rule %s: latency must be positive", label)
+			}
+			if rule.Jitter < 0 || rule.Jitter > rule.Latency {
+				return fmt.Errorf("chaos rule %s: jitter must be between 0 and the latency", label)
+			}
+		case ChaosTruncate:
+			if rule.TruncateBytes < 0 {
+				return fmt.Errorf("chaos rule %s: truncate-bytes must not be negative", label)
+			}
+		case ChaosHTTPError:
+			if rule.Status < 500 || rule.Status > 599 {
+				return fmt.Errorf("chaos rule %s: status %d must be a 5xx code", label, rule.Status)
+			}
+		case ChaosKill:
+			if transport != "stdio" {
+				return fmt.Errorf("chaos rule %s: kill needs the upstream process of stdio mode, not %s", label, transport)
+			}
+		case ChaosDrop, ChaosError:
+		default:
+			return fmt.Errorf("chaos rule %s: invalid type '%s': must be one of latency, drop, error, truncate, kill, http-error", label, rule.Type)
+		}
+	}
+	return nil
+}
+
+// validateRecording checks that the recording to replay exists
+func validateRecording(replay *ReplayConfig, transport string) error {
+	if replay.File == "" {
+		return fmt.Errorf("replay.file cannot be empty for %s transport mode", transport)
+	}
+	if _, err := os.Stat(replay.File); os.IsNotExist(err) {
+		return fmt.Errorf("recording not found at path '%s'", replay.File)
+	}
+	return nil
+}
+
+// validateReplayServerConfig validates the replay-server settings
+func validateReplayServerConfig(replay *ReplayConfig) error {
+	if err := validateRecording(replay, "replay-server"); err != nil {
+		return err
+	}
+
+	switch replay.Match {
+	case "strict", "normalized", "method":
+	default:
+		return fmt.Errorf("invalid replay.match '%s': must be 'strict', 'normalized' or 'method'", replay.Match)
+	}
+
+	switch replay.Timing {
+	case "none", "recorded", "fixed":
+	default:
+		return fmt.Errorf("invalid replay.timing '%s': must be 'none', 'recorded' or 'fixed'", replay.Timing)
+	}
+	if replay.Delay < 0 {
+		return fmt.Errorf("invalid replay.delay %s: must not be negative", replay.Delay)
+	}
+
+	return nil
+}
+
+// upstreamNamePattern matches upstream names, which must be usable in a URI scheme
+var upstreamNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9.-]*$`)
+
+// validateUpstreams checks that the upstreams have distinct names and prefixes
+// and each either an executable or a URL
+func validateUpstreams(upstreams []UpstreamConfig) error {
+	names := make(map[string]bool)
+	prefixes := make(map[string]string)
+	for i, up := range upstreams {
+		if !upstreamNamePattern.MatchString(up.Name) {
+			return fmt.Errorf("upstream #%d: invalid name '%s': must start with a letter and contain only letters, digits, '-' and '.'", i+1, up.Name)
+		}
+		if names[up.Name] {
+			return fmt.Errorf("duplicate upstream '%s'", up.Name)
+		}
+		names[up.Name] = true
+
+		prefix := up.NamePrefix()
+		if !toolNamePattern.MatchString(prefix) {
+			return fmt.Errorf("upstream '%s': invalid prefix '%s'", up.Name, prefix)
+		}
+		if other, ok := prefixes[prefix]; ok {
+			return fmt.Errorf("upstreams '%s' and '%s' have the same prefix '%s'", other, up.Name, prefix)
+		}
+		prefixes[prefix] = up.Name
+
+		switch {
+		case up.ExePath != "" && up.URL != "":
+			return fmt.Errorf("upstream '%s': set either exe-path or url, not both", up.Name)
+		case up.URL != "":
+			u, err := url.Parse(up.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("upstream '%s': invalid url '%s'", up.Name, up.URL)
+			}
+		case up.ExePath != "":
+			if _, err := os.Stat(up.ExePath); os.IsNotExist(err) {
+				return fmt.Errorf("upstream '%s': executable not found at path '%s'", up.Name, up.ExePath)
+			}
+		default:
+			return fmt.Errorf("upstream '%s': exe-path or url is required", up.Name)
+		}
+		for _, env := range up.Env {
+			if !strings.Contains(env, "=") {
+				return fmt.Errorf("upstream '%s': invalid env entry '%s': must be KEY=value", up.Name, env)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRoutingConfig checks that routes have distinct names, value
+// patterns and either an executable or a URL
+func validateRoutingConfig(routing *RoutingConfig) error {
+	if len(routing.Routes) == 0 {
+		return nil
+	}
+	if len(routing.Arguments) == 0 {
+		return fmt.Errorf("routing.arguments cannot be empty when routes are set")
+	}
+	names := make(map[string]bool)
+	for i, route := range routing.Routes {
+		if route.Name == "" {
+			return fmt.Errorf("route #%d: name cannot be empty", i+1)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("duplicate route '%s'", route.Name)
+		}
+		names[route.Name] = true
+
+		if len(route.Match) == 0 {
+			return fmt.Errorf("route '%s': match cannot be empty", route.Name)
+		}
+		if err := validateGlobs(route.Match); err != nil {
+			return fmt.Errorf("route '%s': %w", route.Name, err)
+		}
+
+		switch {
+		case route.ExePath != "" && route.URL != "":
+			return fmt.Errorf("route '%s': set either exe-path or url, not both", route.Name)
+		case route.URL != "":
+			u, err := url.Parse(strings.ReplaceAll(route.URL, "{value}", "value"))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("route '%s': invalid url '%s'", route.Name, route.URL)
+			}
+		case route.ExePath != "":
+			if _, err := os.Stat(route.ExePath); os.IsNotExist(err) {
+				return fmt.Errorf("route '%s': executable not found at path '%s'", route.Name, route.ExePath)
+			}
+		default:
+			return fmt.Errorf("route '%s': exe-path or url is required", route.Name)
+		}
+		for _, env := range route.Env {
+			if !strings.Contains(env, "=") {
+				return fmt.Errorf("route '%s': invalid env entry '%s': must be KEY=value", route.Name, env)
+			}
+		}
+	}
+	if routing.MaxBackends < 0 {
+		return fmt.Errorf("routing.max-backends cannot be negative")
+	}
+	if routing.IdleTimeout < 0 {
+		return fmt.Errorf("routing.idle-timeout cannot be negative")
+	}
+	if routing.InitTimeout <= 0 {
+		return fmt.Errorf("routing.init-timeout must be positive")
+	}
+	return nil
+}
+
+// validateRWSplitConfig checks the replica and its health checks
+func validateRWSplitConfig(split *RWSplitConfig) error {
+	if !split.Enabled() {
+		return nil
+	}
+	replica := &split.Replica
+	if replica.ExePath != "" && replica.URL != "" {
+		return fmt.Errorf("read-write-split.replica: set either exe-path or url, not both")
+	}
+	if replica.URL != "" {
+		u, err := url.Parse(replica.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("read-write-split.replica: invalid url '%s'", replica.URL)
+		}
+	} else if _, err := os.Stat(replica.ExePath); os.IsNotExist(err) {
+		return fmt.Errorf("read-write-split.replica: executable not found at path '%s'", replica.ExePath)
+	}
+	for _, env := range replica.Env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("read-write-split.replica: invalid env entry '%s': must be KEY=value", env)
+		}
+	}
+	if split.PrimaryAfterWrite < 0 {
+		return fmt.Errorf("read-write-split.primary-after-write cannot be negative")
+	}
+
+	check := &split.HealthCheck
+	if check.Interval < 0 {
+		return fmt.Errorf("read-write-split.health-check.interval cannot be negative")
+	}
+	if check.Interval == 0 {
+		return nil
+	}
+	if check.Timeout <= 0 {
+		return fmt.Errorf("read-write-split.health-check.timeout must be positive")
+	}
+	if check.UnhealthyThreshold < 1 || check.HealthyThreshold < 1 {
+		return fmt.Errorf("read-write-split.health-check thresholds must be at least 1")
+	}
+	return nil
+}
+
+// validateEndpoints checks that endpoints have distinct prefixes, a target
+// and valid tokens and policies
+func validateEndpoints(endpoints []EndpointConfig) error {
+	prefixes := make(map[string]bool)
+	for i, endpoint := range endpoints {
+		if !strings.HasPrefix(endpoint.Prefix, "/") || len(endpoint.Prefix) < 2 || strings.HasSuffix(endpoint.Prefix, "/") {
+			return fmt.Errorf("endpoint #%d: invalid prefix '%s': must start and must not end with '/'", i+1, endpoint.Prefix)
+		}
+		if prefixes[endpoint.Prefix] {
+			return fmt.Errorf("duplicate endpoint prefix '%s'", endpoint.Prefix)
+		}
+		prefixes[endpoint.Prefix] = true
+
+		u, err := url.Parse(endpoint.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint '%s': invalid target '%s'", endpoint.Prefix, endpoint.Target)
+		}
+
+		tokens := make(map[string]bool)
+		for j, token := range endpoint.Tokens {
+			if token.Name == "" || token.Token == "" {
+				return fmt.Errorf("endpoint '%s': token #%d: name and token are required", endpoint.Prefix, j+1)
+			}
+			if tokens[token.Token] {
+				return fmt.Errorf("endpoint '%s': token '%s' repeats another token", endpoint.Prefix, token.Name)
+			}
+			tokens[token.Token] = true
+		}
+
+		if err := validateToolFilterConfig(&endpoint.ToolFilter); err != nil {
+			return fmt.Errorf("endpoint '%s': %w", endpoint.Prefix, err)
+		}
+		if err := validateSQLPolicyConfig(&endpoint.SQLPolicy); err != nil {
+			return fmt.Errorf("endpoint '%s': %w", endpoint.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// validatePoolConfig checks the pool's targets, strategy and health checks
+func validatePoolConfig(pool *PoolConfig) error {
+	seen := make(map[string]bool)
+	for i, target := range pool.Targets {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("pool target #%d: invalid url '%s'", i+1, target)
+		}
+		if seen[target] {
+			return fmt.Errorf("duplicate pool target '%s'", target)
+		}
+		seen[target] = true
+	}
+	switch pool.Strategy {
+	case "", BalanceRoundRobin, BalanceLeastInFlight:
+	default:
+		return fmt.Errorf("invalid pool.strategy '%s': must be one of round-robin, least-in-flight", pool.Strategy)
+	}
+	if pool.Affinity && pool.AffinityTimeout <= 0 {
+		return fmt.Errorf("pool.affinity-timeout must be positive when pool.affinity is set")
+	}
+
+	check := &pool.HealthCheck
+	if check.Interval < 0 {
+		return fmt.Errorf("pool.health-check.interval cannot be negative")
+	}
+	if check.Interval == 0 {
+		return nil
+	}
+	if !strings.HasPrefix(check.Path, "/") {
+		return fmt.Errorf("invalid pool.health-check.path '%s': must start with '/'", check.Path)
+	}
+	if check.Timeout <= 0 {
+		return fmt.Errorf("pool.health-check.timeout must be positive")
+	}
+	if check.UnhealthyThreshold < 1 || check.HealthyThreshold < 1 {
+		return fmt.Errorf("pool.health-check thresholds must be at least 1")
+	}
+	return nil
+}
+
+// validateApprovalConfig checks the statement classes held and the timings
+func validateApprovalConfig(approval *ApprovalConfig) error {
+	if !approval.Enabled() {
+		return nil
+	}
+	for _, class := range approval.Classes {
+		switch class {
+		case "dml", "ddl", "dcl", "tcl", "other":
+		default:
+			return fmt.Errorf("invalid approval class '%s': must be one of dml, ddl, dcl, tcl, other", class)
+		}
+	}
+	if approval.Timeout <= 0 {
+		return fmt.Errorf("approval.timeout must be positive")
+	}
+	if approval.ProgressInterval < 0 {
+		return fmt.Errorf("approval.progress-interval cannot be negative")
+	}
+	return nil
+}
+
+// validateConfirmationConfig checks the statement classes confirmed and the timeout
+func validateConfirmationConfig(confirmation *ConfirmationConfig) error {
+	if !confirmation.Enabled() {
+		return nil
+	}
+	for _, class := range confirmation.Classes {
+		switch class {
+		case "dml", "ddl", "dcl", "tcl", "other":
+		default:
+			return fmt.Errorf("invalid confirmation class '%s': must be one of dml, ddl, dcl, tcl, other", class)
+		}
+	}
+	if confirmation.Timeout <= 0 {
+		return fmt.Errorf("confirmation.timeout must be positive")
+	}
+	return nil
+}
+
+// validateChildrenConfig checks the bounds of the child pool
+func validateChildrenConfig(children *ChildrenConfig) error {
+	if !children.Enabled {
+		return nil
+	}
+	if children.MinIdle < 0 || children.MaxIdle < 0 || children.MaxSessions < 0 || children.IdleTimeout < 0 {
+		return fmt.Errorf("children settings cannot be negative")
+	}
+	if children.MaxIdle < children.MinIdle {
+		return fmt.Errorf("children.max-idle (%d) cannot be less than children.min-idle (%d)", children.MaxIdle, children.MinIdle)
+	}
+	return nil
+}
+
+// validateSessionsConfig checks the idle timeout and that the log directory
+// exists
+func validateSessionsConfig(sessions *SessionsConfig) error {
+	if sessions.IdleTimeout < 0 {
+		return fmt.Errorf("sessions.idle-timeout cannot be negative")
+	}
+	if sessions.LogDir != "" {
+		info, err := os.Stat(sessions.LogDir)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("sessions.log-dir '%s' is not a directory", sessions.LogDir)
+		}
+	}
+	return nil
+}
+
+// validateResumptionConfig checks the bounds of the event store and that the
+// spill directory exists
+func validateResumptionConfig(resumption *ResumptionConfig) error {
+	if !resumption.Enabled {
+		return nil
+	}
+	if resumption.MaxBytes <= 0 {
+		return fmt.Errorf("resumption.max-bytes must be positive")
+	}
+	if resumption.Retention <= 0 {
+		return fmt.Errorf("resumption.retention must be positive")
+	}
+	if resumption.SpillDir != "" {
+		info, err := os.Stat(resumption.SpillDir)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("resumption.spill-dir '%s' is not a directory", resumption.SpillDir)
+		}
+	}
+	return nil
+}
+
+// validateReplayClientConfig validates the replay-client settings. The upstream
+// is reached at replay.url when set, otherwise exe-path is spawned.
+func validateReplayClientConfig(config *Config) error {
+	replay := &config.Replay
+	if err := validateRecording(replay, "replay-client"); err != nil {
+		return err
+	}
+
+	if replay.URL == "" {
+		if config.ExePath == "" {
+			return fmt.Errorf("exe-path cannot be empty for replay-client mode without replay.url")
+		}
+		if _, err := os.Stat(config.ExePath); os.IsNotExist(err) {
+			return fmt.Errorf("executable not found at path '%s'", config.ExePath)
+		}
+	}
+
+	for _, pattern := range replay.IgnorePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid replay.ignore-patterns entry '%s': %w", pattern, err)
+		}
+	}
+	if replay.Timeout < 0 {
+		return fmt.Errorf("invalid replay.timeout %s: must not be negative", replay.Timeout)
+	}
+
 	return nil
 }
 
@@ -161,9 +1603,11 @@ func GenerateExampleConfig(filename string) error {
 # This file demonstrates all available configuration options with their default values.
 # You can use YAML, JSON, or TOML format for configuration files.
 
-# Transport mode: "stdio" or "http"
+# Transport mode: "stdio", "http", "replay-server" or "replay-client"
 # - stdio: Communicates via standard input/output (good for command-line tools)
 # - http: Acts as HTTP proxy (good for web applications and services)
+# - replay-server: Stands in for mcp_sqlpp over stdio, answering requests from a recording
+# - replay-client: Replays a recording against mcp_sqlpp and reports differences
 transport: stdio
 
 # Port to listen on when using HTTP transport mode
@@ -186,12 +1630,506 @@ xfer-port: 8891
 # Default: ./mcp_sqlpp
 exe-path: ./mcp_sqlpp
 
+# Several upstreams fronted as one MCP server (stdio and http modes). When set,
+# exe-path and xfer-port are not used: each upstream is spawned from its own
+# exe-path or reached at its url, in http mode once per client session. Their
+# tools/list, prompts/list and resources/list results are merged; tool and
+# prompt names get the upstream's prefix (default <name>_) and resource URIs
+# become <name>+<uri>. Calls are routed to the upstream the name or URI belongs
+# to, and other requests are sent to every upstream.
+upstreams: []
+#  - name: analytics
+#    exe-path: ./mcp_sqlpp
+#    env: ["SQLPP_CONNECTION=analytics"]
+#  - name: billing
+#    prefix: bill_
+#    url: http://localhost:8892/mcp
+
+# Pool of equivalent mcp_sqlpp instances (http mode only). When targets are
+# set, xfer-port is not used: requests that start a session go to an instance
+# chosen by the strategy, and later requests of the session go to the
+# instance that assigned its Mcp-Session-Id. Instances that fail health-check
+# pings are ejected until they recover; clients of a session held by an
+# ejected instance receive 404 and start a new session.
+pool:
+  # Base URLs of the instances; client request paths are appended
+  targets: []
+  #  - http://db1:8891
+  #  - http://db2:8891
+  # How sessions are spread over healthy instances:
+  #   - round-robin: instances take turns
+  #   - least-in-flight: the instance with the fewest exchanges in progress
+  # Default: round-robin
+  strategy: round-robin
+  # Keep each session on the instance that assigned it. Disable only for
+  # instances that share no per-session state.
+  # Default: true
+  affinity: true
+  # Sessions without requests for this long are forgotten; their next request
+  # is balanced like a new one
+  affinity-timeout: 1h
+  health-check:
+    # MCP endpoint path of the instances. The proxy holds its own session on
+    # each instance and pings it.
+    path: /mcp
+    # Time between pings; 0 disables health checks
+    interval: 10s
+    # Time a ping may take
+    timeout: 2s
+    # Consecutive failed pings that eject an instance
+    unhealthy-threshold: 3
+    # Consecutive successful pings that readmit it
+    healthy-threshold: 2
+
+# Endpoints served under path prefixes (http mode only). When set, xfer-port
+# is not used: a request whose path starts with an endpoint's prefix goes to
+# that endpoint's target, with the prefix stripped unless keep-prefix is set;
+# other paths are answered 404. An endpoint with tokens requires one of them
+# as "Authorization: Bearer <token>" and makes the token's name the principal
+# of the session; the header is not forwarded. tool-filter and sql-policy
+# apply to the endpoint's requests, ahead of the settings below, and see tool
+# names as clients see them.
+endpoints: []
+#  - prefix: /analytics
+#    target: http://localhost:8891
+#    tokens:
+#      - name: analytics-team
+#        token: change-me
+#    sql-policy:
+#      read-only: true
+#  - prefix: /billing
+#    target: http://localhost:8892
+#    tool-filter:
+#      rules:
+#        - deny: ["drop_*"]
+
+# Sessions on stdio children (http mode only). When enabled, xfer-port is not
+# used: the proxy holds each client's MCP session itself, backed by a child
+# spawned from exe-path. Children are spawned and initialized ahead of the
+# sessions that use them, so that a new session does not wait for mcp_sqlpp
+# to start and connect to its database, and serve several sessions in turn.
+# A client initialize asking for the protocol version the idle children were
+# initialized with is answered by the child's own initialize result; any other
+# version gets a freshly spawned child. Children are warmed like the latest
+# client initialized.
+children:
+  enabled: false
+  # Initialized children kept ready for new sessions
+  min-idle: 2
+  # Children ending a session beyond this many idle ones are closed
+  max-idle: 4
+  # Idle children beyond min-idle are closed after this long; 0 never
+  idle-timeout: 5m
+  # Sessions a child serves before it is replaced, limiting what one session
+  # can leave behind for the next; 0 means no limit
+  max-sessions: 100
+
+# Relayed sessions (http mode with xfer-port, a pool or endpoints). The proxy
+# tracks each Mcp-Session-Id the upstream assigns, along with the client, the
+# negotiated protocol version and the capabilities the client declared, and
+# forgets it when the client sends DELETE.
+sessions:
+  # Sessions without traffic for this long are deleted upstream and
+  # forgotten; sessions the proxy holds itself (children, upstreams) are
+  # ended, returning their child to the pool. 0 keeps them until the client
+  # deletes them
+  idle-timeout: 30m
+  # Directory for a log file per session
+  # (mcp_sqlpp_proxy_session_<id>.log; xfer-port and pool only); empty logs
+  # every session to the main log
+  log-dir: ""
+
+# Resumable event streams (http mode, except with endpoints). Server-sent
+# events streamed to clients get ids of the proxy's own and are kept per
+# stream, so that a client reconnecting with Last-Event-ID is sent the events
+# it missed, whether or not the upstream supports resumption. A request whose
+# stream the client drops keeps running, so that its response can be picked
+# up on reconnecting.
+resumption:
+  enabled: false
+  # Event data kept in memory per stream, in bytes
+  max-bytes: 1048576
+  # Directory older events of a stream are written to instead of being
+  # dropped; empty drops them
+  spill-dir: ""
+  # How long a stream is kept after it ends
+  retention: 5m
+
+# Replay settings (replay-server and replay-client modes only)
+# A recording is a traffic log previously written by this proxy
+# (mcp_sqlpp_proxy_<pid>_<timestamp>.log), from stdio or http mode.
+replay:
+  # Path to the recorded traffic log
+  file: ""
+
+  # replay-server: how incoming requests are matched against recorded ones:
+  #   - strict: method and params must be identical
+  #   - normalized: _meta fields are ignored, initialize matches on protocol version only
+  #   - method: only the method is compared
+  # Identical requests consume recorded responses in order.
+  # Default: normalized
+  match: normalized
+  # How response latency is emulated:
+  #   - none: respond immediately
+  #   - recorded: wait as long as mcp_sqlpp took in the recording
+  #   - fixed: wait for the configured delay before every response
+  # Default: none
+  timing: none
+  # Delay applied to every response when timing is "fixed"
+  # Default: 0s
+  delay: 0s
+
+  # replay-client: URL of a running mcp_sqlpp HTTP endpoint to replay against.
+  # When empty, exe-path is spawned in stdio mode instead.
+  url: ""
+  # File the JSON diff report is written to (stdout when empty)
+  report: ""
+  # Response fields excluded from comparison: a bare key name is ignored at any
+  # depth, a dotted path may use * for any key or array index.
+  # JSON documents embedded in tool result text are compared field by field.
+  ignore:
+    - timestamp
+  # Regular expressions whose matches inside string values are ignored
+  ignore-patterns:
+    - '\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})?'
+  # Timeout for each replayed request
+  # Default: 30s
+  timeout: 30s
+
+# Admin interface, served on localhost only (stdio and http modes)
+admin:
+  # Port for the admin HTTP interface; 0 disables it
+  port: 0
+  # Bearer token every admin request but GET /healthz must send as
+  # "Authorization: Bearer <token>"; empty requires none. Any local process,
+  # the MCP client included, can reach localhost, so approval requires a
+  # token. Better set via MCP_PROXY_ADMIN_TOKEN.
+  token: ""
+
+# Passive protocol validation (stdio and http modes). Every message is checked
+# against the MCP schema of the negotiated protocol version; violations are
+# logged as [VIOLATION] lines and counted (GET /validation on the admin interface).
+# Traffic is never changed.
+validation:
+  enabled: false
+
+# Protocol version bridging (stdio and http modes). When the upstream answers
+# initialize with a different protocol version than the client asked for, the
+# client is told its own version and traffic is translated between the two:
+# batches are split for upstreams without them, and tool definitions and
+# results drop what the client's version does not know (output schemas,
+# structured content, resource links, annotations). Translations that are not
+# possible are logged as [WARN] lines. Versions the proxy does not know are
+# passed through as negotiated.
+protocol-bridge:
+  enabled: false
+
+# Tool filtering (stdio and http modes). The first rule whose principals
+# patterns match the session's principal (the endpoint token name, the remote
+# address of other HTTP clients, or the local user in stdio mode) decides
+# which tools it sees in tools/list and may call; calls to other tools are
+# rejected with a JSON-RPC error and never forwarded. A tool is available if
+# it matches an allow pattern (or allow is empty) and no deny pattern. A rule
+# without principals matches every principal. All patterns are globs.
+tool-filter:
+  # Access of principals no rule matches: deny or allow
+  default: deny
+  rules: []
+  #  - principals: ["report-*"]
+  #    allow: ["read_*", "describe_*"]
+  #  - deny: ["execute_*"]
+
+# Tool rewriting (stdio and http modes). Changes what clients see in
+# tools/list: tools can be renamed, every name prefixed, and descriptions of
+# tools and their arguments replaced. Calls to the names clients see are mapped
+# back to the upstream names; calls to the upstream names of renamed or
+# prefixed tools are rejected. All other settings refer to upstream tool names.
+tool-rewrite:
+  # Prepended to every tool name, e.g. "sqlpp_" turns execute into sqlpp_execute
+  prefix: ""
+  tools: []
+  #  - name: execute
+  #    rename: run_sql
+  #    description: Run a SQL script against the reporting database
+  #    arguments:
+  #      - name: sql
+  #        description: SQL script; statements are separated by semicolons
+
+# SQL policy (stdio and http modes). SQL is looked for in the tools/call
+# arguments listed per tool (dotted paths, * for any key or array index; an
+# array of strings counts as several scripts). Without tools, the "sql" and
+# "query" arguments of every tool are checked. Each statement is classified as
+# select, dml, ddl, dcl, tcl or other.
+sql-policy:
+  # Reject calls containing anything but reads (SELECT, SHOW, EXPLAIN, ...)
+  read-only: false
+  tools: []
+  #  - name: execute_sql
+  #    arguments: ["sql"]
+  #  - name: "run_*"
+  #    arguments: ["script", "statements.*"]
+  # Tables and schemas each principal may reference, matched as lower-case
+  # schema.table globs. The principal is the endpoint token name, the remote
+  # address of other HTTP clients, or the local user in stdio mode. The first
+  # rule whose principals match decides (a rule without principals matches
+  # every one); a table is available if it matches allow (or allow is empty)
+  # and does not match deny. Decisions are written to the log at the [AUDIT]
+  # level.
+  acl:
+    # Schema assumed for unqualified table names
+    default-schema: ""
+    # Access of principals no rule matches: deny or allow
+    default: deny
+    rules: []
+    #  - name: support
+    #    principals: ["support-*"]
+    #    deny: ["hr.*"]
+    #  - name: reporting
+    #    principals: ["report-*"]
+    #    allow: ["sales.*", "public.*"]
+
+# User confirmation of destructive calls (stdio and http modes). A tools/call
+# whose SQL has statements of the listed classes, from a client that declared
+# the elicitation capability when initializing, is only forwarded once the
+# user confirms it: the proxy sends the client an elicitation/create request
+# showing the SQL. Declined, cancelled and unanswered calls are rejected.
+# Calls from clients without elicitation support are not confirmed.
+confirmation:
+  # Statement classes needing confirmation: dml, ddl, dcl, tcl, other. Empty
+  # disables confirmation.
+  classes: []
+  timeout: 2m
+
+# Human approval of writes (stdio and http modes; requires admin.port and
+# admin.token). A
+# tools/call whose SQL has statements of the listed classes is held until an
+# approver approves or rejects it through the admin interface
+# (GET /approvals, POST /approvals/<id>/approve, POST /approvals/<id>/reject)
+# or the "approvals" command. Calls not decided within timeout are rejected.
+# While a call is held, clients that sent a progress token receive progress
+# notifications every progress-interval (0 disables them).
+approval:
+  # Statement classes needing approval: dml, ddl, dcl, tcl, other. Empty
+  # disables the gate.
+  classes: []
+  timeout: 5m
+  progress-interval: 10s
+
+# SQL audit log (stdio and http modes): one JSON line per SQL argument of every
+# tools/call request, with principal, session, client, database, SQL, statement
+# classes, tables, duration, row count and outcome. SQL is looked for in the
+# arguments configured under sql-policy.tools.
+audit:
+  # File to append records to; empty disables the audit log
+  file: ""
+  # Arguments naming the database or connection a call runs against
+  database-arguments: ["database", "connection"]
+
+# Limits on tools/call results returned to clients; 0 disables a limit.
+# Rows are counted in JSON row sets (arrays, or objects with a "rows" array) in
+# the structured content and in text blocks. Limits hit are logged and noted in
+# the audit log.
+limits:
+  max-bytes: 0
+  max-rows: 0
+  max-items: 0
+  # truncate: cut the result down and append a notice to its content
+  # error: replace the result with a tool error asking for a narrower query
+  action: truncate
+
+# Redaction of the traffic written to the log file (all modes). Message bodies
+# and URLs are rewritten before they are logged; nothing else changes. Logs
+# written with redaction can still be replayed, with the masked values.
+redaction:
+  enabled: false
+  # Log only the id, method, tool and size of each message instead of its body.
+  # Such logs cannot be replayed.
+  metadata-only: false
+  # Built-in detectors: email, card (Luhn-checked card numbers), bearer (Bearer
+  # and Basic credentials), dsn-password (passwords in connection strings)
+  detectors: ["email", "card", "bearer", "dsn-password"]
+  # JSON paths masked in every message, e.g. "params.arguments.password".
+  # * matches any key or array index, ** any number of levels.
+  paths: []
+  #  - "params.arguments.connection"
+  #  - "**.ssn"
+  # Regular expressions whose matches are masked
+  patterns: []
+  #  - "\\b\\d{3}-\\d{2}-\\d{4}\\b"
+  # Text that replaces masked values
+  mask: "[REDACTED]"
+
+# Masking of sensitive values in the tools/call results returned to clients
+# (stdio and http modes). A value is masked by the first rule whose tools match
+# the call and whose columns match its column, or one of whose detectors
+# recognizes it. Columns are found in JSON row sets (arrays of objects, or
+# "columns" and "rows") and in text tables with | separators; detectors also
+# apply to other text.
+masking:
+  # Key of the hash strategy; set it (e.g. via MCP_PROXY_MASKING_SALT) so that
+  # hashes of guessable values such as SSNs cannot be reversed
+  salt: ""
+  # Strategies:
+  #   - hash: a keyed hash, e.g. "hash:3f2a9c0d41e7b58a"; equal values stay equal
+  #   - partial: keep the last "reveal" letters and digits (default 4), e.g. ***-**-6789
+  #   - null: null, or NULL in text
+  # Detectors: ssn (123-45-6789), email, card (Luhn-checked card numbers)
+  rules: []
+  #  - name: ssn
+  #    columns: ["ssn", "*_ssn", "social_security*"]
+  #    detectors: ["ssn"]
+  #    strategy: partial
+  #  - name: contact
+  #    tools: ["execute_*"]
+  #    columns: ["email", "phone*"]
+  #    strategy: hash
+
+# In-memory LRU cache of tools/call results (stdio and http modes). Results are
+# cached per tool and canonicalized arguments, only for the tools listed here
+# and only when all SQL found in the arguments (see sql-policy.tools) is read
+# statements. Error results are never cached. The admin interface reports hit
+# and miss counts (GET /cache) and purges entries (POST /cache/purge).
+cache:
+  # Maximum number of cached results; 0 disables the cache
+  max-entries: 0
+  # Results larger than this many bytes are not cached; 0 means no limit
+  max-entry-bytes: 1048576
+  # global: entries are shared by all sessions; session: kept per session
+  scope: global
+  # Purge the whole cache when a call with SQL that is not a read passes
+  purge-on-write: true
+  # Cacheable tools (name globs; the first match applies) and how long their
+  # results stay fresh
+  tools: []
+  #  - name: list_tables
+  #    ttl: 5m
+  #  - name: describe_*
+  #    ttl: 5m
+  #  - name: execute_sql
+  #    ttl: 10s
+
+# Coalescing of identical concurrent tools/call requests (stdio and http modes).
+# While a call to one of the tools listed here is in progress upstream, calls
+# with the same tool name and canonicalized arguments wait for it instead of
+# being sent again, and each gets the result under its own request id. Calls
+# with SQL that is not a read (see sql-policy.tools) are never coalesced.
+coalesce:
+  # Tool name globs whose calls may be coalesced; empty disables coalescing
+  tools: []
+  #  - list_tables
+  #  - execute_sql
+
+# Routing of tools/call requests by argument (stdio and http modes). A call
+# whose selecting argument matches a route goes to that route's upstream
+# instead of exe-path or xfer-port; other calls and all other requests take
+# the usual path. Route upstreams are spawned or connected on first use, one
+# per route and rendered args, env and url, where {value} stands for the
+# argument's value. They are initialized by the proxy.
+routing:
+  # Arguments that select a route; the first one present in a call applies
+  arguments: [connection, database]
+  # Routes in order; the first whose match globs accept the value applies
+  routes: []
+  #  - name: analytics
+  #    match: [analytics]
+  #    url: http://analytics:8891/mcp
+  #  - name: per-connection
+  #    match: ["dev*", "staging*"]
+  #    exe-path: ./mcp_sqlpp
+  #    args: ["-t", "stdio"]
+  #    env: ["SQLPP_CONNECTION={value}"]
+  # Upstreams open at once over all routes. When a value needs another one,
+  # the upstream unused for longest is closed; if all are busy, the call is
+  # refused. 0 means no limit.
+  max-backends: 16
+  # Upstreams without calls for this long are closed; 0 keeps them open
+  idle-timeout: 10m
+  # Limit on connecting to and initializing an upstream
+  init-timeout: 30s
+
+# Read/write split (stdio and http modes). tools/call requests whose SQL only
+# reads (see sql-policy.tools) go to an upstream pointed at a read replica,
+# opened by the proxy and shared by all sessions; writes, calls without SQL and
+# all other requests go to the usual upstream, the primary. Reads go to the
+# primary as well while the replica is unhealthy, and a read the replica fails
+# to answer is retried on the primary.
+read-write-split:
+  # The replica upstream: exe-path (with args and env) or url; unset disables the split
+  replica: {}
+  #  url: http://replica:8891/mcp
+  #  exe-path: ./mcp_sqlpp
+  #  args: ["-t", "stdio"]
+  #  env: ["SQLPP_CONNECTION=replica"]
+  # Reads of a session stay on the primary this long after it writes, so that
+  # it sees its own writes despite replication lag (0 disables)
+  primary-after-write: 5s
+  # MCP pings that stop reads going to a failing replica and resume them once
+  # it recovers. Without health checks (interval 0), the replica is only
+  # reopened when it goes away, and reads fall back one by one.
+  health-check:
+    interval: 10s
+    timeout: 2s
+    unhealthy-threshold: 3
+    healthy-threshold: 2
+
+# Fault injection (stdio and http modes). Each rule applies to requests whose
+# method and, for tools/call, tool name match its glob patterns; the first
+# matching rule that fires is injected. Rules can be toggled at runtime through
+# the admin interface (GET /chaos, POST /chaos/enable|disable,
+# POST /chaos/rules/<name>/enable|disable).
+chaos:
+  # Master switch for all rules
+  enabled: true
+  # Random seed for probabilities and jitter (0 seeds from the clock)
+  seed: 0
+  # Rule types:
+  #   - latency: delay the request by latency +/- jitter
+  #   - drop: forward the request but never answer the client
+  #   - error: answer with a JSON-RPC error (error-code, error-message) without forwarding
+  #   - truncate: cut the response off after truncate-bytes bytes (default: half)
+  #   - kill: kill the upstream process on the request after the first "after" matches (stdio only)
+  #   - http-error: answer with HTTP status 5xx without forwarding (http only)
+  # probability is between 0 and 1; 0 means every matching request.
+  rules: []
+  #  - name: slow-queries
+  #    type: latency
+  #    tools: ["execute_*"]
+  #    latency: 2s
+  #    jitter: 500ms
+  #  - name: flaky-list
+  #    type: error
+  #    methods: ["tools/list"]
+  #    probability: 0.1
+  #    error-code: -32603
+  #    error-message: "Injected fault"
+  #    disabled: true
+
 # Environment Variable Overrides:
 # All configuration options can also be set via environment variables:
 # - MCP_PROXY_TRANSPORT=http
 # - MCP_PROXY_PORT=8080
 # - MCP_PROXY_XFER_PORT=8891
 # - MCP_PROXY_EXE_PATH=/usr/local/bin/mcp_sqlpp
+# - MCP_PROXY_REPLAY_FILE=./recordings/session.log
+# - MCP_PROXY_REPLAY_MATCH=strict
+# - MCP_PROXY_REPLAY_TIMING=recorded
+# - MCP_PROXY_REPLAY_URL=http://localhost:8891/mcp
+# - MCP_PROXY_REPLAY_REPORT=./replay-report.json
+# - MCP_PROXY_ADMIN_PORT=8090
+# - MCP_PROXY_ADMIN_TOKEN=change-me
+# - MCP_PROXY_CHAOS_ENABLED=false
+# - MCP_PROXY_VALIDATION_ENABLED=true
+# - MCP_PROXY_SQL_POLICY_READ_ONLY=true
+# - MCP_PROXY_AUDIT_FILE=./audit.jsonl
+# - MCP_PROXY_LIMITS_MAX_BYTES=1048576
+# - MCP_PROXY_LIMITS_MAX_ROWS=500
+# - MCP_PROXY_LIMITS_MAX_ITEMS=20
+# - MCP_PROXY_LIMITS_ACTION=error
+# - MCP_PROXY_REDACTION_ENABLED=true
+# - MCP_PROXY_REDACTION_METADATA_ONLY=true
+# - MCP_PROXY_MASKING_SALT=change-me
+# - MCP_PROXY_CACHE_MAX_ENTRIES=1000
+# - MCP_PROXY_CACHE_SCOPE=session
 
 # Command-line flags take the highest precedence and will override 
 # both environment variables and config file values.
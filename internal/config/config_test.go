@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -16,6 +17,19 @@ func TestDefaultConfig(t *testing.T) {
 	assert.Equal(t, 8099, config.Port)
 	assert.Equal(t, 8891, config.XferPort)
 	assert.Equal(t, "./mcp_sqlpp", config.ExePath)
+	assert.Equal(t, "normalized", config.Replay.Match)
+	assert.Equal(t, "none", config.Replay.Timing)
+	assert.Equal(t, 30*time.Second, config.Replay.Timeout)
+	assert.Equal(t, 0, config.Admin.Port)
+	assert.True(t, config.Chaos.Enabled)
+	assert.Empty(t, config.Chaos.Rules)
+	assert.False(t, config.Validation.Enabled)
+	assert.False(t, config.Bridge.Enabled)
+	assert.False(t, config.SQLPolicy.ReadOnly)
+	assert.Empty(t, config.Audit.File)
+	assert.Equal(t, []string{"database", "connection"}, config.Audit.DatabaseArguments)
+	assert.Equal(t, LimitsConfig{Action: LimitTruncate}, config.Limits)
+	assert.False(t, config.Limits.Enabled())
 }
 
 func TestValidateConfig(t *testing.T) {
@@ -450,6 +464,186 @@ func TestValidateConfigEdgeCases(t *testing.T) {
 	}
 }
 
+func TestValidateReplayConfig(t *testing.T) {
+	tempRecording := "temp_recording.log"
+	err := os.WriteFile(tempRecording, []byte(""), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempRecording)
+
+	tests := []struct {
+		name        string
+		replay      ReplayConfig
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "valid replay config",
+			replay:      ReplayConfig{File: tempRecording, Match: "strict", Timing: "fixed", Delay: 10 * time.Millisecond},
+			expectError: false,
+		},
+		{
+			name:        "missing recording file",
+			replay:      ReplayConfig{Match: "normalized", Timing: "none"},
+			expectError: true,
+			errorMsg:    "replay.file cannot be empty",
+		},
+		{
+			name:        "recording does not exist",
+			replay:      ReplayConfig{File: "/definitely/does/not/exist.log", Match: "normalized", Timing: "none"},
+			expectError: true,
+			errorMsg:    "recording not found at path",
+		},
+		{
+			name:        "invalid match mode",
+			replay:      ReplayConfig{File: tempRecording, Match: "fuzzy", Timing: "none"},
+			expectError: true,
+			errorMsg:    "invalid replay.match 'fuzzy'",
+		},
+		{
+			name:        "invalid timing",
+			replay:      ReplayConfig{File: tempRecording, Match: "method", Timing: "slow"},
+			expectError: true,
+			errorMsg:    "invalid replay.timing 'slow'",
+		},
+		{
+			name:        "negative delay",
+			replay:      ReplayConfig{File: tempRecording, Match: "method", Timing: "fixed", Delay: -time.Second},
+			expectError: true,
+			errorMsg:    "must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Transport: "replay-server", Replay: tt.replay}
+			err := ValidateConfig(config)
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateReplayClientConfig(t *testing.T) {
+	tempRecording := "temp_client_recording.log"
+	err := os.WriteFile(tempRecording, []byte(""), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempRecording)
+
+	tempExe := "temp_client_mcp_sqlpp"
+	err = os.WriteFile(tempExe, []byte("#!/bin/bash\necho test"), 0755)
+	require.NoError(t, err)
+	defer os.Remove(tempExe)
+
+	tests := []struct {
+		name        string
+		config      *Config
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "spawned upstream",
+			config: &Config{
+				Transport: "replay-client",
+				ExePath:   tempExe,
+				Replay:    ReplayConfig{File: tempRecording},
+			},
+			expectError: false,
+		},
+		{
+			name: "http upstream does not need exe-path",
+			config: &Config{
+				Transport: "replay-client",
+				ExePath:   "/definitely/does/not/exist",
+				Replay:    ReplayConfig{File: tempRecording, URL: "http://localhost:8891/mcp"},
+			},
+			expectError: false,
+		},
+		{
+			name: "missing executable",
+			config: &Config{
+				Transport: "replay-client",
+				ExePath:   "/definitely/does/not/exist",
+				Replay:    ReplayConfig{File: tempRecording},
+			},
+			expectError: true,
+			errorMsg:    "executable not found at path",
+		},
+		{
+			name: "missing recording",
+			config: &Config{
+				Transport: "replay-client",
+				ExePath:   tempExe,
+			},
+			expectError: true,
+			errorMsg:    "replay.file cannot be empty for replay-client",
+		},
+		{
+			name: "invalid ignore pattern",
+			config: &Config{
+				Transport: "replay-client",
+				ExePath:   tempExe,
+				Replay:    ReplayConfig{File: tempRecording, IgnorePatterns: []string{"(unclosed"}},
+			},
+			expectError: true,
+			errorMsg:    "invalid replay.ignore-patterns entry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(tt.config)
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadReplayConfig(t *testing.T) {
+	viper.Reset()
+
+	tempRecording := "temp_load_recording.log"
+	err := os.WriteFile(tempRecording, []byte(""), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempRecording)
+
+	configContent := `transport: replay-server
+replay:
+  match: method
+  timing: fixed
+  delay: 250ms`
+	tempConfigFile := "test_replay_config.yaml"
+	err = os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	flags := &Flags{
+		ConfigFile: &tempConfigFile,
+		Transport:  stringPtr(""),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+		ReplayFile: stringPtr(tempRecording),
+	}
+
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, "replay-server", config.Transport)
+	assert.Equal(t, tempRecording, config.Replay.File)
+	assert.Equal(t, "method", config.Replay.Match)
+	assert.Equal(t, "fixed", config.Replay.Timing)
+	assert.Equal(t, 250*time.Millisecond, config.Replay.Delay)
+}
+
 // Helper functions for pointer creation
 func stringPtr(s string) *string {
 	return &s
@@ -458,3 +652,1166 @@ func stringPtr(s string) *string {
 func intPtr(i int) *int {
 	return &i
 }
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestValidateChaosConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		transport   string
+		rules       []ChaosRule
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:      "valid rules",
+			transport: "stdio",
+			rules: []ChaosRule{
+				{Name: "slow", Type: ChaosLatency, Latency: time.Second, Jitter: 100 * time.Millisecond},
+				{Name: "fail", Type: ChaosError, Tools: []string{"execute_*"}, Probability: 0.5},
+				{Type: ChaosDrop},
+				{Type: ChaosTruncate, TruncateBytes: 10},
+				{Type: ChaosKill, After: 3},
+				{Type: ChaosHTTPError, Status: 503},
+			},
+			expectError: false,
+		},
+		{
+			name:        "invalid type",
+			rules:       []ChaosRule{{Name: "x", Type: "explode"}},
+			expectError: true,
+			errorMsg:    "invalid type 'explode'",
+		},
+		{
+			name:        "duplicate names",
+			rules:       []ChaosRule{{Name: "x", Type: ChaosDrop}, {Name: "x", Type: ChaosDrop}},
+			expectError: true,
+			errorMsg:    "duplicate chaos rule name 'x'",
+		},
+		{
+			name:        "probability out of range",
+			rules:       []ChaosRule{{Type: ChaosDrop, Probability: 1.5}},
+			expectError: true,
+			errorMsg:    "probability 1.5 must be between 0 and 1",
+		},
+		{
+			name:        "latency missing",
+			rules:       []ChaosRule{{Type: ChaosLatency}},
+			expectError: true,
+			errorMsg:    "latency must be positive",
+		},
+		{
+			name:        "jitter larger than latency",
+			rules:       []ChaosRule{{Type: ChaosLatency, Latency: time.Second, Jitter: 2 * time.Second}},
+			expectError: true,
+			errorMsg:    "jitter must be between 0 and the latency",
+		},
+		{
+			name:        "non-5xx status",
+			rules:       []ChaosRule{{Type: ChaosHTTPError, Status: 404}},
+			expectError: true,
+			errorMsg:    "status 404 must be a 5xx code",
+		},
+		{
+			name:        "kill in http mode",
+			rules:       []ChaosRule{{Name: "crash", Type: ChaosKill}},
+			expectError: true,
+			errorMsg:    "chaos rule crash: kill needs the upstream process of stdio mode, not http",
+		},
+		{
+			name:        "negative after",
+			transport:   "stdio",
+			rules:       []ChaosRule{{Type: ChaosKill, After: -1}},
+			expectError: true,
+			errorMsg:    "after must not be negative",
+		},
+	}
+
+	exe, err := os.CreateTemp("", "mcp_sqlpp")
+	require.NoError(t, err)
+	exe.Close()
+	defer os.Remove(exe.Name())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Transport = "http"
+			if tt.transport != "" {
+				config.Transport = tt.transport
+				config.ExePath = exe.Name()
+			}
+			config.Chaos.Rules = tt.rules
+			err := ValidateConfig(config)
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateAdminConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.Admin.Port = 8090
+	assert.NoError(t, ValidateConfig(config))
+
+	config.Admin.Port = 70000
+	assert.ErrorContains(t, ValidateConfig(config), "invalid admin.port 70000")
+
+	config.Admin.Port = config.Port
+	assert.ErrorContains(t, ValidateConfig(config), "cannot be the same")
+}
+
+func TestLoadChaosConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+admin:
+  port: 8090
+chaos:
+  seed: 7
+  rules:
+    - name: slow
+      type: latency
+      tools: ["execute_*"]
+      latency: 2s
+      jitter: 500ms
+    - name: broken
+      type: http-error
+      status: 503
+      probability: 0.25
+      disabled: true`
+	tempConfigFile := "test_chaos_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	flags := &Flags{
+		ConfigFile: &tempConfigFile,
+		Transport:  stringPtr(""),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+	}
+
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, 8090, config.Admin.Port)
+	assert.True(t, config.Chaos.Enabled)
+	assert.Equal(t, int64(7), config.Chaos.Seed)
+	require.Len(t, config.Chaos.Rules, 2)
+	assert.Equal(t, ChaosRule{Name: "slow", Type: ChaosLatency, Tools: []string{"execute_*"}, Latency: 2 * time.Second, Jitter: 500 * time.Millisecond}, config.Chaos.Rules[0])
+	assert.Equal(t, ChaosRule{Name: "broken", Type: ChaosHTTPError, Status: 503, Probability: 0.25, Disabled: true}, config.Chaos.Rules[1])
+
+	// The admin port can also come from the command line
+	viper.Reset()
+	flags.AdminPort = intPtr(9000)
+	config, err = LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, 9000, config.Admin.Port)
+}
+
+func TestLoadValidationConfig(t *testing.T) {
+	viper.Reset()
+
+	flags := &Flags{
+		ConfigFile: stringPtr(""),
+		Transport:  stringPtr("http"),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+		Validate:   boolPtr(true),
+	}
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.True(t, config.Validation.Enabled)
+
+	viper.Reset()
+	os.Setenv("MCP_PROXY_VALIDATION_ENABLED", "true")
+	defer os.Unsetenv("MCP_PROXY_VALIDATION_ENABLED")
+	flags.Validate = boolPtr(false)
+	config, err = LoadConfig(flags)
+	require.NoError(t, err)
+	assert.True(t, config.Validation.Enabled)
+}
+
+func TestLoadBridgeConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: stdio
+exe-path: /bin/true
+protocol-bridge:
+  enabled: true`
+	tempConfigFile := "test_bridge_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.True(t, config.Bridge.Enabled)
+}
+
+func TestValidateToolFilterConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.ToolFilter.Rules = []ToolFilterRule{
+		{Principals: []string{"report-*"}, Allow: []string{"read_*", "describe_*"}},
+		{Deny: []string{"execute_sql"}},
+	}
+	assert.NoError(t, ValidateConfig(config))
+
+	config.ToolFilter.Default = "maybe"
+	assert.ErrorContains(t, ValidateConfig(config), "invalid tool-filter.default 'maybe'")
+	config.ToolFilter.Default = AccessAllow
+
+	config.ToolFilter.Rules = []ToolFilterRule{{Clients: []string{"report-*"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "tool-filter rule #1: clients is no longer supported")
+
+	config.ToolFilter.Rules = []ToolFilterRule{{Deny: []string{"execute_["}}}
+	assert.ErrorContains(t, ValidateConfig(config), "tool-filter rule #1: invalid pattern 'execute_['")
+}
+
+func TestLoadToolFilterConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+tool-filter:
+  rules:
+    - principals: ["report-*"]
+      allow: ["read_*", "describe_*"]
+    - deny: ["execute_*"]`
+	tempConfigFile := "test_tool_filter_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	flags := &Flags{
+		ConfigFile: &tempConfigFile,
+		Transport:  stringPtr(""),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+	}
+
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, AccessDeny, config.ToolFilter.Default)
+	assert.Equal(t, []ToolFilterRule{
+		{Principals: []string{"report-*"}, Allow: []string{"read_*", "describe_*"}},
+		{Deny: []string{"execute_*"}},
+	}, config.ToolFilter.Rules)
+}
+
+func TestValidateSQLPolicyConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.SQLPolicy.Tools = []SQLToolConfig{
+		{Name: "execute_*", Arguments: []string{"sql"}},
+		{Name: "batch", Arguments: []string{"statements.*.text"}},
+	}
+	assert.NoError(t, ValidateConfig(config))
+
+	config.SQLPolicy.Tools = []SQLToolConfig{{Arguments: []string{"sql"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "sql-policy tool #1: name cannot be empty")
+
+	config.SQLPolicy.Tools = []SQLToolConfig{{Name: "execute_[", Arguments: []string{"sql"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "sql-policy tool #1: invalid pattern 'execute_['")
+
+	config.SQLPolicy.Tools = []SQLToolConfig{{Name: "execute_sql"}}
+	assert.ErrorContains(t, ValidateConfig(config), "sql-policy tool 'execute_sql': arguments cannot be empty")
+
+	for _, arg := range []string{"", ".sql", "sql.", "a..b"} {
+		config.SQLPolicy.Tools = []SQLToolConfig{{Name: "execute_sql", Arguments: []string{arg}}}
+		assert.ErrorContains(t, ValidateConfig(config), "invalid argument path", arg)
+	}
+	config.SQLPolicy.Tools = nil
+
+	config.SQLPolicy.ACL.Rules = []SQLACLRule{
+		{Name: "support", Principals: []string{"support-*"}, Deny: []string{"hr.*"}},
+		{Allow: []string{"public.*"}},
+	}
+	assert.NoError(t, ValidateConfig(config))
+
+	config.SQLPolicy.ACL.Default = "maybe"
+	assert.ErrorContains(t, ValidateConfig(config), "invalid sql-policy.acl.default 'maybe'")
+	config.SQLPolicy.ACL.Default = AccessAllow
+
+	config.SQLPolicy.ACL.Rules = []SQLACLRule{{Name: "support", Clients: []string{"support-*"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "sql-policy acl rule support: clients is no longer supported")
+
+	config.SQLPolicy.ACL.Rules = []SQLACLRule{{Name: "a"}, {Name: "a"}}
+	assert.ErrorContains(t, ValidateConfig(config), "duplicate sql-policy acl rule name 'a'")
+
+	config.SQLPolicy.ACL.Rules = []SQLACLRule{{}, {Deny: []string{"hr.["}}}
+	assert.ErrorContains(t, ValidateConfig(config), "sql-policy acl rule #2: invalid pattern 'hr.['")
+}
+
+func TestLoadSQLPolicyConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+sql-policy:
+  tools:
+    - name: execute_sql
+      arguments: ["sql", "params.query"]
+  acl:
+    default-schema: public
+    rules:
+      - name: support
+        principals: ["support-*"]
+        deny: ["hr.*"]`
+	tempConfigFile := "test_sql_policy_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	flags := &Flags{
+		ConfigFile: &tempConfigFile,
+		Transport:  stringPtr(""),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+		ReadOnly:   boolPtr(true),
+	}
+
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.True(t, config.SQLPolicy.ReadOnly)
+	assert.Equal(t, []SQLToolConfig{{Name: "execute_sql", Arguments: []string{"sql", "params.query"}}}, config.SQLPolicy.Tools)
+	assert.Equal(t, SQLACLConfig{
+		DefaultSchema: "public",
+		Default:       AccessDeny,
+		Rules:         []SQLACLRule{{Name: "support", Principals: []string{"support-*"}, Deny: []string{"hr.*"}}},
+	}, config.SQLPolicy.ACL)
+	assert.True(t, config.SQLPolicy.Enabled())
+}
+
+func TestLoadAuditConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+audit:
+  file: ./from-file.jsonl
+  database-arguments: ["dsn"]`
+	tempConfigFile := "test_audit_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	flags := &Flags{
+		ConfigFile: &tempConfigFile,
+		Transport:  stringPtr(""),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+		AuditFile:  stringPtr(""),
+	}
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, AuditConfig{File: "./from-file.jsonl", DatabaseArguments: []string{"dsn"}}, config.Audit)
+
+	viper.Reset()
+	flags.AuditFile = stringPtr("./from-flag.jsonl")
+	config, err = LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, "./from-flag.jsonl", config.Audit.File)
+}
+
+func TestValidateLimitsConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.Limits = LimitsConfig{MaxBytes: 1 << 20, MaxRows: 500, MaxItems: 10, Action: LimitError}
+	assert.NoError(t, ValidateConfig(config))
+
+	config.Limits = LimitsConfig{MaxRows: -1, Action: LimitTruncate}
+	assert.ErrorContains(t, ValidateConfig(config), "limits cannot be negative")
+
+	config.Limits = LimitsConfig{MaxBytes: 100, Action: LimitTruncate}
+	assert.ErrorContains(t, ValidateConfig(config), "limits.max-bytes 100 is too small")
+
+	config.Limits = LimitsConfig{MaxRows: 10, Action: "drop"}
+	assert.ErrorContains(t, ValidateConfig(config), "invalid limits.action 'drop'")
+}
+
+func TestLoadLimitsConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+limits:
+  max-bytes: 65536
+  max-items: 20
+  action: error`
+	tempConfigFile := "test_limits_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	flags := &Flags{
+		ConfigFile: &tempConfigFile,
+		Transport:  stringPtr(""),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+		MaxBytes:   intPtr(1 << 20),
+		MaxRows:    intPtr(200),
+	}
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, LimitsConfig{MaxBytes: 1 << 20, MaxRows: 200, MaxItems: 20, Action: LimitError}, config.Limits)
+}
+
+func TestValidateRedactionConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.Redaction = RedactionConfig{Enabled: true, Detectors: Detectors, Paths: []string{"params.arguments.*", "**.ssn"}, Patterns: []string{`\d{3}-\d{2}-\d{4}`}}
+	assert.NoError(t, ValidateConfig(config))
+
+	config.Redaction = RedactionConfig{Detectors: []string{"email", "phone"}}
+	assert.ErrorContains(t, ValidateConfig(config), "invalid redaction detector 'phone'")
+
+	config.Redaction = RedactionConfig{Paths: []string{"params..token"}}
+	assert.ErrorContains(t, ValidateConfig(config), "invalid redaction path 'params..token'")
+
+	config.Redaction = RedactionConfig{Patterns: []string{"[a-"}}
+	assert.ErrorContains(t, ValidateConfig(config), "invalid redaction pattern '[a-'")
+}
+
+func TestLoadRedactionConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+redaction:
+  detectors: ["bearer"]
+  paths: ["params.arguments.password"]
+  mask: "***"`
+	tempConfigFile := "test_redaction_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	flags := &Flags{
+		ConfigFile: &tempConfigFile,
+		Transport:  stringPtr(""),
+		Port:       intPtr(0),
+		XferPort:   intPtr(0),
+		ExePath:    stringPtr(""),
+	}
+	config, err := LoadConfig(flags)
+	require.NoError(t, err)
+	assert.False(t, config.Redaction.Active())
+	assert.Equal(t, []string{"bearer"}, config.Redaction.Detectors)
+
+	viper.Reset()
+	flags.Redact = boolPtr(true)
+	flags.LogMetadataOnly = boolPtr(true)
+	config, err = LoadConfig(flags)
+	require.NoError(t, err)
+	assert.Equal(t, RedactionConfig{
+		Enabled:      true,
+		MetadataOnly: true,
+		Detectors:    []string{"bearer"},
+		Paths:        []string{"params.arguments.password"},
+		Mask:         "***",
+	}, config.Redaction)
+
+	// Defaults
+	viper.Reset()
+	os.WriteFile(tempConfigFile, []byte("transport: http"), 0644)
+	config, err = LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, Detectors, config.Redaction.Detectors)
+	assert.Equal(t, "[REDACTED]", config.Redaction.Mask)
+}
+
+func TestValidateMaskingConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.Masking = MaskingConfig{Rules: []MaskingRule{
+		{Name: "ssn", Columns: []string{"ssn", "*_ssn"}, Detectors: []string{DetectSSN}, Strategy: MaskPartial, Reveal: 2},
+		{Tools: []string{"execute_*"}, Detectors: []string{DetectEmail, DetectCard}, Strategy: MaskHash},
+		{Columns: []string{"dob"}, Strategy: MaskNull},
+	}}
+	assert.NoError(t, ValidateConfig(config))
+
+	tests := []struct {
+		rule MaskingRule
+		err  string
+	}{
+		{MaskingRule{Strategy: MaskNull}, "masking rule #1: needs columns or detectors"},
+		{MaskingRule{Name: "x", Columns: []string{"[a"}, Strategy: MaskNull}, "masking rule x: invalid pattern '[a'"},
+		{MaskingRule{Detectors: []string{"bearer"}, Strategy: MaskNull}, "invalid detector 'bearer'"},
+		{MaskingRule{Columns: []string{"ssn"}}, "invalid strategy ''"},
+		{MaskingRule{Columns: []string{"ssn"}, Strategy: MaskPartial, Reveal: -1}, "reveal cannot be negative"},
+	}
+	for _, tt := range tests {
+		config.Masking = MaskingConfig{Rules: []MaskingRule{tt.rule}}
+		assert.ErrorContains(t, ValidateConfig(config), tt.err)
+	}
+
+	config.Masking = MaskingConfig{Rules: []MaskingRule{
+		{Name: "a", Columns: []string{"x"}, Strategy: MaskNull},
+		{Name: "a", Columns: []string{"y"}, Strategy: MaskNull},
+	}}
+	assert.ErrorContains(t, ValidateConfig(config), "duplicate masking rule name 'a'")
+}
+
+func TestLoadMaskingConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+masking:
+  rules:
+    - name: ssn
+      columns: ["ssn"]
+      detectors: ["ssn"]
+      strategy: partial`
+	tempConfigFile := "test_masking_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	os.Setenv("MCP_PROXY_MASKING_SALT", "from-env")
+	defer os.Unsetenv("MCP_PROXY_MASKING_SALT")
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, MaskingConfig{
+		Salt:  "from-env",
+		Rules: []MaskingRule{{Name: "ssn", Columns: []string{"ssn"}, Detectors: []string{"ssn"}, Strategy: MaskPartial}},
+	}, config.Masking)
+}
+
+func TestValidateCacheConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.Cache = CacheConfig{MaxEntries: 100, Scope: CacheSession, Tools: []CacheTool{{Name: "list_*", TTL: time.Minute}}}
+	assert.NoError(t, ValidateConfig(config))
+	assert.True(t, config.Cache.Enabled())
+
+	config.Cache = CacheConfig{MaxEntries: -1}
+	assert.ErrorContains(t, ValidateConfig(config), "cache sizes cannot be negative")
+
+	config.Cache = CacheConfig{Scope: "client"}
+	assert.ErrorContains(t, ValidateConfig(config), "invalid cache.scope 'client'")
+
+	config.Cache = CacheConfig{Tools: []CacheTool{{TTL: time.Second}}}
+	assert.ErrorContains(t, ValidateConfig(config), "cache tool #1: name cannot be empty")
+
+	config.Cache = CacheConfig{Tools: []CacheTool{{Name: "list_tables"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "cache tool 'list_tables': ttl must be positive")
+}
+
+func TestLoadCacheConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+cache:
+  max-entries: 500
+  tools:
+    - name: describe_*
+      ttl: 5m`
+	tempConfigFile := "test_cache_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, CacheConfig{
+		MaxEntries:    500,
+		MaxEntryBytes: 1 << 20,
+		Scope:         CacheGlobal,
+		PurgeOnWrite:  true,
+		Tools:         []CacheTool{{Name: "describe_*", TTL: 5 * time.Minute}},
+	}, config.Cache)
+}
+
+func TestValidateCoalesceConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.Coalesce = CoalesceConfig{Tools: []string{"list_*", "execute_sql"}}
+	assert.NoError(t, ValidateConfig(config))
+
+	config.Coalesce = CoalesceConfig{Tools: []string{"list_["}}
+	assert.ErrorContains(t, ValidateConfig(config), "coalesce: invalid pattern 'list_['")
+}
+
+func TestValidateToolRewriteConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+
+	config.ToolRewrite = ToolRewriteConfig{Prefix: "sqlpp_", Tools: []ToolOverride{
+		{Name: "execute", Rename: "run_sql", Arguments: []ToolArgument{{Name: "sql", Description: "SQL script"}}},
+		{Name: "list_tables", Description: "List tables"},
+	}}
+	assert.NoError(t, ValidateConfig(config))
+	assert.True(t, config.ToolRewrite.Enabled())
+
+	config.ToolRewrite = ToolRewriteConfig{Prefix: "sql pp"}
+	assert.ErrorContains(t, ValidateConfig(config), "invalid tool-rewrite.prefix 'sql pp'")
+
+	config.ToolRewrite = ToolRewriteConfig{Tools: []ToolOverride{{Rename: "x"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "tool-rewrite tool #1: name cannot be empty")
+
+	config.ToolRewrite = ToolRewriteConfig{Tools: []ToolOverride{{Name: "a"}, {Name: "a"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "duplicate tool-rewrite tool 'a'")
+
+	config.ToolRewrite = ToolRewriteConfig{Tools: []ToolOverride{{Name: "a", Rename: "c"}, {Name: "b", Rename: "c"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "tool-rewrite tools 'a' and 'b' are both renamed to 'c'")
+
+	config.ToolRewrite = ToolRewriteConfig{Tools: []ToolOverride{{Name: "a", Rename: "b"}, {Name: "b", Description: "kept"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "tool-rewrite tool 'a' is renamed to 'b', which tool 'b' keeps")
+
+	config.ToolRewrite = ToolRewriteConfig{Tools: []ToolOverride{{Name: "a", Rename: "b!"}}}
+	assert.ErrorContains(t, ValidateConfig(config), "tool-rewrite tool 'a': invalid rename 'b!'")
+
+	config.ToolRewrite = ToolRewriteConfig{Tools: []ToolOverride{{Name: "a", Arguments: []ToolArgument{{Description: "x"}}}}}
+	assert.ErrorContains(t, ValidateConfig(config), "tool-rewrite tool 'a': argument #1: name cannot be empty")
+}
+
+func TestValidateUpstreams(t *testing.T) {
+	exe, err := os.CreateTemp("", "mcp_sqlpp")
+	require.NoError(t, err)
+	exe.Close()
+	defer os.Remove(exe.Name())
+
+	config := DefaultConfig()
+	config.Transport = "stdio"
+	config.ExePath = "/nonexistent/mcp_sqlpp"
+	config.Upstreams = []UpstreamConfig{
+		{Name: "analytics", ExePath: exe.Name(), Env: []string{"SQLPP_CONNECTION=analytics"}},
+		{Name: "billing", Prefix: "bill_", URL: "http://localhost:8892/mcp"},
+	}
+	assert.NoError(t, ValidateConfig(config), "exe-path is not used with upstreams")
+	assert.Equal(t, "analytics_", config.Upstreams[0].NamePrefix())
+	assert.Equal(t, "bill_", config.Upstreams[1].NamePrefix())
+
+	config.Transport = "http"
+	config.XferPort = config.Port
+	assert.NoError(t, ValidateConfig(config), "xfer-port is not used with upstreams")
+
+	for _, tc := range []struct {
+		upstreams []UpstreamConfig
+		errorMsg  string
+	}{
+		{[]UpstreamConfig{{Name: "1st", URL: "http://a"}}, "upstream #1: invalid name '1st'"},
+		{[]UpstreamConfig{{Name: "a", URL: "http://a"}, {Name: "a", URL: "http://b"}}, "duplicate upstream 'a'"},
+		{[]UpstreamConfig{{Name: "a", Prefix: "x_", URL: "http://a"}, {Name: "b", Prefix: "x_", URL: "http://b"}}, "upstreams 'a' and 'b' have the same prefix 'x_'"},
+		{[]UpstreamConfig{{Name: "a", Prefix: "a b", URL: "http://a"}}, "upstream 'a': invalid prefix 'a b'"},
+		{[]UpstreamConfig{{Name: "a", ExePath: exe.Name(), URL: "http://a"}}, "set either exe-path or url, not both"},
+		{[]UpstreamConfig{{Name: "a"}}, "upstream 'a': exe-path or url is required"},
+		{[]UpstreamConfig{{Name: "a", URL: "ftp://a"}}, "upstream 'a': invalid url 'ftp://a'"},
+		{[]UpstreamConfig{{Name: "a", ExePath: "/nonexistent/mcp_sqlpp"}}, "upstream 'a': executable not found at path"},
+		{[]UpstreamConfig{{Name: "a", URL: "http://a", Env: []string{"DEBUG"}}}, "upstream 'a': invalid env entry 'DEBUG'"},
+	} {
+		config.Upstreams = tc.upstreams
+		assert.ErrorContains(t, ValidateConfig(config), tc.errorMsg)
+	}
+}
+
+func TestLoadUpstreams(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+upstreams:
+  - name: billing
+    prefix: bill_
+    url: http://localhost:8892/mcp
+  - name: hr
+    url: http://localhost:8893/mcp`
+	tempConfigFile := "test_upstreams_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, []UpstreamConfig{
+		{Name: "billing", Prefix: "bill_", URL: "http://localhost:8892/mcp"},
+		{Name: "hr", URL: "http://localhost:8893/mcp"},
+	}, config.Upstreams)
+}
+
+func TestValidatePoolConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.XferPort = config.Port
+	config.Pool.Targets = []string{"http://db1:8891", "https://db2:8891/"}
+	assert.NoError(t, ValidateConfig(config), "xfer-port is not used with a pool")
+	assert.True(t, config.Pool.Enabled())
+
+	config.Pool.Strategy = BalanceLeastInFlight
+	config.Pool.HealthCheck.Interval = 0
+	config.Pool.HealthCheck.Timeout = 0
+	assert.NoError(t, ValidateConfig(config), "disabled health checks need no timeout")
+
+	for _, tc := range []struct {
+		modify   func(pool *PoolConfig)
+		errorMsg string
+	}{
+		{func(pool *PoolConfig) { pool.Targets = []string{"db1:8891"} }, "pool target #1: invalid url 'db1:8891'"},
+		{func(pool *PoolConfig) { pool.Targets = []string{"http://a", "http://a"} }, "duplicate pool target 'http://a'"},
+		{func(pool *PoolConfig) { pool.Strategy = "random" }, "invalid pool.strategy 'random'"},
+		{func(pool *PoolConfig) { pool.HealthCheck.Interval = -time.Second }, "pool.health-check.interval cannot be negative"},
+		{func(pool *PoolConfig) { pool.HealthCheck.Path = "mcp" }, "invalid pool.health-check.path 'mcp'"},
+		{func(pool *PoolConfig) { pool.HealthCheck.Timeout = 0 }, "pool.health-check.timeout must be positive"},
+		{func(pool *PoolConfig) { pool.HealthCheck.HealthyThreshold = 0 }, "pool.health-check thresholds must be at least 1"},
+		{func(pool *PoolConfig) { pool.AffinityTimeout = 0 }, "pool.affinity-timeout must be positive when pool.affinity is set"},
+	} {
+		config := DefaultConfig()
+		config.Transport = "http"
+		config.Pool.Targets = []string{"http://db1:8891"}
+		tc.modify(&config.Pool)
+		assert.ErrorContains(t, ValidateConfig(config), tc.errorMsg)
+	}
+
+	config = DefaultConfig()
+	config.Transport = "http"
+	config.Pool.Targets = []string{"http://db1:8891"}
+	config.Pool.Affinity = false
+	config.Pool.AffinityTimeout = 0
+	assert.NoError(t, ValidateConfig(config), "affinity-timeout is not used without affinity")
+
+	config = DefaultConfig()
+	config.Transport = "http"
+	config.Pool.Targets = []string{"http://db1:8891"}
+	config.Upstreams = []UpstreamConfig{{Name: "a", URL: "http://a"}}
+	assert.ErrorContains(t, ValidateConfig(config), "pool and upstreams cannot both be set")
+}
+
+func TestLoadPoolConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+pool:
+  targets: [http://db1:8891, http://db2:8891]
+  strategy: least-in-flight
+  health-check:
+    interval: 30s`
+	tempConfigFile := "test_pool_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, PoolConfig{
+		Targets:         []string{"http://db1:8891", "http://db2:8891"},
+		Strategy:        BalanceLeastInFlight,
+		Affinity:        true,
+		AffinityTimeout: time.Hour,
+		HealthCheck: HealthCheckConfig{
+			Path:               "/mcp",
+			Interval:           30 * time.Second,
+			Timeout:            2 * time.Second,
+			UnhealthyThreshold: 3,
+			HealthyThreshold:   2,
+		},
+	}, config.Pool)
+}
+
+func TestValidateRoutingConfig(t *testing.T) {
+	exe, err := os.CreateTemp("", "mcp_sqlpp")
+	require.NoError(t, err)
+	exe.Close()
+	defer os.Remove(exe.Name())
+
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.Routing.Routes = []RouteConfig{
+		{Name: "analytics", Match: []string{"analytics"}, URL: "http://{value}:8891/mcp"},
+		{Name: "dev", Match: []string{"dev*"}, ExePath: exe.Name(), Env: []string{"SQLPP_CONNECTION={value}"}},
+	}
+	assert.NoError(t, ValidateConfig(config))
+
+	for _, tc := range []struct {
+		routing  RoutingConfig
+		errorMsg string
+	}{
+		{RoutingConfig{Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, URL: "http://a"}}}, "routing.arguments cannot be empty when routes are set"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Match: []string{"a"}, URL: "http://a"}}}, "route #1: name cannot be empty"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, URL: "http://a"}, {Name: "a", Match: []string{"b"}, URL: "http://b"}}}, "duplicate route 'a'"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", URL: "http://a"}}}, "route 'a': match cannot be empty"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"["}, URL: "http://a"}}}, "route 'a': invalid pattern '['"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}}}}, "route 'a': exe-path or url is required"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, ExePath: exe.Name(), URL: "http://a"}}}, "route 'a': set either exe-path or url, not both"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, URL: "a:8891"}}}, "route 'a': invalid url 'a:8891'"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, ExePath: "/nonexistent/mcp_sqlpp"}}}, "route 'a': executable not found at path"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, URL: "http://a", Env: []string{"DEBUG"}}}}, "route 'a': invalid env entry 'DEBUG'"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, URL: "http://a"}}, MaxBackends: -1, InitTimeout: time.Second}, "routing.max-backends cannot be negative"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, URL: "http://a"}}, IdleTimeout: -time.Second, InitTimeout: time.Second}, "routing.idle-timeout cannot be negative"},
+		{RoutingConfig{Arguments: []string{"connection"}, Routes: []RouteConfig{{Name: "a", Match: []string{"a"}, URL: "http://a"}}}, "routing.init-timeout must be positive"},
+	} {
+		config.Routing = tc.routing
+		assert.ErrorContains(t, ValidateConfig(config), tc.errorMsg)
+	}
+}
+
+func TestLoadRoutingConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+routing:
+  routes:
+    - name: dev
+      match: ["dev*"]
+      url: http://{value}:8891/mcp`
+	tempConfigFile := "test_routing_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, RoutingConfig{
+		Arguments:   []string{"connection", "database"},
+		Routes:      []RouteConfig{{Name: "dev", Match: []string{"dev*"}, URL: "http://{value}:8891/mcp"}},
+		MaxBackends: 16,
+		IdleTimeout: 10 * time.Minute,
+		InitTimeout: 30 * time.Second,
+	}, config.Routing)
+}
+
+func TestValidateChildrenConfig(t *testing.T) {
+	exe, err := os.CreateTemp("", "mcp_sqlpp")
+	require.NoError(t, err)
+	exe.Close()
+	defer os.Remove(exe.Name())
+
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.XferPort = config.Port
+	config.ExePath = exe.Name()
+	config.Children.Enabled = true
+	assert.NoError(t, ValidateConfig(config), "xfer-port is not used with children")
+
+	config.ExePath = "/nonexistent/mcp_sqlpp"
+	assert.ErrorContains(t, ValidateConfig(config), "executable not found at path '/nonexistent/mcp_sqlpp'")
+	config.ExePath = exe.Name()
+
+	for _, tc := range []struct {
+		modify   func(*Config)
+		errorMsg string
+	}{
+		{func(c *Config) { c.Children.MinIdle = -1 }, "children settings cannot be negative"},
+		{func(c *Config) { c.Children.IdleTimeout = -time.Second }, "children settings cannot be negative"},
+		{func(c *Config) { c.Children.MinIdle, c.Children.MaxIdle = 3, 2 }, "children.max-idle (2) cannot be less than children.min-idle (3)"},
+		{func(c *Config) { c.Pool.Targets = []string{"http://db1:8891"} }, "children cannot be combined with pool, upstreams or endpoints"},
+		{func(c *Config) { c.Endpoints = []EndpointConfig{{Prefix: "/a", Target: "http://a"}} }, "children cannot be combined with pool, upstreams or endpoints"},
+	} {
+		modified := *config
+		modified.Children = DefaultConfig().Children
+		modified.Children.Enabled = true
+		tc.modify(&modified)
+		assert.ErrorContains(t, ValidateConfig(&modified), tc.errorMsg)
+	}
+}
+
+func TestLoadChildrenConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `children:
+  enabled: true
+  min-idle: 1`
+	tempConfigFile := "test_children_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	exe, err := os.CreateTemp("", "mcp_sqlpp")
+	require.NoError(t, err)
+	exe.Close()
+	defer os.Remove(exe.Name())
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr("http"), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr(exe.Name())})
+	require.NoError(t, err)
+	assert.Equal(t, ChildrenConfig{Enabled: true, MinIdle: 1, MaxIdle: 4, IdleTimeout: 5 * time.Minute, MaxSessions: 100}, config.Children)
+}
+
+func TestValidateRWSplitConfig(t *testing.T) {
+	exe, err := os.CreateTemp("", "mcp_sqlpp")
+	require.NoError(t, err)
+	exe.Close()
+	defer os.Remove(exe.Name())
+
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.RWSplit.Replica = ReplicaConfig{URL: "http://replica:8891/mcp"}
+	assert.NoError(t, ValidateConfig(config))
+	config.RWSplit.Replica = ReplicaConfig{ExePath: exe.Name(), Env: []string{"SQLPP_CONNECTION=replica"}}
+	assert.NoError(t, ValidateConfig(config))
+
+	for _, tc := range []struct {
+		modify   func(*RWSplitConfig)
+		errorMsg string
+	}{
+		{func(c *RWSplitConfig) { c.Replica.URL = "http://replica" }, "read-write-split.replica: set either exe-path or url, not both"},
+		{func(c *RWSplitConfig) { c.Replica = ReplicaConfig{URL: "replica:8891"} }, "read-write-split.replica: invalid url 'replica:8891'"},
+		{func(c *RWSplitConfig) { c.Replica.ExePath = "/nonexistent/mcp_sqlpp" }, "read-write-split.replica: executable not found at path"},
+		{func(c *RWSplitConfig) { c.Replica.Env = []string{"DEBUG"} }, "read-write-split.replica: invalid env entry 'DEBUG'"},
+		{func(c *RWSplitConfig) { c.PrimaryAfterWrite = -time.Second }, "read-write-split.primary-after-write cannot be negative"},
+		{func(c *RWSplitConfig) { c.HealthCheck.Interval = -time.Second }, "read-write-split.health-check.interval cannot be negative"},
+		{func(c *RWSplitConfig) { c.HealthCheck.Timeout = 0 }, "read-write-split.health-check.timeout must be positive"},
+		{func(c *RWSplitConfig) { c.HealthCheck.HealthyThreshold = 0 }, "read-write-split.health-check thresholds must be at least 1"},
+	} {
+		split := DefaultConfig().RWSplit
+		split.Replica = ReplicaConfig{ExePath: exe.Name()}
+		tc.modify(&split)
+		config.RWSplit = split
+		assert.ErrorContains(t, ValidateConfig(config), tc.errorMsg)
+	}
+}
+
+func TestLoadRWSplitConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+read-write-split:
+  replica:
+    url: http://replica:8891/mcp
+  health-check:
+    interval: 5s`
+	tempConfigFile := "test_rwsplit_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.True(t, config.RWSplit.Enabled())
+	assert.Equal(t, "http://replica:8891/mcp", config.RWSplit.Replica.URL)
+	assert.Equal(t, 5*time.Second, config.RWSplit.PrimaryAfterWrite)
+	assert.Equal(t, HealthCheckConfig{Interval: 5 * time.Second, Timeout: 2 * time.Second, UnhealthyThreshold: 3, HealthyThreshold: 2}, config.RWSplit.HealthCheck)
+}
+
+func TestValidateEndpoints(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.XferPort = config.Port
+	config.Endpoints = []EndpointConfig{
+		{Prefix: "/analytics", Target: "http://localhost:8891", Tokens: []EndpointToken{{Name: "analysts", Token: "s3cret"}}},
+		{Prefix: "/billing/v1", Target: "http://localhost:8892", KeepPrefix: true, SQLPolicy: SQLPolicyConfig{ReadOnly: true}},
+	}
+	assert.NoError(t, ValidateConfig(config), "xfer-port is not used with endpoints")
+
+	for _, tc := range []struct {
+		endpoint EndpointConfig
+		errorMsg string
+	}{
+		{EndpointConfig{Prefix: "analytics", Target: "http://a"}, "endpoint #1: invalid prefix 'analytics'"},
+		{EndpointConfig{Prefix: "/", Target: "http://a"}, "endpoint #1: invalid prefix '/'"},
+		{EndpointConfig{Prefix: "/analytics/", Target: "http://a"}, "endpoint #1: invalid prefix '/analytics/'"},
+		{EndpointConfig{Prefix: "/analytics", Target: "localhost:8891"}, "endpoint '/analytics': invalid target 'localhost:8891'"},
+		{EndpointConfig{Prefix: "/analytics", Target: "http://a", Tokens: []EndpointToken{{Name: "analysts"}}}, "endpoint '/analytics': token #1: name and token are required"},
+		{EndpointConfig{Prefix: "/analytics", Target: "http://a", Tokens: []EndpointToken{{Name: "a", Token: "t"}, {Name: "b", Token: "t"}}}, "endpoint '/analytics': token 'b' repeats another token"},
+		{EndpointConfig{Prefix: "/analytics", Target: "http://a", ToolFilter: ToolFilterConfig{Rules: []ToolFilterRule{{Deny: []string{"["}}}}}, "endpoint '/analytics': "},
+	} {
+		config.Endpoints = []EndpointConfig{tc.endpoint}
+		assert.ErrorContains(t, ValidateConfig(config), tc.errorMsg)
+	}
+
+	config.Endpoints = []EndpointConfig{{Prefix: "/a", Target: "http://a"}, {Prefix: "/a", Target: "http://b"}}
+	assert.ErrorContains(t, ValidateConfig(config), "duplicate endpoint prefix '/a'")
+
+	config.Endpoints = []EndpointConfig{{Prefix: "/a", Target: "http://a"}}
+	config.Pool.Targets = []string{"http://b"}
+	assert.ErrorContains(t, ValidateConfig(config), "endpoints cannot be combined with pool or upstreams")
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+endpoints:
+  - prefix: /analytics
+    target: http://localhost:8891
+    tokens:
+      - name: analysts
+        token: s3cret
+    sql-policy:
+      read-only: true`
+	tempConfigFile := "test_endpoints_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, []EndpointConfig{{
+		Prefix:    "/analytics",
+		Target:    "http://localhost:8891",
+		Tokens:    []EndpointToken{{Name: "analysts", Token: "s3cret"}},
+		SQLPolicy: SQLPolicyConfig{ReadOnly: true},
+	}}, config.Endpoints)
+}
+
+func TestValidateApprovalConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.Approval.Classes = []string{"dml", "ddl"}
+	assert.EqualError(t, ValidateConfig(config), "approval requires admin.port, as calls are approved through the admin interface")
+	config.Admin.Port = 9090
+	assert.EqualError(t, ValidateConfig(config), "approval requires admin.token, as any local process could otherwise approve calls, the client's included")
+	config.Admin.Token = "s3cret"
+	assert.NoError(t, ValidateConfig(config))
+
+	for _, tc := range []struct {
+		modify   func(*ApprovalConfig)
+		errorMsg string
+	}{
+		{func(c *ApprovalConfig) { c.Classes = []string{"dml", "select"} }, "invalid approval class 'select': must be one of dml, ddl, dcl, tcl, other"},
+		{func(c *ApprovalConfig) { c.Timeout = 0 }, "approval.timeout must be positive"},
+		{func(c *ApprovalConfig) { c.ProgressInterval = -time.Second }, "approval.progress-interval cannot be negative"},
+	} {
+		approval := DefaultConfig().Approval
+		approval.Classes = []string{"dml"}
+		tc.modify(&approval)
+		config.Approval = approval
+		assert.ErrorContains(t, ValidateConfig(config), tc.errorMsg)
+	}
+}
+
+func TestLoadApprovalConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+admin:
+  port: 9090
+approval:
+  classes: [dml, ddl]
+  timeout: 2m`
+	tempConfigFile := "test_approval_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+	t.Setenv("MCP_PROXY_ADMIN_TOKEN", "s3cret")
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.True(t, config.Approval.Enabled())
+	assert.Equal(t, AdminConfig{Port: 9090, Token: "s3cret"}, config.Admin, "the token can come from the environment")
+	assert.Equal(t, ApprovalConfig{Classes: []string{"dml", "ddl"}, Timeout: 2 * time.Minute, ProgressInterval: 10 * time.Second}, config.Approval)
+}
+
+func TestValidateConfirmationConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.Confirmation.Classes = []string{"dml", "ddl"}
+	assert.NoError(t, ValidateConfig(config), "confirmation does not need the admin interface")
+
+	config.Confirmation.Classes = []string{"select"}
+	assert.EqualError(t, ValidateConfig(config), "invalid confirmation class 'select': must be one of dml, ddl, dcl, tcl, other")
+	config.Confirmation = ConfirmationConfig{Classes: []string{"ddl"}}
+	assert.EqualError(t, ValidateConfig(config), "confirmation.timeout must be positive")
+}
+
+func TestLoadConfirmationConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+confirmation:
+  classes: [ddl]`
+	tempConfigFile := "test_confirmation_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, ConfirmationConfig{Classes: []string{"ddl"}, Timeout: 2 * time.Minute}, config.Confirmation)
+}
+
+func TestValidateSessionsConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.Sessions = SessionsConfig{IdleTimeout: time.Hour, LogDir: t.TempDir()}
+	assert.NoError(t, ValidateConfig(config))
+
+	for _, tc := range []struct {
+		modify   func(*Config)
+		errorMsg string
+	}{
+		{func(c *Config) { c.Sessions.IdleTimeout = -time.Second }, "sessions.idle-timeout cannot be negative"},
+		{func(c *Config) { c.Sessions.LogDir = "/nonexistent/sessions" }, "sessions.log-dir '/nonexistent/sessions' is not a directory"},
+		{func(c *Config) { c.Endpoints = []EndpointConfig{{Prefix: "/a", Target: "http://a"}} }, "sessions.log-dir applies to sessions relayed to xfer-port or the pool"},
+	} {
+		modified := *config
+		tc.modify(&modified)
+		assert.ErrorContains(t, ValidateConfig(&modified), tc.errorMsg)
+	}
+
+	config.Sessions.LogDir = ""
+	config.Endpoints = []EndpointConfig{{Prefix: "/a", Target: "http://a"}}
+	assert.NoError(t, ValidateConfig(config), "idle timeouts apply to endpoints too")
+}
+
+func TestLoadSessionsConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+sessions:
+  idle-timeout: 30m`
+	tempConfigFile := "test_sessions_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, SessionsConfig{IdleTimeout: 30 * time.Minute}, config.Sessions)
+	assert.Positive(t, DefaultConfig().Sessions.IdleTimeout, "sessions expire unless configured not to")
+}
+
+func TestValidateResumptionConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Transport = "http"
+	config.Resumption.Enabled = true
+	config.Resumption.SpillDir = t.TempDir()
+	assert.NoError(t, ValidateConfig(config))
+
+	for _, tc := range []struct {
+		modify   func(*Config)
+		errorMsg string
+	}{
+		{func(c *Config) { c.Resumption.MaxBytes = 0 }, "resumption.max-bytes must be positive"},
+		{func(c *Config) { c.Resumption.Retention = 0 }, "resumption.retention must be positive"},
+		{func(c *Config) { c.Resumption.SpillDir = "/nonexistent/spill" }, "resumption.spill-dir '/nonexistent/spill' is not a directory"},
+		{func(c *Config) { c.Endpoints = []EndpointConfig{{Prefix: "/a", Target: "http://a"}} }, "resumption cannot be combined with endpoints"},
+	} {
+		modified := *config
+		tc.modify(&modified)
+		assert.ErrorContains(t, ValidateConfig(&modified), tc.errorMsg)
+	}
+}
+
+func TestLoadResumptionConfig(t *testing.T) {
+	viper.Reset()
+
+	configContent := `transport: http
+resumption:
+  enabled: true
+  max-bytes: 4096`
+	tempConfigFile := "test_resumption_config.yaml"
+	err := os.WriteFile(tempConfigFile, []byte(configContent), 0644)
+	require.NoError(t, err)
+	defer os.Remove(tempConfigFile)
+
+	config, err := LoadConfig(&Flags{ConfigFile: &tempConfigFile, Transport: stringPtr(""), Port: intPtr(0), XferPort: intPtr(0), ExePath: stringPtr("")})
+	require.NoError(t, err)
+	assert.Equal(t, ResumptionConfig{Enabled: true, MaxBytes: 4096, Retention: 5 * time.Minute}, config.Resumption)
+}
@@ -0,0 +1,153 @@
+package confirm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+)
+
+// requestedSchema is the form shown to the user: a single checkbox
+var requestedSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"confirm": map[string]interface{}{
+			"type":        "boolean",
+			"title":       "Run this SQL",
+			"description": "Check to let the statements run against the database",
+			"default":     false,
+		},
+	},
+	"required": []string{"confirm"},
+}
+
+// Confirmer asks the user to confirm tools/call requests whose SQL has
+// statements of the configured classes before they go on to the upstream. It
+// asks with an elicitation/create request, so only calls from clients that
+// declared the elicitation capability are confirmed; calls from other clients
+// pass as they are.
+type Confirmer struct {
+	classes   map[sqlpolicy.Class]bool
+	extractor *sqlpolicy.Extractor
+	timeout   time.Duration
+	logger    *logging.Logger
+}
+
+// New creates a confirmer for calls with SQL of the configured classes. SQL is
+// found in the arguments named by tools, as for the SQL policy.
+func New(cfg config.ConfirmationConfig, tools []config.SQLToolConfig, logger *logging.Logger) *Confirmer {
+	c := &Confirmer{
+		classes:   make(map[sqlpolicy.Class]bool),
+		extractor: sqlpolicy.NewExtractor(tools),
+		timeout:   cfg.Timeout,
+		logger:    logger,
+	}
+	for _, class := range cfg.Classes {
+		c.classes[sqlpolicy.Class(class)] = true
+	}
+	return c
+}
+
+// Middleware returns the confirmer as proxy middleware
+func (c *Confirmer) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method != "tools/call" {
+				return next(ctx, s, req)
+			}
+			call, err := mcp.ParseToolCall(req)
+			if err != nil {
+				return next(ctx, s, req)
+			}
+			sql, classes := c.destructive(call)
+			if len(classes) == 0 {
+				return next(ctx, s, req)
+			}
+			if !s.ClientInfo().Supports("elicitation") {
+				c.logger.Debugf("Not confirming %s call from client '%s': no elicitation support", call.Name, s.Client())
+				return next(ctx, s, req)
+			}
+			if err := c.confirm(ctx, s, call.Name, sql, classes); err != nil {
+				return nil, err
+			}
+			return next(ctx, s, req)
+		}
+	}
+}
+
+// destructive returns the SQL of a call and the classes of its statements
+// that need confirmation, if any
+func (c *Confirmer) destructive(call *mcp.ToolCall) ([]string, []string) {
+	var sql, classes []string
+	seen := make(map[sqlpolicy.Class]bool)
+	for _, text := range c.extractor.Find(call) {
+		sql = append(sql, text.SQL)
+		for _, stmt := range sqlpolicy.Parse(text.SQL) {
+			if c.classes[stmt.Class] && !seen[stmt.Class] {
+				seen[stmt.Class] = true
+				classes = append(classes, string(stmt.Class))
+			}
+		}
+	}
+	return sql, classes
+}
+
+// confirm asks the user whether a call may run. It returns nil once the user
+// has confirmed it.
+func (c *Confirmer) confirm(ctx context.Context, s *proxy.Session, tool string, sql, classes []string) error {
+	askCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := proxy.Request(askCtx, "elicitation/create", map[string]interface{}{
+		"message":         fmt.Sprintf("The %s tool is about to run %s statements:\n\n%s", tool, strings.Join(classes, ", "), strings.Join(sql, "\n\n")),
+		"requestedSchema": requestedSchema,
+	})
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, context.DeadlineExceeded):
+		return c.denied(ctx, s, tool, classes, "timeout", fmt.Sprintf("Not confirmed within %s", c.timeout))
+	case err != nil:
+		c.logger.Errorf("Could not ask client '%s' to confirm a %s call: %v", s.Client(), tool, err)
+		return c.denied(ctx, s, tool, classes, "unavailable", "Could not ask for confirmation")
+	case resp.Error != nil:
+		return c.denied(ctx, s, tool, classes, "failed", fmt.Sprintf("Confirmation failed (%s)", resp.Error.Message))
+	}
+
+	var result struct {
+		Action  string `json:"action"`
+		Content struct {
+			Confirm bool `json:"confirm"`
+		} `json:"content"`
+	}
+	json.Unmarshal(resp.Result, &result)
+	switch {
+	case result.Action == "accept" && result.Content.Confirm:
+		c.logger.Auditf("Confirmation: %s call from client '%s' confirmed by the user", tool, s.Client())
+		proxy.Note(ctx, "confirmation", "confirmed")
+		return nil
+	case result.Action == "accept":
+		return c.denied(ctx, s, tool, classes, "unconfirmed", "Not confirmed by the user")
+	case result.Action == "cancel":
+		return c.denied(ctx, s, tool, classes, "cancelled", "Confirmation cancelled by the user")
+	default:
+		return c.denied(ctx, s, tool, classes, "declined", "Declined by the user")
+	}
+}
+
+// denied records a call that was not confirmed and builds the error its
+// client receives
+func (c *Confirmer) denied(ctx context.Context, s *proxy.Session, tool string, classes []string, outcome, message string) *mcp.Error {
+	c.logger.Auditf("Confirmation: %s call from client '%s' %s", tool, s.Client(), outcome)
+	proxy.Note(ctx, "confirmation", outcome)
+	err := mcp.NewError(mcp.PolicyDenied, "%s: %s call runs %v statements", message, tool, classes)
+	err.Data, _ = json.Marshal(map[string]interface{}{"confirmation": outcome})
+	return err
+}
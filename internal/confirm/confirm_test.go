@@ -0,0 +1,161 @@
+package confirm
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func newHandler(t *testing.T, timeout time.Duration) proxy.Handler {
+	c := New(config.ConfirmationConfig{Classes: []string{"dml", "ddl"}, Timeout: timeout}, nil, newTestLogger(t))
+	return c.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return mcp.NewResult(req.ID, map[string]bool{"executed": true})
+	})
+}
+
+func toolCall(t *testing.T, sql string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{
+		"name":      "execute_sql",
+		"arguments": map[string]string{"sql": sql},
+	})
+	require.NoError(t, err)
+	return req
+}
+
+func session(capabilities ...string) *proxy.Session {
+	s := proxy.NewSession("s1", nil)
+	s.SetClient(proxy.ClientInfo{Name: "claude", Capabilities: capabilities})
+	return s
+}
+
+// answering returns a context whose client answers requests with result,
+// recording the requests it is sent
+func answering(result string, asked *[]*mcp.Message) context.Context {
+	return proxy.WithRequester(context.Background(), func(ctx context.Context, method string, params interface{}) (*mcp.Message, error) {
+		req, err := mcp.NewRequest(json.RawMessage(`"p-1"`), method, params)
+		if err != nil {
+			return nil, err
+		}
+		*asked = append(*asked, req)
+		if result == "" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return mcp.Parse([]byte(`{"jsonrpc":"2.0","id":"p-1",` + result + `}`))
+	})
+}
+
+func TestConfirmed(t *testing.T) {
+	handler := newHandler(t, time.Minute)
+	var asked []*mcp.Message
+	ctx := answering(`"result":{"action":"accept","content":{"confirm":true}}`, &asked)
+
+	resp, err := handler(ctx, session("elicitation"), toolCall(t, "DELETE FROM orders; DROP TABLE orders"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"executed":true}`, string(resp.Result))
+
+	require.Len(t, asked, 1)
+	assert.Equal(t, "elicitation/create", asked[0].Method)
+	var params struct {
+		Message         string                 `json:"message"`
+		RequestedSchema map[string]interface{} `json:"requestedSchema"`
+	}
+	require.NoError(t, json.Unmarshal(asked[0].Params, &params))
+	assert.Equal(t, "The execute_sql tool is about to run dml, ddl statements:\n\nDELETE FROM orders; DROP TABLE orders", params.Message)
+	assert.Equal(t, []interface{}{"confirm"}, params.RequestedSchema["required"])
+}
+
+func TestNotAsked(t *testing.T) {
+	handler := newHandler(t, time.Minute)
+	var asked []*mcp.Message
+	ctx := answering(`"result":{"action":"decline"}`, &asked)
+
+	for _, tc := range []struct {
+		session *proxy.Session
+		sql     string
+	}{
+		{session("elicitation"), "SELECT * FROM orders"},
+		{session("elicitation"), ""},
+		{session("sampling"), "DELETE FROM orders"},
+		{session(), "DROP TABLE orders"},
+	} {
+		resp, err := handler(ctx, tc.session, toolCall(t, tc.sql))
+		require.NoError(t, err, tc.sql)
+		assert.JSONEq(t, `{"executed":true}`, string(resp.Result))
+	}
+	assert.Empty(t, asked)
+}
+
+func TestNotConfirmed(t *testing.T) {
+	for _, tc := range []struct {
+		result  string
+		outcome string
+		message string
+	}{
+		{`"result":{"action":"decline"}`, "declined", "Declined by the user"},
+		{`"result":{"action":"cancel"}`, "cancelled", "Confirmation cancelled by the user"},
+		{`"result":{"action":"accept","content":{"confirm":false}}`, "unconfirmed", "Not confirmed by the user"},
+		{`"error":{"code":-32601,"message":"Method not found"}`, "failed", "Confirmation failed (Method not found)"},
+	} {
+		handler := newHandler(t, time.Minute)
+		var asked []*mcp.Message
+		ctx, notes := proxy.WithNotes(answering(tc.result, &asked))
+
+		_, err := handler(ctx, session("elicitation"), toolCall(t, "UPDATE orders SET paid = true"))
+		var rpcErr *mcp.Error
+		require.ErrorAs(t, err, &rpcErr, tc.outcome)
+		assert.Equal(t, mcp.PolicyDenied, rpcErr.Code)
+		assert.Equal(t, tc.message+": execute_sql call runs [dml] statements", rpcErr.Message)
+		assert.JSONEq(t, `{"confirmation":"`+tc.outcome+`"}`, string(rpcErr.Data))
+		assert.Equal(t, map[string]interface{}{"confirmation": tc.outcome}, notes.All())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	handler := newHandler(t, 20*time.Millisecond)
+	var asked []*mcp.Message
+
+	_, err := handler(answering("", &asked), session("elicitation"), toolCall(t, "TRUNCATE orders"))
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, "Not confirmed within 20ms: execute_sql call runs [ddl] statements", rpcErr.Message)
+}
+
+func TestClientGivesUp(t *testing.T) {
+	handler := newHandler(t, time.Minute)
+	var asked []*mcp.Message
+	ctx, cancel := context.WithCancel(answering("", &asked))
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := handler(ctx, session("elicitation"), toolCall(t, "DELETE FROM orders"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNoClientConnection(t *testing.T) {
+	handler := newHandler(t, time.Minute)
+
+	_, err := handler(context.Background(), session("elicitation"), toolCall(t, "DELETE FROM orders"))
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, err, &rpcErr, "calls that cannot be confirmed do not run")
+	assert.JSONEq(t, `{"confirmation":"unavailable"}`, string(rpcErr.Data))
+}
@@ -0,0 +1,373 @@
+package eventstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+)
+
+// maxExpiryInterval bounds the time between sweeps for streams past retention
+const maxExpiryInterval = 30 * time.Second
+
+// Errors of Store.Find
+var (
+	// ErrForeignID means an event id was not given by the store, and may be
+	// the upstream's
+	ErrForeignID = errors.New("event id not assigned by the proxy")
+	// ErrNotKept means the events after an id are no longer kept, because
+	// their stream expired or they were dropped to stay within max-bytes
+	ErrNotKept = errors.New("events are no longer kept")
+)
+
+// Event is an event of a stream
+type Event struct {
+	ID   string
+	Data string
+}
+
+// Store keeps the server-sent events streamed to clients, by stream, so that
+// a client that lost a stream can be sent what it missed. Each stream keeps
+// up to max-bytes of event data in memory; older events are written to a
+// spill file, if there is a spill directory, or dropped. Streams are removed
+// once they have ended for longer than the retention, or with their session.
+type Store struct {
+	cfg    config.ResumptionConfig
+	logger *logging.Logger
+
+	mu      sync.Mutex
+	streams map[string]*Stream // By stream id
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Stream is the events of one client stream, in order. Event ids are the
+// stream id and the event's sequence number, starting at 1.
+type Stream struct {
+	id      string
+	session string
+	store   *Store
+
+	mu      sync.Mutex
+	changed chan struct{} // Closed and replaced whenever an event is added or the stream ends
+	next    int           // Sequence number of the next event
+	first   int           // Sequence number of the oldest event kept
+	spilled []spillEntry  // Events in the spill file, oldest first
+	spill   *os.File
+	offset  int64   // End of the spill file
+	memory  []Event // Events in memory, oldest first, after the spilled ones
+	bytes   int     // Data in memory
+	ended   time.Time
+	removed bool // Events added after removal are not kept
+}
+
+// spillEntry locates the data of a spilled event
+type spillEntry struct {
+	id     string
+	offset int64
+	length int
+}
+
+// New creates an event store
+func New(cfg config.ResumptionConfig, logger *logging.Logger) *Store {
+	return &Store{
+		cfg:     cfg,
+		logger:  logger,
+		streams: make(map[string]*Stream),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start removes streams past retention until Close
+func (s *Store) Start() {
+	interval := maxExpiryInterval
+	if s.cfg.Retention < interval {
+		interval = s.cfg.Retention
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Expire()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops removing streams and removes them all, with their spill files
+func (s *Store) Close() error {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	streams := s.streams
+	s.streams = make(map[string]*Stream)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	for _, st := range streams {
+		st.remove()
+	}
+	return nil
+}
+
+// Open starts a stream of a session
+func (s *Store) Open(session string) (*Stream, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate stream id: %w", err)
+	}
+	st := &Stream{
+		id:      hex.EncodeToString(b),
+		session: session,
+		store:   s,
+		changed: make(chan struct{}),
+		next:    1,
+		first:   1,
+	}
+	s.mu.Lock()
+	s.streams[st.id] = st
+	s.mu.Unlock()
+	return st, nil
+}
+
+// Find returns the stream of a session that an event id belongs to, along
+// with the event's sequence number
+func (s *Store) Find(session, eventID string) (*Stream, int, error) {
+	id, seq, ok := parseID(eventID)
+	if !ok {
+		return nil, 0, ErrForeignID
+	}
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	// A stream of another session is as good as unknown
+	if st == nil || st.session != session {
+		return nil, 0, ErrNotKept
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if seq >= st.next || seq+1 < st.first {
+		return nil, 0, ErrNotKept
+	}
+	return st, seq, nil
+}
+
+// Forget removes the streams of a session that ended
+func (s *Store) Forget(session string) {
+	s.mu.Lock()
+	var forgotten []*Stream
+	for id, st := range s.streams {
+		if st.session == session {
+			forgotten = append(forgotten, st)
+			delete(s.streams, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, st := range forgotten {
+		st.remove()
+	}
+}
+
+// Expire removes the streams that ended longer than the retention ago
+func (s *Store) Expire() {
+	s.mu.Lock()
+	var expired []*Stream
+	for id, st := range s.streams {
+		st.mu.Lock()
+		ended := st.ended
+		st.mu.Unlock()
+		if !ended.IsZero() && time.Since(ended) >= s.cfg.Retention {
+			expired = append(expired, st)
+			delete(s.streams, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, st := range expired {
+		st.remove()
+	}
+}
+
+// Append adds an event to the stream and returns it with its id
+func (st *Stream) Append(data string) Event {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	event := Event{ID: st.id + "-" + strconv.Itoa(st.next), Data: data}
+	st.next++
+	if st.removed {
+		st.first = st.next
+		return event
+	}
+	st.memory = append(st.memory, event)
+	st.bytes += len(data)
+	for st.bytes > st.store.cfg.MaxBytes && len(st.memory) > 1 {
+		oldest := st.memory[0]
+		st.memory = st.memory[1:]
+		st.bytes -= len(oldest.Data)
+		st.evict(oldest)
+	}
+	st.notifyLocked()
+	return event
+}
+
+// End marks the stream as complete: no more events will be added, and the
+// retention starts
+func (st *Stream) End() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.ended.IsZero() {
+		st.ended = time.Now()
+		st.notifyLocked()
+	}
+}
+
+// Pending reports whether the stream has, or may still get, events after the
+// one with sequence number seq
+func (st *Stream) Pending(seq int) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.ended.IsZero() || seq+1 < st.next
+}
+
+// Follow calls fn for the events after the one with sequence number seq,
+// first those kept and then new ones as they are added, until the stream
+// ends, fn fails or ctx is done
+func (st *Stream) Follow(ctx context.Context, seq int, fn func(Event) error) error {
+	for {
+		events, changed, ended, err := st.after(seq)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+			seq++
+		}
+		if len(events) > 0 {
+			continue
+		}
+		if ended {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// after returns the events kept after the one with sequence number seq
+func (st *Stream) after(seq int) ([]Event, <-chan struct{}, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if seq+1 < st.first {
+		return nil, nil, false, ErrNotKept
+	}
+	var events []Event
+	// Spilled events are numbered from first, followed by those in memory
+	for i := seq + 1 - st.first; i < len(st.spilled); i++ {
+		data := make([]byte, st.spilled[i].length)
+		if _, err := st.spill.ReadAt(data, st.spilled[i].offset); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to read spilled event %s: %w", st.spilled[i].id, err)
+		}
+		events = append(events, Event{ID: st.spilled[i].id, Data: string(data)})
+	}
+	start := seq + 1 - st.first - len(st.spilled)
+	if start < 0 {
+		start = 0
+	}
+	if start < len(st.memory) {
+		events = append(events, st.memory[start:]...)
+	}
+	return events, st.changed, !st.ended.IsZero(), nil
+}
+
+// evict moves an event out of memory, to the spill file or out of the stream
+func (st *Stream) evict(event Event) {
+	if st.store.cfg.SpillDir != "" && st.write(event) {
+		return
+	}
+	// Only the oldest events can be dropped; spilled ones go with them
+	st.first += len(st.spilled) + 1
+	st.spilled = nil
+}
+
+// write appends an event to the spill file
+func (st *Stream) write(event Event) bool {
+	if st.spill == nil {
+		f, err := os.CreateTemp(st.store.cfg.SpillDir, "mcp_sqlpp_proxy_events_"+st.id+"_*")
+		if err != nil {
+			st.store.logger.Errorf("Failed to create spill file for stream %s: %v", st.id, err)
+			return false
+		}
+		st.spill = f
+	}
+	n, err := st.spill.WriteAt([]byte(event.Data), st.offset)
+	if err != nil {
+		st.store.logger.Errorf("Failed to spill event %s: %v", event.ID, err)
+		return false
+	}
+	st.spilled = append(st.spilled, spillEntry{id: event.ID, offset: st.offset, length: n})
+	st.offset += int64(n)
+	return true
+}
+
+// remove drops the stream's events and its spill file, ending anyone
+// following it
+func (st *Stream) remove() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.removed = true
+	st.first = st.next
+	st.memory, st.spilled, st.bytes = nil, nil, 0
+	if st.spill != nil {
+		st.spill.Close()
+		os.Remove(st.spill.Name())
+		st.spill = nil
+	}
+	if st.ended.IsZero() {
+		st.ended = time.Now()
+	}
+	st.notifyLocked()
+}
+
+func (st *Stream) notifyLocked() {
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// parseID splits an event id into its stream id and sequence number
+func parseID(eventID string) (string, int, bool) {
+	id, n, ok := strings.Cut(eventID, "-")
+	if !ok || len(id) != 16 {
+		return "", 0, false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(n)
+	if err != nil || seq < 1 {
+		return "", 0, false
+	}
+	return id, seq, true
+}
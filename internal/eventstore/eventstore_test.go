@@ -0,0 +1,170 @@
+package eventstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func newStore(t *testing.T, cfg config.ResumptionConfig) *Store {
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	if cfg.Retention == 0 {
+		cfg.Retention = time.Minute
+	}
+	s := New(cfg, newTestLogger(t))
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// collect follows a stream from seq until it ends
+func collect(t *testing.T, st *Stream, seq int) []string {
+	var data []string
+	require.NoError(t, st.Follow(context.Background(), seq, func(e Event) error {
+		data = append(data, e.Data)
+		return nil
+	}))
+	return data
+}
+
+func TestReplay(t *testing.T) {
+	s := newStore(t, config.ResumptionConfig{})
+	st, err := s.Open("s1")
+	require.NoError(t, err)
+	first := st.Append("one")
+	st.Append("two")
+	third := st.Append("three")
+	st.End()
+	assert.NotEqual(t, first.ID, third.ID)
+
+	found, seq, err := s.Find("s1", first.ID)
+	require.NoError(t, err)
+	assert.Same(t, st, found)
+	assert.True(t, found.Pending(seq))
+	assert.Equal(t, []string{"two", "three"}, collect(t, found, seq))
+
+	_, seq, err = s.Find("s1", third.ID)
+	require.NoError(t, err)
+	assert.False(t, st.Pending(seq), "nothing follows the last event of an ended stream")
+
+	_, _, err = s.Find("s2", first.ID)
+	assert.ErrorIs(t, err, ErrNotKept, "streams of other sessions are not found")
+	_, _, err = s.Find("s1", "42")
+	assert.ErrorIs(t, err, ErrForeignID)
+}
+
+func TestFollowLive(t *testing.T) {
+	s := newStore(t, config.ResumptionConfig{})
+	st, err := s.Open("s1")
+	require.NoError(t, err)
+	first := st.Append("one")
+
+	done := make(chan []string)
+	go func() {
+		_, seq, _ := s.Find("s1", first.ID)
+		done <- collect(t, st, seq)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	st.Append("two")
+	st.End()
+	assert.Equal(t, []string{"two"}, <-done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	open, err := s.Open("s1")
+	require.NoError(t, err)
+	assert.ErrorIs(t, open.Follow(ctx, 0, func(Event) error { return nil }), context.DeadlineExceeded)
+}
+
+func TestMaxBytes(t *testing.T) {
+	s := newStore(t, config.ResumptionConfig{MaxBytes: 10})
+	st, err := s.Open("s1")
+	require.NoError(t, err)
+	first := st.Append("aaaaa")
+	second := st.Append("bbbbb")
+	st.Append("ccccc")
+	st.Append("ddddd")
+	st.End()
+
+	_, _, err = s.Find("s1", first.ID)
+	assert.ErrorIs(t, err, ErrNotKept, "the event after the first was dropped")
+	_, seq, err := s.Find("s1", second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ccccc", "ddddd"}, collect(t, st, seq))
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	s := newStore(t, config.ResumptionConfig{MaxBytes: 10, SpillDir: dir})
+	st, err := s.Open("s1")
+	require.NoError(t, err)
+	first := st.Append("aaaaa")
+	for _, data := range []string{"bbbbb", "ccccc", strings.Repeat("d", 20)} {
+		st.Append(data)
+	}
+	st.End()
+
+	_, seq, err := s.Find("s1", first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bbbbb", "ccccc", strings.Repeat("d", 20)}, collect(t, st, seq))
+	files, _ := filepath.Glob(filepath.Join(dir, "mcp_sqlpp_proxy_events_*"))
+	assert.Len(t, files, 1)
+
+	s.Forget("s1")
+	files, _ = filepath.Glob(filepath.Join(dir, "mcp_sqlpp_proxy_events_*"))
+	assert.Empty(t, files, "spill files go with their streams")
+	_, _, err = s.Find("s1", first.ID)
+	assert.ErrorIs(t, err, ErrNotKept)
+}
+
+func TestExpire(t *testing.T) {
+	s := newStore(t, config.ResumptionConfig{Retention: time.Hour})
+	open, err := s.Open("s1")
+	require.NoError(t, err)
+	openEvent := open.Append("one")
+	ended, err := s.Open("s1")
+	require.NoError(t, err)
+	endedEvent := ended.Append("one")
+	ended.End()
+
+	ended.mu.Lock()
+	ended.ended = time.Now().Add(-2 * time.Hour)
+	ended.mu.Unlock()
+	s.Expire()
+
+	_, _, err = s.Find("s1", openEvent.ID)
+	assert.NoError(t, err, "streams are kept while they are open")
+	_, _, err = s.Find("s1", endedEvent.ID)
+	assert.ErrorIs(t, err, ErrNotKept)
+}
+
+func TestParseID(t *testing.T) {
+	id, seq, ok := parseID("0123456789abcdef-12")
+	assert.True(t, ok)
+	assert.Equal(t, "0123456789abcdef", id)
+	assert.Equal(t, 12, seq)
+	for _, foreign := range []string{"", "12", "0123456789abcdef", "0123456789abcdeg-1", "0123456789abcdef-0", "abc-1"} {
+		_, _, ok := parseID(foreign)
+		assert.False(t, ok, foreign)
+	}
+}
@@ -0,0 +1,156 @@
+package limits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+)
+
+// Names of the limits, as used in the configuration
+const (
+	MaxBytes = "max-bytes"
+	MaxRows  = "max-rows"
+	MaxItems = "max-items"
+)
+
+// noticeReserve is the room left for the truncation notice within the byte limit
+const noticeReserve = 512
+
+// Hit is a limit a result exceeded
+type Hit struct {
+	Limit  string `json:"limit"`
+	Max    int    `json:"max"`
+	Actual int    `json:"actual"`
+}
+
+func (h Hit) String() string {
+	switch h.Limit {
+	case MaxRows:
+		return fmt.Sprintf("%d rows (limit %d)", h.Actual, h.Max)
+	case MaxItems:
+		return fmt.Sprintf("%d content items (limit %d)", h.Actual, h.Max)
+	default:
+		return fmt.Sprintf("%d bytes (limit %d)", h.Actual, h.Max)
+	}
+}
+
+// Limiter bounds the size of tools/call results. Results over a limit are
+// cut down with a notice added to their content, or replaced by a tool error.
+type Limiter struct {
+	cfg    config.LimitsConfig
+	logger *logging.Logger
+}
+
+// New creates a limiter from the configuration
+func New(cfg config.LimitsConfig, logger *logging.Logger) *Limiter {
+	return &Limiter{cfg: cfg, logger: logger}
+}
+
+// Middleware returns the limiter as proxy middleware. The limits hit by a
+// request are recorded as the "limits" note.
+func (l *Limiter) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			resp, err := next(ctx, s, req)
+			if req.Method != "tools/call" || err != nil || resp == nil || resp.Result == nil {
+				return resp, err
+			}
+
+			result, hits, err := l.Apply(resp.Result)
+			if err != nil {
+				l.logger.Errorf("Failed to apply result limits: %v", err)
+				return resp, nil
+			}
+			if len(hits) == 0 {
+				return resp, nil
+			}
+
+			proxy.Note(ctx, "limits", hits)
+			limited := resp.Clone()
+			if l.cfg.Action == config.LimitError {
+				l.logger.Infof("Rejected result of tool %s: %s", mcp.ToolName(req), describe(hits))
+				limited.Result, err = errorResult(hits)
+			} else {
+				l.logger.Infof("Truncated result of tool %s: %s", mcp.ToolName(req), describe(hits))
+				limited.Result = result
+			}
+			return limited, err
+		}
+	}
+}
+
+// Apply enforces the limits on a tools/call result. It returns the truncated
+// result, with a notice appended to its content, and the limits that were hit.
+// Results that are not objects are returned unchanged.
+func (l *Limiter) Apply(raw json.RawMessage) (json.RawMessage, []Hit, error) {
+	var r result
+	if err := json.Unmarshal(raw, &r.fields); err != nil {
+		return raw, nil, nil
+	}
+	if content, ok := r.fields["content"]; ok {
+		if err := json.Unmarshal(content, &r.content); err != nil {
+			return raw, nil, nil
+		}
+	}
+
+	var hits []Hit
+	if l.cfg.MaxRows > 0 {
+		if actual := r.cutRows(l.cfg.MaxRows); actual > l.cfg.MaxRows {
+			hits = append(hits, Hit{Limit: MaxRows, Max: l.cfg.MaxRows, Actual: actual})
+		}
+	}
+	if l.cfg.MaxItems > 0 && len(r.content) > l.cfg.MaxItems {
+		hits = append(hits, Hit{Limit: MaxItems, Max: l.cfg.MaxItems, Actual: len(r.content)})
+		r.content = r.content[:l.cfg.MaxItems]
+	}
+	if l.cfg.MaxBytes > 0 {
+		size, err := r.size()
+		if err != nil {
+			return nil, nil, err
+		}
+		if size > l.cfg.MaxBytes {
+			hits = append(hits, Hit{Limit: MaxBytes, Max: l.cfg.MaxBytes, Actual: len(raw)})
+			if err := r.shrink(l.cfg.MaxBytes - noticeReserve); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(hits) == 0 {
+		return raw, nil, nil
+	}
+
+	notice, err := textBlock(fmt.Sprintf("[Result truncated by the proxy: %s. Narrow the query, for example with WHERE or LIMIT, to see the rest.]", describe(hits)))
+	if err != nil {
+		return nil, nil, err
+	}
+	r.content = append(r.content, notice)
+	data, err := r.marshal()
+	return data, hits, err
+}
+
+// errorResult builds the tool error that replaces a result over a limit
+func errorResult(hits []Hit) (json.RawMessage, error) {
+	notice, err := textBlock(fmt.Sprintf("The result is too large to return: %s. Narrow the query, for example with WHERE or LIMIT, and try again.", describe(hits)))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{"content": []json.RawMessage{notice}, "isError": true})
+}
+
+func describe(hits []Hit) string {
+	parts := make([]string, len(hits))
+	for i, h := range hits {
+		parts[i] = h.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func textBlock(text string) (json.RawMessage, error) {
+	return json.Marshal(map[string]string{"type": "text", "text": text})
+}
@@ -0,0 +1,196 @@
+package limits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// toolResult is the decoded form of a result for assertions
+type toolResult struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+		Data string `json:"data"`
+	} `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent"`
+	IsError           bool            `json:"isError"`
+}
+
+func decode(t *testing.T, data json.RawMessage) toolResult {
+	var r toolResult
+	require.NoError(t, json.Unmarshal(data, &r))
+	return r
+}
+
+func rows(n int) string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"id":%d}`, i)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestWithinLimits(t *testing.T) {
+	l := New(config.LimitsConfig{MaxBytes: 1024, MaxRows: 5, MaxItems: 2}, newTestLogger(t))
+	raw := json.RawMessage(`{"content":[{"type":"text","text":` + mustQuote(rows(5)) + `}]}`)
+
+	result, hits, err := l.Apply(raw)
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+	assert.Equal(t, string(raw), string(result), "results within the limits are not re-encoded")
+
+	result, hits, err = l.Apply(json.RawMessage(`"not an object"`))
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+	assert.Equal(t, `"not an object"`, string(result))
+}
+
+func TestMaxRows(t *testing.T) {
+	l := New(config.LimitsConfig{MaxRows: 2}, newTestLogger(t))
+	raw := json.RawMessage(`{
+		"content": [
+			{"type":"text","text":` + mustQuote(rows(3)) + `},
+			{"type":"text","text":` + mustQuote(`{"columns":["id"],"rows":`+rows(4)+`}`) + `},
+			{"type":"text","text":"plain text is left alone"}
+		],
+		"structuredContent": {"rows":` + rows(3) + `,"rowCount":3}
+	}`)
+
+	result, hits, err := l.Apply(raw)
+	require.NoError(t, err)
+	assert.Equal(t, []Hit{{Limit: MaxRows, Max: 2, Actual: 4}}, hits)
+
+	r := decode(t, result)
+	require.Len(t, r.Content, 4)
+	assert.JSONEq(t, rows(2), r.Content[0].Text)
+	assert.JSONEq(t, `{"columns":["id"],"rows":`+rows(2)+`}`, r.Content[1].Text)
+	assert.Equal(t, "plain text is left alone", r.Content[2].Text)
+	assert.Equal(t, "[Result truncated by the proxy: 4 rows (limit 2). Narrow the query, for example with WHERE or LIMIT, to see the rest.]", r.Content[3].Text)
+	assert.JSONEq(t, `{"rows":`+rows(2)+`,"rowCount":3}`, string(r.StructuredContent))
+}
+
+func TestMaxItems(t *testing.T) {
+	l := New(config.LimitsConfig{MaxItems: 1}, newTestLogger(t))
+
+	result, hits, err := l.Apply(json.RawMessage(`{"content":[{"type":"text","text":"a"},{"type":"text","text":"b"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Hit{{Limit: MaxItems, Max: 1, Actual: 2}}, hits)
+	r := decode(t, result)
+	require.Len(t, r.Content, 2)
+	assert.Equal(t, "a", r.Content[0].Text)
+	assert.Contains(t, r.Content[1].Text, "2 content items (limit 1)")
+}
+
+func TestMaxBytesText(t *testing.T) {
+	l := New(config.LimitsConfig{MaxBytes: 2048}, newTestLogger(t))
+	long := strings.Repeat("é\"x", 2000)
+	raw, err := json.Marshal(map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": long}, {"type": "text", "text": "second"}},
+	})
+	require.NoError(t, err)
+
+	result, hits, err := l.Apply(raw)
+	require.NoError(t, err)
+	assert.Equal(t, []Hit{{Limit: MaxBytes, Max: 2048, Actual: len(raw)}}, hits)
+	assert.LessOrEqual(t, len(result), 2048)
+
+	r := decode(t, result)
+	require.Len(t, r.Content, 2, "the cut text and the notice")
+	assert.True(t, strings.HasPrefix(long, r.Content[0].Text))
+	assert.Greater(t, len(r.Content[0].Text), 1000)
+	assert.Contains(t, r.Content[1].Text, fmt.Sprintf("%d bytes (limit 2048)", len(raw)))
+}
+
+func TestMaxBytesStructured(t *testing.T) {
+	l := New(config.LimitsConfig{MaxBytes: 1024}, newTestLogger(t))
+	raw := json.RawMessage(`{"content":[{"type":"text","text":"200 rows"}],"structuredContent":{"rows":` + rows(200) + `}}`)
+
+	result, hits, err := l.Apply(raw)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.LessOrEqual(t, len(result), 1024)
+
+	r := decode(t, result)
+	assert.Equal(t, "200 rows", r.Content[0].Text)
+	var structured struct {
+		Rows []json.RawMessage `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(r.StructuredContent, &structured))
+	assert.NotEmpty(t, structured.Rows)
+	assert.Less(t, len(structured.Rows), 200)
+
+	// Structured content without rows is dropped, and so are trailing non-text blocks
+	raw = json.RawMessage(`{"content":[{"type":"text","text":"x"},{"type":"image","data":"` + strings.Repeat("A", 2000) + `","mimeType":"image/png"}],` +
+		`"structuredContent":{"blob":"` + strings.Repeat("B", 2000) + `"}}`)
+	result, _, err = l.Apply(raw)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(result), 1024)
+	r = decode(t, result)
+	assert.Nil(t, r.StructuredContent)
+	require.Len(t, r.Content, 2)
+	assert.Equal(t, "x", r.Content[0].Text)
+	assert.Contains(t, r.Content[1].Text, "Result truncated")
+}
+
+func TestMiddleware(t *testing.T) {
+	raw := `{"content":[{"type":"text","text":` + mustQuote(rows(10)) + `}]}`
+	upstream := func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(raw)}, nil
+	}
+	call, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{"name": "execute_sql"})
+	require.NoError(t, err)
+	s := proxy.NewSession("test", nil)
+
+	// Truncate
+	l := New(config.LimitsConfig{MaxRows: 3, Action: config.LimitTruncate}, newTestLogger(t))
+	ctx, notes := proxy.WithNotes(context.Background())
+	resp, err := l.Middleware()(upstream)(ctx, s, call)
+	require.NoError(t, err)
+	r := decode(t, resp.Result)
+	assert.False(t, r.IsError)
+	assert.JSONEq(t, rows(3), r.Content[0].Text)
+	assert.Equal(t, map[string]interface{}{"limits": []Hit{{Limit: MaxRows, Max: 3, Actual: 10}}}, notes.All())
+
+	// Error
+	l = New(config.LimitsConfig{MaxRows: 3, Action: config.LimitError}, newTestLogger(t))
+	resp, err = l.Middleware()(upstream)(context.Background(), s, call)
+	require.NoError(t, err)
+	r = decode(t, resp.Result)
+	assert.True(t, r.IsError)
+	require.Len(t, r.Content, 1)
+	assert.Equal(t, "The result is too large to return: 10 rows (limit 3). Narrow the query, for example with WHERE or LIMIT, and try again.", r.Content[0].Text)
+
+	// Other methods are not limited
+	list, err := mcp.NewRequest(json.RawMessage("2"), "tools/list", nil)
+	require.NoError(t, err)
+	resp, err = l.Middleware()(upstream)(context.Background(), s, list)
+	require.NoError(t, err)
+	assert.Equal(t, raw, string(resp.Result))
+}
+
+func mustQuote(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,256 @@
+package limits
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// result is a tools/call result being cut down. Unknown fields are kept as is.
+type result struct {
+	fields  map[string]json.RawMessage
+	content []json.RawMessage
+}
+
+func (r *result) marshal() (json.RawMessage, error) {
+	if _, ok := r.fields["content"]; ok || r.content != nil {
+		content := r.content
+		if content == nil {
+			content = []json.RawMessage{}
+		}
+		data, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		r.fields["content"] = data
+	}
+	return json.Marshal(r.fields)
+}
+
+func (r *result) size() (int, error) {
+	data, err := r.marshal()
+	return len(data), err
+}
+
+// cutRows cuts every row set in the result down to max rows: a JSON array or
+// an object's "rows" array, in the structured content or in a text block. It
+// returns the size of the largest row set.
+func (r *result) cutRows(max int) int {
+	largest := 0
+	if data, ok := r.fields["structuredContent"]; ok {
+		if cut, n, ok := cutRowSet(data, max); ok {
+			r.fields["structuredContent"] = cut
+			largest = n
+		}
+	}
+	for i, block := range r.content {
+		text, ok := blockText(block)
+		if !ok {
+			continue
+		}
+		cut, n, ok := cutRowSet(json.RawMessage(text), max)
+		if !ok {
+			continue
+		}
+		if n > max {
+			r.content[i] = withText(block, string(cut))
+		}
+		if n > largest {
+			largest = n
+		}
+	}
+	return largest
+}
+
+// shrink cuts the result down to at most budget bytes. Text comes first: text
+// blocks are cut to fit, then rows of the structured content are dropped, then
+// non-text blocks are dropped from the end.
+func (r *result) shrink(budget int) error {
+	// Work out the room left for text without structured content, other
+	// blocks and the texts themselves
+	structured, hasStructured := r.fields["structuredContent"]
+	delete(r.fields, "structuredContent")
+	blocks := r.content
+	texts := make(map[int]string)
+	r.content = nil
+	for i, block := range blocks {
+		if text, ok := blockText(block); ok {
+			texts[i] = text
+			r.content = append(r.content, withText(block, ""))
+		}
+	}
+	overhead, err := r.size()
+	if err != nil {
+		return err
+	}
+
+	remaining := budget - overhead
+	r.content = nil
+	for i, block := range blocks {
+		text, isText := texts[i]
+		if !isText {
+			r.content = append(r.content, block)
+			continue
+		}
+		if remaining <= 0 {
+			continue
+		}
+		if n := encodedLen(text); n <= remaining {
+			remaining -= n
+		} else {
+			text = cutText(text, remaining)
+			remaining = 0
+		}
+		r.content = append(r.content, withText(block, text))
+	}
+
+	if hasStructured {
+		r.fields["structuredContent"] = structured
+		if over, err := r.over(budget); err != nil || !over {
+			return err
+		}
+		if err := r.shrinkStructured(structured, budget); err != nil {
+			return err
+		}
+	}
+
+	for len(r.content) > 0 {
+		if over, err := r.over(budget); err != nil || !over {
+			return err
+		}
+		last := -1
+		for i, block := range r.content {
+			if _, isText := blockText(block); !isText {
+				last = i
+			}
+		}
+		if last < 0 {
+			break
+		}
+		r.content = append(r.content[:last], r.content[last+1:]...)
+	}
+	return nil
+}
+
+// shrinkStructured keeps as many rows of the structured content as fit the
+// budget, or drops the structured content if it holds no rows
+func (r *result) shrinkStructured(data json.RawMessage, budget int) error {
+	_, total, ok := cutRowSet(data, -1)
+	if !ok {
+		delete(r.fields, "structuredContent")
+		return nil
+	}
+	var err error
+	keep := sort.Search(total+1, func(n int) bool {
+		cut, _, _ := cutRowSet(data, n)
+		r.fields["structuredContent"] = cut
+		over, e := r.over(budget)
+		if e != nil {
+			err = e
+		}
+		return over
+	}) - 1
+	if keep < 0 {
+		keep = 0
+	}
+	cut, _, _ := cutRowSet(data, keep)
+	r.fields["structuredContent"] = cut
+	return err
+}
+
+func (r *result) over(budget int) (bool, error) {
+	size, err := r.size()
+	return size > budget, err
+}
+
+// cutRowSet cuts a row set, a JSON array or an object with a "rows" array, to
+// max rows; a negative max keeps every row. It returns the cut JSON, the
+// number of rows before cutting, and whether data holds a row set at all.
+func cutRowSet(data json.RawMessage, max int) (json.RawMessage, int, bool) {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return data, 0, false
+	}
+	var rows []json.RawMessage
+	if trimmed[0] == '[' {
+		if json.Unmarshal(data, &rows) != nil {
+			return data, 0, false
+		}
+		if max < 0 || len(rows) <= max {
+			return data, len(rows), true
+		}
+		cut, err := json.Marshal(rows[:max])
+		if err != nil {
+			return data, 0, false
+		}
+		return cut, len(rows), true
+	}
+
+	var obj map[string]json.RawMessage
+	if trimmed[0] != '{' || json.Unmarshal(data, &obj) != nil {
+		return data, 0, false
+	}
+	if json.Unmarshal(obj["rows"], &rows) != nil || rows == nil {
+		return data, 0, false
+	}
+	if max < 0 || len(rows) <= max {
+		return data, len(rows), true
+	}
+	var err error
+	if obj["rows"], err = json.Marshal(rows[:max]); err != nil {
+		return data, 0, false
+	}
+	cut, err := json.Marshal(obj)
+	if err != nil {
+		return data, 0, false
+	}
+	return cut, len(rows), true
+}
+
+// blockText returns the text of a text content block
+func blockText(block json.RawMessage) (string, bool) {
+	var b struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(block, &b) != nil || b.Type != "text" {
+		return "", false
+	}
+	return b.Text, true
+}
+
+// withText returns a copy of a text content block with its text replaced
+func withText(block json.RawMessage, text string) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(block, &fields) != nil {
+		return block
+	}
+	fields["text"], _ = json.Marshal(text)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return block
+	}
+	return data
+}
+
+// encodedLen is the number of bytes text takes as a JSON string, without quotes
+func encodedLen(text string) int {
+	data, _ := json.Marshal(text)
+	return len(data) - 2
+}
+
+// cutText returns the longest prefix of text, cut at a rune boundary, whose
+// JSON encoding takes at most max bytes
+func cutText(text string, max int) string {
+	n := sort.Search(len(text)+1, func(i int) bool {
+		return encodedLen(text[:i]) > max
+	}) - 1
+	for n > 0 && n < len(text) && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	if n < 0 {
+		n = 0
+	}
+	return text[:n]
+}
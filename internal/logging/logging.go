@@ -12,6 +12,15 @@ type Logger struct {
 	*log.Logger
 	filePath string
 	file     *os.File
+	redactor Redactor
+}
+
+// Redactor rewrites traffic before it is written to the log
+type Redactor interface {
+	// Payload rewrites a message body
+	Payload(body string) string
+	// Text rewrites other logged text that can carry secrets, such as URLs
+	Text(text string) string
 }
 
 // LogConfig holds configuration for logging
@@ -44,11 +53,12 @@ func New(config *LogConfig) (*Logger, error) {
 		return nil, fmt.Errorf("failed to open log file '%s': %w", filePath, err)
 	}
 
-	var flags int = log.LstdFlags
+	// Microseconds let replays reproduce the latency of recorded traffic
+	var flags int = log.LstdFlags | log.Lmicroseconds
 	if config != nil && config.TimeFormat != "" {
 		// Custom time format would require more complex implementation
 		// For now, we'll use standard flags
-		flags = log.LstdFlags
+		flags = log.LstdFlags | log.Lmicroseconds
 	}
 
 	logger := &Logger{
@@ -71,6 +81,16 @@ func NewDefault() (*Logger, error) {
 	return New(nil)
 }
 
+// Derive creates a logger as New does, redacting traffic the way l does
+func (l *Logger) Derive(config *LogConfig) (*Logger, error) {
+	derived, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	derived.redactor = l.redactor
+	return derived, nil
+}
+
 // Close closes the log file
 func (l *Logger) Close() error {
 	if l.file != nil {
@@ -86,6 +106,26 @@ func (l *Logger) GetFilePath() string {
 	return l.filePath
 }
 
+// SetRedactor sets the redactor applied to traffic before it is logged; nil
+// logs traffic as is
+func (l *Logger) SetRedactor(r Redactor) {
+	l.redactor = r
+}
+
+func (l *Logger) payload(body string) string {
+	if l.redactor == nil {
+		return body
+	}
+	return l.redactor.Payload(body)
+}
+
+func (l *Logger) text(text string) string {
+	if l.redactor == nil {
+		return text
+	}
+	return l.redactor.Text(text)
+}
+
 // Info logs an informational message
 func (l *Logger) Info(msg string) {
 	l.Printf("[INFO] %s", msg)
@@ -96,6 +136,16 @@ func (l *Logger) Infof(format string, args ...interface{}) {
 	l.Printf("[INFO] "+format, args...)
 }
 
+// Warn logs a warning message
+func (l *Logger) Warn(msg string) {
+	l.Printf("[WARN] %s", msg)
+}
+
+// Warnf logs a warning message with formatting
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.Printf("[WARN] "+format, args...)
+}
+
 // Error logs an error message
 func (l *Logger) Error(msg string) {
 	l.Printf("[ERROR] %s", msg)
@@ -118,27 +168,27 @@ func (l *Logger) Debugf(format string, args ...interface{}) {
 
 // TrafficIn logs incoming traffic
 func (l *Logger) TrafficIn(msg string) {
-	l.Printf("[IN] %s", msg)
+	l.Printf("[IN] %s", l.payload(msg))
 }
 
 // TrafficOut logs outgoing traffic
 func (l *Logger) TrafficOut(msg string) {
-	l.Printf("[OUT] %s", msg)
+	l.Printf("[OUT] %s", l.payload(msg))
 }
 
 // HTTPIn logs incoming HTTP request
 func (l *Logger) HTTPIn(method, url string) {
-	l.Printf("[HTTP IN] %s %s", method, url)
+	l.Printf("[HTTP IN] %s %s", method, l.text(url))
 }
 
 // HTTPInBody logs incoming HTTP request body
 func (l *Logger) HTTPInBody(body string) {
-	l.Printf("[HTTP IN BODY] %s", body)
+	l.Printf("[HTTP IN BODY] %s", l.payload(body))
 }
 
 // HTTPOut logs outgoing HTTP response
 func (l *Logger) HTTPOut(statusCode int, body string) {
-	l.Printf("[HTTP OUT] %d %s", statusCode, body)
+	l.Printf("[HTTP OUT] %d %s", statusCode, l.payload(body))
 }
 
 // HTTPError logs HTTP-related errors
@@ -146,6 +196,26 @@ func (l *Logger) HTTPError(err error) {
 	l.Printf("[HTTP ERROR] %v", err)
 }
 
+// Violation logs a protocol violation
+func (l *Logger) Violation(msg string) {
+	l.Printf("[VIOLATION] %s", msg)
+}
+
+// Violationf logs a protocol violation with formatting
+func (l *Logger) Violationf(format string, args ...interface{}) {
+	l.Printf("[VIOLATION] "+format, args...)
+}
+
+// Audit logs an access control decision
+func (l *Logger) Audit(msg string) {
+	l.Printf("[AUDIT] %s", msg)
+}
+
+// Auditf logs an access control decision with formatting
+func (l *Logger) Auditf(format string, args ...interface{}) {
+	l.Printf("[AUDIT] "+format, args...)
+}
+
 // Startup logs application startup information
 func (l *Logger) Startup(msg string) {
 	l.Printf("[STARTUP] %s", msg)
@@ -61,6 +61,8 @@ func TestLoggerMethods(t *testing.T) {
 	// Test various logging methods
 	logger.Info("test info message")
 	logger.Infof("test info message with format: %s", "test")
+	logger.Warn("test warning message")
+	logger.Warnf("test warning message with format: %s", "formatted")
 	logger.Error("test error message")
 	logger.Errorf("test error message with format: %d", 123)
 	logger.Debug("test debug message")
@@ -71,6 +73,10 @@ func TestLoggerMethods(t *testing.T) {
 	logger.HTTPInBody("test body")
 	logger.HTTPOut(200, "OK")
 	logger.HTTPError(err)
+	logger.Violation("test violation message")
+	logger.Violationf("test violation message with format: %s", "formatted")
+	logger.Audit("test audit message")
+	logger.Auditf("test audit message with format: %s", "formatted")
 	logger.Startup("test startup message")
 	logger.Startupf("test startup message with format: %s", "formatted")
 
@@ -85,6 +91,7 @@ func TestLoggerMethods(t *testing.T) {
 	// Verify that different log types are present
 	expectedPrefixes := []string{
 		"[INFO]",
+		"[WARN]",
 		"[ERROR]",
 		"[DEBUG]",
 		"[IN]",
@@ -93,6 +100,8 @@ func TestLoggerMethods(t *testing.T) {
 		"[HTTP IN BODY]",
 		"[HTTP OUT]",
 		"[HTTP ERROR]",
+		"[VIOLATION]",
+		"[AUDIT]",
 		"[STARTUP]",
 	}
 
@@ -140,3 +149,72 @@ func TestFatalBehavior(t *testing.T) {
 	// In a more comprehensive test suite, we would use a subprocess or mock os.Exit
 	t.Skip("Fatal behavior testing requires subprocess or mocking os.Exit")
 }
+
+// upperRedactor marks what it rewrites so the test can tell the methods apart
+type upperRedactor struct{}
+
+func (upperRedactor) Payload(body string) string { return "payload:" + strings.ToUpper(body) }
+func (upperRedactor) Text(text string) string    { return "text:" + strings.ToUpper(text) }
+
+func TestLoggerRedactor(t *testing.T) {
+	logger, err := NewDefault()
+	if err != nil {
+		t.Fatalf("NewDefault() failed: %v", err)
+	}
+	defer logger.Close()
+	defer os.Remove(logger.GetFilePath())
+
+	logger.SetRedactor(upperRedactor{})
+	logger.TrafficIn("in")
+	logger.TrafficOut("out")
+	logger.HTTPIn("GET", "/mcp?token=x")
+	logger.HTTPInBody("body")
+	logger.HTTPOut(200, "response")
+	logger.Info("not traffic")
+
+	content, err := os.ReadFile(logger.GetFilePath())
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	logContent := string(content)
+
+	expected := []string{
+		"[IN] payload:IN",
+		"[OUT] payload:OUT",
+		"[HTTP IN] GET text:/MCP?TOKEN=X",
+		"[HTTP IN BODY] payload:BODY",
+		"[HTTP OUT] 200 payload:RESPONSE",
+		"[INFO] not traffic",
+	}
+	for _, line := range expected {
+		if !strings.Contains(logContent, line) {
+			t.Errorf("Expected log to contain '%s', but it didn't. Log content:\n%s", line, logContent)
+		}
+	}
+}
+
+func TestDerive(t *testing.T) {
+	logger, err := NewDefault()
+	if err != nil {
+		t.Fatalf("NewDefault() failed: %v", err)
+	}
+	defer logger.Close()
+	defer os.Remove(logger.GetFilePath())
+	logger.SetRedactor(upperRedactor{})
+
+	path := t.TempDir() + "/session.log"
+	derived, err := logger.Derive(&LogConfig{FilePath: path})
+	if err != nil {
+		t.Fatalf("Derive() failed: %v", err)
+	}
+	derived.TrafficIn("in")
+	derived.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.Contains(string(content), "[IN] payload:IN") {
+		t.Errorf("Expected the derived log to be redacted. Log content:\n%s", content)
+	}
+}
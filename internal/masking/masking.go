@@ -0,0 +1,192 @@
+package masking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+)
+
+// Masker masks sensitive values in tools/call results before they reach the
+// client. Values are masked by column in tabular results and by detector
+// anywhere in the result.
+type Masker struct {
+	rules  []*rule
+	salt   []byte
+	logger *logging.Logger
+}
+
+// New creates a masker from the configuration. Unnamed rules are named
+// after their position, e.g. "rule-2".
+func New(cfg config.MaskingConfig, logger *logging.Logger) *Masker {
+	m := &Masker{salt: []byte(cfg.Salt), logger: logger}
+	for i, r := range cfg.Rules {
+		m.rules = append(m.rules, newRule(i, r))
+	}
+	return m
+}
+
+// Middleware returns the masker as proxy middleware. The number of values
+// masked per rule is recorded as the "masking" note.
+func (m *Masker) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			resp, err := next(ctx, s, req)
+			if req.Method != "tools/call" {
+				return resp, err
+			}
+			tool := mcp.ToolName(req)
+			var raw *proxy.RawResponse
+			if errors.As(err, &raw) && len(m.forTool(tool)) > 0 {
+				// Raw bytes, such as a result truncated by chaos rules,
+				// cannot be masked and are not passed on either
+				m.logger.Errorf("Failed to mask result of tool %s: the response is not a valid message", tool)
+				return nil, mcp.NewError(mcp.InternalError, "Failed to mask the result of tool '%s'", tool)
+			}
+			if err != nil || resp == nil || resp.Result == nil {
+				return resp, err
+			}
+
+			result, counts, err := m.Apply(tool, resp.Result)
+			if err != nil {
+				// Never pass on a result that could not be checked
+				m.logger.Errorf("Failed to mask result of tool %s: %v", tool, err)
+				return nil, mcp.NewError(mcp.InternalError, "Failed to mask the result of tool '%s'", tool)
+			}
+			if len(counts) == 0 {
+				return resp, nil
+			}
+
+			proxy.Note(ctx, "masking", counts)
+			m.logger.Infof("Masked values in result of tool %s: %s", tool, describe(counts))
+			masked := resp.Clone()
+			masked.Result = result
+			return masked, nil
+		}
+	}
+}
+
+// Apply masks a tools/call result of a tool: the structured content and the
+// text blocks of the content. It returns the masked result and the number of
+// values masked per rule; results without masked values are returned as is.
+func (m *Masker) Apply(tool string, raw json.RawMessage) (json.RawMessage, map[string]int, error) {
+	c := &call{rules: m.forTool(tool), salt: m.salt, counts: make(map[string]int)}
+	if len(c.rules) == 0 {
+		return raw, nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		return raw, nil, nil
+	}
+	changed := false
+	if data, ok := fields["structuredContent"]; ok {
+		if v, ok := decode(string(data)); ok {
+			if v, ch := c.walk(v, ""); ch {
+				encoded, err := json.Marshal(v)
+				if err != nil {
+					return nil, nil, err
+				}
+				fields["structuredContent"] = encoded
+				changed = true
+			}
+		}
+	}
+	if data, ok := fields["content"]; ok {
+		var content []json.RawMessage
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, nil, fmt.Errorf("invalid content: %w", err)
+		}
+		contentChanged := false
+		for i, block := range content {
+			text, ok := blockText(block)
+			if !ok {
+				continue
+			}
+			if masked, ch := c.text(text); ch {
+				block, err := withText(block, masked)
+				if err != nil {
+					return nil, nil, err
+				}
+				content[i] = block
+				contentChanged = true
+			}
+		}
+		if contentChanged {
+			encoded, err := json.Marshal(content)
+			if err != nil {
+				return nil, nil, err
+			}
+			fields["content"] = encoded
+			changed = true
+		}
+	}
+	if !changed {
+		return raw, nil, nil
+	}
+	result, err := json.Marshal(fields)
+	return result, c.counts, err
+}
+
+// forTool returns the rules that apply to a tool
+func (m *Masker) forTool(tool string) []*rule {
+	var rules []*rule
+	for _, r := range m.rules {
+		if len(r.tools) == 0 || matchAny(r.tools, tool) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// describe lists the counts per rule, e.g. "contact=1, ssn=3"
+func describe(counts map[string]int) string {
+	parts := make([]string, 0, len(counts))
+	for name, n := range counts {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// blockText returns the text of a text content block
+func blockText(block json.RawMessage) (string, bool) {
+	var b struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(block, &b) != nil || b.Type != "text" {
+		return "", false
+	}
+	return b.Text, true
+}
+
+// withText returns a copy of a text content block with its text replaced
+func withText(block json.RawMessage, text string) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(block, &fields); err != nil {
+		return nil, err
+	}
+	var err error
+	if fields["text"], err = json.Marshal(text); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
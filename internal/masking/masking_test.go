@@ -0,0 +1,190 @@
+package masking
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func newMasker(t *testing.T, rules ...config.MaskingRule) *Masker {
+	return New(config.MaskingConfig{Salt: "pepper", Rules: rules}, newTestLogger(t))
+}
+
+var ssnRule = config.MaskingRule{Name: "ssn", Columns: []string{"ssn", "*_ssn"}, Detectors: []string{config.DetectSSN}, Strategy: config.MaskPartial}
+
+func textResult(t *testing.T, text string) json.RawMessage {
+	data, err := json.Marshal(map[string]interface{}{"content": []map[string]string{{"type": "text", "text": text}}})
+	require.NoError(t, err)
+	return data
+}
+
+func resultText(t *testing.T, raw json.RawMessage) string {
+	var r struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &r))
+	require.Len(t, r.Content, 1)
+	return r.Content[0].Text
+}
+
+func TestJSONRows(t *testing.T) {
+	m := newMasker(t, ssnRule, config.MaskingRule{Name: "contact", Columns: []string{"Email"}, Strategy: config.MaskNull})
+
+	raw := json.RawMessage(`{
+		"content": [{"type":"text","text":"[{\"id\":1,\"ssn\":\"123-45-6789\",\"email\":\"a@b.io\"},{\"id\":2,\"spouse_ssn\":987654321,\"email\":null}]"}],
+		"structuredContent": {"columns":[{"name":"id"},{"name":"SSN"}],"rows":[[1,"123-45-6789"],[2,null]],"rowCount":2}
+	}`)
+	result, counts, err := m.Apply("execute_sql", raw)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"ssn": 3, "contact": 1}, counts)
+
+	var r struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+	}
+	require.NoError(t, json.Unmarshal(result, &r))
+	assert.JSONEq(t, `[{"id":1,"ssn":"***-**-6789","email":null},{"id":2,"spouse_ssn":"*****4321","email":null}]`, r.Content[0].Text)
+	assert.JSONEq(t, `{"columns":[{"name":"id"},{"name":"SSN"}],"rows":[[1,"***-**-6789"],[2,null]],"rowCount":2}`, string(r.StructuredContent))
+}
+
+func TestDetectorsInUnnamedColumns(t *testing.T) {
+	m := newMasker(t, ssnRule, config.MaskingRule{Name: "card", Detectors: []string{config.DetectCard, config.DetectEmail}, Strategy: config.MaskHash})
+
+	result, counts, err := m.Apply("query", textResult(t, `{"columns":["note","pan"],"rows":[["SSN on file: 123-45-6789","4111 1111 1111 1111"],["ok","1234"]]}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"ssn": 1, "card": 1}, counts)
+	assert.JSONEq(t, `{"columns":["note","pan"],"rows":[["*** ** ****: ***-**-6789","hash:`+hash(t, "4111 1111 1111 1111")+`"],["ok","1234"]]}`,
+		resultText(t, result))
+}
+
+func TestTextTables(t *testing.T) {
+	m := newMasker(t, ssnRule, config.MaskingRule{Name: "email", Columns: []string{"email"}, Strategy: config.MaskNull})
+
+	psql := " id |     ssn     |   email\n" +
+		"----+-------------+-----------\n" +
+		"  1 | 123-45-6789 | a@b.io\n" +
+		"  2 |             | c@d.io\n" +
+		"(2 rows)\n" +
+		"Reference 555-12-3456 noted"
+	result, counts, err := m.Apply("query", textResult(t, psql))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"ssn": 2, "email": 2}, counts)
+	assert.Equal(t, " id |     ssn     |   email\n"+
+		"----+-------------+-----------\n"+
+		"  1 | ***-**-6789 | NULL  \n"+
+		"  2 |             | NULL  \n"+
+		"(2 rows)\n"+
+		"Reference ***-**-3456 noted", resultText(t, result))
+
+	markdown := "| Name | Tax_SSN |\n|------|---------|\n| Ann | 111223333 |"
+	result, _, err = m.Apply("query", textResult(t, markdown))
+	require.NoError(t, err)
+	assert.Equal(t, "| Name | Tax_SSN |\n|------|---------|\n| Ann | *****3333 |", resultText(t, result))
+}
+
+func TestToolsAndUnchangedResults(t *testing.T) {
+	rule := ssnRule
+	rule.Tools = []string{"execute_*"}
+	m := newMasker(t, rule)
+
+	raw := textResult(t, `[{"ssn":"123-45-6789"}]`)
+	result, counts, err := m.Apply("list_tables", raw)
+	require.NoError(t, err)
+	assert.Nil(t, counts)
+	assert.Equal(t, string(raw), string(result))
+
+	raw = textResult(t, `[{"id":1}]`)
+	result, counts, err = m.Apply("execute_sql", raw)
+	require.NoError(t, err)
+	assert.Nil(t, counts)
+	assert.Equal(t, string(raw), string(result), "results without masked values are not re-encoded")
+}
+
+func TestStrategies(t *testing.T) {
+	hashRule := newRule(0, config.MaskingRule{Strategy: config.MaskHash})
+	a, ok := hashRule.mask("123-45-6789", []byte("k1"))
+	assert.True(t, ok)
+	b, _ := hashRule.mask("123-45-6789", []byte("k1"))
+	c, _ := hashRule.mask("123-45-6789", []byte("k2"))
+	assert.Equal(t, a, b, "equal values hash alike")
+	assert.NotEqual(t, a, c, "the salt keys the hash")
+	assert.Len(t, a, len("hash:")+16)
+
+	assert.Equal(t, "***-**-6789", partial("123-45-6789", 4))
+	assert.Equal(t, "***e@x.io", partial("jane@x.io", 4))
+	assert.Equal(t, "***", partial("abc", 4), "short values are starred entirely")
+	assert.Equal(t, "**a", partial("çéa", 1))
+
+	_, ok = newRule(0, config.MaskingRule{Strategy: config.MaskNull}).mask("x", nil)
+	assert.False(t, ok)
+	assert.Equal(t, "rule-3", newRule(2, config.MaskingRule{Strategy: config.MaskNull}).name)
+}
+
+func TestMiddleware(t *testing.T) {
+	raw := `{"content":[{"type":"text","text":"[{\"ssn\":\"123-45-6789\"}]"}]}`
+	upstream := func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(raw)}, nil
+	}
+	call, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{"name": "execute_sql"})
+	require.NoError(t, err)
+	s := proxy.NewSession("test", nil)
+	m := newMasker(t, ssnRule)
+
+	ctx, notes := proxy.WithNotes(context.Background())
+	resp, err := m.Middleware()(upstream)(ctx, s, call)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"ssn":"***-**-6789"}]`, resultText(t, resp.Result))
+	assert.Equal(t, map[string]interface{}{"masking": map[string]int{"ssn": 1}}, notes.All())
+
+	// Results that cannot be checked are not passed on
+	raw = `{"content":{"type":"text","text":"123-45-6789"}}`
+	_, err = m.Middleware()(upstream)(context.Background(), s, call)
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, mcp.InternalError, rpcErr.Code)
+
+	// Raw responses, such as results truncated by chaos rules, are not passed on
+	truncate := func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return nil, &proxy.RawResponse{Data: []byte(`{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"text","text":"[{\"ssn\":\"123-45-67`)}
+	}
+	_, err = m.Middleware()(truncate)(context.Background(), s, call)
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, mcp.InternalError, rpcErr.Code)
+
+	// Other methods are not masked
+	raw = `{"ssn":"123-45-6789"}`
+	list, err := mcp.NewRequest(json.RawMessage("2"), "resources/list", nil)
+	require.NoError(t, err)
+	resp, err = m.Middleware()(upstream)(context.Background(), s, list)
+	require.NoError(t, err)
+	assert.Equal(t, raw, string(resp.Result))
+}
+
+func hash(t *testing.T, value string) string {
+	masked, ok := newRule(0, config.MaskingRule{Strategy: config.MaskHash}).mask(value, []byte("pepper"))
+	require.True(t, ok)
+	return masked[len("hash:"):]
+}
@@ -0,0 +1,140 @@
+package masking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/redact"
+)
+
+// defaultReveal is the number of characters the partial strategy leaves
+// visible when a rule sets none
+const defaultReveal = 4
+
+// detector finds the sensitive values in a text
+type detector func(text string) [][]int
+
+var detectors = map[string]detector{
+	config.DetectSSN:   findAll(regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)),
+	config.DetectEmail: findAll(regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)),
+	config.DetectCard:  findCards,
+}
+
+func findAll(re *regexp.Regexp) detector {
+	return func(text string) [][]int {
+		return re.FindAllStringIndex(text, -1)
+	}
+}
+
+var cardPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+func findCards(text string) [][]int {
+	var found [][]int
+	for _, loc := range cardPattern.FindAllStringIndex(text, -1) {
+		if redact.Luhn(text[loc[0]:loc[1]]) {
+			found = append(found, loc)
+		}
+	}
+	return found
+}
+
+// rule is a masking rule with lower-cased column patterns
+type rule struct {
+	name      string
+	tools     []string
+	columns   []string
+	detectors []detector
+	strategy  string
+	reveal    int
+}
+
+func newRule(i int, cfg config.MaskingRule) *rule {
+	r := &rule{name: cfg.Name, tools: cfg.Tools, strategy: cfg.Strategy, reveal: cfg.Reveal}
+	if r.name == "" {
+		r.name = fmt.Sprintf("rule-%d", i+1)
+	}
+	if r.reveal == 0 {
+		r.reveal = defaultReveal
+	}
+	for _, c := range cfg.Columns {
+		r.columns = append(r.columns, strings.ToLower(c))
+	}
+	for _, name := range cfg.Detectors {
+		if d, ok := detectors[name]; ok {
+			r.detectors = append(r.detectors, d)
+		}
+	}
+	return r
+}
+
+// matchesColumn reports whether the rule covers a column, ignoring case
+func (r *rule) matchesColumn(column string) bool {
+	if column == "" {
+		return false
+	}
+	column = strings.ToLower(column)
+	for _, p := range r.columns {
+		if ok, _ := path.Match(p, column); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// detects reports whether a detector of the rule finds a value in text
+func (r *rule) detects(text string) bool {
+	for _, d := range r.detectors {
+		if len(d(text)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mask applies the strategy to a value. ok is false for the null strategy.
+func (r *rule) mask(value string, salt []byte) (masked string, ok bool) {
+	switch r.strategy {
+	case config.MaskHash:
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(value))
+		return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16], true
+	case config.MaskPartial:
+		return partial(value, r.reveal), true
+	default:
+		return "", false
+	}
+}
+
+// partial stars the letters and digits of value except the last reveal ones,
+// keeping punctuation, e.g. 123-45-6789 becomes ***-**-6789. Values with no
+// more than reveal letters and digits are starred entirely.
+func partial(value string, reveal int) string {
+	runes := []rune(value)
+	total := 0
+	for _, c := range runes {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			total++
+		}
+	}
+	keep := reveal
+	if total <= reveal {
+		keep = 0
+	}
+	seen := 0
+	for i, c := range runes {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			if seen < total-keep {
+				runes[i] = '*'
+			}
+			seen++
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,255 @@
+package masking
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// call masks the result of one tools/call with the rules for its tool and
+// counts the values masked per rule
+type call struct {
+	rules  []*rule
+	salt   []byte
+	counts map[string]int
+}
+
+// match returns the first rule that covers the column or detects the value
+func (c *call) match(column, value string) *rule {
+	for _, r := range c.rules {
+		if r.matchesColumn(column) || r.detects(value) {
+			return r
+		}
+	}
+	return nil
+}
+
+// walk masks a decoded JSON value found in a column. Object keys name the
+// columns of their values; an object with "columns" and "rows" arrays is a
+// table whose array rows are matched to the columns by position.
+func (c *call) walk(v interface{}, column string) (interface{}, bool) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		changed := false
+		columns, isTable := columnNames(t["columns"])
+		rows, hasRows := t["rows"].([]interface{})
+		for k, child := range t {
+			var ch bool
+			switch {
+			case isTable && k == "columns":
+				continue
+			case isTable && hasRows && k == "rows":
+				ch = c.rows(rows, columns)
+			default:
+				t[k], ch = c.walk(child, k)
+			}
+			changed = changed || ch
+		}
+		return t, changed
+	case []interface{}:
+		changed := false
+		for i, child := range t {
+			var ch bool
+			t[i], ch = c.walk(child, column)
+			changed = changed || ch
+		}
+		return t, changed
+	case string:
+		return c.value(t, t, column)
+	case json.Number:
+		return c.value(t, t.String(), column)
+	}
+	return v, false
+}
+
+// rows masks the rows of a table; array rows are matched to the columns by
+// position, other rows are walked
+func (c *call) rows(rows []interface{}, columns []string) bool {
+	changed := false
+	for i, row := range rows {
+		cells, ok := row.([]interface{})
+		if !ok {
+			var ch bool
+			rows[i], ch = c.walk(row, "")
+			changed = changed || ch
+			continue
+		}
+		for j, cell := range cells {
+			column := ""
+			if j < len(columns) {
+				column = columns[j]
+			}
+			var ch bool
+			cells[j], ch = c.walk(cell, column)
+			changed = changed || ch
+		}
+	}
+	return changed
+}
+
+// value masks a string or number; null masks become JSON null
+func (c *call) value(v interface{}, text, column string) (interface{}, bool) {
+	r := c.match(column, text)
+	if r == nil {
+		return v, false
+	}
+	c.counts[r.name]++
+	if masked, ok := r.mask(text, c.salt); ok {
+		return masked, true
+	}
+	return nil, true
+}
+
+// columnNames returns the names of a "columns" array: strings, or objects
+// with a "name"
+func columnNames(v interface{}) ([]string, bool) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	names := make([]string, len(items))
+	for i, item := range items {
+		switch t := item.(type) {
+		case string:
+			names[i] = t
+		case map[string]interface{}:
+			if name, ok := t["name"].(string); ok {
+				names[i] = name
+			} else {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return names, true
+}
+
+// text masks the text of a content block: JSON text as decoded JSON, other
+// text by its tables and detectors
+func (c *call) text(text string) (string, bool) {
+	if v, ok := decode(text); ok {
+		v, changed := c.walk(v, "")
+		if !changed {
+			return text, false
+		}
+		var data []byte
+		var err error
+		if strings.Contains(strings.TrimSpace(text), "\n") {
+			data, err = json.MarshalIndent(v, "", "  ")
+		} else {
+			data, err = json.Marshal(v)
+		}
+		if err == nil {
+			return string(data), true
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	changed := false
+	for i := 0; i < len(lines); {
+		if i+1 < len(lines) && strings.Contains(lines[i], "|") && isSeparator(lines[i+1]) {
+			columns := strings.Split(lines[i], "|")
+			for k := range columns {
+				columns[k] = strings.TrimSpace(columns[k])
+			}
+			j := i + 2
+			for ; j < len(lines) && strings.Contains(lines[j], "|"); j++ {
+				var ch bool
+				lines[j], ch = c.tableRow(lines[j], columns)
+				changed = changed || ch
+			}
+			i = j
+			continue
+		}
+		var ch bool
+		lines[i], ch = c.freeText(lines[i])
+		changed = changed || ch
+		i++
+	}
+	return strings.Join(lines, "\n"), changed
+}
+
+// isSeparator reports whether a line separates a text table's header from its
+// rows, as in psql output (----+----) or Markdown (|---|---|)
+func isSeparator(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.Contains(trimmed, "-") && strings.Trim(trimmed, "-|+: ") == ""
+}
+
+// tableRow masks the cells of a text table row, keeping the column alignment
+// where the masked value is no longer than the original
+func (c *call) tableRow(line string, columns []string) (string, bool) {
+	cells := strings.Split(line, "|")
+	changed := false
+	for k, cell := range cells {
+		value := strings.TrimSpace(cell)
+		if value == "" {
+			continue
+		}
+		column := ""
+		if k < len(columns) {
+			column = columns[k]
+		}
+		r := c.match(column, value)
+		if r == nil {
+			continue
+		}
+		c.counts[r.name]++
+		masked, ok := r.mask(value, c.salt)
+		if !ok {
+			masked = "NULL"
+		}
+		lead := cell[:strings.Index(cell, value)]
+		replaced := lead + masked
+		if pad := len(cell) - len(replaced); pad > 0 {
+			replaced += strings.Repeat(" ", pad)
+		}
+		cells[k] = replaced
+		changed = true
+	}
+	return strings.Join(cells, "|"), changed
+}
+
+// freeText masks what the detectors of the rules find in text outside tables
+func (c *call) freeText(text string) (string, bool) {
+	changed := false
+	for _, r := range c.rules {
+		for _, d := range r.detectors {
+			locs := d(text)
+			if len(locs) == 0 {
+				continue
+			}
+			var b strings.Builder
+			last := 0
+			for _, loc := range locs {
+				masked, ok := r.mask(text[loc[0]:loc[1]], c.salt)
+				if !ok {
+					masked = "NULL"
+				}
+				b.WriteString(text[last:loc[0]])
+				b.WriteString(masked)
+				last = loc[1]
+				c.counts[r.name]++
+			}
+			b.WriteString(text[last:])
+			text = b.String()
+			changed = true
+		}
+	}
+	return text, changed
+}
+
+// decode decodes JSON text holding an object or array, keeping numbers as written
+func decode(s string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
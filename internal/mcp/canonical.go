@@ -0,0 +1,60 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Canonicalize returns a canonical JSON encoding of raw with object keys sorted
+// and insignificant whitespace removed. Object members whose key is listed in
+// ignore are dropped at any depth, which allows volatile fields such as _meta
+// to be excluded when comparing messages.
+func Canonicalize(raw json.RawMessage, ignore ...string) (string, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return "", nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", fmt.Errorf("failed to decode JSON: %w", err)
+	}
+
+	if len(ignore) > 0 {
+		skip := make(map[string]bool, len(ignore))
+		for _, key := range ignore {
+			skip[key] = true
+		}
+		value = dropKeys(value, skip)
+	}
+
+	// encoding/json writes map keys in sorted order, which gives us a stable form
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return string(out), nil
+}
+
+func dropKeys(value interface{}, skip map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if skip[key] {
+				delete(v, key)
+				continue
+			}
+			v[key] = dropKeys(child, skip)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = dropKeys(child, skip)
+		}
+		return v
+	default:
+		return v
+	}
+}
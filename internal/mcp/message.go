@@ -0,0 +1,191 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// Server error codes used by the proxy itself
+const (
+	PolicyDenied = -32001 // The request was rejected by a proxy policy
+)
+
+// Message represents a single JSON-RPC 2.0 message exchanged over MCP.
+// It covers requests, notifications and responses; the ID, Params and Result
+// fields are kept as raw JSON so they can be forwarded without re-encoding.
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error represents a JSON-RPC error object
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+// NewError creates a JSON-RPC error object with a formatted message
+func NewError(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Parse decodes a single JSON-RPC message
+func Parse(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON-RPC message: %w", err)
+	}
+	return &msg, nil
+}
+
+// ParseBatch decodes either a single JSON-RPC message or a JSON-RPC batch.
+// The returned flag reports whether the input was a batch.
+func ParseBatch(data []byte) ([]*Message, bool, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		msg, err := Parse(trimmed)
+		if err != nil {
+			return nil, false, err
+		}
+		return []*Message{msg}, false, nil
+	}
+
+	var batch []*Message
+	if err := json.Unmarshal(trimmed, &batch); err != nil {
+		return nil, true, fmt.Errorf("failed to parse JSON-RPC batch: %w", err)
+	}
+	return batch, true, nil
+}
+
+// NewRequest creates a request with the given id, method and params
+func NewRequest(id json.RawMessage, method string, params interface{}) (*Message, error) {
+	raw, err := marshalOptional(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{JSONRPC: "2.0", ID: id, Method: method, Params: raw}, nil
+}
+
+// NewNotification creates a notification with the given method and params
+func NewNotification(method string, params interface{}) (*Message, error) {
+	raw, err := marshalOptional(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{JSONRPC: "2.0", Method: method, Params: raw}, nil
+}
+
+// NewResult creates a successful response carrying the given result
+func NewResult(id json.RawMessage, result interface{}) (*Message, error) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode result: %w", err)
+	}
+	return &Message{JSONRPC: "2.0", ID: id, Result: raw}, nil
+}
+
+// NewErrorResponse creates an error response for the given request id
+func NewErrorResponse(id json.RawMessage, err *Error) *Message {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &Message{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// IsRequest reports whether the message is a request expecting a response
+func (m *Message) IsRequest() bool {
+	return m.Method != "" && m.ID != nil
+}
+
+// IsNotification reports whether the message is a notification
+func (m *Message) IsNotification() bool {
+	return m.Method != "" && m.ID == nil
+}
+
+// IsResponse reports whether the message is a response to a request
+func (m *Message) IsResponse() bool {
+	return m.Method == "" && m.ID != nil
+}
+
+// IDKey returns a canonical string form of the message id suitable for use as a map key
+func (m *Message) IDKey() string {
+	return IDKey(m.ID)
+}
+
+// IDKey returns a canonical string form of a raw JSON-RPC id
+func IDKey(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, id); err != nil {
+		return string(id)
+	}
+	return buf.String()
+}
+
+// Marshal encodes the message as compact JSON
+func (m *Message) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// Clone returns a copy of the message that can be modified independently
+func (m *Message) Clone() *Message {
+	c := *m
+	c.ID = cloneRaw(m.ID)
+	c.Params = cloneRaw(m.Params)
+	c.Result = cloneRaw(m.Result)
+	if m.Error != nil {
+		e := *m.Error
+		e.Data = cloneRaw(m.Error.Data)
+		c.Error = &e
+	}
+	return &c
+}
+
+// String returns the message as compact JSON, for logging
+func (m *Message) String() string {
+	data, err := m.Marshal()
+	if err != nil {
+		return fmt.Sprintf("<unencodable message: %v>", err)
+	}
+	return string(data)
+}
+
+func cloneRaw(raw json.RawMessage) json.RawMessage {
+	if raw == nil {
+		return nil
+	}
+	return append(json.RawMessage(nil), raw...)
+}
+
+func marshalOptional(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		return raw, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode params: %w", err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessageKinds(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		request      bool
+		notification bool
+		response     bool
+	}{
+		{
+			name:    "request",
+			input:   `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+			request: true,
+		},
+		{
+			name:         "notification",
+			input:        `{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+			notification: true,
+		},
+		{
+			name:     "result response",
+			input:    `{"jsonrpc":"2.0","id":"abc","result":{}}`,
+			response: true,
+		},
+		{
+			name:     "error response with null id",
+			input:    `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"Parse error"}}`,
+			response: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Parse([]byte(tt.input))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.request, msg.IsRequest())
+			assert.Equal(t, tt.notification, msg.IsNotification())
+			assert.Equal(t, tt.response, msg.IsResponse())
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse([]byte(`not json`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse JSON-RPC message")
+}
+
+func TestParseBatch(t *testing.T) {
+	msgs, batch, err := ParseBatch([]byte(` [{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"}]`))
+	require.NoError(t, err)
+	assert.True(t, batch)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "ping", msgs[0].Method)
+
+	msgs, batch, err = ParseBatch([]byte(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	require.NoError(t, err)
+	assert.False(t, batch)
+	require.Len(t, msgs, 1)
+}
+
+func TestIDKey(t *testing.T) {
+	assert.Equal(t, "1", IDKey(json.RawMessage(" 1 ")))
+	assert.Equal(t, `"a"`, IDKey(json.RawMessage(`"a"`)))
+	assert.NotEqual(t, IDKey(json.RawMessage(`1`)), IDKey(json.RawMessage(`"1"`)))
+}
+
+func TestConstructors(t *testing.T) {
+	req, err := NewRequest(json.RawMessage(`7`), "tools/call", map[string]interface{}{"name": "query"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"query"}}`, req.String())
+
+	note, err := NewNotification("notifications/initialized", nil)
+	require.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, note.String())
+
+	res, err := NewResult(json.RawMessage(`7`), map[string]string{"ok": "yes"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":7,"result":{"ok":"yes"}}`, res.String())
+
+	errResp := NewErrorResponse(nil, NewError(InvalidRequest, "bad %s", "thing"))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"bad thing"}}`, errResp.String())
+}
+
+func TestClone(t *testing.T) {
+	orig, err := Parse([]byte(`{"jsonrpc":"2.0","id":1,"result":{"a":1}}`))
+	require.NoError(t, err)
+
+	c := orig.Clone()
+	c.Result[2] = 'b'
+	assert.Equal(t, `{"a":1}`, string(orig.Result))
+}
+
+func TestCanonicalize(t *testing.T) {
+	a, err := Canonicalize(json.RawMessage(`{"b": 1, "a": {"y": 2, "x": 1}}`))
+	require.NoError(t, err)
+	b, err := Canonicalize(json.RawMessage(`{"a":{"x":1,"y":2},"b":1}`))
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+
+	withMeta, err := Canonicalize(json.RawMessage(`{"name":"q","_meta":{"progressToken":5}}`), "_meta")
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"q"}`, withMeta)
+
+	// Large integers must survive canonicalization unchanged
+	big, err := Canonicalize(json.RawMessage(`{"n":12345678901234567890}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"n":12345678901234567890}`, big)
+
+	empty, err := Canonicalize(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", empty)
+}
@@ -0,0 +1,86 @@
+package mcp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Event is a single server-sent event as used by the Streamable HTTP transport
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry string
+}
+
+// ReadEvents parses a text/event-stream body and calls fn for every event.
+// Reading stops at the end of the stream or when fn returns an error.
+func ReadEvents(r io.Reader, fn func(*Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	var current Event
+	var data []string
+	dispatch := func() error {
+		if data == nil && current.ID == "" && current.Event == "" {
+			return nil
+		}
+		current.Data = strings.Join(data, "\n")
+		event := current
+		current, data = Event{}, nil
+		return fn(&event)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := dispatch(); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			current.ID = value
+		case "event":
+			current.Event = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			current.Retry = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read event stream: %w", err)
+	}
+	return dispatch()
+}
+
+// WriteEvent writes a single server-sent event
+func WriteEvent(w io.Writer, event *Event) error {
+	var b strings.Builder
+	if event.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", event.ID)
+	}
+	if event.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event.Event)
+	}
+	if event.Retry != "" {
+		fmt.Fprintf(&b, "retry: %s\n", event.Retry)
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,57 @@
+package mcp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadEvents(t *testing.T) {
+	stream := ": keep-alive\n" +
+		"id: 1\nevent: message\ndata: {\"a\":1}\n\n" +
+		"data: line one\ndata: line two\n\n" +
+		"id: 3\ndata: last"
+
+	var events []*Event
+	err := ReadEvents(strings.NewReader(stream), func(e *Event) error {
+		events = append(events, e)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	assert.Equal(t, "1", events[0].ID)
+	assert.Equal(t, "message", events[0].Event)
+	assert.Equal(t, `{"a":1}`, events[0].Data)
+	assert.Equal(t, "line one\nline two", events[1].Data)
+	assert.Equal(t, "3", events[2].ID)
+	assert.Equal(t, "last", events[2].Data)
+}
+
+func TestReadEventsStopsOnError(t *testing.T) {
+	stop := errors.New("stop")
+	count := 0
+	err := ReadEvents(strings.NewReader("data: 1\n\ndata: 2\n\n"), func(e *Event) error {
+		count++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, count)
+}
+
+func TestWriteEventRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteEvent(&buf, &Event{ID: "7", Event: "message", Data: "x\ny"}))
+	assert.Equal(t, "id: 7\nevent: message\ndata: x\ndata: y\n\n", buf.String())
+
+	var got *Event
+	require.NoError(t, ReadEvents(&buf, func(e *Event) error {
+		got = e
+		return nil
+	}))
+	assert.Equal(t, &Event{ID: "7", Event: "message", Data: "x\ny"}, got)
+}
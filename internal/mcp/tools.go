@@ -0,0 +1,37 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ToolCall holds the params of a tools/call request
+type ToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Meta      json.RawMessage `json:"_meta,omitempty"`
+}
+
+// ParseToolCall decodes the params of a tools/call request
+func ParseToolCall(msg *Message) (*ToolCall, error) {
+	if msg.Method != "tools/call" {
+		return nil, fmt.Errorf("not a tools/call request: %s", msg.Method)
+	}
+	var call ToolCall
+	if err := json.Unmarshal(msg.Params, &call); err != nil {
+		return nil, fmt.Errorf("invalid tools/call params: %w", err)
+	}
+	return &call, nil
+}
+
+// ToolName returns the tool named by a tools/call request, or "" for any other message
+func ToolName(msg *Message) string {
+	if msg.Method != "tools/call" {
+		return ""
+	}
+	call, err := ParseToolCall(msg)
+	if err != nil {
+		return ""
+	}
+	return call.Name
+}
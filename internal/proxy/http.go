@@ -0,0 +1,815 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/eventstore"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// errResponseFound stops reading an upstream event stream once the response has arrived
+var errResponseFound = errors.New("response found")
+
+// Errors of Targets.Pick that are answered with a status of their own
+var (
+	// ErrSessionLost means the instance holding the client's session is gone.
+	// The client is answered 404, so that it starts a new session.
+	ErrSessionLost = errors.New("upstream instance holding the session is unavailable")
+	// ErrNoTarget means no instance can take the request. The client is
+	// answered 503.
+	ErrNoTarget = errors.New("no healthy upstream instance")
+)
+
+// Targets chooses the upstream instance an HTTPServer relays each exchange to
+type Targets interface {
+	// Pick returns the base URL for an exchange of a session ("" before the
+	// client has one) and a function to call once the exchange is over
+	Pick(session string) (target string, release func(), err error)
+	// Bind records that the instance at target assigned a session
+	Bind(session, target string)
+	// Unbind forgets a session the client ended
+	Unbind(session string)
+}
+
+// singleTarget is a Targets of one upstream
+type singleTarget string
+
+func (t singleTarget) Pick(string) (string, func(), error) { return string(t), func() {}, nil }
+func (t singleTarget) Bind(string, string)                 {}
+func (t singleTarget) Unbind(string)                       {}
+
+// HTTPServer relays Streamable HTTP traffic to an upstream MCP server. JSON-RPC
+// requests posted by the client pass through the middleware chain, those in
+// batches one by one; everything else (notifications, responses, GET streams
+// and DELETE) is relayed as is. Sessions the upstream assigns in response to initialize
+// are tracked by their Mcp-Session-Id, see TrackSessions, and event streams
+// can be made resumable, see ResumeStreams.
+type HTTPServer struct {
+	proxy    *Proxy
+	logger   *logging.Logger
+	targets  Targets
+	client   *http.Client
+	requests *clientRequests
+	events   *eventstore.Store // Nil unless streams are resumable
+
+	mu             sync.Mutex
+	sessions       map[string]*relaySession // By Mcp-Session-Id
+	sessionOptions SessionOptions
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewHTTPServer creates an HTTP frontend that forwards to target, the base URL
+// of the upstream such as http://localhost:8891
+func NewHTTPServer(p *Proxy, target string) *HTTPServer {
+	return NewBalancedHTTPServer(p, singleTarget(strings.TrimSuffix(target, "/")))
+}
+
+// NewBalancedHTTPServer creates an HTTP frontend that forwards each exchange
+// to the upstream instance chosen by targets
+func NewBalancedHTTPServer(p *Proxy, targets Targets) *HTTPServer {
+	return &HTTPServer{
+		proxy:    p,
+		logger:   p.logger,
+		targets:  targets,
+		client:   &http.Client{},
+		requests: newClientRequests(),
+		sessions: make(map[string]*relaySession),
+		stop:     make(chan struct{}),
+	}
+}
+
+// ServeHTTP implements http.Handler. The traffic of a tracked session goes to
+// the session's log, if it has one.
+func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger, done := h.enter(r)
+	logger.HTTPIn(r.Method, r.URL.String())
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		done()
+		logger.HTTPError(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	logger.HTTPInBody(string(body))
+
+	if r.Method == http.MethodGet {
+		if r = resume(w, r, logger, h.events); r == nil {
+			done()
+			return
+		}
+	}
+	if r.Method == http.MethodPost {
+		// Bodies the proxy cannot read are not relayed either, as the
+		// upstream might read requests in them that the chain has not seen
+		msgs, batch, err := mcp.ParseBatch(body)
+		if err != nil {
+			writeError(w, logger, http.StatusBadRequest, nil, mcp.NewError(mcp.ParseError, "Parse error"))
+			done()
+			return
+		}
+		if !batch && msgs[0].IsRequest() {
+			h.serveRequest(w, r, logger, msgs[0])
+			done()
+			return
+		}
+		for _, msg := range msgs {
+			h.proxy.observe(r.Header.Get("Mcp-Session-Id"), FromClient, msg, batch)
+		}
+		if batch {
+			h.serveSplitBatch(w, r, logger, msgs)
+			done()
+			return
+		}
+		if body = h.claim(msgs, body); body == nil {
+			w.WriteHeader(http.StatusAccepted)
+			logger.HTTPOut(http.StatusAccepted, "")
+			done()
+			return
+		}
+	}
+	h.relay(w, r, logger, body)
+	done()
+	if r.Method == http.MethodDelete {
+		h.forget(r.Header.Get("Mcp-Session-Id"))
+	}
+}
+
+// claim takes the client's responses to requests of the proxy's own out of a
+// posted body. It returns what remains to relay to the upstream, or nil if
+// nothing does.
+func (h *HTTPServer) claim(msgs []*mcp.Message, body []byte) []byte {
+	var rest []*mcp.Message
+	for _, msg := range msgs {
+		if !h.requests.resolve(msg) {
+			rest = append(rest, msg)
+		}
+	}
+	switch {
+	case len(rest) == len(msgs):
+		return body
+	case len(rest) == 0:
+		return nil
+	}
+	data, err := json.Marshal(rest)
+	if err != nil {
+		h.logger.Errorf("Failed to encode batch for upstream: %v", err)
+		return body
+	}
+	return data
+}
+
+// serveSplitBatch serves a batch. Its requests run through the chain one by
+// one, as every request must, and are answered together; its other messages
+// are posted to the upstream on their own. The upstream never sees a batch,
+// so upstreams on protocol versions without batches are served as well.
+func (h *HTTPServer) serveSplitBatch(w http.ResponseWriter, r *http.Request, logger *logging.Logger, msgs []*mcp.Message) {
+	var requests []*mcp.Message
+	for _, msg := range msgs {
+		switch {
+		case msg.IsRequest():
+			requests = append(requests, msg)
+		case h.requests.resolve(msg):
+		default:
+			if err := h.post(r, msg); err != nil {
+				logger.Errorf("Failed to pass a batched message to the upstream: %v", err)
+			}
+		}
+	}
+	if len(requests) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		logger.HTTPOut(http.StatusAccepted, "")
+		return
+	}
+
+	responses := make([]*mcp.Message, len(requests))
+	raws := make([][]byte, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func(i int, req *mcp.Message) {
+			defer wg.Done()
+			// Messages the upstream sends ahead of a response cannot be
+			// streamed along with a batch, so only responses come back
+			stream := &responseStream{w: discardResponse{header: make(http.Header)}}
+			handler := h.proxy.Chain(func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+				return h.forward(ctx, r, stream, s, req)
+			})
+			resp, err := handler(r.Context(), h.requestSession(r, req), req)
+			var raw *RawResponse
+			switch {
+			case errors.Is(err, context.Canceled):
+			case errors.As(err, &raw):
+				raws[i] = raw.Data
+			case err != nil:
+				responses[i] = errorResponse(req, err)
+			default:
+				responses[i] = resp
+			}
+		}(i, req)
+	}
+	wg.Wait()
+
+	data := joinBatch(logger, responses, raws, func(resp *mcp.Message) {
+		h.proxy.observe(r.Header.Get("Mcp-Session-Id"), ToClient, resp, true)
+	})
+	if data == nil {
+		data = []byte("[]")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	logger.HTTPOut(http.StatusOK, string(data))
+}
+
+// post passes a client notification or response to the upstream on its own
+func (h *HTTPServer) post(r *http.Request, msg *mcp.Message) error {
+	body, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	target, release, err := h.targets.Pick(r.Header.Get("Mcp-Session-Id"))
+	if err != nil {
+		return err
+	}
+	defer release()
+	out, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	out.Header = r.Header.Clone()
+	out.Header.Del("Content-Length")
+	if tracked, ok := h.session(r.Header.Get("Mcp-Session-Id")); ok {
+		upstreamVersion(out.Header, tracked.UpstreamVersion)
+	}
+	resp, err := h.client.Do(out)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &upstream.StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// writeError answers an HTTP request with a JSON-RPC error
+func writeError(w http.ResponseWriter, logger *logging.Logger, status int, id json.RawMessage, rpcErr *mcp.Error) {
+	data, err := mcp.NewErrorResponse(id, rpcErr).Marshal()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+	logger.HTTPOut(status, string(data))
+}
+
+// discardResponse is a response writer for requests answered other than
+// through their own HTTP response
+type discardResponse struct {
+	header http.Header
+}
+
+func (d discardResponse) Header() http.Header         { return d.header }
+func (d discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponse) WriteHeader(int)             {}
+
+// sessionID returns the session a request belongs to. Before the client has
+// a session id, the one assigned in the upstream's response is used.
+func sessionID(w http.ResponseWriter, r *http.Request) string {
+	if id := r.Header.Get("Mcp-Session-Id"); id != "" {
+		return id
+	}
+	return w.Header().Get("Mcp-Session-Id")
+}
+
+// serveRequest runs a client request through the middleware chain
+func (h *HTTPServer) serveRequest(w http.ResponseWriter, r *http.Request, logger *logging.Logger, req *mcp.Message) {
+	stream := newResponseStream(w, r)
+	defer stream.end()
+	ctx := r.Context()
+	if stream.keep(h.events, r.Header.Get("Mcp-Session-Id"), logger) {
+		// A client that drops the stream comes back for the rest of it, so
+		// dropping it does not cancel the request
+		ctx = context.WithoutCancel(ctx)
+	}
+	if h.proxy.observing() {
+		// The request is observed along with the first message sent back, once
+		// the session id assigned by an initialize response is known
+		observed := false
+		stream.observe = func(msg *mcp.Message) {
+			id := sessionID(w, r)
+			if !observed {
+				observed = true
+				h.proxy.observe(id, FromClient, req, false)
+			}
+			h.proxy.observe(id, ToClient, msg, false)
+		}
+	}
+	ctx = WithSender(ctx, stream.send)
+	ctx = WithRequester(ctx, h.requests.requester(stream.send))
+	session := h.requestSession(r, req)
+
+	handler := h.proxy.Chain(func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+		return h.forward(ctx, r, stream, s, req)
+	})
+	resp, err := handler(ctx, session, req)
+
+	// Track the session the upstream assigned before the client can use it
+	if req.Method == "initialize" && err == nil && resp != nil && resp.Error == nil {
+		if id := w.Header().Get("Mcp-Session-Id"); id != "" {
+			h.track(r, id, session.ClientInfo(), negotiatedVersion(req, resp), session.UpstreamVersion())
+		}
+	}
+	answer(r, logger, stream, req, resp, err)
+}
+
+// requestSession returns the session a client request runs in, as known from
+// the session's initialize or, for sessions that are not tracked, from the
+// request
+func (h *HTTPServer) requestSession(r *http.Request, req *mcp.Message) *Session {
+	session := NewSession(r.Header.Get("Mcp-Session-Id"), nil)
+	info := clientInfo(req)
+	if tracked, ok := h.session(session.ID); ok && req.Method != "initialize" {
+		info = tracked.Client
+		session.SetProtocolVersion(tracked.ProtocolVersion)
+		session.SetUpstreamVersion(tracked.UpstreamVersion)
+	} else {
+		session.SetProtocolVersion(r.Header.Get("Mcp-Protocol-Version"))
+	}
+	session.SetClient(info)
+	session.SetPrincipal(requestPrincipal(r))
+	return session
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context naming who an HTTP request was authenticated
+// as. HTTP frontends take it as the session principal instead of the remote host.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+type endpointKey struct{}
+
+// WithEndpoint returns a context naming the endpoint an HTTP request came in
+// on, when one port serves several with their own upstreams
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+// Endpoint returns the endpoint a request came in on, or "" when the proxy
+// serves a single one. Middleware that shares results between requests keeps
+// them apart by endpoint, as each endpoint reaches its own upstream.
+func Endpoint(ctx context.Context) string {
+	endpoint, _ := ctx.Value(endpointKey{}).(string)
+	return endpoint
+}
+
+// requestPrincipal returns who made an HTTP request
+func requestPrincipal(r *http.Request) string {
+	if principal, ok := r.Context().Value(principalKey{}).(string); ok {
+		return principal
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// answer writes the outcome of running a request through the chain to the
+// client. It returns false when nothing could be answered.
+func answer(r *http.Request, logger *logging.Logger, stream *responseStream, req, resp *mcp.Message, err error) bool {
+	var raw *RawResponse
+	var status *upstream.StatusError
+	var rpcErr *mcp.Error
+	switch {
+	case r.Context().Err() != nil && stream.log == nil:
+		// The client went away and will not come back for the response
+		return false
+	case errors.As(err, &raw):
+		stream.writeRaw(http.StatusOK, raw.Data)
+	case errors.As(err, &status):
+		stream.writeRaw(status.StatusCode, []byte(status.Body))
+	case errors.Is(err, ErrSessionLost):
+		stream.writeRaw(http.StatusNotFound, nil)
+	case errors.Is(err, ErrNoTarget):
+		stream.writeRaw(http.StatusServiceUnavailable, nil)
+	case errors.As(err, &rpcErr):
+		stream.finish(errorResponse(req, err))
+	case err != nil:
+		logger.HTTPError(err)
+		stream.writeRaw(http.StatusBadGateway, nil)
+		return false
+	case resp == nil:
+		// Middleware dropped the response: hold the request open until the client gives up
+		<-r.Context().Done()
+		return false
+	default:
+		stream.finish(resp)
+	}
+	logger.HTTPOut(stream.status, stream.logged.String())
+	return true
+}
+
+// forward posts a request to the upstream with the client's headers and returns
+// the response. Server-sent events that precede the response are streamed to the client.
+func (h *HTTPServer) forward(ctx context.Context, r *http.Request, stream *responseStream, session *Session, req *mcp.Message) (*mcp.Message, error) {
+	body, err := req.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	target, release, err := h.targets.Pick(r.Header.Get("Mcp-Session-Id"))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	out, err := http.NewRequestWithContext(ctx, http.MethodPost, target+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	out.Header = r.Header.Clone()
+	out.Header.Del("Content-Length")
+	upstreamVersion(out.Header, session.UpstreamVersion())
+
+	resp, err := h.client.Do(out)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return nil, &upstream.StatusError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	h.bind(r, resp, target)
+	stream.copyHeaders(resp.Header)
+
+	want := req.IDKey()
+	var result *mcp.Message
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		stream.startEvents()
+		err = mcp.ReadEvents(resp.Body, func(event *mcp.Event) error {
+			// Upstreams on protocol versions with batches may send several
+			// messages in one event; they reach the client one by one
+			msgs, _, err := mcp.ParseBatch([]byte(event.Data))
+			if err != nil {
+				return nil
+			}
+			for _, msg := range msgs {
+				if msg.IsResponse() && msg.IDKey() == want {
+					result = msg
+					return errResponseFound
+				}
+				// Clients that cannot take an event stream only receive the
+				// response. Messages go through ctx, so that middleware can send
+				// them to more than the one client, as coalesced calls do.
+				Notify(ctx, msg)
+			}
+			return nil
+		})
+		if err != nil && err != errResponseFound {
+			return nil, err
+		}
+	} else {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		result, err = mcp.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("upstream sent no response for request %s", want)
+	}
+	return result, nil
+}
+
+// relay forwards a request verbatim and streams the upstream response back
+func (h *HTTPServer) relay(w http.ResponseWriter, r *http.Request, logger *logging.Logger, body []byte) {
+	target, release, err := h.targets.Pick(r.Header.Get("Mcp-Session-Id"))
+	switch {
+	case errors.Is(err, ErrSessionLost):
+		w.WriteHeader(http.StatusNotFound)
+		logger.HTTPOut(http.StatusNotFound, "")
+		return
+	case err != nil:
+		logger.HTTPError(err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	url := target + r.URL.RequestURI()
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, url, bytes.NewReader(body))
+	if err != nil {
+		logger.HTTPError(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req.Header = r.Header.Clone()
+	if tracked, ok := h.session(r.Header.Get("Mcp-Session-Id")); ok {
+		upstreamVersion(req.Header, tracked.UpstreamVersion)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		logger.HTTPError(err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		h.bind(r, resp, target)
+	}
+	isStream := strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+	var log *eventstore.Stream
+	if session := r.Header.Get("Mcp-Session-Id"); isStream && h.events != nil && session != "" {
+		if log, err = h.events.Open(session); err != nil {
+			logger.Errorf("Failed to keep events of session %s: %v", session, err)
+		}
+	}
+
+	for k, v := range resp.Header {
+		for _, vv := range v {
+			w.Header().Add(k, vv)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if log != nil {
+		defer log.End()
+		h.relayEvents(w, r, logger, resp, log)
+		return
+	}
+
+	var events *io.PipeWriter
+	var eventsDone chan struct{}
+	if h.proxy.observing() && isStream {
+		// Observe events as they stream past rather than when the stream ends
+		var pr *io.PipeReader
+		pr, events = io.Pipe()
+		eventsDone = make(chan struct{})
+		go func() {
+			defer close(eventsDone)
+			h.observeEvents(sessionID(w, r), pr)
+		}()
+	}
+
+	// Copy in chunks and flush so event streams reach the client as they arrive
+	var logged bytes.Buffer
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			logged.Write(buf[:n])
+			w.Write(buf[:n])
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if events != nil {
+				events.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	logger.HTTPOut(resp.StatusCode, logged.String())
+
+	if events != nil {
+		events.Close()
+		<-eventsDone
+	} else if h.proxy.observing() && logged.Len() > 0 {
+		if msgs, batch, err := mcp.ParseBatch(logged.Bytes()); err == nil {
+			for _, msg := range msgs {
+				h.proxy.observe(sessionID(w, r), ToClient, msg, batch)
+			}
+		}
+	}
+}
+
+// upstreamVersion makes a request to the upstream carry the protocol version
+// the upstream negotiated, when it differs from the client's
+func upstreamVersion(header http.Header, version string) {
+	if version != "" {
+		header.Set("Mcp-Protocol-Version", version)
+	}
+}
+
+// relayEvents streams the events of an upstream event stream to the client
+// under ids from the event store, keeping them for when the client reconnects
+func (h *HTTPServer) relayEvents(w http.ResponseWriter, r *http.Request, logger *logging.Logger, resp *http.Response, log *eventstore.Stream) {
+	var logged bytes.Buffer
+	flusher, _ := w.(http.Flusher)
+	err := mcp.ReadEvents(resp.Body, func(event *mcp.Event) error {
+		event.ID = log.Append(event.Data).ID
+		if h.proxy.observing() {
+			if msg, err := mcp.Parse([]byte(event.Data)); err == nil {
+				h.proxy.observe(sessionID(w, r), ToClient, msg, false)
+			}
+		}
+		var buf bytes.Buffer
+		if err := mcp.WriteEvent(&buf, event); err != nil {
+			return err
+		}
+		logged.Write(buf.Bytes())
+		w.Write(buf.Bytes())
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && r.Context().Err() == nil {
+		logger.HTTPError(err)
+	}
+	logger.HTTPOut(resp.StatusCode, logged.String())
+}
+
+// bind ties a session the upstream assigned in response to the client to the
+// instance that assigned it
+func (h *HTTPServer) bind(r *http.Request, resp *http.Response, target string) {
+	if r.Header.Get("Mcp-Session-Id") != "" {
+		return
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		h.targets.Bind(id, target)
+	}
+}
+
+// observeEvents passes the messages in a relayed event stream to the observers
+func (h *HTTPServer) observeEvents(session string, r *io.PipeReader) {
+	err := mcp.ReadEvents(r, func(event *mcp.Event) error {
+		if msg, err := mcp.Parse([]byte(event.Data)); err == nil {
+			h.proxy.observe(session, ToClient, msg, false)
+		}
+		return nil
+	})
+	// If reading stopped early, later writes fail instead of blocking the relay
+	r.CloseWithError(err)
+}
+
+// responseStream writes the answer to one client request, either as a single
+// JSON body or, once anything has to be sent ahead of the response, as a
+// server-sent event stream
+type responseStream struct {
+	w          http.ResponseWriter
+	acceptsSSE bool
+	observe    func(msg *mcp.Message) // Called for every message sent; may be nil
+	log        *eventstore.Stream     // Keeps the events sent, see keep; may be nil
+
+	mu      sync.Mutex
+	events  bool // Event stream headers have been written
+	written bool // A complete non-stream response has been written
+	ended   bool // The HTTP handler has returned; nothing more can be sent
+	status  int
+	logged  bytes.Buffer
+}
+
+func newResponseStream(w http.ResponseWriter, r *http.Request) *responseStream {
+	return &responseStream{
+		w:          w,
+		acceptsSSE: strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+	}
+}
+
+// copyHeaders passes upstream headers such as Mcp-Session-Id on to the client
+func (s *responseStream) copyHeaders(header http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.events || s.written || s.ended {
+		return
+	}
+	for k, v := range header {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Length", "Content-Type", "Transfer-Encoding":
+			continue
+		}
+		s.w.Header()[k] = append([]string(nil), v...)
+	}
+}
+
+// startEvents switches the response to an event stream if the client accepts one
+func (s *responseStream) startEvents() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startEventsLocked()
+}
+
+func (s *responseStream) startEventsLocked() bool {
+	if s.events {
+		return true
+	}
+	if !s.acceptsSSE || s.written || s.ended {
+		return false
+	}
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(http.StatusOK)
+	s.events = true
+	s.status = http.StatusOK
+	return true
+}
+
+// send streams a message to the client ahead of the response
+func (s *responseStream) send(msg *mcp.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return errors.New("client stream has ended")
+	}
+	if !s.startEventsLocked() {
+		return errors.New("client did not accept an event stream")
+	}
+	return s.writeEventLocked(msg)
+}
+
+// end stops messages sent from other goroutines from reaching the response
+// writer once the HTTP handler returns
+func (s *responseStream) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	if s.log != nil {
+		s.log.End()
+	}
+}
+
+// finish writes the response
+func (s *responseStream) finish(msg *mcp.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.events {
+		s.writeEventLocked(msg)
+		return
+	}
+	data, err := msg.Marshal()
+	if err != nil {
+		s.writeRawLocked(http.StatusInternalServerError, nil)
+		return
+	}
+	if s.observe != nil {
+		s.observe(msg)
+	}
+	s.w.Header().Set("Content-Type", "application/json")
+	s.writeRawLocked(http.StatusOK, data)
+}
+
+// writeRaw writes a complete response with the given status and body. Once an
+// event stream has started the status can no longer change, so the body is
+// written into the stream.
+func (s *responseStream) writeRaw(status int, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeRawLocked(status, body)
+}
+
+func (s *responseStream) writeRawLocked(status int, body []byte) {
+	if !s.events {
+		s.w.WriteHeader(status)
+		s.status = status
+	}
+	s.w.Write(body)
+	s.logged.Write(body)
+	s.written = true
+}
+
+func (s *responseStream) writeEventLocked(msg *mcp.Message) error {
+	if s.observe != nil {
+		s.observe(msg)
+	}
+	event := &mcp.Event{Event: "message", Data: msg.String()}
+	if s.log != nil {
+		event.ID = s.log.Append(event.Data).ID
+	}
+	var buf bytes.Buffer
+	if err := mcp.WriteEvent(&buf, event); err != nil {
+		return err
+	}
+	s.logged.Write(buf.Bytes())
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUpstreamServer answers posted requests as JSON, or as an event stream
+// with a progress notification ahead of the response for method "stream"
+func newUpstreamServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		msg, err := mcp.Parse(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !msg.IsRequest() {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Mcp-Session-Id", "abc")
+		resp, _ := mcp.NewResult(msg.ID, map[string]string{"method": msg.Method, "path": r.URL.Path})
+		if msg.Method == "stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{}}\n\n")
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", resp.String())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(resp.String()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(t *testing.T, h http.Handler, path, accept, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPForwardJSON(t *testing.T) {
+	up := newUpstreamServer(t)
+	h := NewHTTPServer(New(newTestLogger(t)), up.URL)
+
+	rec := post(t, h, "/mcp", "application/json, text/event-stream", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "abc", rec.Header().Get("Mcp-Session-Id"))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"method":"tools/list","path":"/mcp"}}`, rec.Body.String())
+}
+
+func TestHTTPForwardEventStream(t *testing.T) {
+	up := newUpstreamServer(t)
+	h := NewHTTPServer(New(newTestLogger(t)), up.URL)
+
+	rec := post(t, h, "/mcp", "application/json, text/event-stream", `{"jsonrpc":"2.0","id":1,"method":"stream"}`)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	var events []string
+	require.NoError(t, mcp.ReadEvents(rec.Body, func(e *mcp.Event) error {
+		events = append(events, e.Data)
+		return nil
+	}))
+	require.Len(t, events, 2)
+	assert.Contains(t, events[0], "notifications/progress")
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"method":"stream","path":"/mcp"}}`, events[1])
+
+	// Clients that only accept JSON get just the response
+	rec = post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":2,"method":"stream"}`)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":2,"result":{"method":"stream","path":"/mcp"}}`, rec.Body.String())
+}
+
+func TestHTTPRelaysOtherMessages(t *testing.T) {
+	up := newUpstreamServer(t)
+	h := NewHTTPServer(New(newTestLogger(t)), up.URL)
+
+	rec := post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mcp", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestHTTPMiddlewareErrors(t *testing.T) {
+	up := newUpstreamServer(t)
+	p := New(newTestLogger(t))
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			switch req.Method {
+			case "raw":
+				return nil, &RawResponse{Data: []byte(`{"jsonrpc":`)}
+			case "status":
+				return nil, &upstream.StatusError{StatusCode: 503, Body: "unavailable"}
+			case "fail":
+				return nil, mcp.NewError(mcp.InvalidParams, "nope")
+			}
+			return next(ctx, s, req)
+		}
+	})
+	h := NewHTTPServer(p, up.URL)
+
+	rec := post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":1,"method":"raw"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"jsonrpc":`, rec.Body.String())
+
+	rec = post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":1,"method":"status"}`)
+	assert.Equal(t, 503, rec.Code)
+	assert.Equal(t, "unavailable", rec.Body.String())
+
+	rec = post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":1,"method":"fail"}`)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"nope"}}`, rec.Body.String())
+
+	rec = post(t, h, "/mcp", "application/json", `[{"jsonrpc":"2.0","id":1,"method":"raw"},{"jsonrpc":"2.0","id":2,"method":"fail"}]`)
+	assert.Equal(t, `[{"jsonrpc":,{"jsonrpc":"2.0","id":2,"error":{"code":-32602,"message":"nope"}}]`, rec.Body.String(),
+		"raw responses in a batch are sent in place of their response")
+}
+
+func TestHTTPBatchRunsThroughChain(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		msg, err := mcp.Parse(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp, _ := mcp.NewResult(msg.ID, map[string]string{"method": msg.Method})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(resp.String()))
+	}))
+	t.Cleanup(up.Close)
+	p := New(newTestLogger(t))
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			if strings.Contains(string(req.Params), "DROP TABLE") {
+				return nil, mcp.NewError(mcp.PolicyDenied, "denied")
+			}
+			return next(ctx, s, req)
+		}
+	})
+	h := NewHTTPServer(p, up.URL)
+
+	rec := post(t, h, "/mcp", "application/json", `[`+
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"execute_sql","arguments":{"sql":"DROP TABLE users"}}},`+
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, fmt.Sprintf(`[{"jsonrpc":"2.0","id":1,"error":{"code":%d,"message":"denied"}},`+
+		`{"jsonrpc":"2.0","id":2,"result":{"method":"tools/list"}}]`, mcp.PolicyDenied), rec.Body.String())
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, bodies, 1)
+	assert.NotContains(t, bodies[0], "DROP TABLE")
+
+	rec = post(t, h, "/mcp", "application/json", `[{"jsonrpc":"2.0","id":3,`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Len(t, bodies, 1)
+}
+
+func TestHTTPUpstreamDown(t *testing.T) {
+	up := newUpstreamServer(t)
+	up.Close()
+	h := NewHTTPServer(New(newTestLogger(t)), up.URL)
+
+	rec := post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+func TestHTTPObservers(t *testing.T) {
+	up := newUpstreamServer(t)
+	p := New(newTestLogger(t))
+	var seen []string
+	var mu sync.Mutex
+	p.Observe(func(session string, dir Direction, msg *mcp.Message, batch bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, fmt.Sprintf("%s %s %s", session, dir, describe(msg)))
+	})
+	h := NewHTTPServer(p, up.URL)
+
+	// The session id assigned by the upstream applies to the request that created it
+	post(t, h, "/mcp", "application/json, text/event-stream", `{"jsonrpc":"2.0","id":1,"method":"stream"}`)
+	post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	assert.Equal(t, []string{
+		"abc client->server stream",
+		"abc server->client notifications/progress",
+		"abc server->client response 1",
+		" client->server notifications/initialized",
+	}, seen)
+}
+
+func TestHTTPClientIdentity(t *testing.T) {
+	up := newUpstreamServer(t)
+	p := New(newTestLogger(t))
+	var clients []ClientInfo
+	var principals []string
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			clients = append(clients, s.ClientInfo())
+			principals = append(principals, s.Principal())
+			return next(ctx, s, req)
+		}
+	})
+	h := NewHTTPServer(p, up.URL)
+
+	// The identity given at initialize applies to later requests in the session
+	post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"clientInfo":{"name":"report-bot","version":"1"},"capabilities":{"sampling":{},"elicitation":{},"roots":null}}}`)
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+	req.Header.Set("Mcp-Session-Id", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Requests from other sessions have no identity
+	post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+
+	bot := ClientInfo{Name: "report-bot", Version: "1", Capabilities: []string{"elicitation", "sampling"}}
+	assert.Equal(t, []ClientInfo{bot, bot, {}}, clients)
+	assert.True(t, clients[1].Supports("elicitation"))
+	assert.False(t, clients[1].Supports("roots"), "capabilities declared null are not supported")
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.1", "192.0.2.1"}, principals, "the remote address of httptest requests")
+}
+
+// recordingTargets sends everything to one upstream, or fails picks with err,
+// and records the sessions bound and unbound
+type recordingTargets struct {
+	url     string
+	err     error
+	bound   []string
+	unbound []string
+}
+
+func (r *recordingTargets) Pick(session string) (string, func(), error) {
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return r.url, func() {}, nil
+}
+
+func (r *recordingTargets) Bind(session, target string) {
+	r.bound = append(r.bound, session+" "+target)
+}
+
+func (r *recordingTargets) Unbind(session string) {
+	r.unbound = append(r.unbound, session)
+}
+
+func TestHTTPTargets(t *testing.T) {
+	up := newUpstreamServer(t)
+	targets := &recordingTargets{url: up.URL}
+	h := NewBalancedHTTPServer(New(newTestLogger(t)), targets)
+
+	// Sessions assigned by the upstream are bound to it, then unbound on DELETE
+	post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":1,"method":"initialize"}`)
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+	req.Header.Set("Mcp-Session-Id", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, []string{"abc " + up.URL}, targets.bound, "requests in a session bind nothing")
+
+	req = httptest.NewRequest(http.MethodDelete, "/mcp", nil)
+	req.Header.Set("Mcp-Session-Id", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, []string{"abc"}, targets.unbound)
+
+	// Clients of lost sessions are told to start over; without instances, to come back later
+	targets.err = ErrSessionLost
+	rec := post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	targets.err = ErrNoTarget
+	rec = post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestResponseStreamEnded(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	stream := newResponseStream(rec, req)
+	stream.end()
+
+	stream.copyHeaders(http.Header{"Mcp-Session-Id": {"abc"}})
+	assert.False(t, stream.startEvents(), "a stream whose handler returned cannot start")
+	assert.Error(t, stream.send(&mcp.Message{JSONRPC: "2.0", Method: "notifications/progress"}))
+	assert.Empty(t, rec.Header(), "nothing reaches the response writer")
+	assert.False(t, rec.Flushed)
+}
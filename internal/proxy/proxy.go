@@ -0,0 +1,379 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// Handler produces the response to a client request. Returning a nil response
+// and a nil error sends nothing back to the client.
+type Handler func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error)
+
+// Middleware wraps a Handler to inspect or change requests and responses
+type Middleware func(next Handler) Handler
+
+// RawResponse is returned as an error by middleware that needs to send the
+// client bytes that are not a valid message, such as a truncated response
+type RawResponse struct {
+	Data []byte
+}
+
+// Error implements the error interface
+func (r *RawResponse) Error() string {
+	return fmt.Sprintf("raw response of %d bytes", len(r.Data))
+}
+
+// Direction tells which way a message travels through the proxy
+type Direction int
+
+const (
+	FromClient Direction = iota // Sent by the client
+	ToClient                    // Sent to the client by the upstream or the proxy
+)
+
+// String implements fmt.Stringer
+func (d Direction) String() string {
+	if d == FromClient {
+		return "client->server"
+	}
+	return "server->client"
+}
+
+// Observer is notified of every message exchanged with a client, in the order
+// the proxy handles them. batch reports whether the message was part of a
+// JSON-RPC batch. Observers must not modify the message.
+type Observer func(session string, dir Direction, msg *mcp.Message, batch bool)
+
+// Proxy holds the middleware chain applied to every client request
+type Proxy struct {
+	logger     *logging.Logger
+	middleware []Middleware
+	observers  []Observer
+	policies   int // Position in middleware where With inserts, see MarkPolicies
+}
+
+// New creates a proxy with an empty middleware chain
+func New(logger *logging.Logger) *Proxy {
+	return &Proxy{logger: logger}
+}
+
+// Use appends middleware to the chain. The first middleware added is the
+// outermost: it sees requests first and responses last.
+func (p *Proxy) Use(middleware ...Middleware) {
+	p.middleware = append(p.middleware, middleware...)
+}
+
+// MarkPolicies marks the end of the chain so far as the place for the
+// policies of proxies derived with With. Without a mark they come first.
+func (p *Proxy) MarkPolicies() {
+	p.policies = len(p.middleware)
+}
+
+// With returns a proxy whose chain runs middleware at the mark set with
+// MarkPolicies in the chain of p, sharing the observers of p
+func (p *Proxy) With(middleware ...Middleware) *Proxy {
+	chain := append([]Middleware(nil), p.middleware[:p.policies]...)
+	chain = append(chain, middleware...)
+	chain = append(chain, p.middleware[p.policies:]...)
+	return &Proxy{
+		logger:     p.logger,
+		middleware: chain,
+		observers:  p.observers,
+	}
+}
+
+// Observe registers an observer for all client traffic
+func (p *Proxy) Observe(observer Observer) {
+	p.observers = append(p.observers, observer)
+}
+
+func (p *Proxy) observing() bool {
+	return len(p.observers) > 0
+}
+
+func (p *Proxy) observe(session string, dir Direction, msg *mcp.Message, batch bool) {
+	for _, o := range p.observers {
+		o(session, dir, msg, batch)
+	}
+}
+
+// Chain wraps terminal, which delivers requests upstream, in the middleware chain
+func (p *Proxy) Chain(terminal Handler) Handler {
+	h := terminal
+	for i := len(p.middleware) - 1; i >= 0; i-- {
+		h = p.middleware[i](h)
+	}
+	return h
+}
+
+// Forward is the terminal handler for frontends whose session holds an upstream
+func Forward(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+	if s.Upstream == nil {
+		return nil, mcp.NewError(mcp.InternalError, "no upstream available")
+	}
+	return s.Upstream.Call(ctx, req)
+}
+
+// ClientInfo identifies a client by the clientInfo it sent when initializing,
+// along with the capabilities it declared
+type ClientInfo struct {
+	Name         string
+	Version      string
+	Capabilities []string // Names of the declared capabilities, e.g. "elicitation"
+}
+
+// Supports reports whether the client declared a capability
+func (c ClientInfo) Supports(capability string) bool {
+	for _, name := range c.Capabilities {
+		if name == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Session holds the state of one client connection
+type Session struct {
+	ID       string
+	Upstream upstream.Upstream // Nil when requests are relayed per call (HTTP)
+
+	mu              sync.Mutex
+	client          ClientInfo
+	protocolVersion string
+	upstreamVersion string
+	principal       string
+	values          map[interface{}]interface{}
+}
+
+// NewSession creates a session
+func NewSession(id string, up upstream.Upstream) *Session {
+	return &Session{ID: id, Upstream: up, values: make(map[interface{}]interface{})}
+}
+
+// Client returns the client's identity, the name it gave in clientInfo when
+// initializing, or "" before the session is initialized
+func (s *Session) Client() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.Name
+}
+
+// ClientInfo returns the client's name and version
+func (s *Session) ClientInfo() ClientInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+// SetClient records the client's identity
+func (s *Session) SetClient(info ClientInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client = info
+}
+
+// ProtocolVersion returns the protocol version negotiated when the session
+// was initialized, or "" before then
+func (s *Session) ProtocolVersion() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.protocolVersion
+}
+
+// SetProtocolVersion records the negotiated protocol version
+func (s *Session) SetProtocolVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocolVersion = version
+}
+
+// UpstreamVersion returns the protocol version the upstream negotiated, when
+// it differs from the client's and the traffic is bridged between the two, or
+// "" when the client and the upstream speak the same version
+func (s *Session) UpstreamVersion() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.upstreamVersion
+}
+
+// SetUpstreamVersion records the protocol version the upstream negotiated,
+// when it differs from the client's
+func (s *Session) SetUpstreamVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upstreamVersion = version
+}
+
+// Principal returns who is behind the connection: the local user running the
+// proxy for stdio, the remote address for HTTP
+func (s *Session) Principal() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.principal
+}
+
+// SetPrincipal records who is behind the connection
+func (s *Session) SetPrincipal(principal string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.principal = principal
+}
+
+// Value returns session state stored by middleware under key
+func (s *Session) Value(key interface{}) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// SetValue stores session state for middleware under key
+func (s *Session) SetValue(key, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// Sender delivers a message to the client outside of the request/response flow
+type Sender func(msg *mcp.Message) error
+
+type senderKey struct{}
+
+// WithSender returns a context carrying the sender for the client that made the request
+func WithSender(ctx context.Context, send Sender) context.Context {
+	return context.WithValue(ctx, senderKey{}, send)
+}
+
+// Notify sends a message, typically a notification, to the client whose request
+// ctx belongs to. In HTTP mode this is only possible while the request is open.
+func Notify(ctx context.Context, msg *mcp.Message) error {
+	send, ok := ctx.Value(senderKey{}).(Sender)
+	if !ok {
+		return errors.New("no client connection to notify")
+	}
+	return send(msg)
+}
+
+// Notes collects facts that middleware records about a request, such as a
+// limit it applied, for middleware further out to report
+type Notes struct {
+	mu    sync.Mutex
+	notes map[string]interface{}
+}
+
+type notesKey struct{}
+
+// WithNotes returns a context that collects the notes of a request
+func WithNotes(ctx context.Context) (context.Context, *Notes) {
+	n := &Notes{notes: make(map[string]interface{})}
+	return context.WithValue(ctx, notesKey{}, n), n
+}
+
+// Note records a fact about the request ctx belongs to. It does nothing if no
+// middleware collects notes.
+func Note(ctx context.Context, key string, value interface{}) {
+	n, ok := ctx.Value(notesKey{}).(*Notes)
+	if !ok {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notes[key] = value
+}
+
+// All returns the notes recorded so far, or nil if there are none
+func (n *Notes) All() map[string]interface{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.notes) == 0 {
+		return nil
+	}
+	all := make(map[string]interface{}, len(n.notes))
+	for k, v := range n.notes {
+		all[k] = v
+	}
+	return all
+}
+
+// clientInfo returns the clientInfo and capabilities of an initialize
+// request, or a zero value
+func clientInfo(req *mcp.Message) ClientInfo {
+	if req.Method != "initialize" || len(req.Params) == 0 {
+		return ClientInfo{}
+	}
+	var params struct {
+		ClientInfo struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	json.Unmarshal(req.Params, &params)
+	info := ClientInfo{Name: params.ClientInfo.Name, Version: params.ClientInfo.Version}
+	for name, value := range params.Capabilities {
+		if string(value) != "null" {
+			info.Capabilities = append(info.Capabilities, name)
+		}
+	}
+	sort.Strings(info.Capabilities)
+	return info
+}
+
+// negotiatedVersion returns the protocol version of an initialize result, or
+// failing that the version the client asked for
+func negotiatedVersion(req, resp *mcp.Message) string {
+	var version struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if resp != nil && resp.Error == nil {
+		json.Unmarshal(resp.Result, &version)
+	}
+	if version.ProtocolVersion == "" {
+		json.Unmarshal(req.Params, &version)
+	}
+	return version.ProtocolVersion
+}
+
+// joinBatch encodes the answers to a batch, in order, observing each response
+// with observe. Raw bytes middleware answered with take the place of their
+// response, as they do for single requests. It returns nil when nothing was
+// answered.
+func joinBatch(logger *logging.Logger, responses []*mcp.Message, raws [][]byte, observe func(*mcp.Message)) []byte {
+	var answered [][]byte
+	for i, resp := range responses {
+		switch {
+		case raws[i] != nil:
+			answered = append(answered, raws[i])
+		case resp != nil:
+			data, err := resp.Marshal()
+			if err != nil {
+				logger.Errorf("Failed to encode batch response: %v", err)
+				continue
+			}
+			answered = append(answered, data)
+			observe(resp)
+		}
+	}
+	if len(answered) == 0 {
+		return nil
+	}
+	data := append([]byte{'['}, bytes.Join(answered, []byte{','})...)
+	return append(data, ']')
+}
+
+// errorResponse converts an error returned by the chain into a JSON-RPC error response
+func errorResponse(req *mcp.Message, err error) *mcp.Message {
+	var rpcErr *mcp.Error
+	if errors.As(err, &rpcErr) {
+		return mcp.NewErrorResponse(req.ID, rpcErr)
+	}
+	return mcp.NewErrorResponse(req.ID, mcp.NewError(mcp.InternalError, "%v", err))
+}
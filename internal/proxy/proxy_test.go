@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// fakeUpstream answers requests through respond, echoing the method by default
+type fakeUpstream struct {
+	mu      sync.Mutex
+	sent    []*mcp.Message
+	respond func(ctx context.Context, req *mcp.Message) (*mcp.Message, error)
+}
+
+func (f *fakeUpstream) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	if f.respond != nil {
+		return f.respond(ctx, req)
+	}
+	return mcp.NewResult(req.ID, map[string]string{"method": req.Method})
+}
+
+func (f *fakeUpstream) Send(ctx context.Context, msg *mcp.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeUpstream) Close() error { return nil }
+
+func (f *fakeUpstream) sentMessages() []*mcp.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*mcp.Message(nil), f.sent...)
+}
+
+func request(t *testing.T, id, method string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage(id), method, nil)
+	require.NoError(t, err)
+	return req
+}
+
+func TestChainOrder(t *testing.T) {
+	p := New(newTestLogger(t))
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+				order = append(order, name+" in")
+				resp, err := next(ctx, s, req)
+				order = append(order, name+" out")
+				return resp, err
+			}
+		}
+	}
+	p.Use(tag("a"), tag("b"))
+
+	_, err := p.Chain(Forward)(context.Background(), NewSession("s", &fakeUpstream{}), request(t, "1", "ping"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a in", "b in", "b out", "a out"}, order)
+
+	// Derived proxies run their own middleware first, leaving p as it is
+	order = nil
+	_, err = p.With(tag("c")).Chain(Forward)(context.Background(), NewSession("s", &fakeUpstream{}), request(t, "2", "ping"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c in", "a in", "b in", "b out", "a out", "c out"}, order)
+	assert.Len(t, p.middleware, 2)
+}
+
+func TestForwardWithoutUpstream(t *testing.T) {
+	_, err := Forward(context.Background(), NewSession("s", nil), request(t, "1", "ping"))
+	var rpcErr *mcp.Error
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, mcp.InternalError, rpcErr.Code)
+}
+
+func TestSessionValues(t *testing.T) {
+	s := NewSession("s", nil)
+	assert.Nil(t, s.Value("k"))
+	s.SetValue("k", 1)
+	assert.Equal(t, 1, s.Value("k"))
+}
+
+func TestNotify(t *testing.T) {
+	assert.Error(t, Notify(context.Background(), &mcp.Message{}))
+
+	var got *mcp.Message
+	ctx := WithSender(context.Background(), func(msg *mcp.Message) error {
+		got = msg
+		return nil
+	})
+	msg := &mcp.Message{JSONRPC: "2.0", Method: "notifications/progress"}
+	require.NoError(t, Notify(ctx, msg))
+	assert.Same(t, msg, got)
+}
+
+func TestNotes(t *testing.T) {
+	// Without a collector notes are dropped
+	Note(context.Background(), "k", 1)
+
+	ctx, notes := WithNotes(context.Background())
+	assert.Nil(t, notes.All())
+	Note(ctx, "limit", "max-rows")
+	Note(ctx, "masked", []string{"ssn"})
+	assert.Equal(t, map[string]interface{}{"limit": "max-rows", "masked": []string{"ssn"}}, notes.All())
+}
+
+func TestErrorResponse(t *testing.T) {
+	req := request(t, "7", "ping")
+
+	resp := errorResponse(req, mcp.NewError(mcp.InvalidParams, "bad"))
+	assert.Equal(t, mcp.InvalidParams, resp.Error.Code)
+	assert.Equal(t, "7", string(resp.ID))
+
+	resp = errorResponse(req, errors.New("boom"))
+	assert.Equal(t, mcp.InternalError, resp.Error.Code)
+	assert.Equal(t, "boom", resp.Error.Message)
+}
@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+)
+
+// maxExpiryInterval bounds the time between checks for idle sessions
+const maxExpiryInterval = 30 * time.Second
+
+// SessionOptions control how an HTTPServer keeps the sessions it relays
+type SessionOptions struct {
+	IdleTimeout time.Duration // Sessions without traffic for this long are ended; 0 keeps them until deleted
+	LogDir      string        // Directory for a log file per session; empty logs every session to the main log
+}
+
+// SessionInfo is what an HTTPServer knows of a session it relays
+type SessionInfo struct {
+	ID              string
+	Client          ClientInfo // Including the capabilities the client declared
+	ProtocolVersion string     // As negotiated by initialize
+	UpstreamVersion string     // As the upstream negotiated it, when bridged to a different ProtocolVersion; "" otherwise
+	Started         time.Time
+	LastSeen        time.Time
+	LogFile         string // "" when the session logs to the main log
+}
+
+// relaySession is a session an HTTPServer saw initialized
+type relaySession struct {
+	info   SessionInfo
+	path   string      // Where the client initialized, for ending the session upstream
+	header http.Header // Of the client's latest request, for ending the session upstream
+	logger *logging.Logger
+	active int // Exchanges in progress, including open GET streams
+}
+
+// TrackSessions sets how sessions are kept and, with an idle timeout, ends
+// idle sessions until Close
+func (h *HTTPServer) TrackSessions(opts SessionOptions) {
+	h.mu.Lock()
+	h.sessionOptions = opts
+	h.mu.Unlock()
+	if opts.IdleTimeout <= 0 {
+		return
+	}
+	interval := maxExpiryInterval
+	if opts.IdleTimeout < interval {
+		interval = opts.IdleTimeout
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.Expire()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops ending idle sessions and closes the session logs. Sessions are
+// left to the upstream.
+func (h *HTTPServer) Close() error {
+	h.mu.Lock()
+	select {
+	case <-h.stop:
+	default:
+		close(h.stop)
+	}
+	sessions := h.sessions
+	h.sessions = make(map[string]*relaySession)
+	h.mu.Unlock()
+
+	h.wg.Wait()
+	for _, s := range sessions {
+		if s.logger != nil {
+			s.logger.Close()
+		}
+	}
+	return nil
+}
+
+// Sessions returns the sessions being relayed, oldest first
+func (h *HTTPServer) Sessions() []SessionInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sessions := make([]SessionInfo, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s.info)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+	return sessions
+}
+
+// Expire ends the sessions that have been idle for longer than the idle
+// timeout, deleting them upstream as a client would
+func (h *HTTPServer) Expire() {
+	h.mu.Lock()
+	timeout := h.sessionOptions.IdleTimeout
+	var expired []*relaySession
+	if timeout > 0 {
+		for id, s := range h.sessions {
+			if s.active == 0 && time.Since(s.info.LastSeen) >= timeout {
+				expired = append(expired, s)
+				delete(h.sessions, id)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, s := range expired {
+		h.terminate(s)
+		h.forgetEvents(s.info.ID)
+		h.closeSession(s, fmt.Sprintf("expired after %s without traffic", timeout))
+	}
+}
+
+// track starts keeping a session the upstream assigned in response to an
+// initialize
+func (h *HTTPServer) track(r *http.Request, id string, info ClientInfo, version, upstream string) {
+	now := time.Now()
+	s := &relaySession{
+		info:   SessionInfo{ID: id, Client: info, ProtocolVersion: version, UpstreamVersion: upstream, Started: now, LastSeen: now},
+		path:   r.URL.RequestURI(),
+		header: r.Header.Clone(),
+	}
+
+	h.mu.Lock()
+	dir := h.sessionOptions.LogDir
+	h.mu.Unlock()
+	if dir != "" {
+		path := filepath.Join(dir, "mcp_sqlpp_proxy_session_"+fileSafe(id)+".log")
+		logger, err := h.logger.Derive(&logging.LogConfig{FilePath: path})
+		if err != nil {
+			h.logger.Errorf("Failed to open log of session %s: %v", id, err)
+		} else {
+			s.logger = logger
+			s.info.LogFile = path
+		}
+	}
+
+	h.mu.Lock()
+	previous := h.sessions[id]
+	h.sessions[id] = s
+	h.mu.Unlock()
+	if previous != nil && previous.logger != nil {
+		previous.logger.Close()
+	}
+
+	h.logger.Infof("Session %s started by client '%s' %s (protocol %s, capabilities %v)%s",
+		id, info.Name, info.Version, version, info.Capabilities, describeLogFile(s.info.LogFile))
+	if s.logger != nil {
+		s.logger.Infof("Session %s started by client '%s' %s from %s (protocol %s, capabilities %v)",
+			id, info.Name, info.Version, requestPrincipal(r), version, info.Capabilities)
+	}
+}
+
+// enter marks a session active for an exchange and returns the logger for the
+// exchange's traffic, along with a function to call once the exchange is
+// over. Sessions that are not tracked log to the main log.
+func (h *HTTPServer) enter(r *http.Request) (*logging.Logger, func()) {
+	id := r.Header.Get("Mcp-Session-Id")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.sessions[id]
+	if s == nil {
+		return h.logger, func() {}
+	}
+	s.active++
+	s.info.LastSeen = time.Now()
+	s.header = r.Header.Clone()
+	logger := h.logger
+	if s.logger != nil {
+		logger = s.logger
+	}
+	return logger, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		s.active--
+		s.info.LastSeen = time.Now()
+	}
+}
+
+// session returns what is known of a tracked session
+func (h *HTTPServer) session(id string) (SessionInfo, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[id]
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return s.info, true
+}
+
+// forget stops tracking a session the client deleted
+func (h *HTTPServer) forget(id string) {
+	h.mu.Lock()
+	s := h.sessions[id]
+	delete(h.sessions, id)
+	h.mu.Unlock()
+	h.targets.Unbind(id)
+	h.forgetEvents(id)
+	if s != nil {
+		h.closeSession(s, "ended by the client")
+	}
+}
+
+// terminate deletes an expired session upstream with the client's headers, so
+// that the upstream releases it and answers 404 if the client comes back
+func (h *HTTPServer) terminate(s *relaySession) {
+	defer h.targets.Unbind(s.info.ID)
+	target, release, err := h.targets.Pick(s.info.ID)
+	if err != nil {
+		h.logger.Debugf("Not deleting expired session %s upstream: %v", s.info.ID, err)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, target+s.path, nil)
+	if err != nil {
+		h.logger.Errorf("Failed to delete expired session %s upstream: %v", s.info.ID, err)
+		return
+	}
+	req.Header = s.header.Clone()
+	req.Header.Del("Content-Type")
+	req.Header.Del("Content-Length")
+	req.Header.Set("Mcp-Session-Id", s.info.ID)
+	upstreamVersion(req.Header, s.info.UpstreamVersion)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.logger.Errorf("Failed to delete expired session %s upstream: %v", s.info.ID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// closeSession logs the end of a session and closes its log
+func (h *HTTPServer) closeSession(s *relaySession, why string) {
+	duration := time.Since(s.info.Started).Round(time.Second)
+	h.logger.Infof("Session %s %s after %s", s.info.ID, why, duration)
+	if s.logger != nil {
+		s.logger.Infof("Session %s %s after %s", s.info.ID, why, duration)
+		s.logger.Close()
+	}
+}
+
+// fileSafe turns a session id, which the upstream chooses, into a file name
+// part that cannot leave the log directory
+func fileSafe(id string) string {
+	safe := strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			return c
+		}
+		return '_'
+	}, id)
+	if len(safe) > 64 {
+		safe = safe[:64]
+	}
+	return safe
+}
+
+func describeLogFile(path string) string {
+	if path == "" {
+		return ""
+	}
+	return ", logging to " + path
+}
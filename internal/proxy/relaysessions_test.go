@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const initializeWithVersion = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{"elicitation":{}},"clientInfo":{"name":"report-bot","version":"1"}}}`
+
+// deletions records the DELETE requests an upstream receives, answering
+// everything else like newUpstreamServer
+type deletions struct {
+	mu      sync.Mutex
+	headers []http.Header
+}
+
+func (d *deletions) server(t *testing.T) *httptest.Server {
+	up := newUpstreamServer(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			d.mu.Lock()
+			d.headers = append(d.headers, r.Header.Clone())
+			d.mu.Unlock()
+			return
+		}
+		up.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (d *deletions) recorded() []http.Header {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]http.Header(nil), d.headers...)
+}
+
+func inSession(t *testing.T, h http.Handler, method, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/mcp", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Mcp-Session-Id", "abc")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPSessionTracking(t *testing.T) {
+	d := &deletions{}
+	p := New(newTestLogger(t))
+	var versions []string
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			versions = append(versions, s.ProtocolVersion())
+			return next(ctx, s, req)
+		}
+	})
+	h := NewHTTPServer(p, d.server(t).URL)
+	defer h.Close()
+
+	post(t, h, "/mcp", "application/json", initializeWithVersion)
+	sessions := h.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "abc", sessions[0].ID)
+	assert.Equal(t, ClientInfo{Name: "report-bot", Version: "1", Capabilities: []string{"elicitation"}}, sessions[0].Client)
+	assert.Equal(t, "2025-06-18", sessions[0].ProtocolVersion, "the version asked for, as the upstream did not say")
+	assert.WithinDuration(t, time.Now(), sessions[0].Started, time.Minute)
+	assert.Empty(t, sessions[0].LogFile)
+
+	inSession(t, h, http.MethodPost, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	assert.Equal(t, []string{"", "2025-06-18"}, versions, "the version is negotiated by the initialize")
+
+	rec := inSession(t, h, http.MethodDelete, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, h.Sessions())
+	assert.Len(t, d.recorded(), 1, "the client's DELETE is relayed")
+}
+
+func TestHTTPSessionLogs(t *testing.T) {
+	logger := newTestLogger(t)
+	h := NewHTTPServer(New(logger), newUpstreamServer(t).URL)
+	defer h.Close()
+	dir := t.TempDir()
+	h.TrackSessions(SessionOptions{LogDir: dir})
+
+	post(t, h, "/mcp", "application/json", initializeWithVersion)
+	inSession(t, h, http.MethodPost, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	sessions := h.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, dir+"/mcp_sqlpp_proxy_session_abc.log", sessions[0].LogFile)
+	inSession(t, h, http.MethodDelete, "")
+
+	session, err := os.ReadFile(sessions[0].LogFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(session), "Session abc started by client 'report-bot' 1 from 192.0.2.1 (protocol 2025-06-18, capabilities [elicitation])")
+	assert.Contains(t, string(session), `"method":"tools/list"`)
+	assert.Contains(t, string(session), "Session abc ended by the client after")
+
+	main, err := os.ReadFile(logger.GetFilePath())
+	require.NoError(t, err)
+	assert.Contains(t, string(main), `"method":"initialize"`, "the initialize comes before the session")
+	assert.NotContains(t, string(main), `"method":"tools/list"`)
+	assert.Contains(t, string(main), "Session abc started by client 'report-bot' 1 (protocol 2025-06-18, capabilities [elicitation]), logging to "+sessions[0].LogFile)
+}
+
+func TestHTTPSessionExpiry(t *testing.T) {
+	d := &deletions{}
+	h := NewHTTPServer(New(newTestLogger(t)), d.server(t).URL)
+	defer h.Close()
+	h.TrackSessions(SessionOptions{IdleTimeout: time.Minute})
+
+	post(t, h, "/mcp", "application/json", initializeWithVersion)
+	inSession(t, h, http.MethodPost, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	h.Expire()
+	assert.Len(t, h.Sessions(), 1, "sessions with recent traffic are kept")
+
+	h.mu.Lock()
+	h.sessions["abc"].info.LastSeen = time.Now().Add(-time.Hour)
+	h.sessions["abc"].active++
+	h.mu.Unlock()
+	h.Expire()
+	assert.Len(t, h.Sessions(), 1, "sessions with an exchange in progress are kept")
+
+	h.mu.Lock()
+	h.sessions["abc"].active--
+	h.mu.Unlock()
+	h.Expire()
+	assert.Empty(t, h.Sessions())
+	deleted := d.recorded()
+	require.Len(t, deleted, 1, "expired sessions are deleted upstream")
+	assert.Equal(t, "abc", deleted[0].Get("Mcp-Session-Id"))
+	assert.Equal(t, "Bearer secret", deleted[0].Get("Authorization"), "with the headers of the client's latest request")
+}
+
+func TestHTTPSessionExpiryByDefault(t *testing.T) {
+	d := &deletions{}
+	h := NewHTTPServer(New(newTestLogger(t)), d.server(t).URL)
+	defer h.Close()
+	sessions := config.DefaultConfig().Sessions
+	h.TrackSessions(SessionOptions{IdleTimeout: sessions.IdleTimeout, LogDir: sessions.LogDir})
+
+	post(t, h, "/mcp", "application/json", initializeWithVersion)
+	h.Expire()
+	require.Len(t, h.Sessions(), 1)
+
+	h.mu.Lock()
+	h.sessions["abc"].info.LastSeen = time.Now().Add(-sessions.IdleTimeout)
+	h.mu.Unlock()
+	h.Expire()
+	assert.Empty(t, h.Sessions(), "abandoned sessions are forgotten without any sessions settings")
+	assert.Len(t, d.recorded(), 1)
+}
+
+func TestHTTPBridgedSession(t *testing.T) {
+	// The upstream takes no batches, as on protocol version 2025-06-18
+	up := newUpstreamServer(t)
+	var mu sync.Mutex
+	var versions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		versions = append(versions, r.Header.Get("Mcp-Protocol-Version"))
+		mu.Unlock()
+		up.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	p := New(newTestLogger(t))
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method == "initialize" {
+				s.SetUpstreamVersion("2025-06-18")
+			}
+			return next(ctx, s, req)
+		}
+	})
+	h := NewHTTPServer(p, srv.URL)
+	defer h.Close()
+
+	post(t, h, "/mcp", "application/json", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","clientInfo":{"name":"old-bot"}}}`)
+	sessions := h.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "2025-03-26", sessions[0].ProtocolVersion)
+	assert.Equal(t, "2025-06-18", sessions[0].UpstreamVersion)
+
+	rec := inSession(t, h, http.MethodPost, `[{"jsonrpc":"2.0","id":2,"method":"tools/list"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":3,"method":"stream"}]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var responses []*mcp.Message
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &responses))
+	require.Len(t, responses, 2, "the batch is split for the upstream and answered as one")
+	assert.ElementsMatch(t, []string{"2", "3"}, []string{responses[0].IDKey(), responses[1].IDKey()})
+
+	rec = inSession(t, h, http.MethodPost, `[{"jsonrpc":"2.0","method":"notifications/initialized"}]`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, versions, 5)
+	assert.Equal(t, []string{"2025-06-18", "2025-06-18", "2025-06-18", "2025-06-18"}, versions[1:], "the upstream is told its own version")
+}
+
+func TestFileSafe(t *testing.T) {
+	assert.Equal(t, "abc-123_X", fileSafe("abc-123_X"))
+	assert.Equal(t, "______etc_passwd", fileSafe("../../etc/passwd"))
+	assert.Len(t, fileSafe(strings.Repeat("a", 100)), 64)
+}
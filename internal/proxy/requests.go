@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// Requester sends a request of the proxy's own to a client and returns the
+// client's response
+type Requester func(ctx context.Context, method string, params interface{}) (*mcp.Message, error)
+
+type requesterKey struct{}
+
+// WithRequester returns a context carrying the requester for the client that
+// made the request
+func WithRequester(ctx context.Context, request Requester) context.Context {
+	return context.WithValue(ctx, requesterKey{}, request)
+}
+
+// Request sends a request of the proxy's own, such as elicitation/create, to
+// the client whose request ctx belongs to and waits for the client's response.
+// In HTTP mode this is only possible while the request is open, and not for
+// requests in a batch.
+func Request(ctx context.Context, method string, params interface{}) (*mcp.Message, error) {
+	request, ok := ctx.Value(requesterKey{}).(Requester)
+	if !ok {
+		return nil, errors.New("no client connection to send requests to")
+	}
+	return request(ctx, method, params)
+}
+
+// clientRequests allocates the ids of requests the proxy sends to clients and
+// matches the clients' responses to them. Ids are strings with a random
+// prefix, so that they do not collide with the ids of requests the upstream
+// sends to the same client.
+type clientRequests struct {
+	prefix string
+
+	mu      sync.Mutex
+	next    int64
+	waiting map[string]chan *mcp.Message // By id key
+}
+
+func newClientRequests() *clientRequests {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return &clientRequests{
+		prefix:  "mcp_sqlpp_proxy-" + hex.EncodeToString(b) + "-",
+		waiting: make(map[string]chan *mcp.Message),
+	}
+}
+
+// requester returns a Requester that sends requests through send
+func (c *clientRequests) requester(send Sender) Requester {
+	return func(ctx context.Context, method string, params interface{}) (*mcp.Message, error) {
+		c.mu.Lock()
+		c.next++
+		id, _ := json.Marshal(fmt.Sprintf("%s%d", c.prefix, c.next))
+		key := mcp.IDKey(id)
+		answered := make(chan *mcp.Message, 1)
+		c.waiting[key] = answered
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			delete(c.waiting, key)
+			c.mu.Unlock()
+		}()
+
+		req, err := mcp.NewRequest(id, method, params)
+		if err != nil {
+			return nil, err
+		}
+		if err := send(req); err != nil {
+			return nil, err
+		}
+		select {
+		case resp := <-answered:
+			return resp, nil
+		case <-ctx.Done():
+			// Tell the client to stop asking; it may be gone already
+			if cancelled, err := mcp.NewNotification("notifications/cancelled", map[string]interface{}{
+				"requestId": json.RawMessage(id),
+				"reason":    ctx.Err().Error(),
+			}); err == nil {
+				send(cancelled)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// resolve hands a client response to the request of the proxy it answers. It
+// reports whether the response belongs to the proxy, which it does even when
+// it comes too late to be waited for; it must not reach the upstream either way.
+func (c *clientRequests) resolve(msg *mcp.Message) bool {
+	if !msg.IsResponse() {
+		return false
+	}
+	var id string
+	if json.Unmarshal(msg.ID, &id) != nil || !strings.HasPrefix(id, c.prefix) {
+		return false
+	}
+	c.mu.Lock()
+	answered, ok := c.waiting[msg.IDKey()]
+	delete(c.waiting, msg.IDKey())
+	c.mu.Unlock()
+	if ok {
+		answered <- msg
+	}
+	return true
+}
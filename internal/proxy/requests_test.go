@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// asking is middleware that asks the client before answering with the
+// client's result
+func asking(next Handler) Handler {
+	return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+		resp, err := Request(ctx, "elicitation/create", map[string]string{"message": "Sure?"})
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewResult(req.ID, resp.Result)
+	}
+}
+
+func TestRequestWithoutClient(t *testing.T) {
+	_, err := Request(context.Background(), "elicitation/create", nil)
+	assert.EqualError(t, err, "no client connection to send requests to")
+}
+
+func TestClientRequests(t *testing.T) {
+	c := newClientRequests()
+	var sent []*mcp.Message
+	request := c.requester(func(msg *mcp.Message) error {
+		sent = append(sent, msg)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := request(ctx, "elicitation/create", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, sent, 2)
+	assert.Equal(t, "notifications/cancelled", sent[1].Method, "the client is told to stop asking")
+
+	late := &mcp.Message{JSONRPC: "2.0", ID: sent[0].ID, Result: json.RawMessage(`{}`)}
+	assert.True(t, c.resolve(late), "late responses are claimed, not relayed")
+	assert.False(t, c.resolve(&mcp.Message{JSONRPC: "2.0", ID: json.RawMessage(`"1"`), Result: json.RawMessage(`{}`)}))
+	assert.False(t, c.resolve(&mcp.Message{JSONRPC: "2.0", ID: json.RawMessage(`1`), Result: json.RawMessage(`{}`)}))
+	assert.NotEqual(t, newClientRequests().prefix, c.prefix)
+}
+
+func TestStdioRequest(t *testing.T) {
+	p := New(newTestLogger(t))
+	p.Use(asking)
+	up := &fakeUpstream{}
+	in, input := io.Pipe()
+	var out syncBuffer
+	server := NewStdioServer(p, &out)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(in, NewSession("stdio", up), nil) }()
+
+	io.WriteString(input, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`+"\n")
+	require.Eventually(t, func() bool { return out.lines()[0] != "" }, 5*time.Second, time.Millisecond)
+	asked, err := mcp.Parse([]byte(out.lines()[0]))
+	require.NoError(t, err)
+	assert.Equal(t, "elicitation/create", asked.Method)
+	assert.JSONEq(t, `{"message":"Sure?"}`, string(asked.Params))
+
+	// A response to a request of the upstream goes to the upstream
+	io.WriteString(input, `{"jsonrpc":"2.0","id":1,"result":{}}`+"\n")
+	io.WriteString(input, `{"jsonrpc":"2.0","id":`+string(asked.ID)+`,"result":{"action":"accept"}}`+"\n")
+	input.Close()
+	require.NoError(t, <-served)
+
+	lines := out.lines()
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"action":"accept"}}`, lines[1])
+	sent := up.sentMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "1", string(sent[0].ID))
+}
+
+// answerRequest posts a request to an HTTP frontend and answers the
+// elicitation it receives on the response stream with result, returning the
+// final response
+func answerRequest(t *testing.T, url, session, result string) *mcp.Message {
+	post := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		req.Header.Set("Mcp-Session-Id", session)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := post(`{"jsonrpc":"2.0","id":7,"method":"tools/call"}`)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	var final *mcp.Message
+	require.NoError(t, mcp.ReadEvents(bufio.NewReader(resp.Body), func(event *mcp.Event) error {
+		msg, err := mcp.Parse([]byte(event.Data))
+		require.NoError(t, err)
+		if msg.Method == "elicitation/create" {
+			answer := post(`{"jsonrpc":"2.0","id":` + string(msg.ID) + `,"result":` + result + `}`)
+			answer.Body.Close()
+			assert.Equal(t, http.StatusAccepted, answer.StatusCode)
+			return nil
+		}
+		final = msg
+		return nil
+	}))
+	require.NotNil(t, final)
+	return final
+}
+
+func TestHTTPRequest(t *testing.T) {
+	p := New(newTestLogger(t))
+	p.Use(asking)
+	srv := httptest.NewServer(NewHTTPServer(p, newUpstreamServer(t).URL))
+	defer srv.Close()
+
+	final := answerRequest(t, srv.URL+"/mcp", "abc", `{"action":"decline"}`)
+	assert.Equal(t, "7", string(final.ID))
+	assert.JSONEq(t, `{"action":"decline"}`, string(final.Result))
+}
+
+func TestSessionRequest(t *testing.T) {
+	ups := &sessionUpstreams{}
+	p := New(newTestLogger(t))
+	h := NewSessionServer(p, ups.connect)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	session := sessionRequest(t, h, http.MethodPost, "", initializeBody).Header().Get("Mcp-Session-Id")
+	p.Use(asking)
+
+	final := answerRequest(t, srv.URL+"/mcp", session, `{"action":"accept","content":{"confirm":true}}`)
+	assert.JSONEq(t, `{"action":"accept","content":{"confirm":true}}`, string(final.Result))
+	assert.Empty(t, ups.upstreams[0].sentMessages(), "the client's response does not reach the upstream")
+}
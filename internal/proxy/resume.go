@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"gosqlpp-mcp-proxy/internal/eventstore"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// ResumeStreams makes the server give the events it streams to clients ids
+// from store and keep them there, so that a client reconnecting with
+// Last-Event-ID is sent the events it missed, whether or not the upstream
+// supports resumption. Call it before serving.
+func (h *HTTPServer) ResumeStreams(store *eventstore.Store) {
+	h.events = store
+}
+
+// ResumeStreams makes the server give the events it streams to clients ids
+// from store and keep them there, so that a client reconnecting with
+// Last-Event-ID is sent the events it missed. Call it before serving.
+func (h *SessionServer) ResumeStreams(store *eventstore.Store) {
+	h.events = store
+}
+
+// forgetEvents drops the events kept for a session that ended
+func (h *HTTPServer) forgetEvents(session string) {
+	if h.events != nil {
+		h.events.Forget(session)
+	}
+}
+
+// keep gives the events of the stream ids from store and keeps them there. It
+// returns false when the stream is not kept: without a store, or before the
+// client has a session to come back to.
+func (s *responseStream) keep(store *eventstore.Store, session string, logger *logging.Logger) bool {
+	if store == nil || session == "" {
+		return false
+	}
+	log, err := store.Open(session)
+	if err != nil {
+		logger.Errorf("Failed to keep events of session %s: %v", session, err)
+		return false
+	}
+	s.log = log
+	return true
+}
+
+// resume serves a GET of a client reconnecting with Last-Event-ID: the events
+// it missed are replayed, followed by any added to their stream until the
+// stream ends. When there is nothing to resume, resume returns the request to
+// serve as a new stream, without the header if the id was the proxy's, as
+// the upstream cannot know it. It returns nil once the request is served.
+func resume(w http.ResponseWriter, r *http.Request, logger *logging.Logger, store *eventstore.Store) *http.Request {
+	last := r.Header.Get("Last-Event-ID")
+	if store == nil || last == "" {
+		return r
+	}
+	log, seq, err := store.Find(r.Header.Get("Mcp-Session-Id"), last)
+	if errors.Is(err, eventstore.ErrForeignID) {
+		return r
+	}
+	if err != nil || !log.Pending(seq) {
+		if err != nil {
+			logger.Errorf("Cannot replay the events after %s: %v", last, err)
+		}
+		fresh := r.Clone(r.Context())
+		fresh.Header.Del("Last-Event-ID")
+		return fresh
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	var logged bytes.Buffer
+	err = log.Follow(r.Context(), seq, func(event eventstore.Event) error {
+		var buf bytes.Buffer
+		if err := mcp.WriteEvent(&buf, &mcp.Event{ID: event.ID, Event: "message", Data: event.Data}); err != nil {
+			return err
+		}
+		logged.Write(buf.Bytes())
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && r.Context().Err() == nil {
+		logger.Errorf("Stopped replaying the events after %s: %v", last, err)
+	}
+	logger.HTTPOut(http.StatusOK, logged.String())
+	return nil
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/eventstore"
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEventStore(t *testing.T) *eventstore.Store {
+	store := eventstore.New(config.ResumptionConfig{MaxBytes: 1 << 20, Retention: time.Minute}, newTestLogger(t))
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// readEvents parses a recorded event stream
+func readEvents(t *testing.T, body string) []*mcp.Event {
+	var events []*mcp.Event
+	require.NoError(t, mcp.ReadEvents(strings.NewReader(body), func(e *mcp.Event) error {
+		events = append(events, e)
+		return nil
+	}))
+	return events
+}
+
+func resumeGet(t *testing.T, h http.Handler, session, lastEventID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/mcp", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Mcp-Session-Id", session)
+	req.Header.Set("Last-Event-ID", lastEventID)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPResumeDroppedStream(t *testing.T) {
+	// The upstream sends progress, then the response once released
+	release := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "id: upstream-1\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{}}\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprintf(w, "id: upstream-2\ndata: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{\"rows\":3}}\n\n")
+	}))
+	defer up.Close()
+	h := NewHTTPServer(New(newTestLogger(t)), up.URL)
+	h.ResumeStreams(newEventStore(t))
+	front := httptest.NewServer(h)
+	defer front.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, front.URL+"/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call"}`))
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("Mcp-Session-Id", "abc")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var first *mcp.Event
+	err = mcp.ReadEvents(bufio.NewReader(resp.Body), func(e *mcp.Event) error {
+		first = e
+		return errResponseFound
+	})
+	require.ErrorIs(t, err, errResponseFound)
+	assert.NotEqual(t, "upstream-1", first.ID, "events get ids of the proxy's own")
+	cancel()
+	resp.Body.Close()
+
+	// The call goes on without the client, which comes back for the response
+	close(release)
+	rec := resumeGet(t, h, "abc", first.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	events := readEvents(t, rec.Body.String())
+	require.Len(t, events, 1)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"rows":3}}`, events[0].Data)
+	assert.NotEmpty(t, events[0].ID)
+
+	rec = resumeGet(t, h, "other", first.ID)
+	events = readEvents(t, rec.Body.String())
+	require.Len(t, events, 2, "other sessions get a new stream from the upstream")
+	assert.Contains(t, events[0].Data, "notifications/progress")
+}
+
+func TestHTTPResumeRelayedStream(t *testing.T) {
+	lastEventIDs := make(chan string, 2)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "id: upstream-1\nevent: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/tools/list_changed\"}\n\n")
+	}))
+	defer up.Close()
+	h := NewHTTPServer(New(newTestLogger(t)), up.URL)
+	h.ResumeStreams(newEventStore(t))
+
+	rec := resumeGet(t, h, "abc", "upstream-0")
+	assert.Equal(t, "upstream-0", <-lastEventIDs, "ids that are not the proxy's are left to the upstream")
+	events := readEvents(t, rec.Body.String())
+	require.Len(t, events, 1)
+	assert.Contains(t, events[0].Data, "list_changed")
+
+	rec = resumeGet(t, h, "abc", events[0].ID)
+	assert.Empty(t, <-lastEventIDs, "a stream with nothing left is followed by a new one")
+	assert.Len(t, readEvents(t, rec.Body.String()), 1)
+}
+
+func TestSessionResume(t *testing.T) {
+	ups := &sessionUpstreams{}
+	ups.respond = func(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+		progress, _ := mcp.NewNotification("notifications/progress", map[string]interface{}{"progressToken": "t1"})
+		ups.handlers[0](progress)
+		return mcp.NewResult(req.ID, map[string]int{"rows": 3})
+	}
+	store := newEventStore(t)
+	h := NewSessionServer(New(newTestLogger(t)), ups.connect)
+	h.ResumeStreams(store)
+
+	id := sessionRequest(t, h, http.MethodPost, "", initializeBody).Header().Get("Mcp-Session-Id")
+	rec := sessionRequest(t, h, http.MethodPost, id, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"_meta":{"progressToken":"t1"}}}`)
+	events := readEvents(t, rec.Body.String())
+	require.Len(t, events, 2)
+
+	rec = resumeGet(t, h, id, events[0].ID)
+	replayed := readEvents(t, rec.Body.String())
+	require.Len(t, replayed, 1)
+	assert.Equal(t, events[1].ID, replayed[0].ID)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":2,"result":{"rows":3}}`, replayed[0].Data)
+
+	sessionRequest(t, h, http.MethodDelete, id, "")
+	_, _, err := store.Find(id, events[0].ID)
+	assert.ErrorIs(t, err, eventstore.ErrNotKept, "events go with their session")
+}
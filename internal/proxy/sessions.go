@@ -0,0 +1,548 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/eventstore"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// Connect opens the upstream of a new session, delivering the messages the
+// upstream sends on its own initiative to handler
+type Connect func(handler upstream.MessageHandler) (upstream.Upstream, error)
+
+// SessionServer is a Streamable HTTP frontend that holds MCP sessions itself
+// rather than relaying them: each session a client initializes gets its own
+// upstream from connect, every client request passes through the middleware
+// chain to it, and the session ends with a DELETE or, see ExpireIdle, after
+// going without traffic. Event streams can be made resumable, see
+// ResumeStreams.
+type SessionServer struct {
+	proxy    *Proxy
+	logger   *logging.Logger
+	connect  Connect
+	requests *clientRequests
+	events   *eventstore.Store // Nil unless streams are resumable
+
+	mu          sync.Mutex
+	sessions    map[string]*httpSession
+	idleTimeout time.Duration
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// httpSession is a session held by a SessionServer
+type httpSession struct {
+	*Session
+	logger *logging.Logger
+
+	// Guarded by the server's mu
+	lastSeen time.Time
+	active   int // Exchanges in progress, including open GET streams
+
+	mu       sync.Mutex
+	inflight map[string]*inflightRequest // Requests being answered, by id
+	events   *responseStream             // The stream opened with GET, if any
+}
+
+// inflightRequest is a client request being answered
+type inflightRequest struct {
+	cancel        context.CancelFunc
+	send          Sender // Nil for requests in a batch
+	progressToken string
+}
+
+// NewSessionServer creates an HTTP frontend that opens an upstream per session
+func NewSessionServer(p *Proxy, connect Connect) *SessionServer {
+	return &SessionServer{
+		proxy:    p,
+		logger:   p.logger,
+		connect:  connect,
+		requests: newClientRequests(),
+		sessions: make(map[string]*httpSession),
+		stop:     make(chan struct{}),
+	}
+}
+
+// ExpireIdle ends sessions without traffic for longer than timeout, closing
+// their upstreams, until Close. A timeout of 0 keeps sessions until deleted.
+func (h *SessionServer) ExpireIdle(timeout time.Duration) {
+	h.mu.Lock()
+	h.idleTimeout = timeout
+	h.mu.Unlock()
+	if timeout <= 0 {
+		return
+	}
+	interval := maxExpiryInterval
+	if timeout < interval {
+		interval = timeout
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.Expire()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Expire ends the sessions that have been idle for longer than the idle
+// timeout. Sessions with an exchange in progress are kept.
+func (h *SessionServer) Expire() {
+	h.mu.Lock()
+	timeout := h.idleTimeout
+	var expired []*httpSession
+	if timeout > 0 {
+		for id, s := range h.sessions {
+			if s.active == 0 && time.Since(s.lastSeen) >= timeout {
+				expired = append(expired, s)
+				delete(h.sessions, id)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, s := range expired {
+		h.logger.Infof("Session %s expired after %s without traffic", s.ID, timeout)
+		if err := h.closeSession(s); err != nil {
+			h.logger.Errorf("Failed to close upstream of session %s: %v", s.ID, err)
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *SessionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.logger.HTTPIn(r.Method, r.URL.String())
+	switch r.Method {
+	case http.MethodPost:
+		h.servePost(w, r)
+	case http.MethodGet:
+		h.serveEvents(w, r)
+	case http.MethodDelete:
+		h.serveDelete(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Close stops ending idle sessions, then ends every session and closes their
+// upstreams
+func (h *SessionServer) Close() error {
+	h.mu.Lock()
+	select {
+	case <-h.stop:
+	default:
+		close(h.stop)
+	}
+	h.mu.Unlock()
+	h.wg.Wait()
+
+	h.mu.Lock()
+	sessions := make([]*httpSession, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s)
+	}
+	h.mu.Unlock()
+
+	var errs []error
+	for _, s := range sessions {
+		errs = append(errs, h.end(s))
+	}
+	return errors.Join(errs...)
+}
+
+func (h *SessionServer) servePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.logger.HTTPError(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.logger.HTTPInBody(string(body))
+
+	msgs, batch, err := mcp.ParseBatch(body)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, nil, mcp.NewError(mcp.ParseError, "Parse error"))
+		return
+	}
+
+	var session *httpSession
+	if id := r.Header.Get("Mcp-Session-Id"); id != "" {
+		if session = h.enter(id); session == nil {
+			h.writeError(w, http.StatusNotFound, nil, mcp.NewError(mcp.InvalidRequest, "Unknown session: %s", id))
+			return
+		}
+	} else {
+		if batch || msgs[0].Method != "initialize" || !msgs[0].IsRequest() {
+			h.writeError(w, http.StatusBadRequest, nil, mcp.NewError(mcp.InvalidRequest, "Missing Mcp-Session-Id header"))
+			return
+		}
+		if session, err = h.open(r, msgs[0]); err != nil {
+			h.logger.Errorf("Failed to open session: %v", err)
+			h.writeError(w, http.StatusOK, msgs[0].ID, mcp.NewError(mcp.InternalError, "%v", err))
+			return
+		}
+		w.Header().Set("Mcp-Session-Id", session.ID)
+	}
+	defer h.leave(session)
+
+	for _, msg := range msgs {
+		h.proxy.observe(session.ID, FromClient, msg, batch)
+	}
+
+	if !batch && msgs[0].IsRequest() {
+		h.serveRequest(w, r, session, msgs[0])
+		return
+	}
+
+	var requests []*mcp.Message
+	for _, msg := range msgs {
+		if msg.IsRequest() {
+			requests = append(requests, msg)
+		} else {
+			h.deliver(r.Context(), session, msg)
+		}
+	}
+	if len(requests) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		h.logger.HTTPOut(http.StatusAccepted, "")
+		return
+	}
+	h.serveBatch(w, r, session, requests)
+}
+
+// serveRequest answers a single request, as JSON or as an event stream when
+// messages have to be sent ahead of the response
+func (h *SessionServer) serveRequest(w http.ResponseWriter, r *http.Request, session *httpSession, req *mcp.Message) {
+	stream := newResponseStream(w, r)
+	defer stream.end()
+	ctx := r.Context()
+	if stream.keep(h.events, session.ID, h.logger) {
+		// A client that drops the stream comes back for the rest of it, so
+		// dropping it does not cancel the request
+		ctx = context.WithoutCancel(ctx)
+	}
+	if h.proxy.observing() {
+		stream.observe = func(msg *mcp.Message) {
+			h.proxy.observe(session.ID, ToClient, msg, false)
+		}
+	}
+
+	resp, err := h.handle(ctx, session, req, stream.send)
+	if req.Method == "initialize" {
+		if err != nil || resp == nil || resp.Error != nil {
+			// A session that failed to initialize cannot be used
+			h.end(session)
+		} else {
+			session.SetProtocolVersion(negotiatedVersion(req, resp))
+		}
+	}
+	if errors.Is(err, context.Canceled) && r.Context().Err() == nil {
+		// The client cancelled the request and expects no response
+		return
+	}
+	answer(r, h.logger, stream, req, resp, err)
+}
+
+// serveBatch answers the requests of a batch concurrently with one JSON batch
+func (h *SessionServer) serveBatch(w http.ResponseWriter, r *http.Request, session *httpSession, requests []*mcp.Message) {
+	responses := make([]*mcp.Message, len(requests))
+	raws := make([][]byte, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func(i int, req *mcp.Message) {
+			defer wg.Done()
+			resp, err := h.handle(r.Context(), session, req, nil)
+			var raw *RawResponse
+			switch {
+			case errors.Is(err, context.Canceled):
+			case errors.As(err, &raw):
+				raws[i] = raw.Data
+			case err != nil:
+				responses[i] = errorResponse(req, err)
+			default:
+				responses[i] = resp
+			}
+		}(i, req)
+	}
+	wg.Wait()
+
+	data := joinBatch(h.logger, responses, raws, func(resp *mcp.Message) {
+		h.proxy.observe(session.ID, ToClient, resp, true)
+	})
+	if data == nil {
+		data = []byte("[]")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	h.logger.HTTPOut(http.StatusOK, string(data))
+}
+
+// handle runs a request through the chain. While it runs, the client can
+// cancel it, and messages from the upstream and requests of the proxy's own
+// can reach the client through send.
+func (h *SessionServer) handle(ctx context.Context, session *httpSession, req *mcp.Message, send Sender) (*mcp.Message, error) {
+	if send != nil {
+		ctx = WithSender(ctx, send)
+		ctx = WithRequester(ctx, h.requests.requester(send))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	key := req.IDKey()
+	session.mu.Lock()
+	session.inflight[key] = &inflightRequest{cancel: cancel, send: send, progressToken: progressToken(req)}
+	session.mu.Unlock()
+	defer func() {
+		session.mu.Lock()
+		delete(session.inflight, key)
+		session.mu.Unlock()
+	}()
+
+	return h.proxy.Chain(Forward)(ctx, session.Session, req)
+}
+
+// deliver passes a client notification or response to the upstream. A client
+// cancelling one of its requests cancels the request's context instead, and
+// responses to requests of the proxy's own go to the proxy.
+func (h *SessionServer) deliver(ctx context.Context, session *httpSession, msg *mcp.Message) {
+	if h.requests.resolve(msg) {
+		return
+	}
+	if msg.Method == "notifications/cancelled" {
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		session.mu.Lock()
+		req, ok := session.inflight[mcp.IDKey(params.RequestID)]
+		session.mu.Unlock()
+		if ok {
+			req.cancel()
+		}
+		return
+	}
+	if err := session.Upstream.Send(ctx, msg); err != nil {
+		h.logger.Errorf("Failed to forward %s to upstream: %v", describe(msg), err)
+	}
+}
+
+// serveEvents holds a GET event stream open for messages the upstream sends
+// outside of any request
+func (h *SessionServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	session := h.enter(r.Header.Get("Mcp-Session-Id"))
+	if session == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer h.leave(session)
+	if r = resume(w, r, h.logger, h.events); r == nil {
+		return
+	}
+	stream := newResponseStream(w, r)
+	stream.keep(h.events, session.ID, h.logger)
+	if h.proxy.observing() {
+		stream.observe = func(msg *mcp.Message) {
+			h.proxy.observe(session.ID, ToClient, msg, false)
+		}
+	}
+	if !stream.startEvents() {
+		stream.end()
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	session.mu.Lock()
+	session.events = stream
+	session.mu.Unlock()
+
+	<-r.Context().Done()
+
+	session.mu.Lock()
+	if session.events == stream {
+		session.events = nil
+	}
+	session.mu.Unlock()
+	stream.end()
+	h.logger.HTTPOut(stream.status, stream.logged.String())
+}
+
+func (h *SessionServer) serveDelete(w http.ResponseWriter, r *http.Request) {
+	session := h.lookup(r.Header.Get("Mcp-Session-Id"))
+	if session == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := h.end(session); err != nil {
+		h.logger.Errorf("Failed to close upstream of session %s: %v", session.ID, err)
+	}
+	w.WriteHeader(http.StatusOK)
+	h.logger.HTTPOut(http.StatusOK, "")
+}
+
+// open starts a session for an initialize request
+func (h *SessionServer) open(r *http.Request, req *mcp.Message) (*httpSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	session := &httpSession{
+		Session:  NewSession(id, nil),
+		logger:   h.logger,
+		lastSeen: time.Now(),
+		active:   1, // For the initialize opening it, see leave
+		inflight: make(map[string]*inflightRequest),
+	}
+	up, err := h.connect(session.fromUpstream)
+	if err != nil {
+		return nil, err
+	}
+	session.Upstream = up
+	info := clientInfo(req)
+	session.SetClient(info)
+	session.SetPrincipal(requestPrincipal(r))
+
+	h.mu.Lock()
+	h.sessions[id] = session
+	h.mu.Unlock()
+	h.logger.Infof("Opened session %s for client '%s'", id, info.Name)
+	return session, nil
+}
+
+// end removes a session and closes its upstream
+func (h *SessionServer) end(session *httpSession) error {
+	h.mu.Lock()
+	_, ok := h.sessions[session.ID]
+	delete(h.sessions, session.ID)
+	h.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return h.closeSession(session)
+}
+
+// closeSession closes the upstream of a session that was removed, which
+// returns a leased child to its pool
+func (h *SessionServer) closeSession(session *httpSession) error {
+	h.logger.Infof("Closed session %s", session.ID)
+	if h.events != nil {
+		h.events.Forget(session.ID)
+	}
+	return session.Upstream.Close()
+}
+
+func (h *SessionServer) lookup(id string) *httpSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[id]
+}
+
+// enter looks up a session for an exchange, which keeps it from expiring
+// until leave. It returns nil for unknown sessions.
+func (h *SessionServer) enter(id string) *httpSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.sessions[id]
+	if s != nil {
+		s.active++
+		s.lastSeen = time.Now()
+	}
+	return s
+}
+
+// leave ends an exchange of a session
+func (h *SessionServer) leave(s *httpSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.active--
+	s.lastSeen = time.Now()
+}
+
+// writeError answers with a JSON-RPC error
+func (h *SessionServer) writeError(w http.ResponseWriter, status int, id json.RawMessage, rpcErr *mcp.Error) {
+	writeError(w, h.logger, status, id, rpcErr)
+}
+
+// fromUpstream sends a message the upstream sent on its own initiative to the
+// client: progress to the request it belongs to, anything else to the GET
+// stream or, without one, to any request being answered
+func (s *httpSession) fromUpstream(msg *mcp.Message) {
+	send := s.senderFor(msg)
+	if send == nil {
+		s.logger.Debugf("Dropped %s for session %s: no open stream", describe(msg), s.ID)
+		return
+	}
+	if err := send(msg); err != nil {
+		s.logger.Errorf("Failed to send %s to session %s: %v", describe(msg), s.ID, err)
+	}
+}
+
+func (s *httpSession) senderFor(msg *mcp.Message) Sender {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.Method == "notifications/progress" {
+		token := progressToken(msg)
+		for _, req := range s.inflight {
+			if req.send != nil && token != "" && req.progressToken == token {
+				return req.send
+			}
+		}
+	}
+	if s.events != nil {
+		return s.events.send
+	}
+	for _, req := range s.inflight {
+		if req.send != nil {
+			return req.send
+		}
+	}
+	return nil
+}
+
+// progressToken returns the progress token of a request, or of a progress
+// notification, in canonical form
+func progressToken(msg *mcp.Message) string {
+	var params struct {
+		Token json.RawMessage `json:"progressToken"`
+		Meta  struct {
+			Token json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	json.Unmarshal(msg.Params, &params)
+	if params.Token != nil {
+		return mcp.IDKey(params.Token)
+	}
+	if params.Meta.Token != nil {
+		return mcp.IDKey(params.Meta.Token)
+	}
+	return ""
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
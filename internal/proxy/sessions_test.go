@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const initializeBody = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"clientInfo":{"name":"test","version":"1"}}}`
+
+// sessionUpstreams records the upstreams a SessionServer connects
+type sessionUpstreams struct {
+	mu        sync.Mutex
+	upstreams []*closingUpstream
+	respond   func(ctx context.Context, req *mcp.Message) (*mcp.Message, error)
+	handlers  []upstream.MessageHandler
+}
+
+// closingUpstream is a fakeUpstream that records being closed
+type closingUpstream struct {
+	fakeUpstream
+	closed bool
+}
+
+func (c *closingUpstream) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (s *sessionUpstreams) connect(handler upstream.MessageHandler) (upstream.Upstream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	up := &closingUpstream{fakeUpstream: fakeUpstream{respond: s.respond}}
+	s.upstreams = append(s.upstreams, up)
+	s.handlers = append(s.handlers, handler)
+	return up, nil
+}
+
+func sessionRequest(t *testing.T, h http.Handler, method, session, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/mcp", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if session != "" {
+		req.Header.Set("Mcp-Session-Id", session)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	ups := &sessionUpstreams{}
+	h := NewSessionServer(New(newTestLogger(t)), ups.connect)
+
+	rec := sessionRequest(t, h, http.MethodPost, "", initializeBody)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"method":"initialize"}}`, rec.Body.String())
+	id := rec.Header().Get("Mcp-Session-Id")
+	require.Len(t, id, 32)
+	require.Len(t, ups.upstreams, 1)
+
+	rec = sessionRequest(t, h, http.MethodPost, id, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":2,"result":{"method":"tools/list"}}`, rec.Body.String())
+
+	rec = sessionRequest(t, h, http.MethodPost, id, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	sent := ups.upstreams[0].sentMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "notifications/initialized", sent[0].Method)
+
+	rec = sessionRequest(t, h, http.MethodPost, id, `[{"jsonrpc":"2.0","id":3,"method":"ping"},{"jsonrpc":"2.0","id":4,"method":"tools/list"}]`)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","id":3,"result":{"method":"ping"}},{"jsonrpc":"2.0","id":4,"result":{"method":"tools/list"}}]`, rec.Body.String())
+
+	rec = sessionRequest(t, h, http.MethodDelete, id, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, ups.upstreams[0].closed)
+
+	rec = sessionRequest(t, h, http.MethodPost, id, `{"jsonrpc":"2.0","id":5,"method":"ping"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code, "ended sessions are unknown")
+}
+
+func TestSessionBatchRawResponse(t *testing.T) {
+	ups := &sessionUpstreams{}
+	p := New(newTestLogger(t))
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method == "raw" {
+				return nil, &RawResponse{Data: []byte(`{"jsonrpc":`)}
+			}
+			return next(ctx, s, req)
+		}
+	})
+	h := NewSessionServer(p, ups.connect)
+	id := sessionRequest(t, h, http.MethodPost, "", initializeBody).Header().Get("Mcp-Session-Id")
+
+	rec := sessionRequest(t, h, http.MethodPost, id, `[{"jsonrpc":"2.0","id":2,"method":"raw"},{"jsonrpc":"2.0","id":3,"method":"ping"}]`)
+	assert.Equal(t, `[{"jsonrpc":,{"jsonrpc":"2.0","id":3,"result":{"method":"ping"}}]`, rec.Body.String())
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ups := &sessionUpstreams{}
+	h := NewSessionServer(New(newTestLogger(t)), ups.connect)
+	defer h.Close()
+	h.ExpireIdle(time.Minute)
+
+	id := sessionRequest(t, h, http.MethodPost, "", initializeBody).Header().Get("Mcp-Session-Id")
+	h.Expire()
+	require.NotNil(t, h.lookup(id), "sessions with recent traffic are kept")
+
+	h.mu.Lock()
+	h.sessions[id].lastSeen = time.Now().Add(-time.Hour)
+	h.sessions[id].active++
+	h.mu.Unlock()
+	h.Expire()
+	require.NotNil(t, h.lookup(id), "sessions with an exchange in progress are kept")
+
+	h.mu.Lock()
+	h.sessions[id].active--
+	h.mu.Unlock()
+	h.Expire()
+	assert.Nil(t, h.lookup(id))
+	assert.True(t, ups.upstreams[0].closed, "the upstream of an expired session is closed")
+
+	rec := sessionRequest(t, h, http.MethodPost, id, `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSessionRequired(t *testing.T) {
+	ups := &sessionUpstreams{}
+	h := NewSessionServer(New(newTestLogger(t)), ups.connect)
+
+	rec := sessionRequest(t, h, http.MethodPost, "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = sessionRequest(t, h, http.MethodPost, "unknown", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = sessionRequest(t, h, http.MethodPost, "", `not json`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, ups.upstreams)
+}
+
+func TestSessionFailedInitialize(t *testing.T) {
+	ups := &sessionUpstreams{respond: func(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+		return nil, mcp.NewError(mcp.InvalidParams, "Unsupported protocol version")
+	}}
+	h := NewSessionServer(New(newTestLogger(t)), ups.connect)
+
+	rec := sessionRequest(t, h, http.MethodPost, "", initializeBody)
+	assert.Contains(t, rec.Body.String(), "Unsupported protocol version")
+	require.Len(t, ups.upstreams, 1)
+	assert.True(t, ups.upstreams[0].closed, "the session is ended")
+
+	rec = sessionRequest(t, h, http.MethodPost, rec.Header().Get("Mcp-Session-Id"), `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSessionUpstreamMessages(t *testing.T) {
+	ups := &sessionUpstreams{}
+	ups.respond = func(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+		if req.Method == "tools/call" {
+			// The upstream asks the client something while answering
+			roots, _ := mcp.NewRequest([]byte(`"r1"`), "roots/list", nil)
+			ups.handlers[0](roots)
+		}
+		return mcp.NewResult(req.ID, map[string]string{"method": req.Method})
+	}
+	h := NewSessionServer(New(newTestLogger(t)), ups.connect)
+	id := sessionRequest(t, h, http.MethodPost, "", initializeBody).Header().Get("Mcp-Session-Id")
+
+	rec := sessionRequest(t, h, http.MethodPost, id, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"execute"}}`)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	var events []string
+	require.NoError(t, mcp.ReadEvents(rec.Body, func(e *mcp.Event) error {
+		events = append(events, e.Data)
+		return nil
+	}))
+	require.Len(t, events, 2)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"r1","method":"roots/list"}`, events[0])
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":2,"result":{"method":"tools/call"}}`, events[1])
+
+	// The client's answer goes to the upstream
+	rec = sessionRequest(t, h, http.MethodPost, id, `{"jsonrpc":"2.0","id":"r1","result":{"roots":[]}}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	sent := ups.upstreams[0].sentMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, `"r1"`, string(sent[0].ID))
+
+	require.NoError(t, h.Close())
+	assert.True(t, ups.upstreams[0].closed)
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// StdioServer relays MCP traffic between a client on stdin/stdout and the
+// session's upstream. Client requests pass through the middleware chain;
+// notifications and responses to server-initiated requests are forwarded as is.
+type StdioServer struct {
+	proxy  *Proxy
+	logger *logging.Logger
+
+	writeMu  sync.Mutex
+	out      io.Writer
+	requests *clientRequests
+
+	mu        sync.Mutex
+	inflight  map[string]context.CancelFunc // cancels in-flight requests by client id
+	sessionID string
+}
+
+// NewStdioServer creates a stdio frontend that writes to the client on out
+func NewStdioServer(p *Proxy, out io.Writer) *StdioServer {
+	return &StdioServer{
+		proxy:    p,
+		logger:   p.logger,
+		out:      out,
+		requests: newClientRequests(),
+		inflight: make(map[string]context.CancelFunc),
+	}
+}
+
+// HandleUpstreamMessage forwards a server-initiated message to the client.
+// It is meant to be used as the upstream's message handler.
+func (s *StdioServer) HandleUpstreamMessage(msg *mcp.Message) {
+	s.send(msg)
+}
+
+// Serve reads client messages from in until in is exhausted or done is closed.
+// When in is exhausted, Serve waits for in-flight requests to complete.
+func (s *StdioServer) Serve(in io.Reader, session *Session, done <-chan struct{}) error {
+	s.mu.Lock()
+	s.sessionID = session.ID
+	s.mu.Unlock()
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+		readErr <- scanner.Err()
+		close(lines)
+	}()
+
+	handler := s.proxy.Chain(Forward)
+	ctx := WithSender(context.Background(), s.send)
+	ctx = WithRequester(ctx, s.requests.requester(s.send))
+
+	var wg sync.WaitGroup
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				wg.Wait()
+				return <-readErr
+			}
+			s.handleLine(ctx, handler, session, line, &wg)
+		case <-done:
+			return nil
+		}
+	}
+}
+
+func (s *StdioServer) handleLine(ctx context.Context, handler Handler, session *Session, line []byte, wg *sync.WaitGroup) {
+	s.logger.TrafficIn(string(line))
+
+	msgs, batch, err := mcp.ParseBatch(line)
+	if err != nil {
+		s.logger.Errorf("Invalid message from client: %v", err)
+		s.send(mcp.NewErrorResponse(nil, mcp.NewError(mcp.ParseError, "Parse error")))
+		return
+	}
+	for _, msg := range msgs {
+		s.proxy.observe(session.ID, FromClient, msg, batch)
+	}
+
+	for _, msg := range msgs {
+		if msg.Method == "initialize" && msg.IsRequest() {
+			session.SetClient(clientInfo(msg))
+		}
+	}
+
+	if !batch {
+		msg := msgs[0]
+		if !msg.IsRequest() {
+			s.forward(ctx, session, msg)
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, raw := s.handleRequest(ctx, handler, session, msg)
+			switch {
+			case raw != nil:
+				s.writeLine(raw)
+			case resp != nil:
+				s.send(resp)
+			}
+		}()
+		return
+	}
+
+	// Requests in a batch are handled concurrently and answered with one batch
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		responses := make([]*mcp.Message, len(msgs))
+		raws := make([][]byte, len(msgs))
+		var batchWG sync.WaitGroup
+		for i, msg := range msgs {
+			if !msg.IsRequest() {
+				s.forward(ctx, session, msg)
+				continue
+			}
+			batchWG.Add(1)
+			go func(i int, msg *mcp.Message) {
+				defer batchWG.Done()
+				responses[i], raws[i] = s.handleRequest(ctx, handler, session, msg)
+			}(i, msg)
+		}
+		batchWG.Wait()
+
+		data := joinBatch(s.logger, responses, raws, func(resp *mcp.Message) {
+			s.proxy.observe(session.ID, ToClient, resp, true)
+		})
+		if data != nil {
+			s.writeLine(data)
+		}
+	}()
+}
+
+// handleRequest runs a request through the chain and returns the response to
+// send, or raw bytes when middleware replaced the response with invalid data
+func (s *StdioServer) handleRequest(ctx context.Context, handler Handler, session *Session, req *mcp.Message) (*mcp.Message, []byte) {
+	ctx, cancel := context.WithCancel(ctx)
+	key := req.IDKey()
+	s.mu.Lock()
+	s.inflight[key] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, key)
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	resp, err := handler(ctx, session, req)
+	if req.Method == "initialize" && err == nil && resp != nil && resp.Error == nil {
+		session.SetProtocolVersion(negotiatedVersion(req, resp))
+	}
+	if ctx.Err() != nil {
+		// The client cancelled the request and expects no response
+		return nil, nil
+	}
+	if err != nil {
+		var raw *RawResponse
+		if errors.As(err, &raw) {
+			return nil, raw.Data
+		}
+		return errorResponse(req, err), nil
+	}
+	return resp, nil
+}
+
+// forward relays a notification or a client response to the upstream. A client
+// cancelling one of its in-flight requests cancels the request's context
+// instead, which lets the upstream connection translate the request id.
+// Responses to requests of the proxy's own go to the proxy.
+func (s *StdioServer) forward(ctx context.Context, session *Session, msg *mcp.Message) {
+	if msg.Method == "notifications/cancelled" && s.cancelInflight(msg) {
+		return
+	}
+	if s.requests.resolve(msg) {
+		return
+	}
+	if session.Upstream == nil {
+		return
+	}
+	if err := session.Upstream.Send(ctx, msg); err != nil {
+		s.logger.Errorf("Failed to forward %s to upstream: %v", describe(msg), err)
+	}
+}
+
+func (s *StdioServer) cancelInflight(msg *mcp.Message) bool {
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil || params.RequestID == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.inflight[mcp.IDKey(params.RequestID)]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (s *StdioServer) send(msg *mcp.Message) error {
+	if s.proxy.observing() {
+		s.mu.Lock()
+		id := s.sessionID
+		s.mu.Unlock()
+		s.proxy.observe(id, ToClient, msg, false)
+	}
+	data, err := msg.Marshal()
+	if err != nil {
+		s.logger.Errorf("Failed to encode message for client: %v", err)
+		return err
+	}
+	return s.writeLine(data)
+}
+
+func (s *StdioServer) writeLine(data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.logger.TrafficOut(string(data))
+	_, err := s.out.Write(append(data, '\n'))
+	return err
+}
+
+// describe names a message for log output
+func describe(msg *mcp.Message) string {
+	if msg.Method != "" {
+		return msg.Method
+	}
+	return "response " + msg.IDKey()
+}
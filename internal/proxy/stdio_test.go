@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes and reads
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+func serve(t *testing.T, p *Proxy, up *fakeUpstream, input string) []string {
+	var out syncBuffer
+	server := NewStdioServer(p, &out)
+	require.NoError(t, server.Serve(strings.NewReader(input), NewSession("stdio", up), nil))
+	return out.lines()
+}
+
+func TestStdioServeRequest(t *testing.T) {
+	p := New(newTestLogger(t))
+	lines := serve(t, p, &fakeUpstream{}, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`+"\n")
+
+	require.Len(t, lines, 1)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"method":"tools/list"}}`, lines[0])
+}
+
+func TestStdioForwardsNotifications(t *testing.T) {
+	p := New(newTestLogger(t))
+	up := &fakeUpstream{}
+	serve(t, p, up, `{"jsonrpc":"2.0","method":"notifications/initialized"}`+"\n")
+
+	sent := up.sentMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "notifications/initialized", sent[0].Method)
+}
+
+func TestStdioParseError(t *testing.T) {
+	p := New(newTestLogger(t))
+	lines := serve(t, p, &fakeUpstream{}, "not json\n")
+
+	require.Len(t, lines, 1)
+	msg, err := mcp.Parse([]byte(lines[0]))
+	require.NoError(t, err)
+	assert.Equal(t, mcp.ParseError, msg.Error.Code)
+}
+
+func TestStdioBatch(t *testing.T) {
+	p := New(newTestLogger(t))
+	up := &fakeUpstream{}
+	lines := serve(t, p, up, `[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","method":"notifications/x"},{"jsonrpc":"2.0","id":2,"method":"b"}]`+"\n")
+
+	require.Len(t, lines, 1)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","id":1,"result":{"method":"a"}},{"jsonrpc":"2.0","id":2,"result":{"method":"b"}}]`, lines[0])
+	assert.Len(t, up.sentMessages(), 1)
+}
+
+func TestStdioMiddlewareResults(t *testing.T) {
+	p := New(newTestLogger(t))
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			switch req.Method {
+			case "raw":
+				return nil, &RawResponse{Data: []byte(`{"jsonrpc":`)}
+			case "fail":
+				return nil, mcp.NewError(mcp.InvalidParams, "nope")
+			case "drop":
+				return nil, nil
+			}
+			return next(ctx, s, req)
+		}
+	})
+
+	lines := serve(t, p, &fakeUpstream{}, `{"jsonrpc":"2.0","id":1,"method":"raw"}`+"\n")
+	assert.Equal(t, []string{`{"jsonrpc":`}, lines)
+
+	lines = serve(t, p, &fakeUpstream{}, `{"jsonrpc":"2.0","id":1,"method":"fail"}`+"\n")
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"nope"}}`, lines[0])
+
+	lines = serve(t, p, &fakeUpstream{}, `{"jsonrpc":"2.0","id":1,"method":"drop"}`+"\n")
+	assert.Equal(t, []string{""}, lines)
+
+	lines = serve(t, p, &fakeUpstream{}, `[{"jsonrpc":"2.0","id":1,"method":"raw"},{"jsonrpc":"2.0","id":2,"method":"drop"},{"jsonrpc":"2.0","id":3,"method":"a"}]`+"\n")
+	assert.Equal(t, []string{`[{"jsonrpc":,{"jsonrpc":"2.0","id":3,"result":{"method":"a"}}]`}, lines,
+		"raw responses in a batch are sent in place of their response")
+
+	lines = serve(t, p, &fakeUpstream{}, `[{"jsonrpc":"2.0","id":1,"method":"raw"}]`+"\n")
+	assert.Equal(t, []string{`[{"jsonrpc":]`}, lines)
+}
+
+func TestStdioCancel(t *testing.T) {
+	p := New(newTestLogger(t))
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	up := &fakeUpstream{respond: func(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}}
+
+	in, inWriter := io.Pipe()
+	var out syncBuffer
+	server := NewStdioServer(p, &out)
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(in, NewSession("stdio", up), nil) }()
+
+	io.WriteString(inWriter, `{"jsonrpc":"2.0","id":"a","method":"slow"}`+"\n")
+	<-started
+	io.WriteString(inWriter, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"a"}}`+"\n")
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+	inWriter.Close()
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []string{""}, out.lines(), "cancelled requests get no response")
+	assert.Empty(t, up.sentMessages(), "the cancellation is handled by the proxy")
+}
+
+func TestStdioUpstreamMessages(t *testing.T) {
+	p := New(newTestLogger(t))
+	var out syncBuffer
+	server := NewStdioServer(p, &out)
+
+	server.HandleUpstreamMessage(&mcp.Message{JSONRPC: "2.0", Method: "notifications/message", Params: json.RawMessage(`{}`)})
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"notifications/message","params":{}}`, out.lines()[0])
+}
+
+func TestStdioStopsWhenDone(t *testing.T) {
+	p := New(newTestLogger(t))
+	in, inWriter := io.Pipe()
+	defer inWriter.Close()
+	done := make(chan struct{})
+	close(done)
+
+	server := NewStdioServer(p, io.Discard)
+	assert.NoError(t, server.Serve(in, NewSession("stdio", &fakeUpstream{}), done))
+}
+
+func TestStdioObservers(t *testing.T) {
+	p := New(newTestLogger(t))
+	var seen []string
+	var mu sync.Mutex
+	p.Observe(func(session string, dir Direction, msg *mcp.Message, batch bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, fmt.Sprintf("%s %s %s %t", session, dir, describe(msg), batch))
+	})
+
+	serve(t, p, &fakeUpstream{}, `{"jsonrpc":"2.0","id":1,"method":"ping"}`+"\n"+
+		`[{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`+"\n")
+
+	assert.ElementsMatch(t, []string{
+		"stdio client->server ping false",
+		"stdio server->client response 1 false",
+		"stdio client->server tools/list true",
+		"stdio server->client response 2 true",
+	}, seen)
+}
+
+func TestStdioClientIdentity(t *testing.T) {
+	p := New(newTestLogger(t))
+	var clients []string
+	var mu sync.Mutex
+	p.Use(func(next Handler) Handler {
+		return func(ctx context.Context, s *Session, req *mcp.Message) (*mcp.Message, error) {
+			mu.Lock()
+			clients = append(clients, s.Client())
+			mu.Unlock()
+			return next(ctx, s, req)
+		}
+	})
+
+	session := NewSession("stdio", &fakeUpstream{})
+	server := NewStdioServer(p, io.Discard)
+	require.NoError(t, server.Serve(strings.NewReader(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"clientInfo":{"name":"report-bot","version":"1"}}}`+"\n"), session, nil))
+
+	assert.Equal(t, []string{"report-bot"}, clients)
+	assert.Equal(t, ClientInfo{Name: "report-bot", Version: "1"}, session.ClientInfo())
+}
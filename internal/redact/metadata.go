@@ -0,0 +1,88 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// metadata summarizes a body without its content, e.g.
+// "[metadata] id=3 method=tools/call tool=execute_sql (412 bytes)"
+func (r *Redactor) metadata(body string) string {
+	var parts []string
+	if msgs, ok := messages(body); ok {
+		parts = append(parts, msgs...)
+	} else {
+		for _, line := range strings.Split(body, "\n") {
+			if data, ok := dataLine(line); ok {
+				if msgs, ok := messages(data); ok {
+					parts = append(parts, msgs...)
+				}
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("[metadata] (%d bytes)", len(body))
+	}
+	return fmt.Sprintf("[metadata] %s (%d bytes)", strings.Join(parts, "; "), len(body))
+}
+
+// messages describes the JSON-RPC message or batch in data
+func messages(data string) ([]string, bool) {
+	trimmed := strings.TrimSpace(data)
+	if strings.HasPrefix(trimmed, "[") {
+		var batch []json.RawMessage
+		if json.Unmarshal([]byte(trimmed), &batch) != nil {
+			return nil, false
+		}
+		var parts []string
+		for _, msg := range batch {
+			parts = append(parts, describe(msg))
+		}
+		return parts, true
+	}
+	if !strings.HasPrefix(trimmed, "{") || !json.Valid([]byte(trimmed)) {
+		return nil, false
+	}
+	return []string{describe(json.RawMessage(trimmed))}, true
+}
+
+// describe names the id, method and tool of a message, and for responses
+// whether they carry a result or an error
+func describe(data json.RawMessage) string {
+	var msg struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &msg) != nil {
+		return "invalid"
+	}
+
+	var parts []string
+	if len(msg.ID) > 0 {
+		parts = append(parts, "id="+string(msg.ID))
+	}
+	if msg.Method != "" {
+		parts = append(parts, "method="+msg.Method)
+		var params struct {
+			Name string `json:"name"`
+		}
+		if msg.Method == "tools/call" && json.Unmarshal(msg.Params, &params) == nil && params.Name != "" {
+			parts = append(parts, "tool="+params.Name)
+		}
+	}
+	if msg.Error != nil {
+		parts = append(parts, fmt.Sprintf("error=%d", msg.Error.Code))
+	} else if msg.Result != nil {
+		parts = append(parts, "result")
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, " ")
+}
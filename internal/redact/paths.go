@@ -0,0 +1,49 @@
+package redact
+
+import "strconv"
+
+// maskPath replaces the values at a dotted path with the mask. A segment
+// matches an object key or array index; * matches any key or index and **
+// any number of levels, including none.
+func maskPath(v interface{}, path []string, mask string) (interface{}, bool) {
+	if len(path) == 0 {
+		return mask, true
+	}
+	seg, rest := path[0], path[1:]
+	if seg == "**" {
+		v, changed := maskPath(v, rest, mask)
+		c := eachChild(v, func(string) bool { return true }, func(child interface{}) (interface{}, bool) {
+			return maskPath(child, path, mask)
+		})
+		return v, changed || c
+	}
+	changed := eachChild(v, func(key string) bool { return seg == "*" || seg == key }, func(child interface{}) (interface{}, bool) {
+		return maskPath(child, rest, mask)
+	})
+	return v, changed
+}
+
+// eachChild replaces the children of an object or array whose key or index
+// matches with the result of fn
+func eachChild(v interface{}, match func(key string) bool, fn func(child interface{}) (interface{}, bool)) bool {
+	changed := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if match(k) {
+				var c bool
+				t[k], c = fn(child)
+				changed = changed || c
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			if match(strconv.Itoa(i)) {
+				var c bool
+				t[i], c = fn(child)
+				changed = changed || c
+			}
+		}
+	}
+	return changed
+}
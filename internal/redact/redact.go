@@ -0,0 +1,186 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+)
+
+// Redactor masks sensitive values in the traffic written to the log. JSON
+// messages are decoded so that the configured paths are masked and detectors
+// and patterns only see decoded strings; other bodies and URLs are matched as
+// text. In metadata-only mode, bodies are replaced by a summary of the
+// messages they carry.
+type Redactor struct {
+	paths        [][]string
+	rules        []rule
+	mask         string
+	metadataOnly bool
+}
+
+// New creates a redactor from the configuration
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	r := &Redactor{mask: cfg.Mask, metadataOnly: cfg.MetadataOnly}
+	if r.mask == "" {
+		r.mask = "[REDACTED]"
+	}
+	for _, p := range cfg.Paths {
+		r.paths = append(r.paths, strings.Split(p, "."))
+	}
+	for _, name := range cfg.Detectors {
+		d, ok := detectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction detector '%s'", name)
+		}
+		r.rules = append(r.rules, d)
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern '%s': %w", pattern, err)
+		}
+		r.rules = append(r.rules, rule{re: re, replace: maskAll})
+	}
+	return r, nil
+}
+
+// Payload returns a message body as it may be logged: a JSON message or
+// batch, an event stream whose data lines hold JSON messages, or plain text
+func (r *Redactor) Payload(body string) string {
+	if r.metadataOnly {
+		return r.metadata(body)
+	}
+	if v, ok := decode(body); ok {
+		return r.redactJSON(body, v)
+	}
+	if isEventStream(body) {
+		lines := strings.Split(body, "\n")
+		for i, line := range lines {
+			if data, ok := dataLine(line); ok {
+				if v, ok := decode(data); ok {
+					lines[i] = "data: " + r.redactJSON(data, v)
+					if strings.HasSuffix(line, "\r") {
+						lines[i] += "\r"
+					}
+					continue
+				}
+			}
+			lines[i] = r.Text(line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	return r.Text(body)
+}
+
+// Text masks the matches of the detectors and patterns in text
+func (r *Redactor) Text(text string) string {
+	for _, rule := range r.rules {
+		text = rule.apply(text, r.mask)
+	}
+	return text
+}
+
+// redactJSON masks the paths and the strings matching a rule in a decoded
+// message. The original text is returned when nothing is masked.
+func (r *Redactor) redactJSON(original string, v interface{}) string {
+	changed := false
+	if messages, ok := v.([]interface{}); ok {
+		for i, msg := range messages {
+			var c bool
+			messages[i], c = r.maskPaths(msg)
+			changed = changed || c
+		}
+	} else {
+		v, changed = r.maskPaths(v)
+	}
+	v, c := r.maskValues(v)
+	if !changed && !c {
+		return original
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return r.Text(original)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (r *Redactor) maskPaths(msg interface{}) (interface{}, bool) {
+	changed := false
+	for _, p := range r.paths {
+		var c bool
+		msg, c = maskPath(msg, p, r.mask)
+		changed = changed || c
+	}
+	return msg, changed
+}
+
+// maskValues applies the rules to every string and number in v. Numbers
+// with a match are masked whole.
+func (r *Redactor) maskValues(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		masked := r.Text(t)
+		return masked, masked != t
+	case json.Number:
+		if r.Text(t.String()) != t.String() {
+			return r.mask, true
+		}
+	case map[string]interface{}:
+		changed := false
+		for k, child := range t {
+			var c bool
+			t[k], c = r.maskValues(child)
+			changed = changed || c
+		}
+		return t, changed
+	case []interface{}:
+		changed := false
+		for i, child := range t {
+			var c bool
+			t[i], c = r.maskValues(child)
+			changed = changed || c
+		}
+		return t, changed
+	}
+	return v, false
+}
+
+// decode decodes a complete JSON value, keeping numbers as written
+func decode(s string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+func isEventStream(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if _, ok := dataLine(line); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dataLine returns the data of a server-sent event data line
+func dataLine(line string) (string, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if !strings.HasPrefix(line, "data:") {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "), true
+}
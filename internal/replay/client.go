@@ -0,0 +1,162 @@
+package replay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// Result statuses reported for each replayed request
+const (
+	StatusMatch    = "match"
+	StatusMismatch = "mismatch"
+	StatusError    = "error"
+)
+
+// Result is the outcome of replaying a single recorded request
+type Result struct {
+	Method      string       `json:"method"`
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	Differences []Difference `json:"differences,omitempty"`
+}
+
+// Report summarizes a replay of a recording against a live upstream
+type Report struct {
+	Total      int      `json:"total"`
+	Matched    int      `json:"matched"`
+	Mismatched int      `json:"mismatched"`
+	Failed     int      `json:"failed"`
+	Results    []Result `json:"results"`
+}
+
+// OK reports whether every replayed response matched the recording
+func (r *Report) OK() bool {
+	return r.Mismatched == 0 && r.Failed == 0
+}
+
+// ClientOptions holds settings for a replay client
+type ClientOptions struct {
+	Ignore  *IgnoreRules
+	Timeout time.Duration // Per-request timeout; zero means no timeout
+}
+
+// Client drives the client side of a recording into a fresh upstream and
+// compares the responses with the recorded ones
+type Client struct {
+	options ClientOptions
+	logger  *logging.Logger
+
+	mu       sync.Mutex
+	upstream upstream.Upstream
+}
+
+// NewClient creates a replay client
+func NewClient(options ClientOptions, logger *logging.Logger) *Client {
+	return &Client{options: options, logger: logger}
+}
+
+// HandleServerMessage handles messages the upstream sends on its own initiative.
+// It is meant to be used as the upstream's message handler: notifications are
+// logged, and server-to-client requests are rejected because a recording holds
+// no client answers to replay.
+func (c *Client) HandleServerMessage(msg *mcp.Message) {
+	c.logger.TrafficOut(msg.String())
+	if !msg.IsRequest() {
+		return
+	}
+
+	c.mu.Lock()
+	up := c.upstream
+	c.mu.Unlock()
+	if up == nil {
+		return
+	}
+
+	resp := mcp.NewErrorResponse(msg.ID, mcp.NewError(mcp.MethodNotFound, "replay client does not support %s", msg.Method))
+	c.logger.TrafficIn(resp.String())
+	if err := up.Send(context.Background(), resp); err != nil {
+		c.logger.Errorf("Failed to answer server request %s: %v", msg.Method, err)
+	}
+}
+
+// Run sends the recorded client messages to up in their original order and
+// returns a report comparing each response with the recording
+func (c *Client) Run(ctx context.Context, up upstream.Upstream, rec *Recording) *Report {
+	c.mu.Lock()
+	c.upstream = up
+	c.mu.Unlock()
+
+	exchanges := make(map[*mcp.Message]*Exchange, len(rec.Exchanges))
+	for _, ex := range rec.Exchanges {
+		exchanges[ex.Request] = ex
+	}
+
+	report := &Report{}
+	for _, msg := range rec.ClientMessages {
+		if msg.IsNotification() {
+			c.logger.TrafficIn(msg.String())
+			if err := up.Send(ctx, msg); err != nil {
+				c.logger.Errorf("Failed to send %s: %v", msg.Method, err)
+			}
+			continue
+		}
+
+		ex, ok := exchanges[msg]
+		if !ok {
+			// The request was never answered in the recording, so there is nothing to compare
+			continue
+		}
+		result := c.replay(ctx, up, ex)
+		report.Results = append(report.Results, result)
+		report.Total++
+		switch result.Status {
+		case StatusMatch:
+			report.Matched++
+		case StatusMismatch:
+			report.Mismatched++
+		default:
+			report.Failed++
+		}
+	}
+	return report
+}
+
+func (c *Client) replay(ctx context.Context, up upstream.Upstream, ex *Exchange) Result {
+	result := Result{Method: ex.Request.Method, ID: ex.Request.IDKey()}
+
+	if c.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.Timeout)
+		defer cancel()
+	}
+
+	c.logger.TrafficIn(ex.Request.String())
+	resp, err := up.Call(ctx, ex.Request)
+	if err != nil {
+		c.logger.Errorf("Replay of %s request %s failed: %v", ex.Request.Method, result.ID, err)
+		result.Status = StatusError
+		result.Error = err.Error()
+		return result
+	}
+	c.logger.TrafficOut(resp.String())
+
+	diffs, err := Diff(ex.Response, resp, c.options.Ignore)
+	if err != nil {
+		result.Status = StatusError
+		result.Error = err.Error()
+		return result
+	}
+	if len(diffs) > 0 {
+		result.Status = StatusMismatch
+		result.Differences = diffs
+		return result
+	}
+	result.Status = StatusMatch
+	return result
+}
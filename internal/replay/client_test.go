@@ -0,0 +1,110 @@
+package replay
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUpstream answers requests from a fixed table of results keyed by method
+type fakeUpstream struct {
+	results map[string]string
+	sent    []*mcp.Message
+}
+
+func (f *fakeUpstream) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	result, ok := f.results[req.Method]
+	if !ok {
+		return mcp.NewErrorResponse(req.ID, mcp.NewError(mcp.MethodNotFound, "unknown method")), nil
+	}
+	return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: []byte(result)}, nil
+}
+
+func (f *fakeUpstream) Send(ctx context.Context, msg *mcp.Message) error {
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeUpstream) Close() error { return nil }
+
+func TestClientRun(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	up := &fakeUpstream{results: map[string]string{
+		"initialize": `{"protocolVersion":"2025-06-18"}`,
+		"tools/call": `{"content":[{"type":"text","text":"2"}]}`,
+	}}
+
+	client := NewClient(ClientOptions{}, newTestLogger(t))
+	report := client.Run(context.Background(), up, rec)
+
+	assert.Equal(t, 2, report.Total)
+	assert.Equal(t, 1, report.Matched)
+	assert.Equal(t, 1, report.Mismatched)
+	assert.False(t, report.OK())
+
+	require.Len(t, report.Results, 2)
+	assert.Equal(t, StatusMatch, report.Results[0].Status)
+	assert.Equal(t, StatusMismatch, report.Results[1].Status)
+	require.Len(t, report.Results[1].Differences, 1)
+	assert.Equal(t, "result.content.0.text", report.Results[1].Differences[0].Path)
+	assert.Equal(t, "1", report.Results[1].Differences[0].Recorded)
+	assert.Equal(t, "2", report.Results[1].Differences[0].Actual)
+
+	// The initialized notification is forwarded in order
+	require.Len(t, up.sent, 1)
+	assert.Equal(t, "notifications/initialized", up.sent[0].Method)
+}
+
+func TestClientReportsErrors(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	up := &fakeUpstream{results: map[string]string{
+		"initialize": `{"protocolVersion":"2025-06-18"}`,
+	}}
+	report := NewClient(ClientOptions{}, newTestLogger(t)).Run(context.Background(), up, rec)
+
+	// A JSON-RPC error where the recording holds a result is a mismatch
+	assert.Equal(t, 1, report.Mismatched)
+	paths := make([]string, 0)
+	for _, d := range report.Results[1].Differences {
+		paths = append(paths, d.Path)
+	}
+	assert.Contains(t, paths, "result")
+	assert.Contains(t, paths, "error")
+}
+
+func TestDiffIgnoreRules(t *testing.T) {
+	recorded := &mcp.Message{Result: []byte(`{"content":[{"type":"text","text":"{\"rows\":[{\"id\":1,\"fetched_at\":\"2025-01-01T10:00:00Z\"}],\"elapsed\":\"12ms\"}"}],"meta":{"server":"a"}}`)}
+	actual := &mcp.Message{Result: []byte(`{"content":[{"type":"text","text":"{\"rows\":[{\"id\":1,\"fetched_at\":\"2025-03-04T11:22:33Z\"}],\"elapsed\":\"40ms\"}"}],"meta":{"server":"b"}}`)}
+
+	// Without rules every volatile field is reported, inside the embedded JSON too
+	diffs, err := Diff(recorded, actual, nil)
+	require.NoError(t, err)
+	paths := make([]string, 0)
+	for _, d := range diffs {
+		paths = append(paths, d.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"result.content.0.text.rows.0.fetched_at",
+		"result.content.0.text.elapsed",
+		"result.meta.server",
+	}, paths)
+
+	rules, err := CompileIgnoreRules([]string{"fetched_at", "result.meta.*"}, []string{`\d+ms`})
+	require.NoError(t, err)
+	diffs, err = Diff(recorded, actual, rules)
+	require.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	_, err = CompileIgnoreRules(nil, []string{"("})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid ignore pattern")
+}
@@ -0,0 +1,221 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// ignoredValue replaces the parts of string values matched by an ignore pattern
+const ignoredValue = "<ignored>"
+
+// Difference is a single value that differs between a recorded and a replayed response
+type Difference struct {
+	Path     string      `json:"path"`
+	Recorded interface{} `json:"recorded"`
+	Actual   interface{} `json:"actual"`
+}
+
+// IgnoreRules select volatile parts of responses that are excluded from comparison
+type IgnoreRules struct {
+	fields   [][]string
+	patterns []*regexp.Regexp
+}
+
+// CompileIgnoreRules builds ignore rules. A field is either a bare key name,
+// which is ignored at any depth (for example "timestamp"), or a dotted path
+// where "*" matches any single key or array index (for example
+// "result.content.*.text"). Patterns are regular expressions whose matches
+// inside string values are masked before comparing.
+func CompileIgnoreRules(fields, patterns []string) (*IgnoreRules, error) {
+	rules := &IgnoreRules{}
+	for _, field := range fields {
+		if field == "" {
+			continue
+		}
+		rules.fields = append(rules.fields, strings.Split(field, "."))
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern '%s': %w", pattern, err)
+		}
+		rules.patterns = append(rules.patterns, re)
+	}
+	return rules, nil
+}
+
+// ignores reports whether the value at path is excluded from comparison
+func (r *IgnoreRules) ignores(path []string) bool {
+	if r == nil || len(path) == 0 {
+		return false
+	}
+	for _, rule := range r.fields {
+		if len(rule) == 1 && rule[0] == path[len(path)-1] {
+			return true
+		}
+		if len(rule) == len(path) && pathMatches(rule, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *IgnoreRules) mask(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, ignoredValue)
+	}
+	return s
+}
+
+func pathMatches(rule, path []string) bool {
+	for i := range rule {
+		if rule[i] != "*" && rule[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff compares the result or error of a recorded response with a replayed one.
+// String values that contain JSON documents (as tool results often do) are
+// compared structurally so that differences point at the field that changed.
+func Diff(recorded, actual *mcp.Message, rules *IgnoreRules) ([]Difference, error) {
+	left, err := responseTree(recorded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode recorded response: %w", err)
+	}
+	right, err := responseTree(actual)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode replayed response: %w", err)
+	}
+
+	var diffs []Difference
+	compare(nil, left, right, rules, &diffs)
+	return diffs, nil
+}
+
+func responseTree(msg *mcp.Message) (map[string]interface{}, error) {
+	tree := make(map[string]interface{})
+	if msg.Result != nil {
+		value, err := decode(msg.Result)
+		if err != nil {
+			return nil, err
+		}
+		tree["result"] = value
+	}
+	if msg.Error != nil {
+		data, err := json.Marshal(msg.Error)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+		tree["error"] = value
+	}
+	return tree, nil
+}
+
+func decode(data []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// embeddedJSON decodes a string value that holds a JSON object or array
+func embeddedJSON(s string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(s)
+	if len(trimmed) < 2 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+	value, err := decode([]byte(trimmed))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func compare(path []string, left, right interface{}, rules *IgnoreRules, diffs *[]Difference) {
+	if rules.ignores(path) {
+		return
+	}
+
+	switch l := left.(type) {
+	case map[string]interface{}:
+		r, ok := right.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range l {
+			keys[k] = true
+		}
+		for k := range r {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			compare(append(path[:len(path):len(path)], k), l[k], r[k], rules, diffs)
+		}
+		return
+	case []interface{}:
+		r, ok := right.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(l)
+		if len(r) > n {
+			n = len(r)
+		}
+		for i := 0; i < n; i++ {
+			var lv, rv interface{}
+			if i < len(l) {
+				lv = l[i]
+			}
+			if i < len(r) {
+				rv = r[i]
+			}
+			compare(append(path[:len(path):len(path)], strconv.Itoa(i)), lv, rv, rules, diffs)
+		}
+		return
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			break
+		}
+		lj, lok := embeddedJSON(l)
+		rj, rok := embeddedJSON(r)
+		if lok && rok {
+			compare(path, lj, rj, rules, diffs)
+			return
+		}
+		if rules.mask(l) == rules.mask(r) {
+			return
+		}
+	}
+
+	if reflect.DeepEqual(left, right) {
+		return
+	}
+	*diffs = append(*diffs, Difference{Path: strings.Join(path, "."), Recorded: left, Actual: right})
+}
@@ -0,0 +1,110 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// MatchMode controls how strictly incoming requests are matched against a recording
+type MatchMode string
+
+const (
+	// MatchStrict requires the method and the canonicalized params to be identical
+	MatchStrict MatchMode = "strict"
+	// MatchNormalized ignores _meta fields and compares only the protocol version of initialize
+	MatchNormalized MatchMode = "normalized"
+	// MatchMethod compares only the method; recorded responses are replayed in order
+	MatchMethod MatchMode = "method"
+)
+
+// Matcher finds the recorded exchange that answers a request
+type Matcher struct {
+	mode MatchMode
+
+	mu        sync.Mutex
+	exchanges map[string][]*Exchange
+	cursor    map[string]int
+}
+
+// NewMatcher indexes a recording for matching with the given mode
+func NewMatcher(rec *Recording, mode MatchMode) (*Matcher, error) {
+	switch mode {
+	case MatchStrict, MatchNormalized, MatchMethod:
+	default:
+		return nil, fmt.Errorf("invalid replay match mode '%s': must be 'strict', 'normalized' or 'method'", mode)
+	}
+
+	m := &Matcher{
+		mode:      mode,
+		exchanges: make(map[string][]*Exchange),
+		cursor:    make(map[string]int),
+	}
+	for _, ex := range rec.Exchanges {
+		key, err := m.key(ex.Request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index recorded %s request: %w", ex.Request.Method, err)
+		}
+		m.exchanges[key] = append(m.exchanges[key], ex)
+	}
+	return m, nil
+}
+
+// Match returns the next recorded exchange for an equivalent request. Identical
+// requests consume recorded responses in order; once exhausted, the last
+// response is repeated.
+func (m *Matcher) Match(req *mcp.Message) (*Exchange, bool) {
+	key, err := m.key(req)
+	if err != nil {
+		return nil, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	candidates := m.exchanges[key]
+	if len(candidates) == 0 {
+		return nil, false
+	}
+	idx := m.cursor[key]
+	if idx >= len(candidates) {
+		idx = len(candidates) - 1
+	}
+	m.cursor[key] = idx + 1
+	return candidates[idx], true
+}
+
+func (m *Matcher) key(req *mcp.Message) (string, error) {
+	switch m.mode {
+	case MatchMethod:
+		return req.Method, nil
+	case MatchNormalized:
+		params := req.Params
+		if req.Method == "initialize" {
+			// Client info and capabilities differ between clients; only the
+			// requested protocol version affects the recorded answer
+			var init struct {
+				ProtocolVersion string `json:"protocolVersion"`
+			}
+			if len(params) > 0 {
+				if err := json.Unmarshal(params, &init); err != nil {
+					return "", err
+				}
+			}
+			return req.Method + "\x00" + init.ProtocolVersion, nil
+		}
+		canonical, err := mcp.Canonicalize(params, "_meta")
+		if err != nil {
+			return "", err
+		}
+		return req.Method + "\x00" + canonical, nil
+	default:
+		canonical, err := mcp.Canonicalize(req.Params)
+		if err != nil {
+			return "", err
+		}
+		return req.Method + "\x00" + canonical, nil
+	}
+}
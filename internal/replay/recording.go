@@ -0,0 +1,193 @@
+package replay
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// Exchange is a single client request and the response recorded for it
+type Exchange struct {
+	Request  *mcp.Message
+	Response *mcp.Message
+	Latency  time.Duration // Time between the request and its response in the recording
+}
+
+// Recording holds the request/response exchanges captured in a proxy traffic log
+type Recording struct {
+	Exchanges []*Exchange
+
+	// ClientMessages holds every request and notification the client sent, in order
+	ClientMessages []*mcp.Message
+}
+
+// logTimeLayout matches the timestamp written by the logging package
+// (log.LstdFlags|log.Lmicroseconds). Parsing accepts the fraction of a second
+// without the layout naming it, and logs of older versions have none.
+const logTimeLayout = "2006/01/02 15:04:05"
+
+// entryPattern matches a traffic entry written by the logging package, for example
+// "2025/01/01 12:00:01.204311 [IN] {...}" or "2025/01/01 12:00:01.204311 [HTTP OUT] 200 {...}"
+var entryPattern = regexp.MustCompile(`^(.*?)(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) \[([A-Z ]+)\] ?(.*)$`)
+
+// logEntry is a single (possibly multi-line) entry from a traffic log
+type logEntry struct {
+	time time.Time
+	tag  string
+	body string
+}
+
+// Load reads a recording from a proxy traffic log file
+func Load(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	rec, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse recording '%s': %w", path, err)
+	}
+	return rec, nil
+}
+
+// Parse reads a recording from proxy traffic log content. Stdio traffic ([IN]/[OUT])
+// and HTTP traffic ([HTTP IN BODY]/[HTTP OUT]) are both understood; every other
+// entry is ignored.
+func Parse(r io.Reader) (*Recording, error) {
+	entries, err := readEntries(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type pendingRequest struct {
+		exchange *Exchange
+		at       time.Time
+	}
+
+	rec := &Recording{}
+	pending := make(map[string][]pendingRequest) // unanswered requests by id
+
+	for _, entry := range entries {
+		var payload string
+		var fromClient bool
+
+		switch entry.tag {
+		case "IN", "HTTP IN BODY":
+			payload, fromClient = entry.body, true
+		case "OUT":
+			payload = entry.body
+		case "HTTP OUT":
+			// Strip the status code that precedes the body
+			if idx := strings.IndexByte(entry.body, ' '); idx >= 0 {
+				payload = entry.body[idx+1:]
+			}
+		default:
+			continue
+		}
+
+		for _, msg := range decodePayload(payload) {
+			if fromClient && msg.Method != "" {
+				rec.ClientMessages = append(rec.ClientMessages, msg)
+			}
+
+			switch {
+			case fromClient && msg.IsRequest():
+				ex := &Exchange{Request: msg}
+				pending[msg.IDKey()] = append(pending[msg.IDKey()], pendingRequest{exchange: ex, at: entry.time})
+				rec.Exchanges = append(rec.Exchanges, ex)
+			case !fromClient && msg.IsResponse():
+				// Pair the response with the most recent unanswered request that has the same id
+				queue := pending[msg.IDKey()]
+				if len(queue) == 0 {
+					continue
+				}
+				req := queue[len(queue)-1]
+				pending[msg.IDKey()] = queue[:len(queue)-1]
+				req.exchange.Response = msg
+				req.exchange.Latency = entry.time.Sub(req.at)
+			}
+		}
+	}
+
+	// Drop requests that never received a response
+	answered := rec.Exchanges[:0]
+	for _, ex := range rec.Exchanges {
+		if ex.Response != nil {
+			answered = append(answered, ex)
+		}
+	}
+	rec.Exchanges = answered
+
+	return rec, nil
+}
+
+// readEntries splits log content into entries, joining continuation lines
+// (such as multi-line SSE bodies) onto the entry they belong to
+func readEntries(r io.Reader) ([]*logEntry, error) {
+	var entries []*logEntry
+	var current *logEntry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := entryPattern.FindStringSubmatch(line)
+		if match == nil {
+			if current != nil {
+				current.body += "\n" + line
+			}
+			continue
+		}
+
+		ts, err := time.ParseInLocation(logTimeLayout, match[2], time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp '%s': %w", match[2], err)
+		}
+		current = &logEntry{time: ts, tag: match[3], body: match[4]}
+		entries = append(entries, current)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// decodePayload extracts JSON-RPC messages from a logged body, which is either
+// a JSON message or batch, or a server-sent event stream carrying messages
+func decodePayload(payload string) []*mcp.Message {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return nil
+	}
+
+	if payload[0] == '{' || payload[0] == '[' {
+		msgs, _, err := mcp.ParseBatch([]byte(payload))
+		if err != nil {
+			return nil
+		}
+		return msgs
+	}
+
+	var msgs []*mcp.Message
+	for _, line := range strings.Split(payload, "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		batch, _, err := mcp.ParseBatch([]byte(strings.TrimSpace(data)))
+		if err != nil {
+			continue
+		}
+		msgs = append(msgs, batch...)
+	}
+	return msgs
+}
@@ -0,0 +1,92 @@
+package replay
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stdioLog = `2025/01/01 12:00:00.000120 [STARTUP] Starting MCP SQLPP Proxy with configuration: Config{...}
+2025/01/01 12:00:00.000410 [INFO] Starting in stdio mode with exe-path: ./mcp_sqlpp
+2025/01/01 12:00:01.204311 [IN] {"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","clientInfo":{"name":"agent"}}}
+2025/01/01 12:00:01.204311 [OUT] {"jsonrpc":"2.0","id":1,"result":{"protocolVersion":"2025-06-18"}}
+2025/01/01 12:00:01.205002 [IN] {"jsonrpc":"2.0","method":"notifications/initialized"}
+2025/01/01 12:00:01.990500 [IN] {"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 1"}}}
+2025/01/01 12:00:02.027250 [OUT] {"jsonrpc":"2.0","id":2,"result":{"content":[{"type":"text","text":"1"}]}}
+2025/01/01 12:00:05.000001 [IN] {"jsonrpc":"2.0","id":3,"method":"ping"}
+`
+
+func TestParseStdioLog(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	// The unanswered ping is dropped
+	require.Len(t, rec.Exchanges, 2)
+
+	assert.Equal(t, "initialize", rec.Exchanges[0].Request.Method)
+	assert.Equal(t, `{"protocolVersion":"2025-06-18"}`, string(rec.Exchanges[0].Response.Result))
+	assert.Equal(t, time.Duration(0), rec.Exchanges[0].Latency)
+
+	assert.Equal(t, "tools/call", rec.Exchanges[1].Request.Method)
+	assert.Equal(t, 36750*time.Microsecond, rec.Exchanges[1].Latency, "latency is recorded to the microsecond")
+
+	// Client messages keep notifications and unanswered requests in order
+	require.Len(t, rec.ClientMessages, 4)
+	assert.Equal(t, "notifications/initialized", rec.ClientMessages[1].Method)
+	assert.Equal(t, "ping", rec.ClientMessages[3].Method)
+}
+
+func TestParseHTTPLogWithSSE(t *testing.T) {
+	content := `2025/01/01 12:00:01 [HTTP IN] POST /mcp
+2025/01/01 12:00:01 [HTTP IN BODY] {"jsonrpc":"2.0","id":"a","method":"tools/list"}
+2025/01/01 12:00:01 [HTTP OUT] 200 event: message
+data: {"jsonrpc":"2.0","method":"notifications/message","params":{}}
+
+event: message
+data: {"jsonrpc":"2.0","id":"a","result":{"tools":[]}}
+
+2025/01/01 12:00:02 [HTTP IN] POST /mcp
+2025/01/01 12:00:02 [HTTP IN BODY] {"jsonrpc":"2.0","id":"b","method":"ping"}
+2025/01/01 12:00:02 [HTTP OUT] 200 {"jsonrpc":"2.0","id":"b","result":{}}
+`
+	rec, err := Parse(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Len(t, rec.Exchanges, 2)
+
+	assert.Equal(t, "tools/list", rec.Exchanges[0].Request.Method)
+	assert.Equal(t, `{"tools":[]}`, string(rec.Exchanges[0].Response.Result))
+	assert.Equal(t, "ping", rec.Exchanges[1].Request.Method)
+}
+
+func TestParseRepeatedIDs(t *testing.T) {
+	// Two sessions in one log reuse the same id; each response pairs with its own
+	// request. Timestamps to the second, as older versions wrote, are understood.
+	content := `2025/01/01 12:00:01 [IN] {"jsonrpc":"2.0","id":1,"method":"ping"}
+2025/01/01 12:00:01 [OUT] {"jsonrpc":"2.0","id":1,"result":{"n":1}}
+2025/01/01 12:00:02 [IN] {"jsonrpc":"2.0","id":1,"method":"tools/list"}
+2025/01/01 12:00:02 [OUT] {"jsonrpc":"2.0","id":1,"result":{"n":2}}
+`
+	rec, err := Parse(strings.NewReader(content))
+	require.NoError(t, err)
+	require.Len(t, rec.Exchanges, 2)
+	assert.Equal(t, `{"n":1}`, string(rec.Exchanges[0].Response.Result))
+	assert.Equal(t, `{"n":2}`, string(rec.Exchanges[1].Response.Result))
+}
+
+func TestLoad(t *testing.T) {
+	tempFile := "test_recording.log"
+	require.NoError(t, os.WriteFile(tempFile, []byte(stdioLog), 0644))
+	defer os.Remove(tempFile)
+
+	rec, err := Load(tempFile)
+	require.NoError(t, err)
+	assert.Len(t, rec.Exchanges, 2)
+
+	_, err = Load("nonexistent_recording.log")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to open recording")
+}
@@ -0,0 +1,150 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// Timing controls how response latency is emulated
+type Timing string
+
+const (
+	// TimingNone answers every request immediately
+	TimingNone Timing = "none"
+	// TimingRecorded waits as long as the upstream took in the recording
+	TimingRecorded Timing = "recorded"
+	// TimingFixed waits a fixed delay before every response
+	TimingFixed Timing = "fixed"
+)
+
+// ServerOptions holds settings for a replay server
+type ServerOptions struct {
+	Match  MatchMode
+	Timing Timing
+	Delay  time.Duration // Delay used with TimingFixed
+}
+
+// Server answers MCP requests from a recording, standing in for mcp_sqlpp
+type Server struct {
+	matcher *Matcher
+	options ServerOptions
+	logger  *logging.Logger
+
+	mu  sync.Mutex // serializes writes to the client
+	out io.Writer
+}
+
+// NewServer creates a replay server for the given recording
+func NewServer(rec *Recording, options ServerOptions, logger *logging.Logger) (*Server, error) {
+	matcher, err := NewMatcher(rec, options.Match)
+	if err != nil {
+		return nil, err
+	}
+
+	switch options.Timing {
+	case TimingNone, TimingRecorded, TimingFixed:
+	default:
+		return nil, fmt.Errorf("invalid replay timing '%s': must be 'none', 'recorded' or 'fixed'", options.Timing)
+	}
+
+	return &Server{matcher: matcher, options: options, logger: logger}, nil
+}
+
+// Serve reads newline-delimited JSON-RPC messages and batches from in and
+// writes replayed responses to out until in is exhausted. A batch is answered
+// with one batch once its slowest response is due.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+
+	var wg sync.WaitGroup
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.logger.TrafficIn(line)
+
+		msgs, batch, err := mcp.ParseBatch([]byte(line))
+		if err != nil {
+			s.write(mcp.NewErrorResponse(nil, mcp.NewError(mcp.ParseError, "Parse error")).String())
+			continue
+		}
+
+		// Matching happens in arrival order so replays are deterministic;
+		// only the emulated delay runs concurrently
+		var responses []*mcp.Message
+		var delay time.Duration
+		for _, msg := range msgs {
+			if !msg.IsRequest() {
+				// Notifications and client responses need no answer
+				continue
+			}
+			resp, d := s.respond(msg)
+			responses = append(responses, resp)
+			delay = max(delay, d)
+		}
+		if len(responses) == 0 {
+			continue
+		}
+
+		var reply string
+		if batch {
+			data, err := json.Marshal(responses)
+			if err != nil {
+				s.logger.Errorf("Failed to encode batch response: %v", err)
+				continue
+			}
+			reply = string(data)
+		} else {
+			reply = responses[0].String()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			s.write(reply)
+		}()
+	}
+
+	wg.Wait()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read client input: %w", err)
+	}
+	return nil
+}
+
+// respond builds the response for a request and the delay to apply before sending it
+func (s *Server) respond(req *mcp.Message) (*mcp.Message, time.Duration) {
+	ex, ok := s.matcher.Match(req)
+	if !ok {
+		s.logger.Errorf("No recorded response for %s request %s", req.Method, req.IDKey())
+		return mcp.NewErrorResponse(req.ID, mcp.NewError(mcp.InternalError, "no recorded response for %s", req.Method)), 0
+	}
+
+	resp := ex.Response.Clone()
+	resp.ID = req.ID
+
+	switch s.options.Timing {
+	case TimingRecorded:
+		return resp, ex.Latency
+	case TimingFixed:
+		return resp, s.options.Delay
+	default:
+		return resp, 0
+	}
+}
+
+func (s *Server) write(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger.TrafficOut(line)
+	io.WriteString(s.out, line+"\n")
+}
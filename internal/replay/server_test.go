@@ -0,0 +1,215 @@
+package replay
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func mustParse(t *testing.T, line string) *mcp.Message {
+	msg, err := mcp.Parse([]byte(line))
+	require.NoError(t, err)
+	return msg
+}
+
+func TestMatcherModes(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		mode    MatchMode
+		request string
+		matched bool
+	}{
+		{
+			name:    "strict matches identical params",
+			mode:    MatchStrict,
+			request: `{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"arguments":{"sql":"SELECT 1"},"name":"query"}}`,
+			matched: true,
+		},
+		{
+			name:    "strict rejects extra _meta",
+			mode:    MatchStrict,
+			request: `{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 1"},"_meta":{"progressToken":1}}}`,
+			matched: false,
+		},
+		{
+			name:    "normalized ignores _meta",
+			mode:    MatchNormalized,
+			request: `{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 1"},"_meta":{"progressToken":1}}}`,
+			matched: true,
+		},
+		{
+			name:    "normalized ignores client info on initialize",
+			mode:    MatchNormalized,
+			request: `{"jsonrpc":"2.0","id":9,"method":"initialize","params":{"protocolVersion":"2025-06-18","clientInfo":{"name":"other"}}}`,
+			matched: true,
+		},
+		{
+			name:    "normalized rejects different arguments",
+			mode:    MatchNormalized,
+			request: `{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 2"}}}`,
+			matched: false,
+		},
+		{
+			name:    "method ignores params",
+			mode:    MatchMethod,
+			request: `{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"other"}}`,
+			matched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := NewMatcher(rec, tt.mode)
+			require.NoError(t, err)
+
+			_, ok := matcher.Match(mustParse(t, tt.request))
+			assert.Equal(t, tt.matched, ok)
+		})
+	}
+}
+
+func TestMatcherConsumesInOrder(t *testing.T) {
+	content := `2025/01/01 12:00:01 [IN] {"jsonrpc":"2.0","id":1,"method":"ping"}
+2025/01/01 12:00:01 [OUT] {"jsonrpc":"2.0","id":1,"result":{"n":1}}
+2025/01/01 12:00:02 [IN] {"jsonrpc":"2.0","id":2,"method":"ping"}
+2025/01/01 12:00:02 [OUT] {"jsonrpc":"2.0","id":2,"result":{"n":2}}
+`
+	rec, err := Parse(strings.NewReader(content))
+	require.NoError(t, err)
+	matcher, err := NewMatcher(rec, MatchNormalized)
+	require.NoError(t, err)
+
+	ping := mustParse(t, `{"jsonrpc":"2.0","id":5,"method":"ping"}`)
+	for _, expected := range []string{`{"n":1}`, `{"n":2}`, `{"n":2}`} {
+		ex, ok := matcher.Match(ping)
+		require.True(t, ok)
+		assert.Equal(t, expected, string(ex.Response.Result))
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	_, err := NewMatcher(&Recording{}, "fuzzy")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid replay match mode")
+
+	_, err = NewServer(&Recording{}, ServerOptions{Match: MatchStrict, Timing: "slow"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid replay timing")
+}
+
+func TestServerServe(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	server, err := NewServer(rec, ServerOptions{Match: MatchNormalized, Timing: TimingNone}, newTestLogger(t))
+	require.NoError(t, err)
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":"x","method":"initialize","params":{"protocolVersion":"2025-06-18"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":"y","method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 1"}}}`,
+		`{"jsonrpc":"2.0","id":"z","method":"resources/list"}`,
+		`garbage`,
+	}, "\n")
+
+	var out bytes.Buffer
+	require.NoError(t, server.Serve(strings.NewReader(input), &out))
+
+	responses := make(map[string]*mcp.Message)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		msg := mustParse(t, line)
+		responses[msg.IDKey()] = msg
+	}
+	require.Len(t, responses, 4)
+
+	assert.Equal(t, `{"protocolVersion":"2025-06-18"}`, string(responses[`"x"`].Result))
+	assert.Equal(t, `{"content":[{"type":"text","text":"1"}]}`, string(responses[`"y"`].Result))
+	require.NotNil(t, responses[`"z"`].Error)
+	assert.Contains(t, responses[`"z"`].Error.Message, "no recorded response for resources/list")
+	require.NotNil(t, responses["null"].Error)
+	assert.Equal(t, mcp.ParseError, responses["null"].Error.Code)
+}
+
+func TestServerBatch(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	server, err := NewServer(rec, ServerOptions{Match: MatchNormalized, Timing: TimingNone}, newTestLogger(t))
+	require.NoError(t, err)
+
+	input := strings.Join([]string{
+		`[{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18"}},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":2,"method":"resources/list"}]`,
+		`[{"jsonrpc":"2.0","method":"notifications/initialized"}]`,
+		`[{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 1"}}}]`,
+	}, "\n")
+
+	var out bytes.Buffer
+	require.NoError(t, server.Serve(strings.NewReader(input), &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2, "batches of notifications need no answer")
+	batches := make(map[string][]*mcp.Message)
+	for _, line := range lines {
+		msgs, batch, err := mcp.ParseBatch([]byte(line))
+		require.NoError(t, err)
+		require.True(t, batch, "batches are answered with batches")
+		batches[msgs[0].IDKey()] = msgs
+	}
+
+	require.Len(t, batches["1"], 2)
+	assert.Equal(t, `{"protocolVersion":"2025-06-18"}`, string(batches["1"][0].Result))
+	require.NotNil(t, batches["1"][1].Error)
+	assert.Equal(t, "2", batches["1"][1].IDKey())
+	require.Len(t, batches["3"], 1)
+	assert.Equal(t, `{"content":[{"type":"text","text":"1"}]}`, string(batches["3"][0].Result))
+}
+
+func TestServerRecordedTiming(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	server, err := NewServer(rec, ServerOptions{Match: MatchMethod, Timing: TimingRecorded}, newTestLogger(t))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	start := time.Now()
+	require.NoError(t, server.Serve(strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call"}`), &out))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 36750*time.Microsecond)
+	assert.Less(t, elapsed, time.Second, "sub-second latency is not rounded to whole seconds")
+}
+
+func TestServerFixedTiming(t *testing.T) {
+	rec, err := Parse(strings.NewReader(stdioLog))
+	require.NoError(t, err)
+
+	server, err := NewServer(rec, ServerOptions{Match: MatchMethod, Timing: TimingFixed, Delay: 50 * time.Millisecond}, newTestLogger(t))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	start := time.Now()
+	require.NoError(t, server.Serve(strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`), &out))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Contains(t, out.String(), `"protocolVersion":"2025-06-18"`)
+}
@@ -0,0 +1,350 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// defaultInitialize initializes route upstreams before any client has
+// initialized through the proxy
+const defaultInitialize = `{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"mcp_sqlpp_proxy","version":"1.0"}}`
+
+// maxExpiryInterval bounds the time between sweeps for idle upstreams
+const maxExpiryInterval = 30 * time.Second
+
+// errTooManyBackends refuses a call needing another upstream while max-backends
+// are open and busy
+var errTooManyBackends = errors.New("too many upstreams open; try again when calls to others have finished")
+
+// Connect opens the upstream of a route for an argument value
+type Connect func(route config.RouteConfig, value string, handler upstream.MessageHandler) (upstream.Upstream, error)
+
+// Router sends tools/call requests whose arguments select a route to the
+// route's upstream, spawned or connected on first use and shared by all
+// sessions. At most max-backends upstreams are open at once, and those without
+// calls for idle-timeout are closed.
+type Router struct {
+	cfg     config.RoutingConfig
+	logger  *logging.Logger
+	connect Connect
+
+	mu         sync.Mutex
+	backends   map[string]*backend
+	initialize json.RawMessage // Params of the latest client initialize, reused for route upstreams
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// backend is the upstream of a route for one rendering of its templates
+type backend struct {
+	key      string
+	name     string        // Route and value, for logs
+	ready    chan struct{} // Closed once up or err is set
+	up       *Shared
+	err      error
+	active   int       // Calls in progress, guarded by Router.mu
+	lastUsed time.Time // When the latest call ended, guarded by Router.mu
+}
+
+// New creates a router for the configured routes
+func New(cfg config.RoutingConfig, logger *logging.Logger) *Router {
+	r := &Router{
+		cfg:        cfg,
+		logger:     logger,
+		backends:   make(map[string]*backend),
+		initialize: json.RawMessage(defaultInitialize),
+		stop:       make(chan struct{}),
+	}
+	r.connect = func(route config.RouteConfig, value string, handler upstream.MessageHandler) (upstream.Upstream, error) {
+		if route.URL != "" {
+			return upstream.NewHTTP(renderURL(route.URL, value), handler), nil
+		}
+		return upstream.StartStdio(upstream.StdioOptions{
+			ExePath: route.ExePath,
+			Args:    render(route.Args, value),
+			Env:     render(route.Env, value),
+			Handler: handler,
+			Logger:  logger,
+		})
+	}
+	return r
+}
+
+// Middleware returns the router as proxy middleware. It takes the place of the
+// upstream for routed calls, so it should come last.
+func (r *Router) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			switch req.Method {
+			case "initialize":
+				if len(req.Params) > 0 {
+					r.mu.Lock()
+					r.initialize = req.Params
+					r.mu.Unlock()
+				}
+			case "tools/call":
+				if route, value, ok := r.match(req); ok {
+					proxy.Note(ctx, "route", route.Name)
+					return r.call(ctx, route, value, req)
+				}
+			}
+			return next(ctx, s, req)
+		}
+	}
+}
+
+// Start closes upstreams past the idle timeout until Close
+func (r *Router) Start() {
+	if r.cfg.IdleTimeout <= 0 {
+		return
+	}
+	interval := maxExpiryInterval
+	if r.cfg.IdleTimeout < interval {
+		interval = r.cfg.IdleTimeout
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Expire()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Expire closes the upstreams without calls for longer than the idle timeout
+func (r *Router) Expire() {
+	if r.cfg.IdleTimeout <= 0 {
+		return
+	}
+	r.mu.Lock()
+	var expired []*backend
+	for key, b := range r.backends {
+		if b.idleLocked() && time.Since(b.lastUsed) >= r.cfg.IdleTimeout {
+			expired = append(expired, b)
+			delete(r.backends, key)
+		}
+	}
+	r.mu.Unlock()
+	for _, b := range expired {
+		r.logger.Infof("Closing upstream of route %s: idle for %s", b.name, r.cfg.IdleTimeout)
+		b.up.Close()
+	}
+}
+
+// Close closes the upstreams of all routes
+func (r *Router) Close() error {
+	r.mu.Lock()
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	backends := make([]*backend, 0, len(r.backends))
+	for _, b := range r.backends {
+		backends = append(backends, b)
+	}
+	r.backends = make(map[string]*backend)
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	var errs []error
+	for _, b := range backends {
+		<-b.ready
+		if b.up != nil {
+			errs = append(errs, b.up.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// match returns the route a call's selecting argument leads to
+func (r *Router) match(req *mcp.Message) (config.RouteConfig, string, bool) {
+	call, err := mcp.ParseToolCall(req)
+	if err != nil {
+		return config.RouteConfig{}, "", false
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return config.RouteConfig{}, "", false
+	}
+	for _, name := range r.cfg.Arguments {
+		value, ok := args[name].(string)
+		if !ok {
+			continue
+		}
+		for _, route := range r.cfg.Routes {
+			for _, pattern := range route.Match {
+				if ok, _ := path.Match(pattern, value); ok {
+					return route, value, true
+				}
+			}
+		}
+		return config.RouteConfig{}, "", false
+	}
+	return config.RouteConfig{}, "", false
+}
+
+// call sends a routed call to its upstream, which is shared by all sessions
+func (r *Router) call(ctx context.Context, route config.RouteConfig, value string, req *mcp.Message) (*mcp.Message, error) {
+	b, err := r.backend(ctx, route, value)
+	if err != nil {
+		r.logger.Errorf("Route %s unavailable for '%s': %v", route.Name, value, err)
+		return nil, mcp.NewError(mcp.InternalError, "Route %s is unavailable: %v", route.Name, err)
+	}
+	defer r.release(b)
+
+	resp, err := b.up.Call(ctx, req)
+	if errors.Is(err, upstream.ErrClosed) {
+		r.forget(b)
+	}
+	return resp, err
+}
+
+// backend returns the upstream of a route for a value, connecting and
+// initializing it unless that has been done. At max-backends, the upstream
+// unused for longest makes room, unless all are busy. The caller releases
+// the backend once its call is done.
+func (r *Router) backend(ctx context.Context, route config.RouteConfig, value string) (*backend, error) {
+	key := strings.Join(append([]string{route.Name, route.ExePath, renderURL(route.URL, value)},
+		append(render(route.Args, value), render(route.Env, value)...)...), "\x00")
+
+	var evicted *backend
+	r.mu.Lock()
+	b, ok := r.backends[key]
+	if !ok {
+		if r.cfg.MaxBackends > 0 && len(r.backends) >= r.cfg.MaxBackends {
+			evicted = r.leastRecentlyUsedLocked()
+			if evicted == nil {
+				r.mu.Unlock()
+				return nil, errTooManyBackends
+			}
+			delete(r.backends, evicted.key)
+		}
+		b = &backend{key: key, name: route.Name + " for '" + value + "'", ready: make(chan struct{})}
+		r.backends[key] = b
+		params := r.initialize
+		go r.open(b, route, value, params)
+	}
+	b.active++
+	r.mu.Unlock()
+	if evicted != nil {
+		r.logger.Infof("Closing upstream of route %s to make room for %s", evicted.name, b.name)
+		evicted.up.Close()
+	}
+
+	select {
+	case <-b.ready:
+	case <-ctx.Done():
+		r.release(b)
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		r.release(b)
+		return nil, b.err
+	}
+	return b, nil
+}
+
+// release ends a call to a backend
+func (r *Router) release(b *backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b.active--
+	b.lastUsed = time.Now()
+}
+
+// leastRecentlyUsedLocked returns the idle backend unused for longest, or nil
+// when every backend is busy. The caller holds r.mu.
+func (r *Router) leastRecentlyUsedLocked() *backend {
+	var lru *backend
+	for _, b := range r.backends {
+		if b.idleLocked() && (lru == nil || b.lastUsed.Before(lru.lastUsed)) {
+			lru = b
+		}
+	}
+	return lru
+}
+
+// idleLocked reports whether a backend is open without calls in progress.
+// The caller holds Router.mu.
+func (b *backend) idleLocked() bool {
+	if b.active > 0 {
+		return false
+	}
+	select {
+	case <-b.ready:
+		return b.up != nil
+	default:
+		return false
+	}
+}
+
+// open connects a backend and initializes its session. Backends that fail are
+// forgotten, so that the next call tries again.
+func (r *Router) open(b *backend, route config.RouteConfig, value string, params json.RawMessage) {
+	defer close(b.ready)
+	up, err := OpenShared("route", func(handler upstream.MessageHandler) (upstream.Upstream, error) {
+		return r.connect(route, value, handler)
+	}, params, r.cfg.InitTimeout, r.logger)
+	if err != nil {
+		b.err = err
+		r.forget(b)
+		return
+	}
+	b.up = up
+	r.logger.Infof("Opened upstream of route %s for '%s'", route.Name, value)
+
+	if done := up.Done(); done != nil {
+		go func() {
+			<-done
+			r.logger.Infof("Upstream of route %s for '%s' exited", route.Name, value)
+			r.forget(b)
+		}()
+	}
+}
+
+// forget removes a backend, unless it has been replaced already
+func (r *Router) forget(b *backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.backends[b.key] == b {
+		delete(r.backends, b.key)
+	}
+}
+
+// render substitutes value for {value} in templates
+func render(templates []string, value string) []string {
+	if templates == nil {
+		return nil
+	}
+	rendered := make([]string, len(templates))
+	for i, t := range templates {
+		rendered[i] = strings.ReplaceAll(t, "{value}", value)
+	}
+	return rendered
+}
+
+// renderURL substitutes value, escaped, for {value} in a URL template
+func renderURL(template, value string) string {
+	return strings.ReplaceAll(template, "{value}", url.PathEscape(value))
+}
@@ -0,0 +1,336 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+var testConfig = config.RoutingConfig{
+	Arguments: []string{"connection", "database"},
+	Routes: []config.RouteConfig{
+		{Name: "analytics", Match: []string{"analytics"}, URL: "http://analytics:8891/{value}/mcp"},
+		{Name: "dev", Match: []string{"dev*", "staging"}, ExePath: "./mcp_sqlpp", Env: []string{"SQLPP_CONNECTION={value}"}},
+	},
+}
+
+// fake is a route upstream that records what it receives. tools/call results
+// name the upstream's value; progress reports the progress token received.
+// Calls wait for hold when it is set, and dev-mute never answers initialize.
+type fake struct {
+	value   string
+	handler upstream.MessageHandler
+	hold    chan struct{}
+
+	mu       sync.Mutex
+	messages []*mcp.Message
+	closed   bool
+}
+
+func (f *fake) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	f.mu.Lock()
+	f.messages = append(f.messages, req)
+	f.mu.Unlock()
+	if req.Method == "initialize" && f.value == "dev-mute" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if req.Method == "tools/call" {
+		if f.hold != nil {
+			<-f.hold
+		}
+		var params struct {
+			Meta struct {
+				ProgressToken json.RawMessage `json:"progressToken"`
+			} `json:"_meta"`
+		}
+		json.Unmarshal(req.Params, &params)
+		if params.Meta.ProgressToken != nil {
+			progress, _ := mcp.NewNotification("notifications/progress", map[string]interface{}{"progressToken": params.Meta.ProgressToken, "progress": 1})
+			f.handler(progress)
+		}
+	}
+	return mcp.NewResult(req.ID, map[string]string{"value": f.value})
+}
+
+func (f *fake) Send(ctx context.Context, msg *mcp.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+func (f *fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fake) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func (f *fake) methods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var methods []string
+	for _, msg := range f.messages {
+		methods = append(methods, msg.Method)
+	}
+	return methods
+}
+
+// newRouter creates a router connecting fakes, recorded by route and value.
+// Fakes for dev-busy wait for hold to be closed before answering calls.
+func newRouter(t *testing.T, cfg config.RoutingConfig, hold chan struct{}) (*Router, map[string]*fake) {
+	r := New(cfg, newTestLogger(t))
+	var mu sync.Mutex
+	fakes := make(map[string]*fake)
+	r.connect = func(route config.RouteConfig, value string, handler upstream.MessageHandler) (upstream.Upstream, error) {
+		if value == "dev-broken" {
+			return nil, errors.New("spawn failed")
+		}
+		f := &fake{value: value, handler: handler}
+		if value == "dev-busy" {
+			f.hold = hold
+		}
+		mu.Lock()
+		fakes[route.Name+" "+value] = f
+		mu.Unlock()
+		return f, nil
+	}
+	return r, fakes
+}
+
+func toolCall(t *testing.T, id string, args map[string]interface{}) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage(id), "tools/call", map[string]interface{}{"name": "execute", "arguments": args})
+	require.NoError(t, err)
+	return req
+}
+
+func TestRouting(t *testing.T) {
+	r, fakes := newRouter(t, testConfig, nil)
+	var passed []*mcp.Message
+	handler := r.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		passed = append(passed, req)
+		return mcp.NewResult(req.ID, map[string]string{"value": "default"})
+	})
+	s := proxy.NewSession("test", nil)
+	ctx := context.Background()
+
+	initialize, err := mcp.NewRequest(json.RawMessage("1"), "initialize", map[string]string{"protocolVersion": "2025-03-26"})
+	require.NoError(t, err)
+	_, err = handler(ctx, s, initialize)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		args  map[string]interface{}
+		value string
+	}{
+		{map[string]interface{}{"connection": "dev1", "sql": "SELECT 1"}, "dev1"},
+		{map[string]interface{}{"connection": "dev1"}, "dev1"},
+		{map[string]interface{}{"database": "analytics"}, "analytics"},
+		{map[string]interface{}{"connection": "staging", "database": "analytics"}, "staging"},
+		{map[string]interface{}{"connection": "prod"}, "default"},
+		{map[string]interface{}{"connection": "prod", "database": "analytics"}, "default"},
+		{map[string]interface{}{"connection": 7}, "default"},
+		{map[string]interface{}{}, "default"},
+	} {
+		resp, err := handler(ctx, s, toolCall(t, `"a"`, tc.args))
+		require.NoError(t, err)
+		assert.Equal(t, `"a"`, string(resp.ID))
+		assert.JSONEq(t, `{"value":"`+tc.value+`"}`, string(resp.Result), "%v", tc.args)
+	}
+
+	require.Len(t, fakes, 3, "one upstream per route and value")
+	assert.Equal(t, []string{"initialize", "notifications/initialized", "tools/call", "tools/call"}, fakes["dev dev1"].methods())
+	assert.JSONEq(t, `{"protocolVersion":"2025-03-26"}`, string(fakes["dev dev1"].messages[0].Params),
+		"upstreams are initialized like the client initialized the proxy")
+	assert.Len(t, passed, 5, "initialize and unrouted calls take the usual path")
+
+	require.NoError(t, r.Close())
+	assert.True(t, fakes["analytics analytics"].closed)
+}
+
+func TestRouteUnavailable(t *testing.T) {
+	r, fakes := newRouter(t, testConfig, nil)
+	handler := r.Middleware()(nil)
+
+	_, err := handler(context.Background(), proxy.NewSession("test", nil), toolCall(t, "1", map[string]interface{}{"connection": "dev-broken"}))
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, mcp.InternalError, rpcErr.Code)
+	assert.Contains(t, rpcErr.Message, "spawn failed")
+	assert.Empty(t, fakes)
+	assert.Empty(t, r.backends, "failed upstreams are retried on the next call")
+}
+
+func TestRouteProgress(t *testing.T) {
+	r, fakes := newRouter(t, testConfig, nil)
+	handler := r.Middleware()(nil)
+	var notified []*mcp.Message
+	ctx := proxy.WithSender(context.Background(), func(msg *mcp.Message) error {
+		notified = append(notified, msg)
+		return nil
+	})
+
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{
+		"name":      "execute",
+		"arguments": map[string]string{"connection": "dev1"},
+		"_meta":     map[string]int{"progressToken": 7},
+	})
+	require.NoError(t, err)
+	_, err = handler(ctx, proxy.NewSession("test", nil), req)
+	require.NoError(t, err)
+
+	sent := fakes["dev dev1"].messages[2]
+	assert.JSONEq(t, `{"name":"execute","arguments":{"connection":"dev1"},"_meta":{"progressToken":"route-1"}}`, string(sent.Params),
+		"upstreams shared by sessions see tokens of the router's own")
+	require.Len(t, notified, 1)
+	assert.JSONEq(t, `{"progressToken":7,"progress":1}`, string(notified[0].Params))
+	for _, b := range r.backends {
+		assert.Empty(t, b.up.progress)
+	}
+}
+
+func TestRouteLimit(t *testing.T) {
+	cfg := testConfig
+	cfg.MaxBackends = 2
+	hold := make(chan struct{})
+	r, fakes := newRouter(t, cfg, hold)
+	handler := r.Middleware()(nil)
+	s := proxy.NewSession("test", nil)
+	ctx := context.Background()
+
+	busy := make(chan error, 1)
+	go func() {
+		_, err := handler(ctx, s, toolCall(t, "1", map[string]interface{}{"connection": "dev-busy"}))
+		busy <- err
+	}()
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.backends) == 1
+	}, time.Second, time.Millisecond)
+
+	_, err := handler(ctx, s, toolCall(t, "2", map[string]interface{}{"connection": "dev1"}))
+	require.NoError(t, err)
+	_, err = handler(ctx, s, toolCall(t, "3", map[string]interface{}{"connection": "dev2"}))
+	require.NoError(t, err)
+	assert.True(t, fakes["dev dev1"].isClosed(), "the idle upstream unused for longest makes room")
+	assert.False(t, fakes["dev dev-busy"].isClosed(), "upstreams with calls in progress are kept")
+	assert.Len(t, r.backends, 2)
+
+	_, err = handler(ctx, s, toolCall(t, "4", map[string]interface{}{"connection": "dev2"}))
+	require.NoError(t, err, "open upstreams take calls at the limit")
+
+	_, err = handler(ctx, s, toolCall(t, "5", map[string]interface{}{"connection": "dev3"}))
+	require.NoError(t, err)
+	assert.True(t, fakes["dev dev2"].isClosed())
+
+	close(hold)
+	require.NoError(t, <-busy)
+	require.NoError(t, r.Close())
+}
+
+func TestRouteLimitBusy(t *testing.T) {
+	cfg := testConfig
+	cfg.MaxBackends = 1
+	hold := make(chan struct{})
+	r, fakes := newRouter(t, cfg, hold)
+	handler := r.Middleware()(nil)
+	s := proxy.NewSession("test", nil)
+
+	busy := make(chan error, 1)
+	go func() {
+		_, err := handler(context.Background(), s, toolCall(t, "1", map[string]interface{}{"connection": "dev-busy"}))
+		busy <- err
+	}()
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.backends) == 1
+	}, time.Second, time.Millisecond)
+
+	_, err := handler(context.Background(), s, toolCall(t, "2", map[string]interface{}{"connection": "dev1"}))
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, mcp.InternalError, rpcErr.Code)
+	assert.Contains(t, rpcErr.Message, "too many upstreams open")
+	close(hold)
+	require.NoError(t, <-busy)
+	_, ok := fakes["dev dev1"]
+	assert.False(t, ok, "no upstream is opened past the limit while all are busy")
+	require.NoError(t, r.Close())
+}
+
+func TestRouteExpire(t *testing.T) {
+	cfg := testConfig
+	cfg.IdleTimeout = time.Minute
+	r, fakes := newRouter(t, cfg, nil)
+	handler := r.Middleware()(nil)
+
+	for _, value := range []string{"dev1", "dev2"} {
+		_, err := handler(context.Background(), proxy.NewSession("test", nil), toolCall(t, "1", map[string]interface{}{"connection": value}))
+		require.NoError(t, err)
+	}
+	r.mu.Lock()
+	for _, b := range r.backends {
+		if b.name == "dev for 'dev1'" {
+			b.lastUsed = time.Now().Add(-2 * time.Minute)
+		}
+	}
+	r.mu.Unlock()
+
+	r.Expire()
+	assert.True(t, fakes["dev dev1"].isClosed(), "upstreams idle past the timeout are closed")
+	assert.False(t, fakes["dev dev2"].isClosed())
+	assert.Len(t, r.backends, 1)
+	require.NoError(t, r.Close())
+}
+
+func TestRouteInitTimeout(t *testing.T) {
+	cfg := testConfig
+	cfg.InitTimeout = 10 * time.Millisecond
+	r, fakes := newRouter(t, cfg, nil)
+	handler := r.Middleware()(nil)
+
+	_, err := handler(context.Background(), proxy.NewSession("test", nil), toolCall(t, "1", map[string]interface{}{"connection": "dev-mute"}))
+	var rpcErr *mcp.Error
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Contains(t, rpcErr.Message, "no answer to initialize within 10ms")
+	assert.True(t, fakes["dev dev-mute"].isClosed(), "upstreams failing the handshake are closed")
+	assert.Empty(t, r.backends)
+}
+
+func TestRender(t *testing.T) {
+	assert.Equal(t, []string{"--connection", "dev1"}, render([]string{"--connection", "{value}"}, "dev1"))
+	assert.Nil(t, render(nil, "dev1"))
+	assert.Equal(t, "http://db:8891/a%2Fb/mcp", renderURL("http://db:8891/{value}/mcp", "a/b"))
+}
@@ -0,0 +1,222 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// Shared is an upstream the proxy opens and initializes on its own and shares
+// among all sessions. Calls reach it under progress tokens of its own, so that
+// progress goes to the right client, and requests it makes are refused, as
+// there is no one client to pass them to.
+type Shared struct {
+	name   string // Describes the upstream in logs and prefixes its progress tokens
+	logger *logging.Logger
+	ready  chan struct{} // Closed once the upstream is initialized or failed to
+	up     upstream.Upstream
+
+	mu        sync.Mutex
+	nextToken int64
+	progress  map[string]*progressTarget // Calls reporting progress, by the token the upstream saw
+}
+
+// progressTarget is the client call a rewritten progress token belongs to
+type progressTarget struct {
+	ctx   context.Context // Carries the sender of the client that made the call
+	token json.RawMessage // The client's own token
+}
+
+// defaultInitTimeout bounds the handshake of shared upstreams opened without
+// a timeout of their own
+const defaultInitTimeout = 30 * time.Second
+
+// OpenShared connects an upstream and initializes its session with params,
+// or defaultInitialize when there are none. An upstream that does not
+// complete the handshake within timeout, or defaultInitTimeout when 0, is
+// closed.
+func OpenShared(name string, connect func(handler upstream.MessageHandler) (upstream.Upstream, error), params json.RawMessage, timeout time.Duration, logger *logging.Logger) (*Shared, error) {
+	s := &Shared{
+		name:     name,
+		logger:   logger,
+		ready:    make(chan struct{}),
+		progress: make(map[string]*progressTarget),
+	}
+	defer close(s.ready)
+
+	up, err := connect(s.fromUpstream)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) == 0 {
+		params = json.RawMessage(defaultInitialize)
+	}
+	if timeout <= 0 {
+		timeout = defaultInitTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := initialize(ctx, up, params); err != nil {
+		up.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("no answer to initialize within %s", timeout)
+		}
+		return nil, err
+	}
+	s.up = up
+	return s, nil
+}
+
+// Call sends a request to the upstream, under a progress token of its own
+// when the request has one
+func (s *Shared) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	out, token, err := s.rewriteProgress(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		defer func() {
+			s.mu.Lock()
+			delete(s.progress, token)
+			s.mu.Unlock()
+		}()
+	}
+
+	resp, err := s.up.Call(ctx, out)
+	if resp != nil {
+		resp.ID = req.ID
+	}
+	return resp, err
+}
+
+// Ping sends an MCP ping, turning an error response into an error
+func (s *Shared) Ping(ctx context.Context) error {
+	req, err := mcp.NewRequest(json.RawMessage(`"ping"`), "ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.up.Call(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("ping failed: %s", resp.Error.Message)
+	}
+	return nil
+}
+
+// Done returns a channel closed when a spawned upstream exits, or nil for
+// upstreams that are not processes
+func (s *Shared) Done() <-chan struct{} {
+	if process, ok := s.up.(interface{ Done() <-chan struct{} }); ok {
+		return process.Done()
+	}
+	return nil
+}
+
+// Close closes the upstream
+func (s *Shared) Close() error {
+	return s.up.Close()
+}
+
+// initialize runs the MCP handshake with a shared upstream
+func initialize(ctx context.Context, up upstream.Upstream, params json.RawMessage) error {
+	req := &mcp.Message{JSONRPC: "2.0", ID: json.RawMessage(`"initialize"`), Method: "initialize", Params: params}
+	resp, err := up.Call(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("initialize failed: %s", resp.Error.Message)
+	}
+	initialized, err := mcp.NewNotification("notifications/initialized", nil)
+	if err != nil {
+		return err
+	}
+	return up.Send(ctx, initialized)
+}
+
+// rewriteProgress replaces the progress token of a call with one of the
+// upstream's own, and records where progress reported under it goes
+func (s *Shared) rewriteProgress(ctx context.Context, req *mcp.Message) (*mcp.Message, string, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return req, "", nil
+	}
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(params["_meta"], &meta); err != nil || meta["progressToken"] == nil {
+		return req, "", nil
+	}
+
+	s.mu.Lock()
+	s.nextToken++
+	token := fmt.Sprintf("%s-%d", s.name, s.nextToken)
+	s.progress[token] = &progressTarget{ctx: ctx, token: meta["progressToken"]}
+	s.mu.Unlock()
+
+	var err error
+	if meta["progressToken"], err = json.Marshal(token); err != nil {
+		return nil, "", err
+	}
+	if params["_meta"], err = json.Marshal(meta); err != nil {
+		return nil, "", err
+	}
+	out := req.Clone()
+	if out.Params, err = json.Marshal(params); err != nil {
+		return nil, "", err
+	}
+	return out, token, nil
+}
+
+// fromUpstream handles a message the upstream sends on its own initiative.
+// Progress goes to the call it belongs to; requests are refused.
+func (s *Shared) fromUpstream(msg *mcp.Message) {
+	if msg.IsRequest() {
+		// Answered once the upstream is ready, as it may still be initializing
+		go func() {
+			<-s.ready
+			if s.up == nil {
+				return
+			}
+			resp := mcp.NewErrorResponse(msg.ID, mcp.NewError(mcp.MethodNotFound, "Method not found: %s", msg.Method))
+			if err := s.up.Send(context.Background(), resp); err != nil {
+				s.logger.Errorf("Failed to refuse %s from %s upstream: %v", msg.Method, s.name, err)
+			}
+		}()
+		return
+	}
+	if msg.Method != "notifications/progress" {
+		s.logger.Debugf("Dropped %s from %s upstream", msg.Method, s.name)
+		return
+	}
+
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return
+	}
+	var token string
+	json.Unmarshal(params["progressToken"], &token)
+	s.mu.Lock()
+	target, ok := s.progress[token]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	params["progressToken"] = target.token
+	data, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	out := msg.Clone()
+	out.Params = data
+	if err := proxy.Notify(target.ctx, out); err != nil {
+		s.logger.Debugf("Dropped progress of a call to %s upstream: %v", s.name, err)
+	}
+}
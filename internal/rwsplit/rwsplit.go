@@ -0,0 +1,286 @@
+package rwsplit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/route"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+	"gosqlpp-mcp-proxy/internal/upstream"
+)
+
+// Splitter sends tools/call requests whose SQL only reads to an upstream
+// pointed at a read replica, shared by all sessions. Everything else takes
+// the usual path to the primary, as do reads while the replica is unhealthy
+// and reads of a session that has just written, which the replica may not
+// have caught up with.
+type Splitter struct {
+	extractor         *sqlpolicy.Extractor
+	primaryAfterWrite time.Duration
+	check             config.HealthCheckConfig
+	logger            *logging.Logger
+	open              func() (*route.Shared, error)
+	checking          atomic.Bool
+
+	mu        sync.Mutex
+	replica   *route.Shared // nil until opened, and again once it failed
+	healthy   bool
+	failures  int // Consecutive failures
+	successes int // Consecutive successful health checks
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// lastWriteKey is the session value holding the time the session last wrote
+type lastWriteKey struct{}
+
+// New creates a splitter for the configured replica. SQL is found in the
+// arguments named by tools, as for the SQL policy.
+func New(cfg config.RWSplitConfig, tools []config.SQLToolConfig, logger *logging.Logger) *Splitter {
+	sp := &Splitter{
+		extractor:         sqlpolicy.NewExtractor(tools),
+		primaryAfterWrite: cfg.PrimaryAfterWrite,
+		check:             cfg.HealthCheck,
+		logger:            logger,
+		healthy:           true,
+		stop:              make(chan struct{}),
+	}
+	sp.open = func() (*route.Shared, error) {
+		return route.OpenShared("replica", func(handler upstream.MessageHandler) (upstream.Upstream, error) {
+			if cfg.Replica.URL != "" {
+				return upstream.NewHTTP(cfg.Replica.URL, handler), nil
+			}
+			return upstream.StartStdio(upstream.StdioOptions{
+				ExePath: cfg.Replica.ExePath,
+				Args:    cfg.Replica.Args,
+				Env:     cfg.Replica.Env,
+				Handler: handler,
+				Logger:  logger,
+			})
+		}, nil, 0, logger)
+	}
+	return sp
+}
+
+// Middleware returns the splitter as proxy middleware. It takes the place of
+// the upstream for reads, so it should come last.
+func (sp *Splitter) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method != "tools/call" {
+				return next(ctx, s, req)
+			}
+			call, err := mcp.ParseToolCall(req)
+			if err != nil {
+				return next(ctx, s, req)
+			}
+
+			switch sp.classify(call) {
+			case write:
+				s.SetValue(lastWriteKey{}, time.Now())
+			case read:
+				if replica := sp.pick(s); replica != nil {
+					resp, err := replica.Call(ctx, req)
+					if err == nil || ctx.Err() != nil {
+						proxy.Note(ctx, "upstream", "replica")
+						return resp, err
+					}
+					// Reads are safe to send again
+					sp.failed(replica, err)
+					sp.logger.Errorf("Read with %s failed on the replica, retrying on the primary: %v", call.Name, err)
+				}
+			}
+			proxy.Note(ctx, "upstream", "primary")
+			return next(ctx, s, req)
+		}
+	}
+}
+
+// Kinds of calls told apart by their SQL
+const (
+	other = iota // No SQL
+	read         // Only reads
+	write        // Anything else
+)
+
+// classify tells whether a call reads, writes or holds no SQL
+func (sp *Splitter) classify(call *mcp.ToolCall) int {
+	kind := other
+	for _, text := range sp.extractor.Find(call) {
+		for _, stmt := range sqlpolicy.Parse(text.SQL) {
+			if stmt.Class != sqlpolicy.ClassSelect {
+				return write
+			}
+			kind = read
+		}
+	}
+	return kind
+}
+
+// pick returns the replica if it may serve a read of a session
+func (sp *Splitter) pick(s *proxy.Session) *route.Shared {
+	if wrote, ok := s.Value(lastWriteKey{}).(time.Time); ok && time.Since(wrote) < sp.primaryAfterWrite {
+		return nil
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.replica == nil && sp.check.Interval <= 0 {
+		// Without health checks, the replica is reopened on demand
+		go sp.Check()
+	}
+	if !sp.healthy {
+		return nil
+	}
+	return sp.replica
+}
+
+// Start opens the replica in the background and runs health checks on it
+// until Close. Without health checks, the replica is only opened.
+func (sp *Splitter) Start() {
+	sp.wg.Add(1)
+	go func() {
+		defer sp.wg.Done()
+		if sp.check.Interval <= 0 {
+			sp.Check()
+			return
+		}
+		ticker := time.NewTicker(sp.check.Interval)
+		defer ticker.Stop()
+		for {
+			sp.Check()
+			select {
+			case <-ticker.C:
+			case <-sp.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the health checks and closes the replica
+func (sp *Splitter) Close() error {
+	close(sp.stop)
+	sp.wg.Wait()
+	sp.mu.Lock()
+	replica := sp.replica
+	sp.replica = nil
+	sp.mu.Unlock()
+	if replica != nil {
+		return replica.Close()
+	}
+	return nil
+}
+
+// Check pings the replica, first opening it unless it is open, and ejects or
+// readmits it once the thresholds are reached. Checks do not overlap.
+func (sp *Splitter) Check() {
+	if !sp.checking.CompareAndSwap(false, true) {
+		return
+	}
+	defer sp.checking.Store(false)
+
+	ctx := context.Background()
+	if sp.check.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sp.check.Timeout)
+		defer cancel()
+	}
+	err := sp.ping(ctx)
+	if err != nil {
+		sp.logger.Debugf("Health check of the replica failed: %v", err)
+		sp.record(err)
+		return
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.failures = 0
+	sp.successes++
+	if !sp.healthy && sp.successes >= sp.check.HealthyThreshold {
+		sp.healthy = true
+		sp.logger.Infof("Reads go to the replica again after %d successful health checks", sp.successes)
+	}
+}
+
+// ping sends an MCP ping to the replica, opening it first if need be. A
+// replica that fails is dropped, so that the next check opens it again.
+func (sp *Splitter) ping(ctx context.Context) error {
+	sp.mu.Lock()
+	replica := sp.replica
+	sp.mu.Unlock()
+
+	if replica == nil {
+		var err error
+		if replica, err = sp.open(); err != nil {
+			return err
+		}
+		sp.mu.Lock()
+		select {
+		case <-sp.stop:
+			sp.mu.Unlock()
+			replica.Close()
+			return upstream.ErrClosed
+		default:
+		}
+		sp.replica = replica
+		sp.mu.Unlock()
+		sp.logger.Infof("Opened the replica upstream")
+
+		if done := replica.Done(); done != nil {
+			go func() {
+				<-done
+				sp.logger.Infof("The replica upstream exited")
+				sp.drop(replica)
+			}()
+		}
+	}
+
+	if err := replica.Ping(ctx); err != nil {
+		sp.drop(replica)
+		return err
+	}
+	return nil
+}
+
+// failed counts a read the replica failed to serve
+func (sp *Splitter) failed(replica *route.Shared, err error) {
+	if errors.Is(err, upstream.ErrClosed) {
+		sp.drop(replica)
+	}
+	sp.record(err)
+}
+
+// record counts a failure, ejecting the replica at the threshold. Only health
+// checks readmit it, so failures are not counted without them.
+func (sp *Splitter) record(err error) {
+	if sp.check.Interval <= 0 {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.successes = 0
+	sp.failures++
+	if sp.healthy && sp.failures >= sp.check.UnhealthyThreshold {
+		sp.healthy = false
+		sp.logger.Errorf("Reads go to the primary after %d failures of the replica: %v", sp.failures, err)
+	}
+}
+
+// drop forgets a failed replica, unless it has been replaced already, and
+// closes it in the background, as it may not answer
+func (sp *Splitter) drop(replica *route.Shared) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.replica == replica {
+		sp.replica = nil
+		go replica.Close()
+	}
+}
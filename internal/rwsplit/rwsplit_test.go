@@ -0,0 +1,183 @@
+package rwsplit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/route"
+	"gosqlpp-mcp-proxy/internal/upstream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// replica is a fake replica upstream answering with its name until it fails
+type replica struct {
+	mu      sync.Mutex
+	failing bool
+	methods []string
+}
+
+func (r *replica) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.methods = append(r.methods, req.Method)
+	if r.failing {
+		return nil, errors.New("connection refused")
+	}
+	return mcp.NewResult(req.ID, map[string]string{"upstream": "replica"})
+}
+
+func (r *replica) Send(ctx context.Context, msg *mcp.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.methods = append(r.methods, msg.Method)
+	return nil
+}
+
+func (r *replica) Close() error { return nil }
+
+func (r *replica) fail(failing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing = failing
+}
+
+// newSplitter creates a splitter whose replica is the fake, and a handler
+// answering from the primary otherwise
+func newSplitter(t *testing.T, primaryAfterWrite time.Duration) (*Splitter, *replica, proxy.Handler) {
+	cfg := config.DefaultConfig().RWSplit
+	cfg.Replica.URL = "http://replica:8891/mcp"
+	cfg.PrimaryAfterWrite = primaryAfterWrite
+	cfg.HealthCheck.UnhealthyThreshold = 2
+	cfg.HealthCheck.HealthyThreshold = 1
+	sp := New(cfg, nil, newTestLogger(t))
+
+	fake := &replica{}
+	sp.open = func() (*route.Shared, error) {
+		if fake.failing {
+			return nil, errors.New("connection refused")
+		}
+		return route.OpenShared("replica", func(upstream.MessageHandler) (upstream.Upstream, error) {
+			return fake, nil
+		}, nil, 0, sp.logger)
+	}
+	handler := sp.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return mcp.NewResult(req.ID, map[string]string{"upstream": "primary"})
+	})
+	return sp, fake, handler
+}
+
+// upstreamOf sends a tools/call with sql and returns which upstream answered
+func upstreamOf(t *testing.T, handler proxy.Handler, s *proxy.Session, sql string) string {
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{
+		"name":      "execute_sql",
+		"arguments": map[string]string{"sql": sql},
+	})
+	require.NoError(t, err)
+	resp, err := handler(context.Background(), s, req)
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(resp.ID))
+	var result map[string]string
+	require.NoError(t, json.Unmarshal(resp.Result, &result))
+	return result["upstream"]
+}
+
+func TestSplit(t *testing.T) {
+	sp, fake, handler := newSplitter(t, 0)
+	s := proxy.NewSession("test", nil)
+	assert.Equal(t, "primary", upstreamOf(t, handler, s, "SELECT 1"), "the replica is not open yet")
+
+	sp.Check()
+	assert.Equal(t, []string{"initialize", "notifications/initialized", "ping"}, fake.methods)
+
+	for sql, want := range map[string]string{
+		"SELECT * FROM orders":                           "replica",
+		"select 1; WITH t AS (SELECT 1) SELECT * FROM t": "replica",
+		"UPDATE orders SET paid = true":                  "primary",
+		"SELECT 1; DELETE FROM orders":                   "primary",
+		"BEGIN; SELECT 1; COMMIT":                        "primary",
+		"CREATE TABLE t (id int)":                        "primary",
+		"":                                               "primary",
+	} {
+		assert.Equal(t, want, upstreamOf(t, handler, s, sql), sql)
+	}
+
+	list, err := mcp.NewRequest(json.RawMessage("2"), "tools/list", nil)
+	require.NoError(t, err)
+	resp, err := handler(context.Background(), s, list)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"upstream":"primary"}`, string(resp.Result))
+	require.NoError(t, sp.Close())
+}
+
+func TestPrimaryAfterWrite(t *testing.T) {
+	sp, _, handler := newSplitter(t, time.Hour)
+	sp.Check()
+	writer, other := proxy.NewSession("writer", nil), proxy.NewSession("other", nil)
+
+	assert.Equal(t, "replica", upstreamOf(t, handler, writer, "SELECT 1"))
+	assert.Equal(t, "primary", upstreamOf(t, handler, writer, "INSERT INTO orders VALUES (1)"))
+	assert.Equal(t, "primary", upstreamOf(t, handler, writer, "SELECT 1"), "the session reads its own writes")
+	assert.Equal(t, "replica", upstreamOf(t, handler, other, "SELECT 1"))
+
+	writer.SetValue(lastWriteKey{}, time.Now().Add(-2*time.Hour))
+	assert.Equal(t, "replica", upstreamOf(t, handler, writer, "SELECT 1"))
+}
+
+func TestFallback(t *testing.T) {
+	sp, fake, handler := newSplitter(t, 0)
+	sp.Check()
+	s := proxy.NewSession("test", nil)
+
+	fake.fail(true)
+	assert.Equal(t, "primary", upstreamOf(t, handler, s, "SELECT 1"), "failed reads are retried on the primary")
+	assert.True(t, sp.healthy, "one failure is below the threshold")
+	sp.Check()
+	assert.False(t, sp.healthy)
+	assert.Nil(t, sp.replica, "the failed replica is dropped")
+
+	fake.mu.Lock()
+	calls := len(fake.methods)
+	fake.mu.Unlock()
+	assert.Equal(t, "primary", upstreamOf(t, handler, s, "SELECT 1"))
+	assert.Len(t, fake.methods, calls, "reads skip the ejected replica")
+
+	fake.fail(false)
+	sp.Check()
+	assert.True(t, sp.healthy, "the reopened replica is readmitted")
+	assert.Equal(t, "replica", upstreamOf(t, handler, s, "SELECT 1"))
+}
+
+func TestStart(t *testing.T) {
+	sp, fake, _ := newSplitter(t, 0)
+	sp.check.Interval = 10 * time.Millisecond
+
+	sp.Start()
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.methods) >= 4
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, sp.Close())
+	assert.Nil(t, sp.replica)
+}
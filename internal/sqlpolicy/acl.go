@@ -0,0 +1,97 @@
+package sqlpolicy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+)
+
+// ACL decides which tables each principal may reference. Principals are who
+// the session authenticated as, never the name a client gives itself. The
+// first rule whose principal patterns match decides; principals no rule
+// matches are denied every table unless the default is allow.
+type ACL struct {
+	defaultSchema  string
+	allowUnmatched bool
+	rules          []config.SQLACLRule
+}
+
+// Decision is the outcome of checking one table against the ACL
+type Decision struct {
+	Table   string // Name the patterns were matched against
+	Allowed bool
+	Rule    string // Rule that decided; empty if no rule matches the principal
+	Pattern string // Pattern that decided; empty if the rule has no matching pattern
+}
+
+// NewACL creates an ACL from the configuration. Unnamed rules are named
+// rule-N after their position. Patterns are matched case-insensitively.
+func NewACL(cfg config.SQLACLConfig) *ACL {
+	rules := make([]config.SQLACLRule, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		rule.Allow = lower(rule.Allow)
+		rule.Deny = lower(rule.Deny)
+		rules[i] = rule
+	}
+	return &ACL{
+		defaultSchema:  strings.ToLower(cfg.DefaultSchema),
+		allowUnmatched: cfg.Default == config.AccessAllow,
+		rules:          rules,
+	}
+}
+
+// Decide checks whether a principal may reference a table. Unqualified table
+// names are qualified with the default schema, if one is configured.
+func (a *ACL) Decide(principal, table string) Decision {
+	if a.defaultSchema != "" && !strings.Contains(table, ".") {
+		table = a.defaultSchema + "." + table
+	}
+	d := Decision{Table: table, Allowed: true}
+
+	rule := a.ruleFor(principal)
+	if rule == nil {
+		d.Allowed = a.allowUnmatched
+		return d
+	}
+	d.Rule = rule.Name
+	if p := matchFirst(rule.Deny, table); p != "" {
+		d.Allowed, d.Pattern = false, p
+	} else if len(rule.Allow) > 0 {
+		d.Pattern = matchFirst(rule.Allow, table)
+		d.Allowed = d.Pattern != ""
+	}
+	return d
+}
+
+func (a *ACL) ruleFor(principal string) *config.SQLACLRule {
+	for i := range a.rules {
+		rule := &a.rules[i]
+		if len(rule.Principals) == 0 || matchFirst(rule.Principals, principal) != "" {
+			return rule
+		}
+	}
+	return nil
+}
+
+// matchFirst returns the first pattern matching name, or "" if none does
+func matchFirst(patterns []string, name string) string {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return p
+		}
+	}
+	return ""
+}
+
+func lower(patterns []string) []string {
+	out := make([]string, len(patterns))
+	for i, p := range patterns {
+		out[i] = strings.ToLower(p)
+	}
+	return out
+}
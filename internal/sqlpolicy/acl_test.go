@@ -0,0 +1,107 @@
+package sqlpolicy
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newACLConfig() config.SQLACLConfig {
+	return config.SQLACLConfig{
+		DefaultSchema: "public",
+		Rules: []config.SQLACLRule{
+			{Name: "support", Principals: []string{"support-*"}, Deny: []string{"HR.*"}},
+			{Principals: []string{"report-*"}, Allow: []string{"sales.*", "public.*"}, Deny: []string{"sales.secret_*"}},
+			{Principals: []string{"admin"}},
+		},
+	}
+}
+
+func TestDecide(t *testing.T) {
+	acl := NewACL(newACLConfig())
+
+	assert.Equal(t, Decision{Table: "hr.salaries", Rule: "support", Pattern: "hr.*"}, acl.Decide("support-bot", "hr.salaries"))
+	assert.Equal(t, Decision{Table: "public.orders", Allowed: true, Rule: "support"}, acl.Decide("support-bot", "orders"))
+
+	assert.Equal(t, Decision{Table: "sales.orders", Allowed: true, Rule: "rule-2", Pattern: "sales.*"}, acl.Decide("report-x", "sales.orders"))
+	assert.Equal(t, Decision{Table: "sales.secret_plans", Rule: "rule-2", Pattern: "sales.secret_*"}, acl.Decide("report-x", "sales.secret_plans"))
+	assert.Equal(t, Decision{Table: "hr.salaries", Rule: "rule-2"}, acl.Decide("report-x", "hr.salaries"), "not in the allow list")
+
+	assert.True(t, acl.Decide("admin", "hr.salaries").Allowed)
+	assert.Equal(t, Decision{Table: "hr.salaries"}, acl.Decide("other", "hr.salaries"), "principals no rule matches are denied")
+
+	cfg := newACLConfig()
+	cfg.Default = config.AccessAllow
+	assert.Equal(t, Decision{Table: "hr.salaries", Allowed: true}, NewACL(cfg).Decide("other", "hr.salaries"))
+}
+
+func TestMiddlewareMatchesPrincipal(t *testing.T) {
+	p := New(config.SQLPolicyConfig{ACL: newACLConfig()}, newTestLogger(t))
+	handler := p.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return mcp.NewResult(req.ID, map[string]interface{}{})
+	})
+	s := proxy.NewSession("test", nil)
+	s.SetClient(proxy.ClientInfo{Name: "admin"})
+	s.SetPrincipal("support-bot")
+
+	_, err := handler(context.Background(), s, newCallRequest(t, "execute_sql", `{"sql":"SELECT * FROM hr.salaries"}`))
+	require.Error(t, err, "the name a client gives itself does not select the rule")
+
+	s.SetPrincipal("admin")
+	_, err = handler(context.Background(), s, newCallRequest(t, "execute_sql", `{"sql":"SELECT * FROM hr.salaries"}`))
+	assert.NoError(t, err)
+}
+
+func TestCheckACL(t *testing.T) {
+	logger := newTestLogger(t)
+	p := New(config.SQLPolicyConfig{ACL: newACLConfig()}, logger)
+
+	assert.Nil(t, p.Check(context.Background(), "support-bot", newCall("execute_sql", `{"sql":"SELECT * FROM orders JOIN customers USING (id)"}`)))
+	assert.Nil(t, p.Check(context.Background(), "support-bot", newCall("execute_sql", `{"sql":"DELETE FROM orders"}`)), "ACLs do not restrict statement types")
+
+	err := p.Check(context.Background(), "support-bot", newCall("execute_sql", `{"sql":"SELECT 1; SELECT * FROM orders o JOIN HR.Salaries s ON o.id = s.id"}`))
+	require.NotNil(t, err)
+	assert.Equal(t, mcp.PolicyDenied, err.Code)
+	assert.Equal(t, "Access denied: statement 2 of 2 in argument 'sql' references table 'hr.salaries', which is not available to principal 'support-bot'", err.Message)
+	assert.JSONEq(t, `{"policy":"acl","argument":"sql","statement":2,"table":"hr.salaries","rule":"support"}`, string(err.Data))
+
+	content, readErr := os.ReadFile(logger.GetFilePath())
+	require.NoError(t, readErr)
+	assert.Contains(t, string(content), "[AUDIT] ACL allow: principal='support-bot' tool=execute_sql argument=sql table=public.orders rule=support pattern=(none)")
+	assert.Contains(t, string(content), "[AUDIT] ACL deny: principal='support-bot' tool=execute_sql argument=sql table=hr.salaries rule=support pattern=hr.*")
+}
+
+func TestCheckACLNotes(t *testing.T) {
+	p := New(config.SQLPolicyConfig{ACL: newACLConfig()}, newTestLogger(t))
+
+	ctx, notes := proxy.WithNotes(context.Background())
+	err := p.Check(ctx, "support-bot", newCall("execute_sql", `{"sql":"SELECT * FROM orders JOIN hr.salaries USING (id)"}`))
+	require.NotNil(t, err)
+	assert.Equal(t, map[string]interface{}{"acl": []map[string]interface{}{
+		{"decision": "allow", "rule": "support", "table": "public.orders"},
+		{"decision": "deny", "rule": "support", "table": "hr.salaries"},
+	}}, notes.All(), "decisions up to the denial are noted for the audit record")
+
+	ctx, notes = proxy.WithNotes(context.Background())
+	assert.Nil(t, p.Check(ctx, "support-bot", newCall("describe_table", `{"table":"orders"}`)))
+	assert.Nil(t, notes.All(), "nothing is noted without decisions")
+}
+
+func TestCheckReadOnlyAndACL(t *testing.T) {
+	p := New(config.SQLPolicyConfig{ReadOnly: true, ACL: newACLConfig()}, newTestLogger(t))
+
+	err := p.Check(context.Background(), "admin", newCall("execute_sql", `{"sql":"DELETE FROM hr.salaries"}`))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Message, "Read-only policy")
+
+	err = p.Check(context.Background(), "report-x", newCall("execute_sql", `{"query":"SELECT * FROM hr.salaries"}`))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Message, "Access denied")
+}
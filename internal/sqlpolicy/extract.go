@@ -0,0 +1,124 @@
+package sqlpolicy
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// DefaultArguments are the argument paths searched for SQL when no tools are configured
+var DefaultArguments = []string{"sql", "query"}
+
+// SQLText is SQL found in a tools/call argument
+type SQLText struct {
+	Path string // Path of the argument, e.g. "sql" or "statements.1"
+	SQL  string
+}
+
+// Extractor finds SQL in tools/call arguments using the configured argument
+// paths per tool. Paths are dotted; * matches any key or array index, and an
+// argument holding an array of strings yields each string.
+type Extractor struct {
+	tools []config.SQLToolConfig
+}
+
+// NewExtractor creates an extractor. Without configured tools, the
+// DefaultArguments of every tool are searched.
+func NewExtractor(tools []config.SQLToolConfig) *Extractor {
+	if len(tools) == 0 {
+		tools = []config.SQLToolConfig{{Name: "*", Arguments: DefaultArguments}}
+	}
+	return &Extractor{tools: tools}
+}
+
+// Find returns the SQL in the arguments of a tool call, in argument order
+func (e *Extractor) Find(call *mcp.ToolCall) []SQLText {
+	paths := e.paths(call.Name)
+	if len(paths) == 0 || len(call.Arguments) == 0 {
+		return nil
+	}
+	var args interface{}
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return nil
+	}
+
+	var found []SQLText
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		walk(args, strings.Split(p, "."), "", func(at string, v interface{}) {
+			if seen[at] {
+				return
+			}
+			switch v := v.(type) {
+			case string:
+				seen[at] = true
+				found = append(found, SQLText{Path: at, SQL: v})
+			case []interface{}:
+				seen[at] = true
+				for i, item := range v {
+					if s, ok := item.(string); ok {
+						found = append(found, SQLText{Path: join(at, strconv.Itoa(i)), SQL: s})
+					}
+				}
+			}
+		})
+	}
+	return found
+}
+
+// Covers reports whether SQL is looked for in the arguments of a tool
+func (e *Extractor) Covers(tool string) bool {
+	return len(e.paths(tool)) > 0
+}
+
+// paths returns the argument paths of the first configured tool matching name
+func (e *Extractor) paths(name string) []string {
+	for _, tool := range e.tools {
+		if ok, _ := path.Match(tool.Name, name); ok {
+			return tool.Arguments
+		}
+	}
+	return nil
+}
+
+// walk calls fn with every value at the path segments below v
+func walk(v interface{}, segments []string, at string, fn func(string, interface{})) {
+	if len(segments) == 0 {
+		fn(at, v)
+		return
+	}
+	seg, rest := segments[0], segments[1:]
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if seg == "*" {
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				walk(v[key], rest, join(at, key), fn)
+			}
+		} else if child, ok := v[seg]; ok {
+			walk(child, rest, join(at, seg), fn)
+		}
+	case []interface{}:
+		for i, child := range v {
+			if seg == "*" || seg == strconv.Itoa(i) {
+				walk(child, rest, join(at, strconv.Itoa(i)), fn)
+			}
+		}
+	}
+}
+
+func join(at, seg string) string {
+	if at == "" {
+		return seg
+	}
+	return at + "." + seg
+}
@@ -0,0 +1,147 @@
+package sqlpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+)
+
+// Policy enforces rules on the SQL found in tools/call arguments. Calls that
+// break a rule are answered with a PolicyDenied error and never forwarded, and
+// so are calls whose params cannot be read, as the policy cannot inspect them.
+type Policy struct {
+	readOnly  bool
+	acl       *ACL
+	extractor *Extractor
+	logger    *logging.Logger
+}
+
+// New creates a policy from the configuration
+func New(cfg config.SQLPolicyConfig, logger *logging.Logger) *Policy {
+	p := &Policy{
+		readOnly:  cfg.ReadOnly,
+		extractor: NewExtractor(cfg.Tools),
+		logger:    logger,
+	}
+	if len(cfg.ACL.Rules) > 0 {
+		p.acl = NewACL(cfg.ACL)
+	}
+	return p
+}
+
+// Middleware returns the policy as proxy middleware
+func (p *Policy) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			if req.Method != "tools/call" {
+				return next(ctx, s, req)
+			}
+			call, err := mcp.ParseToolCall(req)
+			if err != nil {
+				p.logger.Infof("Rejected call with unreadable params for principal '%s': %v", s.Principal(), err)
+				return nil, mcp.NewError(mcp.InvalidParams, "Invalid params: %v", err)
+			}
+			if err := p.Check(ctx, s.Principal(), call); err != nil {
+				p.logger.Infof("Rejected call to tool %s for principal '%s': %s", call.Name, s.Principal(), err.Message)
+				return nil, err
+			}
+			return next(ctx, s, req)
+		}
+	}
+}
+
+// Check applies the policy to a tool call by a principal and returns the error
+// to answer it with, or nil if the call may proceed. Every ACL decision is
+// written to the audit log and noted for the audit record of the call.
+func (p *Policy) Check(ctx context.Context, principal string, call *mcp.ToolCall) *mcp.Error {
+	if !p.readOnly && p.acl == nil {
+		return nil
+	}
+	var decisions []map[string]interface{}
+	defer func() {
+		if len(decisions) > 0 {
+			proxy.Note(ctx, "acl", decisions)
+		}
+	}()
+	for _, text := range p.extractor.Find(call) {
+		statements := Parse(text.SQL)
+		for i, stmt := range statements {
+			if p.readOnly && stmt.Class != ClassSelect {
+				return denied(
+					map[string]interface{}{
+						"policy":    "read-only",
+						"argument":  text.Path,
+						"statement": i + 1,
+						"class":     stmt.Class,
+						"verb":      stmt.Verb,
+					},
+					"Read-only policy: statement %d of %d in argument '%s' (%s) is %s; only reads are allowed",
+					i+1, len(statements), text.Path, describeVerb(stmt.Verb), strings.ToUpper(string(stmt.Class)))
+			}
+			if p.acl == nil {
+				continue
+			}
+			for _, table := range stmt.Tables() {
+				d := p.acl.Decide(principal, table)
+				p.audit(principal, call.Name, text.Path, d)
+				decisions = append(decisions, map[string]interface{}{
+					"decision": decision(d),
+					"rule":     d.Rule,
+					"table":    d.Table,
+				})
+				if !d.Allowed {
+					return denied(
+						map[string]interface{}{
+							"policy":    "acl",
+							"argument":  text.Path,
+							"statement": i + 1,
+							"table":     d.Table,
+							"rule":      d.Rule,
+						},
+						"Access denied: statement %d of %d in argument '%s' references table '%s', which is not available to principal '%s'",
+						i+1, len(statements), text.Path, d.Table, principal)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// audit writes an ACL decision to the audit log
+func (p *Policy) audit(principal, tool, argument string, d Decision) {
+	p.logger.Auditf("ACL %s: principal='%s' tool=%s argument=%s table=%s rule=%s pattern=%s",
+		decision(d), principal, tool, argument, d.Table, orNone(d.Rule), orNone(d.Pattern))
+}
+
+func decision(d Decision) string {
+	if d.Allowed {
+		return "allow"
+	}
+	return "deny"
+}
+
+// denied builds a PolicyDenied error with structured details
+func denied(data map[string]interface{}, format string, args ...interface{}) *mcp.Error {
+	err := mcp.NewError(mcp.PolicyDenied, format, args...)
+	err.Data, _ = json.Marshal(data)
+	return err
+}
+
+func describeVerb(verb string) string {
+	if verb == "" {
+		return "unrecognized statement"
+	}
+	return verb
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
@@ -0,0 +1,119 @@
+package sqlpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func newCall(name, args string) *mcp.ToolCall {
+	return &mcp.ToolCall{Name: name, Arguments: json.RawMessage(args)}
+}
+
+func newCallRequest(t *testing.T, name string, args string) *mcp.Message {
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call",
+		map[string]interface{}{"name": name, "arguments": json.RawMessage(args)})
+	require.NoError(t, err)
+	return req
+}
+
+func TestExtractorDefaults(t *testing.T) {
+	e := NewExtractor(nil)
+
+	found := e.Find(newCall("execute_sql", `{"query":"SELECT 1","sql":"DELETE FROM t","limit":10}`))
+	assert.Equal(t, []SQLText{{Path: "sql", SQL: "DELETE FROM t"}, {Path: "query", SQL: "SELECT 1"}}, found)
+
+	assert.Empty(t, e.Find(newCall("execute_sql", `{"statement":"DELETE FROM t"}`)))
+	assert.Empty(t, e.Find(newCall("execute_sql", `not json`)))
+	assert.True(t, e.Covers("anything"))
+}
+
+func TestExtractorConfiguredPaths(t *testing.T) {
+	e := NewExtractor([]config.SQLToolConfig{
+		{Name: "batch_*", Arguments: []string{"statements", "steps.*.sql"}},
+		{Name: "run", Arguments: []string{"options.text"}},
+	})
+
+	found := e.Find(newCall("batch_run", `{
+		"statements": ["SELECT 1", 2, "DROP TABLE t"],
+		"steps": [{"sql": "UPDATE t SET x = 1"}, {"name": "no sql"}]
+	}`))
+	assert.Equal(t, []SQLText{
+		{Path: "statements.0", SQL: "SELECT 1"},
+		{Path: "statements.2", SQL: "DROP TABLE t"},
+		{Path: "steps.0.sql", SQL: "UPDATE t SET x = 1"},
+	}, found)
+
+	found = e.Find(newCall("run", `{"options":{"text":"SELECT 1"},"sql":"DROP TABLE t"}`))
+	assert.Equal(t, []SQLText{{Path: "options.text", SQL: "SELECT 1"}}, found)
+
+	assert.False(t, e.Covers("describe_table"))
+	assert.Empty(t, e.Find(newCall("describe_table", `{"sql":"DROP TABLE t"}`)))
+}
+
+func TestCheck(t *testing.T) {
+	p := New(config.SQLPolicyConfig{ReadOnly: true}, newTestLogger(t))
+
+	assert.Nil(t, p.Check(context.Background(), "", newCall("execute_sql", `{"sql":"SELECT 1; WITH a AS (SELECT 1) SELECT * FROM a"}`)))
+	assert.Nil(t, p.Check(context.Background(), "", newCall("describe_table", `{"table":"t"}`)))
+
+	err := p.Check(context.Background(), "", newCall("execute_sql", `{"sql":"SELECT 1; DROP TABLE users"}`))
+	require.NotNil(t, err)
+	assert.Equal(t, mcp.PolicyDenied, err.Code)
+	assert.Equal(t, "Read-only policy: statement 2 of 2 in argument 'sql' (DROP TABLE) is DDL; only reads are allowed", err.Message)
+	assert.JSONEq(t, `{"policy":"read-only","argument":"sql","statement":2,"class":"ddl","verb":"DROP TABLE"}`, string(err.Data))
+
+	// Without read-only mode everything passes
+	p = New(config.SQLPolicyConfig{}, newTestLogger(t))
+	assert.Nil(t, p.Check(context.Background(), "", newCall("execute_sql", `{"sql":"DROP TABLE users"}`)))
+}
+
+func TestMiddleware(t *testing.T) {
+	p := New(config.SQLPolicyConfig{ReadOnly: true}, newTestLogger(t))
+	var forwarded []string
+	handler := p.Middleware()(func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		forwarded = append(forwarded, req.Method)
+		return mcp.NewResult(req.ID, map[string]interface{}{})
+	})
+	s := proxy.NewSession("test", nil)
+
+	_, err := handler(context.Background(), s, newCallRequest(t, "execute_sql", `{"sql":"INSERT INTO t VALUES (1)"}`))
+	var rpcErr *mcp.Error
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, -32001, rpcErr.Code)
+	assert.Empty(t, forwarded, "rejected calls are not forwarded")
+
+	_, err = handler(context.Background(), s, newCallRequest(t, "execute_sql", `{"sql":"SELECT * FROM t"}`))
+	require.NoError(t, err)
+	req, err := mcp.NewRequest(json.RawMessage("2"), "tools/list", nil)
+	require.NoError(t, err)
+	_, err = handler(context.Background(), s, req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tools/call", "tools/list"}, forwarded)
+
+	req = &mcp.Message{JSONRPC: "2.0", ID: json.RawMessage("3"), Method: "tools/call", Params: json.RawMessage(`["DROP TABLE t"]`)}
+	_, err = handler(context.Background(), s, req)
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, mcp.InvalidParams, rpcErr.Code)
+	assert.Equal(t, []string{"tools/call", "tools/list"}, forwarded, "calls the policy cannot read are not forwarded")
+}
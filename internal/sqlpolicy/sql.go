@@ -0,0 +1,398 @@
+package sqlpolicy
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Class is the kind of a SQL statement
+type Class string
+
+// Statement classes
+const (
+	ClassSelect Class = "select" // Reads: SELECT, WITH ... SELECT, SHOW, EXPLAIN, ...
+	ClassDML    Class = "dml"    // INSERT, UPDATE, DELETE, MERGE, SELECT INTO, ...
+	ClassDDL    Class = "ddl"    // CREATE, ALTER, DROP, TRUNCATE, ...
+	ClassDCL    Class = "dcl"    // GRANT, REVOKE, DENY
+	ClassTCL    Class = "tcl"    // BEGIN, COMMIT, ROLLBACK, SAVEPOINT, ...
+	ClassOther  Class = "other"  // Anything else, e.g. SET, CALL, EXEC
+)
+
+// Statement is one statement of a SQL script
+type Statement struct {
+	Text  string
+	Class Class
+	Verb  string // Leading keywords, e.g. "SELECT" or "DROP TABLE"
+
+	tokens []token
+}
+
+// tokenKind is the lexical category of a token
+type tokenKind int
+
+const (
+	tokWord   tokenKind = iota // Keyword or unquoted identifier
+	tokIdent                   // Quoted identifier: "x", `x` or [x]
+	tokString                  // String literal, including dollar-quoted strings
+	tokNumber                  // Numeric literal or positional parameter
+	tokPunct                   // Any other single character
+)
+
+type token struct {
+	kind tokenKind
+	text string // Unquoted value; upper case for words
+	raw  string // Source text
+}
+
+func (t token) is(word string) bool {
+	return t.kind == tokWord && t.text == word
+}
+
+// Parse splits a SQL script into statements and classifies them. Dialects
+// disagree on whether a backslash escapes a quote inside a string, so the
+// script is read both ways and statements from either reading are returned.
+// A statement hidden inside a string by one reading is thus still seen.
+func Parse(sql string) []Statement {
+	statements := split(sql, false)
+	seen := make(map[string]bool, len(statements))
+	for _, s := range statements {
+		seen[s.Text] = true
+	}
+	for _, s := range split(sql, true) {
+		if !seen[s.Text] {
+			seen[s.Text] = true
+			statements = append(statements, s)
+		}
+	}
+	return statements
+}
+
+// split tokenizes a script and cuts it into statements at semicolons
+func split(sql string, backslashEscapes bool) []Statement {
+	var statements []Statement
+	var current []token
+	flush := func() {
+		if len(current) > 0 {
+			statements = append(statements, newStatement(current))
+		}
+		current = nil
+	}
+	lex(sql, backslashEscapes, func(t token) {
+		if t.kind == tokPunct && t.text == ";" {
+			flush()
+			return
+		}
+		current = append(current, t)
+	})
+	flush()
+	return statements
+}
+
+func newStatement(tokens []token) Statement {
+	raw := make([]string, len(tokens))
+	for i, t := range tokens {
+		raw[i] = t.raw
+	}
+	s := Statement{Text: strings.Join(raw, " "), tokens: tokens}
+	s.Class, s.Verb = classify(tokens)
+	return s
+}
+
+// lex calls emit for every token of sql, skipping whitespace and comments.
+// The content of MySQL executable comments (/*! ... */) is lexed as code.
+func lex(sql string, backslashEscapes bool, emit func(token)) {
+	src := []rune(sql)
+	n := len(src)
+	for i := 0; i < n; {
+		c := src[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '-' && i+1 < n && src[i+1] == '-':
+			for i < n && src[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < n && src[i+1] == '*':
+			if i+2 < n && src[i+2] == '!' {
+				// Executable comment: skip the marker and optional version number
+				i += 3
+				for i < n && unicode.IsDigit(src[i]) {
+					i++
+				}
+				continue
+			}
+			depth := 0
+			for i < n {
+				if src[i] == '/' && i+1 < n && src[i+1] == '*' {
+					depth++
+					i += 2
+				} else if src[i] == '*' && i+1 < n && src[i+1] == '/' {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+
+		case c == '*' && i+1 < n && src[i+1] == '/':
+			// End of an executable comment
+			i += 2
+
+		case c == '\'':
+			end, value := quoted(src, i, '\'', backslashEscapes)
+			emit(token{kind: tokString, text: value, raw: string(src[i:end])})
+			i = end
+
+		case (c == 'E' || c == 'e') && i+1 < n && src[i+1] == '\'':
+			// PostgreSQL escape string: backslashes always escape
+			end, value := quoted(src, i+1, '\'', true)
+			emit(token{kind: tokString, text: value, raw: string(src[i:end])})
+			i = end
+
+		case c == '"' || c == '`':
+			end, value := quoted(src, i, c, backslashEscapes && c == '"')
+			emit(token{kind: tokIdent, text: value, raw: string(src[i:end])})
+			i = end
+
+		case c == '[':
+			end, value := quoted(src, i, ']', false)
+			emit(token{kind: tokIdent, text: value, raw: string(src[i:end])})
+			i = end
+
+		case c == '$' && i+1 < n && unicode.IsDigit(src[i+1]):
+			start := i
+			i++
+			for i < n && unicode.IsDigit(src[i]) {
+				i++
+			}
+			emit(token{kind: tokNumber, text: string(src[start:i]), raw: string(src[start:i])})
+
+		case c == '$':
+			if end, value, ok := dollarQuoted(src, i); ok {
+				emit(token{kind: tokString, text: value, raw: string(src[i:end])})
+				i = end
+			} else {
+				emit(token{kind: tokPunct, text: "$", raw: "$"})
+				i++
+			}
+
+		case isWordStart(c):
+			start := i
+			for i < n && isWordPart(src[i]) {
+				i++
+			}
+			word := string(src[start:i])
+			emit(token{kind: tokWord, text: strings.ToUpper(word), raw: word})
+
+		case unicode.IsDigit(c):
+			start := i
+			for i < n && (unicode.IsDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			emit(token{kind: tokNumber, text: string(src[start:i]), raw: string(src[start:i])})
+
+		default:
+			emit(token{kind: tokPunct, text: string(c), raw: string(c)})
+			i++
+		}
+	}
+}
+
+// quoted reads a quoted token starting at src[start] and closed by closer. A
+// doubled closer stands for itself. It returns the end offset and the value.
+func quoted(src []rune, start int, closer rune, backslashEscapes bool) (int, string) {
+	var value strings.Builder
+	i := start + 1
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case backslashEscapes && c == '\\' && i+1 < len(src):
+			value.WriteRune(src[i+1])
+			i += 2
+		case c == closer && i+1 < len(src) && src[i+1] == closer:
+			value.WriteRune(c)
+			i += 2
+		case c == closer:
+			return i + 1, value.String()
+		default:
+			value.WriteRune(c)
+			i++
+		}
+	}
+	return len(src), value.String() // Unterminated: runs to the end
+}
+
+// dollarQuoted reads a PostgreSQL dollar-quoted string such as $$...$$ or $tag$...$tag$
+func dollarQuoted(src []rune, start int) (int, string, bool) {
+	i := start + 1
+	for i < len(src) && src[i] != '$' {
+		if !unicode.IsLetter(src[i]) && !unicode.IsDigit(src[i]) && src[i] != '_' {
+			return 0, "", false
+		}
+		i++
+	}
+	if i >= len(src) {
+		return 0, "", false
+	}
+	tag := string(src[start : i+1])
+	body := string(src[i+1:])
+	if end := strings.Index(body, tag); end >= 0 {
+		return i + 1 + len([]rune(body[:end])) + len([]rune(tag)), body[:end], true
+	}
+	return len(src), body, true
+}
+
+func isWordStart(c rune) bool {
+	return unicode.IsLetter(c) || c == '_' || c == '@' || c == '#'
+}
+
+func isWordPart(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '$' || c == '@' || c == '#'
+}
+
+// Statement verbs by class
+var verbClasses = map[string]Class{
+	"SELECT": ClassSelect, "VALUES": ClassSelect, "TABLE": ClassSelect, "SHOW": ClassSelect,
+	"DESCRIBE": ClassSelect, "DESC": ClassSelect, "EXPLAIN": ClassSelect,
+
+	"INSERT": ClassDML, "UPDATE": ClassDML, "DELETE": ClassDML, "MERGE": ClassDML, "UPSERT": ClassDML,
+	"REPLACE": ClassDML, "COPY": ClassDML, "LOAD": ClassDML,
+
+	"CREATE": ClassDDL, "ALTER": ClassDDL, "DROP": ClassDDL, "TRUNCATE": ClassDDL, "RENAME": ClassDDL,
+	"COMMENT": ClassDDL,
+
+	"GRANT": ClassDCL, "REVOKE": ClassDCL, "DENY": ClassDCL,
+
+	"BEGIN": ClassTCL, "START": ClassTCL, "COMMIT": ClassTCL, "ROLLBACK": ClassTCL, "SAVEPOINT": ClassTCL,
+	"RELEASE": ClassTCL, "END": ClassTCL, "ABORT": ClassTCL,
+}
+
+// objectVerbs are the DDL verbs followed by the kind of object they act on, e.g. DROP TABLE
+var objectVerbs = map[string]bool{"CREATE": true, "ALTER": true, "DROP": true}
+
+// dmlVerbs are the verbs that make a WITH statement or an EXPLAIN ANALYZE modify data
+var dmlVerbs = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true}
+
+// classify determines the class and verb of a statement from its tokens
+func classify(tokens []token) (Class, string) {
+	// Skip parentheses around a query, e.g. (SELECT 1) UNION (SELECT 2)
+	i := 0
+	for i < len(tokens) && tokens[i].kind == tokPunct && tokens[i].text == "(" {
+		i++
+	}
+	if i >= len(tokens) || tokens[i].kind != tokWord {
+		return ClassOther, ""
+	}
+	verb := tokens[i].text
+	rest := tokens[i+1:]
+
+	switch verb {
+	case "WITH":
+		return classifyWith(rest), verb
+	case "SELECT":
+		if hasTopLevel(rest, "INTO") {
+			return ClassDML, "SELECT INTO"
+		}
+		return ClassSelect, verb
+	case "EXPLAIN":
+		// EXPLAIN ANALYZE executes the statement it explains
+		if hasWord(rest, "ANALYZE") || hasWord(rest, "ANALYSE") {
+			for j, t := range rest {
+				if t.kind == tokWord && (dmlVerbs[t.text] || t.text == "SELECT" || t.text == "WITH") {
+					class, _ := classify(rest[j:])
+					return class, "EXPLAIN ANALYZE"
+				}
+			}
+		}
+		return ClassSelect, verb
+	case "PRAGMA":
+		if hasPunct(rest, "=") {
+			return ClassOther, verb
+		}
+		return ClassSelect, verb
+	case "SET":
+		if len(rest) > 0 && rest[0].is("TRANSACTION") {
+			return ClassTCL, "SET TRANSACTION"
+		}
+		return ClassOther, verb
+	}
+
+	class, ok := verbClasses[verb]
+	if !ok {
+		return ClassOther, verb
+	}
+	if (objectVerbs[verb] || class == ClassDCL) && len(rest) > 0 && rest[0].kind == tokWord {
+		verb += " " + rest[0].text
+	}
+	return class, verb
+}
+
+// classifyWith classifies a statement with common table expressions. It
+// modifies data if its main statement or any CTE body is a DML statement.
+func classifyWith(tokens []token) Class {
+	depth := 0
+	for i, t := range tokens {
+		if t.kind == tokPunct {
+			switch t.text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+			continue
+		}
+		if t.kind != tokWord {
+			continue
+		}
+		afterParen := i > 0 && tokens[i-1].kind == tokPunct && tokens[i-1].text == "("
+		if dmlVerbs[t.text] && (depth == 0 || afterParen) {
+			return ClassDML
+		}
+		if depth == 0 && t.text == "SELECT" {
+			if hasTopLevel(tokens[i+1:], "INTO") {
+				return ClassDML
+			}
+			return ClassSelect
+		}
+	}
+	return ClassSelect
+}
+
+// hasTopLevel reports whether word occurs outside of parentheses
+func hasTopLevel(tokens []token, word string) bool {
+	depth := 0
+	for _, t := range tokens {
+		switch {
+		case t.kind == tokPunct && t.text == "(":
+			depth++
+		case t.kind == tokPunct && t.text == ")":
+			depth--
+		case depth == 0 && t.is(word):
+			return true
+		}
+	}
+	return false
+}
+
+func hasWord(tokens []token, word string) bool {
+	for _, t := range tokens {
+		if t.is(word) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPunct(tokens []token, punct string) bool {
+	for _, t := range tokens {
+		if t.kind == tokPunct && t.text == punct {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package sqlpolicy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func classes(statements []Statement) []Class {
+	var out []Class
+	for _, s := range statements {
+		out = append(out, s.Class)
+	}
+	return out
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		sql   string
+		class Class
+		verb  string
+	}{
+		{"SELECT * FROM t", ClassSelect, "SELECT"},
+		{"  select 1", ClassSelect, "SELECT"},
+		{"(SELECT 1) UNION (SELECT 2)", ClassSelect, "SELECT"},
+		{"SELECT * FROM t FOR UPDATE", ClassSelect, "SELECT"},
+		{"SELECT * INTO backup FROM t", ClassDML, "SELECT INTO"},
+		{"SELECT (SELECT 1 INTO x) FROM t", ClassSelect, "SELECT"},
+		{"WITH a AS (SELECT 1) SELECT * FROM a", ClassSelect, "WITH"},
+		{"WITH a AS (SELECT 1) DELETE FROM t WHERE id IN (SELECT * FROM a)", ClassDML, "WITH"},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", ClassDML, "WITH"},
+		{"WITH a AS (SELECT * FROM t FOR UPDATE) SELECT * FROM a", ClassSelect, "WITH"},
+		{"SHOW TABLES", ClassSelect, "SHOW"},
+		{"DESCRIBE t", ClassSelect, "DESCRIBE"},
+		{"EXPLAIN SELECT 1", ClassSelect, "EXPLAIN"},
+		{"EXPLAIN DELETE FROM t", ClassSelect, "EXPLAIN"},
+		{"EXPLAIN ANALYZE DELETE FROM t", ClassDML, "EXPLAIN ANALYZE"},
+		{"EXPLAIN (ANALYZE, BUFFERS) UPDATE t SET x = 1", ClassDML, "EXPLAIN ANALYZE"},
+		{"VALUES (1), (2)", ClassSelect, "VALUES"},
+		{"PRAGMA table_info(t)", ClassSelect, "PRAGMA"},
+		{"PRAGMA journal_mode = WAL", ClassOther, "PRAGMA"},
+		{"INSERT INTO t VALUES (1)", ClassDML, "INSERT"},
+		{"update t set x = 1", ClassDML, "UPDATE"},
+		{"DELETE FROM t", ClassDML, "DELETE"},
+		{"MERGE INTO t USING s ON t.id = s.id WHEN MATCHED THEN DELETE", ClassDML, "MERGE"},
+		{"CREATE TABLE t (id int)", ClassDDL, "CREATE TABLE"},
+		{"DROP TABLE t", ClassDDL, "DROP TABLE"},
+		{"ALTER TABLE t ADD c int", ClassDDL, "ALTER TABLE"},
+		{"TRUNCATE t", ClassDDL, "TRUNCATE"},
+		{"GRANT SELECT ON t TO u", ClassDCL, "GRANT SELECT"},
+		{"REVOKE ALL ON t FROM u", ClassDCL, "REVOKE ALL"},
+		{"BEGIN", ClassTCL, "BEGIN"},
+		{"START TRANSACTION", ClassTCL, "START"},
+		{"COMMIT", ClassTCL, "COMMIT"},
+		{"ROLLBACK TO SAVEPOINT a", ClassTCL, "ROLLBACK"},
+		{"SET TRANSACTION READ ONLY", ClassTCL, "SET TRANSACTION"},
+		{"SET search_path = x", ClassOther, "SET"},
+		{"CALL do_things()", ClassOther, "CALL"},
+		{"EXEC sp_who", ClassOther, "EXEC"},
+		{"?", ClassOther, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			statements := Parse(tt.sql)
+			if assert.Len(t, statements, 1) {
+				assert.Equal(t, tt.class, statements[0].Class)
+				assert.Equal(t, tt.verb, statements[0].Verb)
+			}
+		})
+	}
+}
+
+func TestMultiStatementScripts(t *testing.T) {
+	assert.Equal(t, []Class{ClassSelect, ClassDDL, ClassTCL},
+		classes(Parse("SELECT 1; DROP TABLE t;\nCOMMIT;")))
+
+	// Empty statements and comments produce nothing
+	assert.Empty(t, Parse(" ; -- just a comment\n ; /* and another */ "))
+}
+
+func TestCommentsAndQuoting(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		classes []Class
+	}{
+		{"line comment", "SELECT 1 -- ; DROP TABLE t", []Class{ClassSelect}},
+		{"block comment", "SELECT /* ; DROP TABLE t; */ 1", []Class{ClassSelect}},
+		{"nested block comment", "SELECT /* a /* b */ ; DROP TABLE t; */ 1", []Class{ClassSelect}},
+		{"comment before verb", "/* hello */ -- there\n DELETE FROM t", []Class{ClassDML}},
+		{"semicolon in string", "SELECT ';DROP TABLE t;'", []Class{ClassSelect}},
+		{"doubled quote", "SELECT 'it''s; DROP TABLE t'", []Class{ClassSelect}},
+		{"quoted identifier", `SELECT "a;b", ` + "`c;d`, [e;f] FROM t", []Class{ClassSelect}},
+		{"dollar quoted", "SELECT $$; DROP TABLE t;$$", []Class{ClassSelect}},
+		{"tagged dollar quoted", "SELECT $x$ $$; DROP TABLE t; $x$", []Class{ClassSelect}},
+		{"positional parameter", "SELECT $1; SELECT $2", []Class{ClassSelect, ClassSelect}},
+		{"executable comment", "SELECT 1 /*!50000 ; DROP TABLE t */", []Class{ClassSelect, ClassDDL}},
+		{"escape string", `SELECT E'\'; DROP TABLE t; --'`, []Class{ClassSelect}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.classes, classes(Parse(tt.sql)))
+		})
+	}
+}
+
+func TestBackslashEscapeAmbiguity(t *testing.T) {
+	// With backslash escapes (MySQL) the DROP runs; without them it is inside a string
+	statements := Parse(`SELECT 'x\''; DROP TABLE t; -- '`)
+	assert.Contains(t, classes(statements), ClassDDL)
+
+	// Without backslash escapes (standard SQL) the DROP runs
+	statements = Parse(`SELECT 'a\'; DROP TABLE t; --'`)
+	assert.Contains(t, classes(statements), ClassDDL)
+}
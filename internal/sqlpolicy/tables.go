@@ -0,0 +1,199 @@
+package sqlpolicy
+
+import "strings"
+
+// Tables returns the tables a statement references, lower-cased and without
+// duplicates. Names keep the qualification used in the SQL, e.g.
+// "hr.salaries" or "orders". Common table expressions are not included.
+func (s Statement) Tables() []string {
+	tokens := s.tokens
+	ctes := cteNames(tokens)
+
+	var tables []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name == "" || strings.HasPrefix(name, "@") || seen[name] || (!strings.Contains(name, ".") && ctes[name]) {
+			return
+		}
+		seen[name] = true
+		tables = append(tables, name)
+	}
+
+	onNamesTable := strings.HasPrefix(s.Verb, "CREATE INDEX") || strings.HasPrefix(s.Verb, "CREATE UNIQUE") || s.Class == ClassDCL
+	// query tracks per parenthesis level whether it holds a query, so that
+	// FROM in EXTRACT(YEAR FROM d) or TRIM(x FROM y) is not taken for a table
+	query := []bool{true}
+	for i, t := range tokens {
+		if t.kind == tokPunct {
+			switch {
+			case t.text == "(":
+				query = append(query, false)
+			case t.text == ")" && len(query) > 1:
+				query = query[:len(query)-1]
+			}
+			continue
+		}
+		if t.kind != tokWord {
+			continue
+		}
+		switch t.text {
+		case "SELECT", "INSERT", "DELETE", "MERGE", "SHOW":
+			query[len(query)-1] = true
+		case "FROM", "USING":
+			if query[len(query)-1] {
+				readTableList(tokens, i+1, false, add)
+			}
+		case "JOIN", "INTO", "REFERENCES", "TABLE":
+			readTableList(tokens, i+1, true, add)
+		case "UPDATE":
+			// Not FOR UPDATE, ON UPDATE, DO UPDATE or ON DUPLICATE KEY UPDATE
+			if i == 0 || (tokens[i-1].kind == tokPunct && (tokens[i-1].text == "(" || tokens[i-1].text == ")")) {
+				query[len(query)-1] = true
+				readTableList(tokens, i+1, true, add)
+			}
+		case "TRUNCATE", "DESCRIBE", "DESC":
+			if i == 0 {
+				readTableList(tokens, i+1, true, add)
+			}
+		case "ON":
+			if onNamesTable {
+				readTableList(tokens, i+1, true, add)
+			}
+		}
+	}
+	return tables
+}
+
+// tableModifiers are words that may precede a table name, e.g. FROM ONLY t
+var tableModifiers = map[string]bool{
+	"ONLY": true, "LATERAL": true, "IF": true, "NOT": true, "EXISTS": true, "TABLE": true,
+}
+
+// clauseWords end a table reference where a bare alias could otherwise follow
+var clauseWords = map[string]bool{
+	"WHERE": true, "GROUP": true, "ORDER": true, "HAVING": true, "LIMIT": true, "OFFSET": true,
+	"FETCH": true, "FOR": true, "WINDOW": true, "UNION": true, "EXCEPT": true, "INTERSECT": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "CROSS": true,
+	"OUTER": true, "NATURAL": true, "ON": true, "USING": true, "SET": true, "VALUES": true,
+	"SELECT": true, "RETURNING": true, "DEFAULT": true, "WHEN": true, "TO": true, "FROM": true,
+	"CASCADE": true, "RESTRICT": true, "WITH": true, "AS": true, "INTO": true,
+}
+
+// readTableList reads comma-separated table references starting at tokens[i].
+// Unless always is set, a name followed by "(" is a function call, not a table.
+func readTableList(tokens []token, i int, always bool, add func(string)) {
+	for i < len(tokens) {
+		for i < len(tokens) && tokens[i].kind == tokWord && tableModifiers[tokens[i].text] {
+			i++
+		}
+		if i >= len(tokens) {
+			return
+		}
+
+		if tokens[i].kind == tokPunct && tokens[i].text == "(" {
+			// Subquery or LATERAL: its own FROM clauses are read separately
+			i = skipParens(tokens, i)
+		} else {
+			name, next := qualifiedName(tokens, i)
+			if name == "" {
+				return
+			}
+			isCall := next < len(tokens) && tokens[next].kind == tokPunct && tokens[next].text == "("
+			if always || !isCall {
+				add(name)
+			}
+			i = next
+			if isCall {
+				i = skipParens(tokens, i)
+			}
+		}
+
+		// Optional alias
+		if i < len(tokens) && tokens[i].is("AS") {
+			i += 2
+		} else if i < len(tokens) && (tokens[i].kind == tokIdent || (tokens[i].kind == tokWord && !clauseWords[tokens[i].text])) {
+			i++
+		}
+		if i >= len(tokens) || tokens[i].kind != tokPunct || tokens[i].text != "," {
+			return
+		}
+		i++
+	}
+}
+
+// qualifiedName reads a possibly dotted name such as schema.table at tokens[i]
+// and returns it lower-cased with the offset of the token after it
+func qualifiedName(tokens []token, i int) (string, int) {
+	var parts []string
+	for i < len(tokens) && (tokens[i].kind == tokWord || tokens[i].kind == tokIdent) {
+		if tokens[i].kind == tokWord && clauseWords[tokens[i].text] && len(parts) == 0 {
+			break
+		}
+		parts = append(parts, strings.ToLower(tokens[i].text))
+		i++
+		if i+1 < len(tokens) && tokens[i].kind == tokPunct && tokens[i].text == "." {
+			i++
+			continue
+		}
+		break
+	}
+	return strings.Join(parts, "."), i
+}
+
+// skipParens returns the offset after the parenthesis opened at tokens[i]
+func skipParens(tokens []token, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		if tokens[i].kind != tokPunct {
+			continue
+		}
+		switch tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// cteNames returns the lower-case names of the common table expressions
+// defined anywhere in a statement: WITH [RECURSIVE] name [(cols)] AS (...), ...
+func cteNames(tokens []token) map[string]bool {
+	names := make(map[string]bool)
+	for i, t := range tokens {
+		if !t.is("WITH") {
+			continue
+		}
+		j := i + 1
+		if j < len(tokens) && tokens[j].is("RECURSIVE") {
+			j++
+		}
+		for j < len(tokens) && (tokens[j].kind == tokWord || tokens[j].kind == tokIdent) {
+			name := strings.ToLower(tokens[j].text)
+			j++
+			if j < len(tokens) && tokens[j].kind == tokPunct && tokens[j].text == "(" {
+				j = skipParens(tokens, j)
+			}
+			if j >= len(tokens) || !tokens[j].is("AS") {
+				break
+			}
+			names[name] = true
+			j++
+			for j < len(tokens) && (tokens[j].is("NOT") || tokens[j].is("MATERIALIZED")) {
+				j++
+			}
+			if j < len(tokens) && tokens[j].kind == tokPunct && tokens[j].text == "(" {
+				j = skipParens(tokens, j)
+			}
+			if j >= len(tokens) || tokens[j].kind != tokPunct || tokens[j].text != "," {
+				break
+			}
+			j++
+		}
+	}
+	return names
+}
@@ -0,0 +1,57 @@
+package sqlpolicy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTables(t *testing.T) {
+	tests := []struct {
+		sql    string
+		tables []string
+	}{
+		{"SELECT 1", nil},
+		{"SELECT * FROM hr.Employees", []string{"hr.employees"}},
+		{`SELECT * FROM "HR"."Salaries" s`, []string{"hr.salaries"}},
+		{"SELECT * FROM [dbo].[orders] AS o", []string{"dbo.orders"}},
+		{"SELECT * FROM a x, b AS y, c WHERE x.id = y.id", []string{"a", "b", "c"}},
+		{"SELECT * FROM a JOIN b ON a.id = b.id LEFT OUTER JOIN c.d USING (id)", []string{"a", "b", "c.d"}},
+		{"SELECT * FROM a WHERE id IN (SELECT id FROM b)", []string{"a", "b"}},
+		{"SELECT * FROM (SELECT * FROM a) sub, b", []string{"b", "a"}},
+		{"SELECT * FROM generate_series(1, 3) g, t", []string{"t"}},
+		{"SELECT EXTRACT(YEAR FROM created) FROM t", []string{"t"}},
+		{"SELECT TRIM(BOTH ' ' FROM name) FROM ONLY t", []string{"t"}},
+		{"WITH recent AS (SELECT * FROM orders) SELECT * FROM recent JOIN hr.recent r ON true", []string{"orders", "hr.recent"}},
+		{"WITH RECURSIVE a(n) AS (SELECT 1 UNION SELECT n FROM a), b AS NOT MATERIALIZED (SELECT 1) SELECT * FROM a, b", nil},
+		{"INSERT INTO audit.log (id, msg) SELECT id, msg FROM staging", []string{"audit.log", "staging"}},
+		{"INSERT INTO t VALUES (1) ON CONFLICT (id) DO UPDATE SET x = 1", []string{"t"}},
+		{"UPDATE t SET x = (SELECT y FROM u) FROM v WHERE t.id = v.id", []string{"t", "u", "v"}},
+		{"UPDATE ONLY a, b SET a.x = b.x", []string{"a", "b"}},
+		{"DELETE FROM t USING u WHERE t.id = u.id", []string{"t", "u"}},
+		{"MERGE INTO t USING s ON t.id = s.id WHEN MATCHED THEN UPDATE SET x = 1", []string{"t", "s"}},
+		{"SELECT * FROM t FOR UPDATE", []string{"t"}},
+		{"SELECT * INTO backup FROM t", []string{"backup", "t"}},
+		{"SELECT 1 INTO @x", nil},
+		{"CREATE TABLE IF NOT EXISTS hr.x (id int REFERENCES hr.y(id))", []string{"hr.x", "hr.y"}},
+		{"DROP TABLE IF EXISTS a, b CASCADE", []string{"a", "b"}},
+		{"ALTER TABLE t ADD c int", []string{"t"}},
+		{"TRUNCATE t", []string{"t"}},
+		{"TRUNCATE TABLE t", []string{"t"}},
+		{"TABLE t", []string{"t"}},
+		{"DESCRIBE t", []string{"t"}},
+		{"SHOW COLUMNS FROM t", []string{"t"}},
+		{"CREATE INDEX i ON t (c)", []string{"t"}},
+		{"GRANT SELECT ON hr.salaries TO bob", []string{"hr.salaries"}},
+		{"SELECT * FROM a JOIN b ON a.x = b.x", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			statements := Parse(tt.sql)
+			require.Len(t, statements, 1)
+			assert.Equal(t, tt.tables, statements[0].Tables())
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package toolfilter
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+)
+
+// Filter hides tools from tools/list and rejects calls to them, depending on
+// the session's principal: who it authenticated as, never the name a client
+// gives itself. Rejected calls never reach the upstream.
+type Filter struct {
+	rules          []config.ToolFilterRule
+	allowUnmatched bool
+	logger         *logging.Logger
+}
+
+// New creates a filter from the configured rules
+func New(cfg config.ToolFilterConfig, logger *logging.Logger) *Filter {
+	return &Filter{rules: cfg.Rules, allowUnmatched: cfg.Default == config.AccessAllow, logger: logger}
+}
+
+// Middleware returns the filter as proxy middleware
+func (f *Filter) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			switch req.Method {
+			case "tools/call":
+				name := mcp.ToolName(req)
+				if !f.Allowed(s.Principal(), name) {
+					f.logger.Infof("Rejected call to tool %s for principal '%s'", name, s.Principal())
+					return nil, mcp.NewError(mcp.InvalidParams, "Unknown tool: %s", name)
+				}
+			case "tools/list":
+				resp, err := next(ctx, s, req)
+				if err != nil || resp == nil || resp.Result == nil {
+					return resp, err
+				}
+				return f.filterList(s.Principal(), resp)
+			}
+			return next(ctx, s, req)
+		}
+	}
+}
+
+// Allowed reports whether a principal may see and call a tool. The first rule
+// whose principal patterns match decides; principals no rule matches may use
+// no tool unless the default is allow.
+func (f *Filter) Allowed(principal, tool string) bool {
+	rule := f.ruleFor(principal)
+	if rule == nil {
+		return f.allowUnmatched
+	}
+	if len(rule.Allow) > 0 && !matchAny(rule.Allow, tool) {
+		return false
+	}
+	return !matchAny(rule.Deny, tool)
+}
+
+func (f *Filter) ruleFor(principal string) *config.ToolFilterRule {
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if len(rule.Principals) == 0 || matchAny(rule.Principals, principal) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// filterList removes the tools the principal may not use from a tools/list response
+func (f *Filter) filterList(principal string, resp *mcp.Message) (*mcp.Message, error) {
+	if f.ruleFor(principal) == nil && f.allowUnmatched {
+		return resp, nil
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return resp, nil
+	}
+	var tools []json.RawMessage
+	if err := json.Unmarshal(result["tools"], &tools); err != nil {
+		return resp, nil
+	}
+
+	kept := make([]json.RawMessage, 0, len(tools))
+	for _, tool := range tools {
+		var t struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal(tool, &t)
+		if f.Allowed(principal, t.Name) {
+			kept = append(kept, tool)
+		}
+	}
+	if len(kept) == len(tools) {
+		return resp, nil
+	}
+
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return nil, err
+	}
+	result["tools"] = data
+	filtered := resp.Clone()
+	if filtered.Result, err = json.Marshal(result); err != nil {
+		return nil, err
+	}
+	f.logger.Infof("Hid %d of %d tools from principal '%s'", len(tools)-len(kept), len(tools), principal)
+	return filtered, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package toolfilter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+const toolList = `{"tools":[{"name":"read_table"},{"name":"describe_table"},{"name":"execute_sql"}],"nextCursor":"x"}`
+
+// upstream answers tools/list with toolList and counts everything else it receives
+type upstream struct {
+	calls []string
+}
+
+func (u *upstream) handle(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+	if req.Method == "tools/list" {
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(toolList)}, nil
+	}
+	u.calls = append(u.calls, mcp.ToolName(req))
+	return mcp.NewResult(req.ID, map[string]interface{}{"content": []interface{}{}})
+}
+
+func newRules() config.ToolFilterConfig {
+	return config.ToolFilterConfig{Rules: []config.ToolFilterRule{
+		{Principals: []string{"report-*"}, Allow: []string{"read_*", "describe_*"}},
+		{Principals: []string{"admin"}},
+		{Deny: []string{"execute_sql"}},
+	}}
+}
+
+func run(t *testing.T, f *Filter, up *upstream, principal string, req *mcp.Message) (*mcp.Message, error) {
+	s := proxy.NewSession("test", nil)
+	s.SetPrincipal(principal)
+	return f.Middleware()(up.handle)(context.Background(), s, req)
+}
+
+func toolNames(t *testing.T, resp *mcp.Message) []string {
+	var result struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+		NextCursor string `json:"nextCursor"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Result, &result))
+	assert.Equal(t, "x", result.NextCursor)
+	var names []string
+	for _, tool := range result.Tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func TestAllowed(t *testing.T) {
+	f := New(newRules(), newTestLogger(t))
+
+	assert.True(t, f.Allowed("report-bot", "read_table"))
+	assert.False(t, f.Allowed("report-bot", "execute_sql"))
+	assert.True(t, f.Allowed("admin", "execute_sql"), "first matching rule decides")
+	assert.False(t, f.Allowed("other", "execute_sql"))
+	assert.True(t, f.Allowed("other", "read_table"))
+
+	// Principals no rule matches get the default access
+	unmatched := config.ToolFilterConfig{Rules: []config.ToolFilterRule{{Principals: []string{"admin"}}}}
+	assert.False(t, New(unmatched, newTestLogger(t)).Allowed("other", "read_table"))
+	unmatched.Default = config.AccessAllow
+	assert.True(t, New(unmatched, newTestLogger(t)).Allowed("other", "read_table"))
+}
+
+func TestToolsList(t *testing.T) {
+	f := New(newRules(), newTestLogger(t))
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/list", nil)
+	require.NoError(t, err)
+
+	resp, err := run(t, f, &upstream{}, "report-bot", req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read_table", "describe_table"}, toolNames(t, resp))
+
+	resp, err = run(t, f, &upstream{}, "admin", req)
+	require.NoError(t, err)
+	assert.JSONEq(t, toolList, string(resp.Result))
+}
+
+func TestToolsCall(t *testing.T) {
+	f := New(newRules(), newTestLogger(t))
+	up := &upstream{}
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{"name": "execute_sql"})
+	require.NoError(t, err)
+
+	_, err = run(t, f, up, "report-bot", req)
+	var rpcErr *mcp.Error
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, mcp.InvalidParams, rpcErr.Code)
+	assert.Equal(t, "Unknown tool: execute_sql", rpcErr.Message)
+	assert.Empty(t, up.calls, "rejected calls are not forwarded")
+
+	_, err = run(t, f, up, "admin", req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"execute_sql"}, up.calls)
+}
+
+func TestSpoofedClientName(t *testing.T) {
+	f := New(config.ToolFilterConfig{Rules: []config.ToolFilterRule{
+		{Principals: []string{"admin"}},
+		{Principals: []string{"report-*"}, Allow: []string{"read_*"}},
+	}}, newTestLogger(t))
+	up := &upstream{}
+	req, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", map[string]interface{}{"name": "execute_sql"})
+	require.NoError(t, err)
+
+	s := proxy.NewSession("test", nil)
+	s.SetClient(proxy.ClientInfo{Name: "admin"})
+	s.SetPrincipal("report-bot")
+	_, err = f.Middleware()(up.handle)(context.Background(), s, req)
+	assert.Error(t, err, "the name a client gives itself does not select the rule")
+
+	s.SetPrincipal("10.0.0.7")
+	_, err = f.Middleware()(up.handle)(context.Background(), s, req)
+	assert.Error(t, err, "principals no rule matches are denied")
+
+	list, err := mcp.NewRequest(json.RawMessage("2"), "tools/list", nil)
+	require.NoError(t, err)
+	resp, err := f.Middleware()(up.handle)(context.Background(), s, list)
+	require.NoError(t, err)
+	assert.Empty(t, toolNames(t, resp))
+	assert.Empty(t, up.calls)
+}
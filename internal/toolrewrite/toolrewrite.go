@@ -0,0 +1,209 @@
+package toolrewrite
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+)
+
+// Rewriter presents upstream tools to clients under other names and
+// descriptions, and maps calls to those names back to the upstream tools
+type Rewriter struct {
+	prefix    string
+	overrides map[string]config.ToolOverride // By upstream name
+	renamed   map[string]string              // Upstream names by new name, without the prefix
+	logger    *logging.Logger
+}
+
+// New creates a rewriter from the configured prefix and overrides
+func New(cfg config.ToolRewriteConfig, logger *logging.Logger) *Rewriter {
+	r := &Rewriter{
+		prefix:    cfg.Prefix,
+		overrides: make(map[string]config.ToolOverride, len(cfg.Tools)),
+		renamed:   make(map[string]string),
+		logger:    logger,
+	}
+	for _, tool := range cfg.Tools {
+		r.overrides[tool.Name] = tool
+		if tool.Rename != "" {
+			r.renamed[tool.Rename] = tool.Name
+		}
+	}
+	return r
+}
+
+// Middleware returns the rewriter as proxy middleware. It should come first,
+// so that the rest of the chain sees upstream tool names.
+func (r *Rewriter) Middleware() proxy.Middleware {
+	return func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			switch req.Method {
+			case "tools/call":
+				mapped, err := r.mapCall(req)
+				if err != nil {
+					return nil, err
+				}
+				return next(ctx, s, mapped)
+			case "tools/list":
+				resp, err := next(ctx, s, req)
+				if err != nil || resp == nil || resp.Result == nil {
+					return resp, err
+				}
+				return r.rewriteList(resp)
+			}
+			return next(ctx, s, req)
+		}
+	}
+}
+
+// ClientName returns the name clients see for an upstream tool
+func (r *Rewriter) ClientName(tool string) string {
+	if o, ok := r.overrides[tool]; ok && o.Rename != "" {
+		tool = o.Rename
+	}
+	return r.prefix + tool
+}
+
+// UpstreamName returns the upstream tool behind a name clients see. ok is
+// false for names no tool is presented under.
+func (r *Rewriter) UpstreamName(name string) (tool string, ok bool) {
+	if !strings.HasPrefix(name, r.prefix) {
+		return "", false
+	}
+	name = strings.TrimPrefix(name, r.prefix)
+	if tool, ok := r.renamed[name]; ok {
+		return tool, true
+	}
+	if o, ok := r.overrides[name]; ok && o.Rename != "" {
+		return "", false
+	}
+	return name, true
+}
+
+// mapCall returns a copy of a tools/call request naming the upstream tool
+func (r *Rewriter) mapCall(req *mcp.Message) (*mcp.Message, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		// Left for the upstream to reject
+		return req, nil
+	}
+	var name string
+	if err := json.Unmarshal(params["name"], &name); err != nil {
+		return req, nil
+	}
+	tool, ok := r.UpstreamName(name)
+	if !ok {
+		r.logger.Infof("Rejected call to unknown tool %s", name)
+		return nil, mcp.NewError(mcp.InvalidParams, "Unknown tool: %s", name)
+	}
+	if tool == name {
+		return req, nil
+	}
+
+	data, err := json.Marshal(tool)
+	if err != nil {
+		return nil, err
+	}
+	params["name"] = data
+	mapped := req.Clone()
+	if mapped.Params, err = json.Marshal(params); err != nil {
+		return nil, err
+	}
+	return mapped, nil
+}
+
+// rewriteList renames the tools of a tools/list response and replaces their
+// descriptions. Upstream tools whose name another tool is renamed to are
+// dropped, so that every name clients see maps to one tool.
+func (r *Rewriter) rewriteList(resp *mcp.Message) (*mcp.Message, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return resp, nil
+	}
+	var tools []map[string]json.RawMessage
+	if err := json.Unmarshal(result["tools"], &tools); err != nil {
+		return resp, nil
+	}
+
+	kept := make([]map[string]json.RawMessage, 0, len(tools))
+	for _, tool := range tools {
+		var name string
+		if err := json.Unmarshal(tool["name"], &name); err != nil {
+			kept = append(kept, tool)
+			continue
+		}
+		if other, ok := r.renamed[name]; ok && r.overrides[name].Rename == "" {
+			r.logger.Infof("Hid upstream tool %s, as tool %s is renamed to it", name, other)
+			continue
+		}
+		if err := r.rewriteTool(name, tool); err != nil {
+			return nil, err
+		}
+		kept = append(kept, tool)
+	}
+
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return nil, err
+	}
+	result["tools"] = data
+	rewritten := resp.Clone()
+	if rewritten.Result, err = json.Marshal(result); err != nil {
+		return nil, err
+	}
+	return rewritten, nil
+}
+
+// rewriteTool applies the name and overrides of an upstream tool to its
+// tools/list entry
+func (r *Rewriter) rewriteTool(name string, tool map[string]json.RawMessage) error {
+	var err error
+	if tool["name"], err = json.Marshal(r.ClientName(name)); err != nil {
+		return err
+	}
+	o, ok := r.overrides[name]
+	if !ok {
+		return nil
+	}
+	if o.Description != "" {
+		if tool["description"], err = json.Marshal(o.Description); err != nil {
+			return err
+		}
+	}
+	if len(o.Arguments) == 0 {
+		return nil
+	}
+
+	var schema map[string]json.RawMessage
+	if err := json.Unmarshal(tool["inputSchema"], &schema); err != nil {
+		return nil
+	}
+	var properties map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(schema["properties"], &properties); err != nil {
+		return nil
+	}
+	for _, arg := range o.Arguments {
+		property, ok := properties[arg.Name]
+		if !ok {
+			r.logger.Debugf("Tool %s has no argument %s to describe", name, arg.Name)
+			continue
+		}
+		if property == nil {
+			property = make(map[string]json.RawMessage)
+			properties[arg.Name] = property
+		}
+		if property["description"], err = json.Marshal(arg.Description); err != nil {
+			return err
+		}
+	}
+	if schema["properties"], err = json.Marshal(properties); err != nil {
+		return err
+	}
+	tool["inputSchema"], err = json.Marshal(schema)
+	return err
+}
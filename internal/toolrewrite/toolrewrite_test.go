@@ -0,0 +1,123 @@
+package toolrewrite
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+var testConfig = config.ToolRewriteConfig{
+	Prefix: "sqlpp_",
+	Tools: []config.ToolOverride{
+		{
+			Name:        "execute",
+			Rename:      "run_sql",
+			Description: "Run SQL against the reporting database",
+			Arguments:   []config.ToolArgument{{Name: "sql", Description: "SQL script"}, {Name: "missing", Description: "ignored"}},
+		},
+		{Name: "list_tables", Description: "List the tables of a schema"},
+	},
+}
+
+const toolList = `{"tools":[
+	{"name":"execute","description":"Execute SQL","inputSchema":{"type":"object","properties":{"sql":{"type":"string","description":"SQL"},"timeout":{"type":"number"}},"required":["sql"]}},
+	{"name":"list_tables","inputSchema":{"type":"object"}},
+	{"name":"run_sql","description":"Shadowed by the renamed execute tool"},
+	{"name":"describe_table","description":"Describe a table"}
+],"nextCursor":"abc"}`
+
+func TestNames(t *testing.T) {
+	r := New(testConfig, newTestLogger(t))
+
+	assert.Equal(t, "sqlpp_run_sql", r.ClientName("execute"))
+	assert.Equal(t, "sqlpp_describe_table", r.ClientName("describe_table"))
+
+	for name, want := range map[string]string{
+		"sqlpp_run_sql":        "execute",
+		"sqlpp_list_tables":    "list_tables",
+		"sqlpp_describe_table": "describe_table",
+	} {
+		tool, ok := r.UpstreamName(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, tool)
+	}
+	for _, name := range []string{"sqlpp_execute", "run_sql", "list_tables", ""} {
+		_, ok := r.UpstreamName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestToolsList(t *testing.T) {
+	r := New(testConfig, newTestLogger(t))
+	upstream := func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(toolList)}, nil
+	}
+	list, err := mcp.NewRequest(json.RawMessage("1"), "tools/list", nil)
+	require.NoError(t, err)
+
+	resp, err := r.Middleware()(upstream)(context.Background(), proxy.NewSession("test", nil), list)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"tools":[
+		{"name":"sqlpp_run_sql","description":"Run SQL against the reporting database","inputSchema":{"type":"object","properties":{"sql":{"type":"string","description":"SQL script"},"timeout":{"type":"number"}},"required":["sql"]}},
+		{"name":"sqlpp_list_tables","description":"List the tables of a schema","inputSchema":{"type":"object"}},
+		{"name":"sqlpp_describe_table","description":"Describe a table"}
+	],"nextCursor":"abc"}`, string(resp.Result))
+}
+
+func TestToolsCall(t *testing.T) {
+	r := New(testConfig, newTestLogger(t))
+	var forwarded *mcp.Message
+	upstream := func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+		forwarded = req
+		return &mcp.Message{JSONRPC: "2.0", ID: req.ID, Result: json.RawMessage(`{"content":[]}`)}, nil
+	}
+	handler := r.Middleware()(upstream)
+	s := proxy.NewSession("test", nil)
+
+	params := map[string]interface{}{"name": "sqlpp_run_sql", "arguments": map[string]string{"sql": "SELECT 1"}, "_meta": map[string]int{"progressToken": 7}}
+	call, err := mcp.NewRequest(json.RawMessage("1"), "tools/call", params)
+	require.NoError(t, err)
+	resp, err := handler(context.Background(), s, call)
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(resp.ID))
+	assert.JSONEq(t, `{"name":"execute","arguments":{"sql":"SELECT 1"},"_meta":{"progressToken":7}}`, string(forwarded.Params))
+	assert.Equal(t, "sqlpp_run_sql", mcp.ToolName(call), "the client's request is not modified")
+
+	// Upstream names of renamed or prefixed tools are not callable
+	for _, name := range []string{"execute", "sqlpp_execute", "describe_table"} {
+		forwarded = nil
+		call, err := mcp.NewRequest(json.RawMessage("2"), "tools/call", map[string]string{"name": name})
+		require.NoError(t, err)
+		_, err = handler(context.Background(), s, call)
+		var rpcErr *mcp.Error
+		require.ErrorAs(t, err, &rpcErr, name)
+		assert.Equal(t, mcp.InvalidParams, rpcErr.Code)
+		assert.Nil(t, forwarded)
+	}
+
+	// Other methods pass through unchanged
+	ping, err := mcp.NewRequest(json.RawMessage("3"), "ping", nil)
+	require.NoError(t, err)
+	_, err = handler(context.Background(), s, ping)
+	require.NoError(t, err)
+	assert.Same(t, ping, forwarded)
+}
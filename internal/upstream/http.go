@@ -0,0 +1,201 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// errResponseFound stops reading an event stream once the response has arrived
+var errResponseFound = errors.New("response found")
+
+// HTTP is an upstream MCP server reached over the Streamable HTTP transport.
+// Each HTTP upstream holds a single MCP session: the Mcp-Session-Id returned by
+// initialize and the negotiated protocol version are sent with later requests.
+type HTTP struct {
+	url     string
+	client  *http.Client
+	handler MessageHandler
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+// NewHTTP creates an upstream that posts messages to url
+func NewHTTP(url string, handler MessageHandler) *HTTP {
+	return &HTTP{
+		url:     url,
+		client:  &http.Client{},
+		handler: handler,
+	}
+}
+
+// URL returns the endpoint the upstream posts to
+func (h *HTTP) URL() string {
+	return h.url
+}
+
+// SessionID returns the MCP session id assigned by the server, if any
+func (h *HTTP) SessionID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessionID
+}
+
+// Call implements Upstream. Server-sent events that precede the response are
+// passed to the message handler.
+func (h *HTTP) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	resp, err := h.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result *mcp.Message
+	want := req.IDKey()
+	consume := func(msg *mcp.Message) bool {
+		if msg.IsResponse() && msg.IDKey() == want {
+			result = msg
+			return true
+		}
+		if h.handler != nil {
+			h.handler(msg)
+		}
+		return false
+	}
+
+	if isEventStream(resp) {
+		err := mcp.ReadEvents(resp.Body, func(event *mcp.Event) error {
+			msgs, _, err := mcp.ParseBatch([]byte(event.Data))
+			if err != nil {
+				return nil // not a JSON-RPC event
+			}
+			for _, msg := range msgs {
+				if consume(msg) {
+					return errResponseFound
+				}
+			}
+			return nil
+		})
+		if err != nil && err != errResponseFound {
+			return nil, err
+		}
+	} else {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream response: %w", err)
+		}
+		msgs, _, err := mcp.ParseBatch(body)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			consume(msg)
+		}
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("upstream sent no response for request %s", want)
+	}
+	if req.Method == "initialize" && result.Error == nil {
+		h.rememberProtocolVersion(result)
+	}
+	return result, nil
+}
+
+// Send implements Upstream
+func (h *HTTP) Send(ctx context.Context, msg *mcp.Message) error {
+	resp, err := h.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// Close terminates the MCP session, if the server assigned one
+func (h *HTTP) Close() error {
+	sessionID := h.SessionID()
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, h.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Mcp-Session-Id", sessionID)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to close upstream session: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (h *HTTP) post(ctx context.Context, msg *mcp.Message) (*http.Response, error) {
+	body, err := msg.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	h.mu.Lock()
+	if h.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", h.sessionID)
+	}
+	if h.protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", h.protocolVersion)
+	}
+	h.mu.Unlock()
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach upstream: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+
+	if sessionID := resp.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		h.mu.Lock()
+		h.sessionID = sessionID
+		h.mu.Unlock()
+	}
+	return resp, nil
+}
+
+func (h *HTTP) rememberProtocolVersion(resp *mcp.Message) {
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil || result.ProtocolVersion == "" {
+		return
+	}
+	h.mu.Lock()
+	h.protocolVersion = result.ProtocolVersion
+	h.mu.Unlock()
+}
+
+func isEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
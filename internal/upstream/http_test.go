@@ -0,0 +1,110 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPCallJSONAndSession(t *testing.T) {
+	var seenHeaders []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenHeaders = append(seenHeaders, r.Header.Clone())
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		msg, err := mcp.Parse(body)
+		require.NoError(t, err)
+
+		if msg.IsNotification() {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if msg.Method == "initialize" {
+			w.Header().Set("Mcp-Session-Id", "session-123")
+			resp, _ := mcp.NewResult(msg.ID, map[string]string{"protocolVersion": "2025-06-18"})
+			w.Write([]byte(resp.String()))
+			return
+		}
+		resp, _ := mcp.NewResult(msg.ID, map[string]string{"method": msg.Method})
+		w.Write([]byte(resp.String()))
+	}))
+	defer server.Close()
+
+	h := NewHTTP(server.URL+"/mcp", nil)
+	ctx := context.Background()
+
+	initReq, _ := mcp.NewRequest(json.RawMessage(`1`), "initialize", map[string]string{"protocolVersion": "2025-06-18"})
+	_, err := h.Call(ctx, initReq)
+	require.NoError(t, err)
+	assert.Equal(t, "session-123", h.SessionID())
+
+	note, _ := mcp.NewNotification("notifications/initialized", nil)
+	require.NoError(t, h.Send(ctx, note))
+
+	listReq, _ := mcp.NewRequest(json.RawMessage(`2`), "tools/list", nil)
+	resp, err := h.Call(ctx, listReq)
+	require.NoError(t, err)
+	assert.Equal(t, `{"method":"tools/list"}`, string(resp.Result))
+
+	require.NoError(t, h.Close())
+
+	require.Len(t, seenHeaders, 4)
+	assert.Empty(t, seenHeaders[0].Get("Mcp-Session-Id"))
+	for _, header := range seenHeaders[1:] {
+		assert.Equal(t, "session-123", header.Get("Mcp-Session-Id"))
+	}
+	assert.Equal(t, "2025-06-18", seenHeaders[2].Get("MCP-Protocol-Version"))
+}
+
+func TestHTTPCallEventStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		msg, _ := mcp.Parse(body)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		progress, _ := mcp.NewNotification("notifications/progress", map[string]int{"progress": 50})
+		mcp.WriteEvent(w, &mcp.Event{Event: "message", Data: progress.String()})
+		resp, _ := mcp.NewResult(msg.ID, map[string]bool{"done": true})
+		mcp.WriteEvent(w, &mcp.Event{Event: "message", Data: resp.String()})
+	}))
+	defer server.Close()
+
+	var notifications []*mcp.Message
+	h := NewHTTP(server.URL, func(msg *mcp.Message) { notifications = append(notifications, msg) })
+
+	req, _ := mcp.NewRequest(json.RawMessage(`"q"`), "tools/call", nil)
+	resp, err := h.Call(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, `{"done":true}`, string(resp.Result))
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "notifications/progress", notifications[0].Method)
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backend down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	h := NewHTTP(server.URL, nil)
+	req, _ := mcp.NewRequest(json.RawMessage(`1`), "ping", nil)
+	_, err := h.Call(context.Background(), req)
+
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	assert.Equal(t, "backend down", statusErr.Body)
+}
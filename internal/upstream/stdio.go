@@ -0,0 +1,293 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// DefaultStdioArgs are the arguments that start mcp_sqlpp in stdio mode
+var DefaultStdioArgs = []string{"-t", "stdio"}
+
+// ErrClosed is returned for calls on an upstream whose process has exited
+var ErrClosed = errors.New("upstream closed")
+
+// StdioOptions configures a spawned upstream process
+type StdioOptions struct {
+	ExePath string
+	Args    []string       // Defaults to DefaultStdioArgs
+	Env     []string       // Extra environment variables (KEY=value)
+	Handler MessageHandler // Receives server-initiated messages
+	Stderr  io.Writer      // Defaults to os.Stderr
+	Logger  *logging.Logger
+}
+
+// Stdio is an upstream process spoken to over its stdin and stdout. Request ids
+// are rewritten to ids owned by the upstream connection so that requests from
+// several clients can share one process without colliding; responses are
+// restored to the id the caller used.
+type Stdio struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	handler MessageHandler
+	logger  *logging.Logger
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]*pendingCall
+	done    chan struct{}
+	err     error
+}
+
+type pendingCall struct {
+	originalID json.RawMessage
+	response   chan *mcp.Message
+}
+
+// StartStdio spawns the upstream process and starts reading its output
+func StartStdio(options StdioOptions) (*Stdio, error) {
+	args := options.Args
+	if args == nil {
+		args = DefaultStdioArgs
+	}
+
+	cmd := exec.Command(options.ExePath, args...)
+	if len(options.Env) > 0 {
+		cmd.Env = append(os.Environ(), options.Env...)
+	}
+	cmd.Stderr = options.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin of '%s': %w", options.ExePath, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout of '%s': %w", options.ExePath, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start '%s': %w", options.ExePath, err)
+	}
+
+	s := &Stdio{
+		cmd:     cmd,
+		stdin:   stdin,
+		handler: options.Handler,
+		logger:  options.Logger,
+		pending: make(map[int64]*pendingCall),
+		done:    make(chan struct{}),
+	}
+	go s.readLoop(stdout)
+	return s, nil
+}
+
+// Call implements Upstream
+func (s *Stdio) Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error) {
+	s.mu.Lock()
+	if s.isDone() {
+		s.mu.Unlock()
+		return nil, s.Err()
+	}
+	s.nextID++
+	id := s.nextID
+	call := &pendingCall{originalID: req.ID, response: make(chan *mcp.Message, 1)}
+	s.pending[id] = call
+	s.mu.Unlock()
+
+	out := req.Clone()
+	out.ID = json.RawMessage(strconv.FormatInt(id, 10))
+	if err := s.write(out); err != nil {
+		s.forget(id)
+		return nil, err
+	}
+
+	select {
+	case resp := <-call.response:
+		resp.ID = req.ID
+		return resp, nil
+	case <-ctx.Done():
+		s.forget(id)
+		s.cancel(id, ctx.Err())
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// Send implements Upstream. Cancellation notifications that refer to a request
+// sent through Call are rewritten to the id the upstream saw.
+func (s *Stdio) Send(ctx context.Context, msg *mcp.Message) error {
+	if msg.Method == "notifications/cancelled" {
+		msg = s.translateCancel(msg)
+	}
+	return s.write(msg)
+}
+
+// Close closes the upstream's stdin and waits briefly for it to exit before killing it
+func (s *Stdio) Close() error {
+	s.stdin.Close()
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(5 * time.Second):
+		return s.Kill()
+	}
+}
+
+// Kill terminates the upstream process immediately
+func (s *Stdio) Kill() error {
+	if s.cmd.Process == nil {
+		return nil
+	}
+	return s.cmd.Process.Kill()
+}
+
+// Done is closed when the upstream process has exited
+func (s *Stdio) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the upstream stopped, once Done is closed
+func (s *Stdio) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return ErrClosed
+}
+
+// Pid returns the process id of the upstream process
+func (s *Stdio) Pid() int {
+	if s.cmd.Process == nil {
+		return 0
+	}
+	return s.cmd.Process.Pid
+}
+
+func (s *Stdio) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		// Children on protocol versions with batches may write several
+		// messages on one line
+		msgs, _, err := mcp.ParseBatch(line)
+		if err != nil {
+			s.logger.Errorf("Discarding invalid upstream output: %s", string(line))
+			continue
+		}
+		for _, msg := range msgs {
+			if msg.IsResponse() && s.deliver(msg) {
+				continue
+			}
+			if s.handler != nil {
+				s.handler(msg)
+			}
+		}
+	}
+
+	waitErr := s.cmd.Wait()
+
+	s.mu.Lock()
+	if waitErr != nil {
+		s.err = fmt.Errorf("%w: %v", ErrClosed, waitErr)
+	}
+	close(s.done)
+	s.mu.Unlock()
+}
+
+// deliver hands a response to the Call waiting for it
+func (s *Stdio) deliver(msg *mcp.Message) bool {
+	id, err := strconv.ParseInt(mcp.IDKey(msg.ID), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	call, ok := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	call.response <- msg
+	return true
+}
+
+func (s *Stdio) forget(id int64) {
+	s.mu.Lock()
+	delete(s.pending, id)
+	s.mu.Unlock()
+}
+
+// cancel tells the upstream to stop working on an abandoned request
+func (s *Stdio) cancel(id int64, reason error) {
+	note, err := mcp.NewNotification("notifications/cancelled", map[string]interface{}{
+		"requestId": id,
+		"reason":    reason.Error(),
+	})
+	if err == nil {
+		s.write(note)
+	}
+}
+
+func (s *Stdio) translateCancel(msg *mcp.Message) *mcp.Message {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return msg
+	}
+	requestID := mcp.IDKey(params["requestId"])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, call := range s.pending {
+		if mcp.IDKey(call.originalID) == requestID {
+			params["requestId"] = json.RawMessage(strconv.FormatInt(id, 10))
+			translated := msg.Clone()
+			translated.Params, _ = json.Marshal(params)
+			return translated
+		}
+	}
+	return msg
+}
+
+func (s *Stdio) write(msg *mcp.Message) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if _, err := s.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to upstream: %w", err)
+	}
+	return nil
+}
+
+func (s *Stdio) isDone() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
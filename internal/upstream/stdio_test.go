@@ -0,0 +1,213 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary double as a fake mcp_sqlpp server
+func TestMain(m *testing.M) {
+	if os.Getenv("UPSTREAM_TEST_SERVER") == "1" {
+		runFakeServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runFakeServer echoes requests back: the result carries the method and params
+// it received. "slow" waits before answering, "notify" sends a notification
+// first, "batch" sends one along with the response in a batch and "exit"
+// terminates the process.
+func runFakeServer() {
+	var mu sync.Mutex
+	write := func(v interface{}) {
+		data, _ := json.Marshal(v)
+		mu.Lock()
+		fmt.Println(string(data))
+		mu.Unlock()
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		msg, err := mcp.Parse(scanner.Bytes())
+		if err != nil {
+			fmt.Println("not json")
+			continue
+		}
+		if !msg.IsRequest() {
+			if msg.Method == "notifications/cancelled" {
+				write(map[string]interface{}{"jsonrpc": "2.0", "method": "test/cancelled", "params": msg.Params})
+			}
+			continue
+		}
+		switch msg.Method {
+		case "exit":
+			os.Exit(3)
+		case "notify":
+			write(map[string]interface{}{"jsonrpc": "2.0", "method": "notifications/message", "params": map[string]string{"data": "hello"}})
+		case "batch":
+			write([]interface{}{
+				map[string]interface{}{"jsonrpc": "2.0", "method": "notifications/message", "params": map[string]string{"data": "hello"}},
+				map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": map[string]interface{}{"method": msg.Method}},
+			})
+			continue
+		case "slow":
+			go func(msg *mcp.Message) {
+				time.Sleep(200 * time.Millisecond)
+				write(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": map[string]interface{}{"method": msg.Method}})
+			}(msg)
+			continue
+		}
+		write(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": map[string]interface{}{"method": msg.Method, "params": msg.Params, "id": msg.ID}})
+	}
+}
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+func startFakeStdio(t *testing.T, handler MessageHandler) *Stdio {
+	t.Setenv("UPSTREAM_TEST_SERVER", "1")
+	s, err := StartStdio(StdioOptions{
+		ExePath: os.Args[0],
+		Args:    []string{},
+		Handler: handler,
+		Logger:  newTestLogger(t),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStdioCallRestoresID(t *testing.T) {
+	s := startFakeStdio(t, nil)
+
+	req, err := mcp.NewRequest(json.RawMessage(`"client-1"`), "tools/list", map[string]int{"page": 1})
+	require.NoError(t, err)
+
+	resp, err := s.Call(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, `"client-1"`, string(resp.ID))
+
+	var result struct {
+		Method string          `json:"method"`
+		ID     json.RawMessage `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Result, &result))
+	assert.Equal(t, "tools/list", result.Method)
+	// The upstream saw an id owned by the connection, not the client's
+	assert.Equal(t, "1", string(result.ID))
+}
+
+func TestStdioConcurrentCallsWithSameID(t *testing.T) {
+	s := startFakeStdio(t, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			method := fmt.Sprintf("method/%d", i)
+			req, _ := mcp.NewRequest(json.RawMessage(`1`), method, nil)
+			resp, err := s.Call(context.Background(), req)
+			require.NoError(t, err)
+			assert.Contains(t, string(resp.Result), method)
+			assert.Equal(t, "1", string(resp.ID))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStdioServerInitiatedMessages(t *testing.T) {
+	received := make(chan *mcp.Message, 1)
+	s := startFakeStdio(t, func(msg *mcp.Message) { received <- msg })
+
+	req, _ := mcp.NewRequest(json.RawMessage(`5`), "notify", nil)
+	_, err := s.Call(context.Background(), req)
+	require.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "notifications/message", msg.Method)
+	case <-time.After(2 * time.Second):
+		t.Fatal("notification was not delivered to the handler")
+	}
+}
+
+func TestStdioBatchedOutput(t *testing.T) {
+	received := make(chan *mcp.Message, 1)
+	s := startFakeStdio(t, func(msg *mcp.Message) { received <- msg })
+
+	req, _ := mcp.NewRequest(json.RawMessage(`5`), "batch", nil)
+	resp, err := s.Call(context.Background(), req)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp.Result), "batch")
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "notifications/message", msg.Method)
+	case <-time.After(2 * time.Second):
+		t.Fatal("batched notification was not delivered to the handler")
+	}
+}
+
+func TestStdioCallCancelled(t *testing.T) {
+	received := make(chan *mcp.Message, 1)
+	s := startFakeStdio(t, func(msg *mcp.Message) { received <- msg })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := mcp.NewRequest(json.RawMessage(`"slow-1"`), "slow", nil)
+	_, err := s.Call(ctx, req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The upstream is told which of its requests was abandoned
+	select {
+	case msg := <-received:
+		assert.Equal(t, "test/cancelled", msg.Method)
+		assert.Contains(t, string(msg.Params), `"requestId":1`)
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancellation was not sent upstream")
+	}
+}
+
+func TestStdioProcessExit(t *testing.T) {
+	s := startFakeStdio(t, nil)
+
+	req, _ := mcp.NewRequest(json.RawMessage(`1`), "exit", nil)
+	_, err := s.Call(context.Background(), req)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done was not closed after the process exited")
+	}
+
+	_, err = s.Call(context.Background(), req)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestStartStdioMissingExecutable(t *testing.T) {
+	_, err := StartStdio(StdioOptions{ExePath: "/definitely/does/not/exist"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to start")
+}
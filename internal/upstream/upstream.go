@@ -0,0 +1,33 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+
+	"gosqlpp-mcp-proxy/internal/mcp"
+)
+
+// Upstream is a connection to an MCP server such as mcp_sqlpp
+type Upstream interface {
+	// Call sends a request and waits for the response carrying the same id
+	Call(ctx context.Context, req *mcp.Message) (*mcp.Message, error)
+	// Send forwards a notification, or a response to a server-initiated request
+	Send(ctx context.Context, msg *mcp.Message) error
+	// Close shuts the connection down
+	Close() error
+}
+
+// MessageHandler receives messages the server sends on its own initiative:
+// notifications and server-to-client requests
+type MessageHandler func(msg *mcp.Message)
+
+// StatusError is returned when an HTTP upstream answers with an unexpected status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream returned HTTP %d: %s", e.StatusCode, e.Body)
+}
@@ -0,0 +1,280 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// object is a decoded JSON object whose members are decoded on demand
+type object map[string]json.RawMessage
+
+// jsonType returns the JSON type of a value: "string", "number", "boolean",
+// "null", "object", "array", or "" when the value is absent
+func jsonType(raw json.RawMessage) string {
+	trimmed := strings.TrimSpace(string(raw))
+	if trimmed == "" {
+		return ""
+	}
+	switch trimmed[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// decodeObject decodes raw as an object, reporting false if it is not one
+func decodeObject(raw json.RawMessage) (object, bool) {
+	var obj object
+	if jsonType(raw) != "object" || json.Unmarshal(raw, &obj) != nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// require checks that the members named in fields exist with the given types
+// (e.g. "name:string") and returns a description of the first problem
+func (o object) require(fields ...string) string {
+	for _, field := range fields {
+		name, want, _ := strings.Cut(field, ":")
+		got := jsonType(o[name])
+		if got == "" {
+			return fmt.Sprintf("missing %s", name)
+		}
+		if got != want {
+			return fmt.Sprintf("%s must be %s, got %s", name, want, got)
+		}
+	}
+	return ""
+}
+
+// optional checks the types of members that may be absent
+func (o object) optional(fields ...string) string {
+	for _, field := range fields {
+		name, want, _ := strings.Cut(field, ":")
+		if got := jsonType(o[name]); got != "" && got != want {
+			return fmt.Sprintf("%s must be %s, got %s", name, want, got)
+		}
+	}
+	return ""
+}
+
+// checkParams validates the params of a request against its method's schema
+func checkParams(c *check) {
+	params := c.msg.Params
+	if t := jsonType(params); t != "" && t != "object" {
+		c.report(RuleInvalidParams, "params must be object, got %s", t)
+		return
+	}
+	obj, _ := decodeObject(params)
+
+	var problem string
+	switch c.msg.Method {
+	case "initialize":
+		problem = obj.require("protocolVersion:string", "capabilities:object", "clientInfo:object")
+		if problem == "" {
+			problem = implementation(obj["clientInfo"], "clientInfo")
+		}
+	case "tools/call":
+		problem = obj.require("name:string")
+		if problem == "" {
+			problem = obj.optional("arguments:object")
+		}
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+		problem = obj.require("uri:string")
+	case "prompts/get":
+		problem = obj.require("name:string")
+		if problem == "" {
+			problem = obj.optional("arguments:object")
+		}
+	case "tools/list", "resources/list", "resources/templates/list", "prompts/list":
+		problem = obj.optional("cursor:string")
+	}
+	if problem != "" {
+		c.report(RuleInvalidParams, "%s", problem)
+	}
+}
+
+// checkResult validates a successful result against the schema of the request's method
+func checkResult(c *check, method, version string) {
+	obj, ok := decodeObject(c.msg.Result)
+	if !ok {
+		c.report(RuleInvalidResult, "result must be object, got %s", jsonType(c.msg.Result))
+		return
+	}
+
+	var problem string
+	switch method {
+	case "initialize":
+		problem = obj.require("protocolVersion:string", "capabilities:object", "serverInfo:object")
+		if problem == "" {
+			problem = implementation(obj["serverInfo"], "serverInfo")
+		}
+		if problem == "" {
+			problem = obj.optional("instructions:string")
+		}
+	case "tools/list":
+		problem = toolList(obj, version)
+	case "resources/list":
+		problem = list(obj, "resources", "uri:string", "name:string")
+	case "prompts/list":
+		problem = list(obj, "prompts", "name:string")
+	case "tools/call":
+		if problem := toolResult(obj, version); problem != "" {
+			c.report(RuleInvalidToolResult, "%s", problem)
+		}
+		return
+	}
+	if problem != "" {
+		c.report(RuleInvalidResult, "%s", problem)
+	}
+}
+
+// implementation checks a clientInfo or serverInfo object
+func implementation(raw json.RawMessage, field string) string {
+	obj, _ := decodeObject(raw)
+	if problem := obj.require("name:string", "version:string"); problem != "" {
+		return field + ": " + problem
+	}
+	return ""
+}
+
+// list checks a paginated list result whose items need the given fields
+func list(obj object, member string, fields ...string) string {
+	if problem := obj.require(member + ":array"); problem != "" {
+		return problem
+	}
+	if problem := obj.optional("nextCursor:string"); problem != "" {
+		return problem
+	}
+	var items []json.RawMessage
+	json.Unmarshal(obj[member], &items)
+	for i, raw := range items {
+		item, ok := decodeObject(raw)
+		if !ok {
+			return fmt.Sprintf("%s[%d] must be an object", member, i)
+		}
+		if problem := item.require(fields...); problem != "" {
+			return fmt.Sprintf("%s[%d]: %s", member, i, problem)
+		}
+	}
+	return ""
+}
+
+// toolList checks a tools/list result
+func toolList(obj object, version string) string {
+	if problem := list(obj, "tools", "name:string", "inputSchema:object"); problem != "" {
+		return problem
+	}
+	var tools []object
+	json.Unmarshal(obj["tools"], &tools)
+	for i, tool := range tools {
+		if problem := schemaObject(tool["inputSchema"], "inputSchema"); problem != "" {
+			return fmt.Sprintf("tools[%d]: %s", i, problem)
+		}
+		if jsonType(tool["outputSchema"]) != "" {
+			if version < "2025-06-18" {
+				return fmt.Sprintf("tools[%d]: outputSchema is not part of protocol version %s", i, version)
+			}
+			if problem := schemaObject(tool["outputSchema"], "outputSchema"); problem != "" {
+				return fmt.Sprintf("tools[%d]: %s", i, problem)
+			}
+		}
+		if problem := tool.optional("description:string", "annotations:object"); problem != "" {
+			return fmt.Sprintf("tools[%d]: %s", i, problem)
+		}
+	}
+	return ""
+}
+
+// schemaObject checks a tool's input or output schema, which must describe an object
+func schemaObject(raw json.RawMessage, field string) string {
+	schema, ok := decodeObject(raw)
+	if !ok {
+		return field + " must be an object"
+	}
+	var typ string
+	json.Unmarshal(schema["type"], &typ)
+	if typ != "object" {
+		return fmt.Sprintf("%s.type is %q, must be \"object\"", field, typ)
+	}
+	return ""
+}
+
+// toolResult checks a tools/call result
+func toolResult(obj object, version string) string {
+	if problem := obj.require("content:array"); problem != "" {
+		return problem
+	}
+	if problem := obj.optional("isError:boolean"); problem != "" {
+		return problem
+	}
+	if jsonType(obj["structuredContent"]) != "" {
+		if version < "2025-06-18" {
+			return fmt.Sprintf("structuredContent is not part of protocol version %s", version)
+		}
+		if problem := obj.optional("structuredContent:object"); problem != "" {
+			return problem
+		}
+	}
+
+	var content []json.RawMessage
+	json.Unmarshal(obj["content"], &content)
+	for i, raw := range content {
+		if problem := contentBlock(raw, version); problem != "" {
+			return fmt.Sprintf("content[%d]: %s", i, problem)
+		}
+	}
+	return ""
+}
+
+// contentBlock checks one item of a tool result's content
+func contentBlock(raw json.RawMessage, version string) string {
+	block, ok := decodeObject(raw)
+	if !ok {
+		return "must be an object"
+	}
+	if problem := block.require("type:string"); problem != "" {
+		return problem
+	}
+	var typ string
+	json.Unmarshal(block["type"], &typ)
+
+	switch typ {
+	case "text":
+		return block.require("text:string")
+	case "image":
+		return block.require("data:string", "mimeType:string")
+	case "audio":
+		if version < "2025-03-26" {
+			return fmt.Sprintf("audio content is not part of protocol version %s", version)
+		}
+		return block.require("data:string", "mimeType:string")
+	case "resource":
+		if problem := block.require("resource:object"); problem != "" {
+			return problem
+		}
+		resource, _ := decodeObject(block["resource"])
+		if problem := resource.require("uri:string"); problem != "" {
+			return "resource: " + problem
+		}
+		if jsonType(resource["text"]) != "string" && jsonType(resource["blob"]) != "string" {
+			return "resource must have a text or a blob"
+		}
+		return ""
+	case "resource_link":
+		if version < "2025-06-18" {
+			return fmt.Sprintf("resource_link content is not part of protocol version %s", version)
+		}
+		return block.require("uri:string", "name:string")
+	}
+	return fmt.Sprintf("unknown content type %q", typ)
+}
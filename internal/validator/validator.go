@@ -0,0 +1,314 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+)
+
+// Violation rules
+const (
+	RuleJSONRPC           = "jsonrpc-version"      // jsonrpc field missing or not "2.0"
+	RuleInvalidMessage    = "invalid-message"      // Neither a request, a notification nor a response
+	RuleInvalidID         = "invalid-id"           // Request id that is not a string or an integer
+	RuleIDTypeMismatch    = "id-type-mismatch"     // Response id of a different JSON type than the request id
+	RuleUnknownResponseID = "unknown-response-id"  // Response to no outstanding request
+	RuleDuplicateID       = "duplicate-request-id" // Request id reused while the first request is outstanding
+	RuleNotInitialized    = "not-initialized"      // Message sent before initialization completed
+	RuleBatch             = "batch-not-allowed"    // JSON-RPC batch under a protocol version without batching
+	RuleProtocolVersion   = "protocol-version"     // Negotiated protocol version the validator does not know
+	RuleInvalidParams     = "invalid-params"       // Request params that do not match the schema
+	RuleInvalidError      = "invalid-error"        // Error object without an integer code and a message
+	RuleInvalidResult     = "invalid-result"       // Result that does not match the schema for its method
+	RuleInvalidToolResult = "invalid-tool-result"  // tools/call result that does not match the schema
+)
+
+// LatestVersion is the newest protocol version the validator knows. Sessions
+// whose version is not known yet are validated against it.
+const LatestVersion = "2025-06-18"
+
+// knownVersions lists the protocol versions whose schema the validator checks
+var knownVersions = map[string]bool{
+	"2024-11-05": true,
+	"2025-03-26": true,
+	"2025-06-18": true,
+}
+
+// Status reports the number of violations seen, in total and per rule
+type Status struct {
+	Total int64            `json:"total"`
+	Rules map[string]int64 `json:"rules"`
+}
+
+// Validator passively checks the traffic of every session against the MCP
+// schema of the session's negotiated protocol version. Messages are never
+// changed or rejected; violations are logged and counted.
+type Validator struct {
+	logger *logging.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	total    int64
+	counts   map[string]int64
+}
+
+// session is the protocol state of one client connection
+type session struct {
+	version       string // Negotiated protocol version, "" until initialize is answered
+	initRequested bool   // The client sent initialize
+	initAnswered  bool   // The server answered initialize
+	initialized   bool   // The client sent notifications/initialized
+
+	// Outstanding requests by id, indexed by the direction the request was sent in
+	pending [2]map[string]pendingRequest
+}
+
+type pendingRequest struct {
+	method string
+}
+
+// New creates a validator
+func New(logger *logging.Logger) *Validator {
+	return &Validator{
+		logger:   logger,
+		sessions: make(map[string]*session),
+		counts:   make(map[string]int64),
+	}
+}
+
+// Observe checks a message; it has the signature of proxy.Observer
+func (v *Validator) Observe(sessionID string, dir proxy.Direction, msg *mcp.Message, batch bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.sessions[sessionID]
+	if !ok {
+		s = &session{pending: [2]map[string]pendingRequest{{}, {}}}
+		v.sessions[sessionID] = s
+	}
+	c := &check{v: v, dir: dir, msg: msg}
+
+	if msg.JSONRPC != "2.0" {
+		c.report(RuleJSONRPC, "jsonrpc is %q, must be \"2.0\"", msg.JSONRPC)
+	}
+	if batch && s.schemaVersion() >= "2025-06-18" {
+		c.report(RuleBatch, "JSON-RPC batches are not supported in protocol version %s", s.schemaVersion())
+	}
+
+	switch {
+	case msg.Method != "" && msg.ID != nil:
+		v.checkRequest(c, s)
+	case msg.Method != "":
+		v.checkNotification(c, s)
+	case msg.ID != nil && (msg.Result != nil || msg.Error != nil):
+		v.checkResponse(c, s)
+	default:
+		c.report(RuleInvalidMessage, "message has neither a method nor a result or error")
+	}
+}
+
+// Forget drops the state of a session that has ended
+func (v *Validator) Forget(sessionID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.sessions, sessionID)
+}
+
+// Status returns the violation counters
+func (v *Validator) Status() Status {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	status := Status{Total: v.total, Rules: make(map[string]int64, len(v.counts))}
+	for rule, n := range v.counts {
+		status.Rules[rule] = n
+	}
+	return status
+}
+
+// LogSummary writes the violation counters to the log
+func (v *Validator) LogSummary() {
+	status := v.Status()
+	rules := make([]string, 0, len(status.Rules))
+	for rule := range status.Rules {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	v.logger.Infof("Protocol validation found %d violations", status.Total)
+	for _, rule := range rules {
+		v.logger.Infof("  %s: %d", rule, status.Rules[rule])
+	}
+}
+
+// Register adds the validation endpoint to the admin interface
+func (v *Validator) Register(srv *admin.Server) {
+	srv.HandleFunc("GET /validation", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, v.Status())
+	})
+}
+
+func (v *Validator) checkRequest(c *check, s *session) {
+	msg := c.msg
+	if !validID(msg.ID) {
+		c.report(RuleInvalidID, "request id %s must be a string or an integer", msg.ID)
+	}
+
+	pending := s.pending[c.dir]
+	key := msg.IDKey()
+	if p, ok := pending[key]; ok {
+		c.report(RuleDuplicateID, "request id %s is already in use by an outstanding %s request", key, p.method)
+	}
+	pending[key] = pendingRequest{method: msg.Method}
+
+	if c.dir == proxy.FromClient {
+		switch {
+		case msg.Method == "initialize":
+			if s.initRequested {
+				c.report(RuleNotInitialized, "initialize sent more than once")
+			}
+			s.initRequested = true
+		case msg.Method != "ping" && !s.initialized:
+			c.report(RuleNotInitialized, "request sent before notifications/initialized")
+		}
+	} else if msg.Method != "ping" && !s.initialized {
+		c.report(RuleNotInitialized, "server request sent before the client sent notifications/initialized")
+	}
+
+	checkParams(c)
+}
+
+func (v *Validator) checkNotification(c *check, s *session) {
+	msg := c.msg
+	if c.dir == proxy.FromClient {
+		if msg.Method == "notifications/initialized" {
+			if !s.initAnswered {
+				c.report(RuleNotInitialized, "notifications/initialized sent before the initialize response")
+			}
+			s.initialized = true
+		}
+		return
+	}
+
+	switch msg.Method {
+	case "notifications/message", "notifications/progress", "notifications/cancelled":
+	default:
+		if !s.initialized {
+			c.report(RuleNotInitialized, "server notification sent before the client sent notifications/initialized")
+		}
+	}
+}
+
+func (v *Validator) checkResponse(c *check, s *session) {
+	msg := c.msg
+	if msg.Result != nil && msg.Error != nil {
+		c.report(RuleInvalidMessage, "response has both a result and an error")
+	}
+	if msg.Error != nil && msg.Error.Message == "" {
+		c.report(RuleInvalidError, "error has no message")
+	}
+
+	// Responses answer requests sent the other way
+	pending := s.pending[1-c.dir]
+	key := msg.IDKey()
+	req, ok := pending[key]
+	if !ok {
+		if string(msg.ID) == "null" && msg.Error != nil {
+			return // Errors for requests whose id could not be read
+		}
+		if other, found := pending[otherIDType(msg.ID)]; found {
+			c.report(RuleIDTypeMismatch, "response id %s has a different type than the id of the outstanding %s request", key, other.method)
+			delete(pending, otherIDType(msg.ID))
+		} else {
+			c.report(RuleUnknownResponseID, "response id %s matches no outstanding request", key)
+		}
+		return
+	}
+	delete(pending, key)
+	c.method = req.method
+
+	if req.method == "initialize" && c.dir == proxy.ToClient {
+		s.initAnswered = true
+		if msg.Result != nil {
+			var result struct {
+				ProtocolVersion string `json:"protocolVersion"`
+			}
+			json.Unmarshal(msg.Result, &result)
+			s.version = result.ProtocolVersion
+			if s.version != "" && !knownVersions[s.version] {
+				c.report(RuleProtocolVersion, "negotiated protocol version %q is unknown; validating against %s", s.version, LatestVersion)
+			}
+		}
+	}
+
+	if msg.Result != nil && msg.Error == nil {
+		checkResult(c, req.method, s.schemaVersion())
+	}
+}
+
+// schemaVersion returns the protocol version whose schema applies to the session
+func (s *session) schemaVersion() string {
+	if knownVersions[s.version] {
+		return s.version
+	}
+	return LatestVersion
+}
+
+// check collects the context needed to report a violation for one message
+type check struct {
+	v      *Validator
+	dir    proxy.Direction
+	msg    *mcp.Message
+	method string // Method of the request a response answers
+}
+
+func (c *check) report(rule, format string, args ...interface{}) {
+	c.v.total++
+	c.v.counts[rule]++
+
+	what := c.msg.Method
+	if what == "" {
+		what = "response"
+		if c.method != "" {
+			what = c.method + " response"
+		}
+	}
+	if c.msg.ID != nil {
+		what += " id " + c.msg.IDKey()
+	}
+	c.v.logger.Violationf("%s (%s, %s): %s", rule, c.dir, what, fmt.Sprintf(format, args...))
+}
+
+// validID reports whether a request id is a string or an integer
+func validID(id json.RawMessage) bool {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(id))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return false
+	}
+	switch v := v.(type) {
+	case string:
+		return true
+	case json.Number:
+		_, err := strconv.ParseInt(v.String(), 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+// otherIDType returns the key of the same id as the other JSON type: "1" for 1 and 1 for "1"
+func otherIDType(id json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(id, &s); err == nil {
+		return s
+	}
+	return strconv.Quote(mcp.IDKey(id))
+}
@@ -0,0 +1,264 @@
+package validator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+const (
+	initializeRequest  = `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"test","version":"1.0"}}}`
+	initializeResponse = `{"jsonrpc":"2.0","id":0,"result":{"protocolVersion":"2025-06-18","capabilities":{},"serverInfo":{"name":"mcp_sqlpp","version":"1.0"}}}`
+	initialized        = `{"jsonrpc":"2.0","method":"notifications/initialized"}`
+)
+
+// exchange feeds messages to a validator; lines starting with "<" travel to
+// the client and all others come from the client
+func exchange(t *testing.T, v *Validator, lines ...string) {
+	for _, line := range lines {
+		dir := proxy.FromClient
+		if strings.HasPrefix(line, "<") {
+			dir = proxy.ToClient
+			line = line[1:]
+		}
+		msgs, batch, err := mcp.ParseBatch([]byte(line))
+		require.NoError(t, err)
+		for _, msg := range msgs {
+			v.Observe("s1", dir, msg, batch)
+		}
+	}
+}
+
+// handshake runs a valid initialization
+func handshake(t *testing.T, v *Validator) {
+	exchange(t, v, initializeRequest, "<"+initializeResponse, initialized)
+}
+
+func TestValidSession(t *testing.T) {
+	v := New(newTestLogger(t))
+	handshake(t, v)
+	exchange(t, v,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+		`<{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"query","inputSchema":{"type":"object"},"outputSchema":{"type":"object"}}]}}`,
+		`{"jsonrpc":"2.0","id":"two","method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 1"}}}`,
+		`<{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":1,"progress":1}}`,
+		`<{"jsonrpc":"2.0","id":"two","result":{"content":[{"type":"text","text":"1"},{"type":"resource_link","uri":"db://t","name":"t"}],"structuredContent":{"x":1}}}`,
+		`<{"jsonrpc":"2.0","id":7,"method":"roots/list"}`,
+		`{"jsonrpc":"2.0","id":7,"result":{"roots":[]}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"query"}}`,
+		`<{"jsonrpc":"2.0","id":3,"error":{"code":-32602,"message":"bad sql"}}`,
+	)
+	assert.Equal(t, Status{Total: 0, Rules: map[string]int64{}}, v.Status())
+}
+
+func TestViolations(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup bool // Run the handshake first
+		lines []string
+		rule  string
+	}{
+		{
+			name:  "missing jsonrpc",
+			setup: true,
+			lines: []string{`{"id":1,"method":"ping"}`},
+			rule:  RuleJSONRPC,
+		},
+		{
+			name:  "fractional id",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1.5,"method":"ping"}`},
+			rule:  RuleInvalidID,
+		},
+		{
+			name:  "id type mismatch",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"ping"}`, `<{"jsonrpc":"2.0","id":"1","result":{}}`},
+			rule:  RuleIDTypeMismatch,
+		},
+		{
+			name:  "unknown response id",
+			setup: true,
+			lines: []string{`<{"jsonrpc":"2.0","id":42,"result":{}}`},
+			rule:  RuleUnknownResponseID,
+		},
+		{
+			name:  "duplicate request id",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"ping"}`, `{"jsonrpc":"2.0","id":1,"method":"ping"}`},
+			rule:  RuleDuplicateID,
+		},
+		{
+			name:  "request before initialized",
+			lines: []string{initializeRequest, "<" + initializeResponse, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`},
+			rule:  RuleNotInitialized,
+		},
+		{
+			name:  "initialized before initialize response",
+			lines: []string{initializeRequest, initialized},
+			rule:  RuleNotInitialized,
+		},
+		{
+			name:  "server request before initialized",
+			lines: []string{initializeRequest, `<{"jsonrpc":"2.0","id":9,"method":"roots/list"}`},
+			rule:  RuleNotInitialized,
+		},
+		{
+			name:  "batch under 2025-06-18",
+			setup: true,
+			lines: []string{`[{"jsonrpc":"2.0","id":1,"method":"ping"}]`},
+			rule:  RuleBatch,
+		},
+		{
+			name: "unknown protocol version",
+			lines: []string{initializeRequest,
+				`<{"jsonrpc":"2.0","id":0,"result":{"protocolVersion":"2099-01-01","capabilities":{},"serverInfo":{"name":"x","version":"1"}}}`},
+			rule: RuleProtocolVersion,
+		},
+		{
+			name:  "invalid initialize params",
+			lines: []string{`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{}}}`},
+			rule:  RuleInvalidParams,
+		},
+		{
+			name:  "tools/call without name",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"arguments":{}}}`},
+			rule:  RuleInvalidParams,
+		},
+		{
+			name:  "error without message",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"ping"}`, `<{"jsonrpc":"2.0","id":1,"error":{"code":-32603}}`},
+			rule:  RuleInvalidError,
+		},
+		{
+			name:  "both result and error",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"ping"}`, `<{"jsonrpc":"2.0","id":1,"result":{},"error":{"code":1,"message":"x"}}`},
+			rule:  RuleInvalidMessage,
+		},
+		{
+			name:  "tool without input schema",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, `<{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"query"}]}}`},
+			rule:  RuleInvalidResult,
+		},
+		{
+			name:  "tool result without content",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"q"}}`, `<{"jsonrpc":"2.0","id":1,"result":{"rows":[]}}`},
+			rule:  RuleInvalidToolResult,
+		},
+		{
+			name:  "text content without text",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"q"}}`, `<{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"text"}]}}`},
+			rule:  RuleInvalidToolResult,
+		},
+		{
+			name:  "unknown content type",
+			setup: true,
+			lines: []string{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"q"}}`, `<{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"table"}]}}`},
+			rule:  RuleInvalidToolResult,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New(newTestLogger(t))
+			if tt.setup {
+				handshake(t, v)
+			}
+			exchange(t, v, tt.lines...)
+
+			status := v.Status()
+			assert.Equal(t, int64(1), status.Rules[tt.rule], "rules: %v", status.Rules)
+			assert.Equal(t, int64(1), status.Total, "rules: %v", status.Rules)
+		})
+	}
+}
+
+func TestVersionSpecificSchema(t *testing.T) {
+	v := New(newTestLogger(t))
+	exchange(t, v,
+		`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1.0"}}}`,
+		`<{"jsonrpc":"2.0","id":0,"result":{"protocolVersion":"2024-11-05","capabilities":{},"serverInfo":{"name":"x","version":"1"}}}`,
+		initialized,
+		// Batches are allowed before 2025-06-18
+		`[{"jsonrpc":"2.0","id":1,"method":"ping"}]`,
+		`<[{"jsonrpc":"2.0","id":1,"result":{}}]`,
+		// structuredContent and audio are not
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"q"}}`,
+		`<{"jsonrpc":"2.0","id":2,"result":{"content":[],"structuredContent":{}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"q"}}`,
+		`<{"jsonrpc":"2.0","id":3,"result":{"content":[{"type":"audio","data":"","mimeType":"audio/wav"}]}}`,
+	)
+	assert.Equal(t, Status{Total: 2, Rules: map[string]int64{RuleInvalidToolResult: 2}}, v.Status())
+}
+
+func TestSessionsAreIndependent(t *testing.T) {
+	v := New(newTestLogger(t))
+	handshake(t, v)
+
+	msg, err := mcp.Parse([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	require.NoError(t, err)
+	v.Observe("s2", proxy.FromClient, msg, false)
+	assert.Equal(t, int64(1), v.Status().Rules[RuleNotInitialized])
+
+	// Forgetting a session clears its state
+	v.Forget("s1")
+	exchange(t, v, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	assert.Equal(t, int64(2), v.Status().Rules[RuleNotInitialized])
+}
+
+func TestViolationsAreLogged(t *testing.T) {
+	logger := newTestLogger(t)
+	v := New(logger)
+	exchange(t, v, `<{"jsonrpc":"2.0","id":5,"result":{}}`)
+	v.LogSummary()
+
+	content, err := os.ReadFile(logger.GetFilePath())
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "[VIOLATION] unknown-response-id (server->client, response id 5): response id 5 matches no outstanding request")
+	assert.Contains(t, string(content), "Protocol validation found 1 violations")
+}
+
+func TestAdminEndpoint(t *testing.T) {
+	logger := newTestLogger(t)
+	v := New(logger)
+	srv := admin.New(0, "", logger)
+	v.Register(srv)
+	exchange(t, v, `{"id":1,"method":"tools/list"}`)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/validation", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var status Status
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, int64(1), status.Rules[RuleJSONRPC])
+	assert.Equal(t, int64(1), status.Rules[RuleNotInitialized])
+	assert.Equal(t, int64(2), status.Total)
+}
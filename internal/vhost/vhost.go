@@ -0,0 +1,122 @@
+package vhost
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+	"gosqlpp-mcp-proxy/internal/toolfilter"
+)
+
+// Hosts serves several endpoints on one port, each under its own path prefix
+// with its own upstream, tokens and policies
+type Hosts struct {
+	endpoints []*endpoint // Longest prefix first
+	logger    *logging.Logger
+}
+
+// endpoint is one prefix served by Hosts
+type endpoint struct {
+	prefix     string
+	keepPrefix bool
+	tokens     []config.EndpointToken
+	server     *proxy.HTTPServer
+}
+
+// New creates the endpoints, relaying each through p with the endpoint's
+// own policies at the policy mark of p. Sessions idle for longer than
+// sessions.IdleTimeout are ended until Close.
+func New(cfgs []config.EndpointConfig, sessions config.SessionsConfig, p *proxy.Proxy, logger *logging.Logger) *Hosts {
+	h := &Hosts{logger: logger}
+	for _, cfg := range cfgs {
+		var policies []proxy.Middleware
+		if len(cfg.ToolFilter.Rules) > 0 {
+			policies = append(policies, toolfilter.New(cfg.ToolFilter, logger).Middleware())
+		}
+		if cfg.SQLPolicy.Enabled() {
+			policies = append(policies, sqlpolicy.New(cfg.SQLPolicy, logger).Middleware())
+		}
+		server := proxy.NewHTTPServer(p.With(policies...), cfg.Target)
+		server.TrackSessions(proxy.SessionOptions{IdleTimeout: sessions.IdleTimeout})
+		h.endpoints = append(h.endpoints, &endpoint{
+			prefix:     cfg.Prefix,
+			keepPrefix: cfg.KeepPrefix,
+			tokens:     cfg.Tokens,
+			server:     server,
+		})
+	}
+	sort.SliceStable(h.endpoints, func(i, j int) bool {
+		return len(h.endpoints[i].prefix) > len(h.endpoints[j].prefix)
+	})
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Hosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e := h.match(r.URL.Path)
+	if e == nil {
+		h.logger.HTTPIn(r.Method, r.URL.String())
+		h.logger.HTTPOut(http.StatusNotFound, "")
+		http.NotFound(w, r)
+		return
+	}
+
+	out := r.Clone(proxy.WithEndpoint(r.Context(), e.prefix))
+	if len(e.tokens) > 0 {
+		name, ok := e.authenticate(r)
+		if !ok {
+			h.logger.HTTPIn(r.Method, r.URL.String())
+			h.logger.Infof("Rejected unauthenticated request to endpoint %s", e.prefix)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+e.prefix+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			h.logger.HTTPOut(http.StatusUnauthorized, "")
+			return
+		}
+		// The token is for the proxy; the upstream does not get to see it
+		out.Header.Del("Authorization")
+		out = out.WithContext(proxy.WithPrincipal(out.Context(), name))
+	}
+	if !e.keepPrefix {
+		out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, e.prefix), "/")
+		out.URL.RawPath = ""
+	}
+	e.server.ServeHTTP(w, out)
+}
+
+// Close stops ending idle sessions on every endpoint
+func (h *Hosts) Close() error {
+	for _, e := range h.endpoints {
+		e.server.Close()
+	}
+	return nil
+}
+
+// match returns the endpoint whose prefix is the longest to cover a path
+func (h *Hosts) match(urlPath string) *endpoint {
+	for _, e := range h.endpoints {
+		if urlPath == e.prefix || strings.HasPrefix(urlPath, e.prefix+"/") {
+			return e
+		}
+	}
+	return nil
+}
+
+// authenticate returns the name of the token a request bears
+func (e *endpoint) authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	given := []byte(strings.TrimSpace(auth[7:]))
+	for _, token := range e.tokens {
+		if subtle.ConstantTimeCompare(given, []byte(token.Token)) == 1 {
+			return token.Name, true
+		}
+	}
+	return "", false
+}
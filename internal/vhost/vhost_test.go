@@ -0,0 +1,178 @@
+package vhost
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gosqlpp-mcp-proxy/internal/cache"
+	"gosqlpp-mcp-proxy/internal/coalesce"
+	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/mcp"
+	"gosqlpp-mcp-proxy/internal/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewDefault()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		logger.Close()
+		os.Remove(logger.GetFilePath())
+	})
+	return logger
+}
+
+// newUpstream answers requests with its name, the path posted to and the
+// Authorization header received
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		msg, err := mcp.Parse(body)
+		if err != nil || !msg.IsRequest() {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		resp, _ := mcp.NewResult(msg.ID, map[string]string{"upstream": name, "path": r.URL.Path, "auth": r.Header.Get("Authorization")})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(resp.String()))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(h http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+const ping = `{"jsonrpc":"2.0","id":1,"method":"ping"}`
+
+func TestPrefixes(t *testing.T) {
+	analytics, billing := newUpstream(t, "analytics"), newUpstream(t, "billing")
+	h := New([]config.EndpointConfig{
+		{Prefix: "/analytics", Target: analytics.URL},
+		{Prefix: "/analytics/v2", Target: billing.URL, KeepPrefix: true},
+		{Prefix: "/billing", Target: billing.URL},
+	}, config.SessionsConfig{}, proxy.New(newTestLogger(t)), newTestLogger(t))
+
+	for path, want := range map[string]string{
+		"/analytics/mcp":    `{"upstream":"analytics","path":"/mcp","auth":""}`,
+		"/analytics":        `{"upstream":"analytics","path":"/","auth":""}`,
+		"/analytics/v2/mcp": `{"upstream":"billing","path":"/analytics/v2/mcp","auth":""}`,
+		"/billing/mcp":      `{"upstream":"billing","path":"/mcp","auth":""}`,
+	} {
+		rec := post(h, path, "", ping)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":`+want+`}`, rec.Body.String(), path)
+	}
+
+	for _, path := range []string{"/", "/mcp", "/analyticsx/mcp"} {
+		assert.Equal(t, http.StatusNotFound, post(h, path, "", ping).Code, path)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	analytics := newUpstream(t, "analytics")
+	p := proxy.New(newTestLogger(t))
+	var principals []string
+	p.Use(func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			principals = append(principals, s.Principal())
+			return next(ctx, s, req)
+		}
+	})
+	h := New([]config.EndpointConfig{{
+		Prefix: "/analytics",
+		Target: analytics.URL,
+		Tokens: []config.EndpointToken{{Name: "analysts", Token: "s3cret"}, {Name: "ops", Token: "0ps"}},
+	}}, config.SessionsConfig{}, p, newTestLogger(t))
+
+	for _, token := range []string{"", "wrong", "s3cre"} {
+		rec := post(h, "/analytics/mcp", token, ping)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, token)
+		assert.Equal(t, `Bearer realm="/analytics"`, rec.Header().Get("WWW-Authenticate"))
+	}
+	assert.Empty(t, principals, "rejected requests go nowhere")
+
+	rec := post(h, "/analytics/mcp", "0ps", ping)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"upstream":"analytics","path":"/mcp","auth":""}}`, rec.Body.String(),
+		"the token is not forwarded")
+	assert.Equal(t, []string{"ops"}, principals)
+}
+
+func TestPolicies(t *testing.T) {
+	analytics, billing := newUpstream(t, "analytics"), newUpstream(t, "billing")
+	p := proxy.New(newTestLogger(t))
+	h := New([]config.EndpointConfig{
+		{Prefix: "/analytics", Target: analytics.URL, SQLPolicy: config.SQLPolicyConfig{ReadOnly: true}},
+		{Prefix: "/billing", Target: billing.URL, ToolFilter: config.ToolFilterConfig{Rules: []config.ToolFilterRule{{Deny: []string{"drop_*"}}}}},
+	}, config.SessionsConfig{}, p, newTestLogger(t))
+
+	update := `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"execute","arguments":{"sql":"DELETE FROM orders"}}}`
+	drop := `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"drop_table","arguments":{}}}`
+
+	assert.Contains(t, post(h, "/analytics/mcp", "", update).Body.String(), `"error"`)
+	assert.Contains(t, post(h, "/analytics/mcp", "", drop).Body.String(), `"result"`)
+	assert.Contains(t, post(h, "/billing/mcp", "", update).Body.String(), `"result"`)
+	assert.Contains(t, post(h, "/billing/mcp", "", drop).Body.String(), `"error"`)
+}
+
+func TestPoliciesInsideChain(t *testing.T) {
+	analytics := newUpstream(t, "analytics")
+	p := proxy.New(newTestLogger(t))
+	var seen []string
+	p.Use(func(next proxy.Handler) proxy.Handler {
+		return func(ctx context.Context, s *proxy.Session, req *mcp.Message) (*mcp.Message, error) {
+			resp, err := next(ctx, s, req)
+			if err != nil || resp.Error != nil {
+				seen = append(seen, "error")
+			} else {
+				seen = append(seen, "result")
+			}
+			return resp, err
+		}
+	})
+	p.MarkPolicies()
+	h := New([]config.EndpointConfig{
+		{Prefix: "/analytics", Target: analytics.URL, SQLPolicy: config.SQLPolicyConfig{ReadOnly: true}},
+	}, config.SessionsConfig{}, p, newTestLogger(t))
+
+	post(h, "/analytics/mcp", "", `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"execute","arguments":{"sql":"DELETE FROM orders"}}}`)
+	assert.Equal(t, []string{"error"}, seen, "middleware ahead of the mark, such as the audit log, sees the denial")
+}
+
+func TestResultsKeptApart(t *testing.T) {
+	analytics, billing := newUpstream(t, "analytics"), newUpstream(t, "billing")
+	logger := newTestLogger(t)
+	p := proxy.New(logger)
+	p.Use(cache.New(config.CacheConfig{MaxEntries: 10, Scope: config.CacheGlobal, Tools: []config.CacheTool{{Name: "query", TTL: time.Minute}}}, nil, logger).Middleware())
+	p.Use(coalesce.New(config.CoalesceConfig{Tools: []string{"query"}}, nil, logger).Middleware())
+	h := New([]config.EndpointConfig{
+		{Prefix: "/analytics", Target: analytics.URL},
+		{Prefix: "/billing", Target: billing.URL},
+	}, config.SessionsConfig{}, p, logger)
+
+	call := `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT 1"}}}`
+	for i := 0; i < 2; i++ {
+		assert.Contains(t, post(h, "/analytics/mcp", "", call).Body.String(), `"upstream":"analytics"`)
+		assert.Contains(t, post(h, "/billing/mcp", "", call).Body.String(), `"upstream":"billing"`,
+			"cached results of one endpoint never answer another")
+	}
+}
@@ -1,22 +1,52 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/user"
 
+	"gosqlpp-mcp-proxy/internal/admin"
+	"gosqlpp-mcp-proxy/internal/aggregate"
+	"gosqlpp-mcp-proxy/internal/approval"
+	"gosqlpp-mcp-proxy/internal/audit"
+	"gosqlpp-mcp-proxy/internal/balance"
+	"gosqlpp-mcp-proxy/internal/bridge"
+	"gosqlpp-mcp-proxy/internal/cache"
+	"gosqlpp-mcp-proxy/internal/chaos"
+	"gosqlpp-mcp-proxy/internal/childpool"
+	"gosqlpp-mcp-proxy/internal/coalesce"
 	"gosqlpp-mcp-proxy/internal/config"
+	"gosqlpp-mcp-proxy/internal/confirm"
+	"gosqlpp-mcp-proxy/internal/eventstore"
+	"gosqlpp-mcp-proxy/internal/limits"
 	"gosqlpp-mcp-proxy/internal/logging"
+	"gosqlpp-mcp-proxy/internal/masking"
+	"gosqlpp-mcp-proxy/internal/proxy"
+	"gosqlpp-mcp-proxy/internal/redact"
+	"gosqlpp-mcp-proxy/internal/replay"
+	"gosqlpp-mcp-proxy/internal/route"
+	"gosqlpp-mcp-proxy/internal/rwsplit"
+	"gosqlpp-mcp-proxy/internal/sqlpolicy"
+	"gosqlpp-mcp-proxy/internal/toolfilter"
+	"gosqlpp-mcp-proxy/internal/toolrewrite"
+	"gosqlpp-mcp-proxy/internal/upstream"
+	"gosqlpp-mcp-proxy/internal/validator"
+	"gosqlpp-mcp-proxy/internal/vhost"
 )
 
 func main() {
 	// Parse command-line flags
 	flags := config.ParseFlags()
 
+	if len(flags.Args) > 0 && flags.Args[0] == "approvals" {
+		runApprovals(flags)
+		return
+	}
+
 	// Load configuration from all sources
 	cfg, err := config.LoadConfig(flags)
 	if err != nil {
@@ -30,87 +60,388 @@ func main() {
 	}
 	defer logger.Close()
 
+	if cfg.Redaction.Active() {
+		redactor, err := redact.New(cfg.Redaction)
+		if err != nil {
+			logger.Fatalf("Failed to set up log redaction: %v", err)
+		}
+		logger.SetRedactor(redactor)
+	}
+
 	logger.Startupf("Starting MCP SQLPP Proxy with configuration: %s", cfg.String())
 
 	switch cfg.Transport {
 	case "stdio":
 		logger.Infof("Starting in stdio mode with exe-path: %s", cfg.ExePath)
-		runStdioProxy(cfg.ExePath, logger)
+		runStdioProxy(cfg, logger)
 	case "http":
 		logger.Infof("Starting in http mode on port %d, forwarding to localhost:%d", cfg.Port, cfg.XferPort)
-		runHTTPProxy(cfg.Port, cfg.XferPort, logger)
+		runHTTPProxy(cfg, logger)
+	case "replay-server":
+		logger.Infof("Starting in replay-server mode with recording: %s", cfg.Replay.File)
+		runReplayServer(cfg.Replay, logger)
+	case "replay-client":
+		logger.Infof("Starting in replay-client mode with recording: %s", cfg.Replay.File)
+		if !runReplayClient(cfg, logger) {
+			logger.Close()
+			os.Exit(1)
+		}
 	default:
 		logger.Fatalf("Unknown transport: %s", cfg.Transport)
 	}
 }
 
-func runStdioProxy(exePath string, logger *logging.Logger) {
-	cmd := exec.Command(exePath, "-t", "stdio")
-	mcpIn, _ := cmd.StdinPipe()
-	mcpOut, _ := cmd.StdoutPipe()
-	cmd.Stderr = os.Stderr
+// newProxy builds the middleware chain shared by the stdio and http transports
+// and starts the admin interface when one is configured. The returned function
+// logs end-of-run summaries and closes the audit log.
+func newProxy(cfg *config.Config, logger *logging.Logger) (*proxy.Proxy, func()) {
+	p := proxy.New(logger)
+	var finishers []func()
+	finish := func() {
+		for _, f := range finishers {
+			f()
+		}
+	}
 
-	if err := cmd.Start(); err != nil {
-		logger.Fatalf("Failed to start mcp_sqlpp at '%s': %v", exePath, err)
+	var adminServer *admin.Server
+	if cfg.Admin.Port > 0 {
+		adminServer = admin.New(cfg.Admin.Port, cfg.Admin.Token, logger)
 	}
 
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			line := scanner.Text()
-			logger.TrafficIn(line)
-			io.WriteString(mcpIn, line+"\n")
+	if cfg.Validation.Enabled {
+		v := validator.New(logger)
+		p.Observe(v.Observe)
+		if adminServer != nil {
+			v.Register(adminServer)
 		}
-	}()
+		finishers = append(finishers, v.LogSummary)
+		logger.Infof("Protocol validation enabled")
+	}
+
+	// Protocol bridging wraps everything else, so that the client is answered
+	// on its own protocol version whatever the other middleware does
+	if cfg.Bridge.Enabled {
+		p.Use(bridge.New(logger).Middleware())
+		logger.Infof("Protocol version bridging enabled")
+	}
+
+	// Tool rewriting comes first, so that all other settings and the audit log
+	// refer to upstream tool names
+	if cfg.ToolRewrite.Enabled() {
+		p.Use(toolrewrite.New(cfg.ToolRewrite, logger).Middleware())
+		logger.Infof("Tool rewriting enabled (prefix '%s', %d tool overrides)", cfg.ToolRewrite.Prefix, len(cfg.ToolRewrite.Tools))
+	}
+
+	if cfg.Audit.File != "" {
+		auditLog, err := audit.Open(cfg.Audit, cfg.SQLPolicy.Tools, logger)
+		if err != nil {
+			logger.Fatalf("Failed to start SQL audit: %v", err)
+		}
+		p.Use(auditLog.Middleware())
+		finishers = append(finishers, func() { auditLog.Close() })
+		logger.Infof("SQL audit log: %s", cfg.Audit.File)
+	}
+
+	// Endpoint policies go with the global ones, inside the audit log so that
+	// their denials are recorded too
+	p.MarkPolicies()
+
+	if len(cfg.ToolFilter.Rules) > 0 {
+		p.Use(toolfilter.New(cfg.ToolFilter, logger).Middleware())
+		logger.Infof("Tool filtering configured with %d rules", len(cfg.ToolFilter.Rules))
+	}
+
+	if cfg.SQLPolicy.Enabled() {
+		p.Use(sqlpolicy.New(cfg.SQLPolicy, logger).Middleware())
+		logger.Infof("SQL policy enabled (read-only: %v, %d ACL rules)", cfg.SQLPolicy.ReadOnly, len(cfg.SQLPolicy.ACL.Rules))
+	}
 
-	scanner := bufio.NewScanner(mcpOut)
-	for scanner.Scan() {
-		line := scanner.Text()
-		logger.TrafficOut(line)
-		fmt.Println(line)
+	// Users confirm calls the SQL policy allowed before approvers see them
+	if cfg.Confirmation.Enabled() {
+		p.Use(confirm.New(cfg.Confirmation, cfg.SQLPolicy.Tools, logger).Middleware())
+		logger.Infof("Confirmation through elicitation required for %v statements (timeout %s)", cfg.Confirmation.Classes, cfg.Confirmation.Timeout)
 	}
 
-	cmd.Wait()
+	// Calls are held for approval once the SQL policy has allowed them
+	if cfg.Approval.Enabled() {
+		gate := approval.New(cfg.Approval, cfg.SQLPolicy.Tools, logger)
+		p.Use(gate.Middleware())
+		gate.Register(adminServer)
+		logger.Infof("Approval required for %v statements (timeout %s)", cfg.Approval.Classes, cfg.Approval.Timeout)
+	}
+
+	// The cache sits inside the SQL policy, so that every call is still checked,
+	// and outside limits and masking, so that it holds results as returned
+	if cfg.Cache.Enabled() {
+		responseCache := cache.New(cfg.Cache, cfg.SQLPolicy.Tools, logger)
+		p.Use(responseCache.Middleware())
+		if adminServer != nil {
+			responseCache.Register(adminServer)
+		}
+		logger.Infof("Response cache enabled for %d tools (max-entries %d, scope %s)", len(cfg.Cache.Tools), cfg.Cache.MaxEntries, cfg.Cache.Scope)
+	}
+
+	// Coalescing sits inside the cache, so that only cache misses wait for a
+	// call in progress
+	if len(cfg.Coalesce.Tools) > 0 {
+		p.Use(coalesce.New(cfg.Coalesce, cfg.SQLPolicy.Tools, logger).Middleware())
+		logger.Infof("Coalescing of identical concurrent calls enabled for %d tool patterns", len(cfg.Coalesce.Tools))
+	}
+
+	if cfg.Limits.Enabled() {
+		p.Use(limits.New(cfg.Limits, logger).Middleware())
+		logger.Infof("Result limits: max-bytes %d, max-rows %d, max-items %d (action: %s)",
+			cfg.Limits.MaxBytes, cfg.Limits.MaxRows, cfg.Limits.MaxItems, cfg.Limits.Action)
+	}
+
+	// Masking runs inside the limits, so that results are cut down after masking
+	if len(cfg.Masking.Rules) > 0 {
+		p.Use(masking.New(cfg.Masking, logger).Middleware())
+		logger.Infof("Result masking configured with %d rules", len(cfg.Masking.Rules))
+	}
+
+	if len(cfg.Chaos.Rules) > 0 {
+		injector := chaos.New(cfg.Chaos, logger)
+		p.Use(injector.Middleware())
+		if adminServer != nil {
+			injector.Register(adminServer)
+		}
+		logger.Infof("Fault injection configured with %d rules (enabled: %t)", len(cfg.Chaos.Rules), cfg.Chaos.Enabled)
+	}
+
+	// Routing takes the place of the upstream for routed calls, so it comes last
+	if len(cfg.Routing.Routes) > 0 {
+		router := route.New(cfg.Routing, logger)
+		router.Start()
+		p.Use(router.Middleware())
+		finishers = append(finishers, func() { router.Close() })
+		logger.Infof("Routing of tool calls by %v configured with %d routes", cfg.Routing.Arguments, len(cfg.Routing.Routes))
+	}
+
+	// Reads that no route claimed go to the replica
+	if cfg.RWSplit.Enabled() {
+		splitter := rwsplit.New(cfg.RWSplit, cfg.SQLPolicy.Tools, logger)
+		p.Use(splitter.Middleware())
+		splitter.Start()
+		finishers = append(finishers, func() { splitter.Close() })
+		replica := cfg.RWSplit.Replica.URL
+		if replica == "" {
+			replica = cfg.RWSplit.Replica.ExePath
+		}
+		logger.Infof("Read/write split enabled: reads go to the replica at %s", replica)
+	}
+
+	if adminServer != nil {
+		if err := adminServer.Start(); err != nil {
+			logger.Fatalf("Failed to start admin interface: %v", err)
+		}
+	}
+	return p, finish
 }
 
-func runHTTPProxy(listenPort, xferPort int, logger *logging.Logger) {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		logger.HTTPIn(r.Method, r.URL.String())
-		// Read request body
-		body, _ := io.ReadAll(r.Body)
-		logger.HTTPInBody(string(body))
+// runApprovals runs the approvals command against the admin interface of a
+// running proxy, found on --admin-port or else in the configuration. The admin
+// token comes from the configuration or MCP_PROXY_ADMIN_TOKEN.
+func runApprovals(flags *config.Flags) {
+	port := *flags.AdminPort
+	token := os.Getenv("MCP_PROXY_ADMIN_TOKEN")
+	if cfg, err := config.LoadConfig(flags); err == nil {
+		if port == 0 {
+			port = cfg.Admin.Port
+		}
+		token = cfg.Admin.Token
+	}
+	if port == 0 {
+		log.Fatalf("No admin port: pass --admin-port or set admin.port in the configuration\n%s", approval.CLIUsage)
+	}
+	if err := approval.RunCLI(port, token, flags.Args[1:], os.Stdout); err != nil {
+		log.Fatalf("approvals: %v", err)
+	}
+}
+
+func runStdioProxy(cfg *config.Config, logger *logging.Logger) {
+	p, finish := newProxy(cfg, logger)
+	defer finish()
+	server := proxy.NewStdioServer(p, os.Stdout)
 
-		// Forward to mcp_sqlpp HTTP server
-		url := fmt.Sprintf("http://localhost:%d%s", xferPort, r.URL.Path)
-		req, err := http.NewRequest(r.Method, url, r.Body)
+	var up upstream.Upstream
+	var done <-chan struct{}
+	if len(cfg.Upstreams) > 0 {
+		agg, err := aggregate.Start(cfg.Upstreams, server.HandleUpstreamMessage, logger)
 		if err != nil {
-			logger.HTTPError(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			logger.Fatalf("Failed to start upstreams: %v", err)
 		}
-		req.Header = r.Header
+		up, done = agg, agg.Done()
+	} else {
+		stdio, err := upstream.StartStdio(upstream.StdioOptions{
+			ExePath: cfg.ExePath,
+			Handler: server.HandleUpstreamMessage,
+			Logger:  logger,
+		})
+		if err != nil {
+			logger.Fatalf("Failed to start mcp_sqlpp at '%s': %v", cfg.ExePath, err)
+		}
+		up, done = stdio, stdio.Done()
+	}
+	defer up.Close()
+
+	session := proxy.NewSession("stdio", up)
+	if u, err := user.Current(); err == nil {
+		session.SetPrincipal(u.Username)
+	}
+	if err := server.Serve(os.Stdin, session, done); err != nil {
+		logger.Errorf("Failed to read from client: %v", err)
+	}
+}
+
+func runHTTPProxy(cfg *config.Config, logger *logging.Logger) {
+	p, finish := newProxy(cfg, logger)
+	defer finish()
+	var events *eventstore.Store
+	if cfg.Resumption.Enabled {
+		events = eventstore.New(cfg.Resumption, logger)
+		events.Start()
+		defer events.Close()
+		logger.Infof("Keeping up to %d bytes of events per stream for clients that reconnect (retention %s)",
+			cfg.Resumption.MaxBytes, cfg.Resumption.Retention)
+	}
+	if len(cfg.Upstreams) > 0 {
+		// The proxy holds the sessions, each with its own set of upstreams
+		server := proxy.NewSessionServer(p, func(handler upstream.MessageHandler) (upstream.Upstream, error) {
+			return aggregate.Start(cfg.Upstreams, handler, logger)
+		})
+		server.ResumeStreams(events)
+		defer expireSessions(server, cfg.Sessions, logger)()
+		http.Handle("/", server)
+	} else if len(cfg.Endpoints) > 0 {
+		hosts := vhost.New(cfg.Endpoints, cfg.Sessions, p, logger)
+		defer hosts.Close()
+		http.Handle("/", hosts)
+		logger.Infof("Serving %d endpoints under path prefixes", len(cfg.Endpoints))
+	} else if cfg.Children.Enabled {
+		// The proxy holds the sessions, each on a child from the warm pool
+		children := childpool.New(cfg.Children, cfg.ExePath, logger)
+		children.Start()
+		defer children.Close()
+		server := proxy.NewSessionServer(p, children.Connect)
+		server.ResumeStreams(events)
+		defer expireSessions(server, cfg.Sessions, logger)()
+		http.Handle("/", server)
+		logger.Infof("Serving sessions from children of %s (min-idle %d, max-idle %d, max-sessions %d)",
+			cfg.ExePath, cfg.Children.MinIdle, cfg.Children.MaxIdle, cfg.Children.MaxSessions)
+	} else if cfg.Pool.Enabled() {
+		pool := balance.New(cfg.Pool, logger)
+		pool.Start()
+		defer pool.Close()
+		server := proxy.NewBalancedHTTPServer(p, pool)
+		server.ResumeStreams(events)
+		defer trackSessions(server, cfg.Sessions, logger)()
+		http.Handle("/", server)
+		logger.Infof("Balancing across %d upstreams (strategy %s, affinity %t)", len(cfg.Pool.Targets), cfg.Pool.Strategy, cfg.Pool.Affinity)
+	} else {
+		server := proxy.NewHTTPServer(p, fmt.Sprintf("http://localhost:%d", cfg.XferPort))
+		server.ResumeStreams(events)
+		defer trackSessions(server, cfg.Sessions, logger)()
+		http.Handle("/", server)
+	}
+
+	logger.Infof("Listening on http://localhost:%d", cfg.Port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), nil); err != nil {
+		logger.Fatalf("HTTP server failed: %v", err)
+	}
+}
+
+// trackSessions applies the sessions settings to a server relaying sessions,
+// returning the function that closes it
+func trackSessions(server *proxy.HTTPServer, sessions config.SessionsConfig, logger *logging.Logger) func() {
+	server.TrackSessions(proxy.SessionOptions{IdleTimeout: sessions.IdleTimeout, LogDir: sessions.LogDir})
+	if sessions.IdleTimeout > 0 {
+		logger.Infof("Ending relayed sessions idle for %s", sessions.IdleTimeout)
+	}
+	if sessions.LogDir != "" {
+		logger.Infof("Logging each relayed session to its own file in %s", sessions.LogDir)
+	}
+	return func() { server.Close() }
+}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+// expireSessions applies the sessions idle timeout to a server holding
+// sessions itself and returns a function that ends them
+func expireSessions(server *proxy.SessionServer, sessions config.SessionsConfig, logger *logging.Logger) func() {
+	server.ExpireIdle(sessions.IdleTimeout)
+	if sessions.IdleTimeout > 0 {
+		logger.Infof("Ending sessions idle for %s", sessions.IdleTimeout)
+	}
+	return func() { server.Close() }
+}
+
+func runReplayServer(replayCfg config.ReplayConfig, logger *logging.Logger) {
+	rec, err := replay.Load(replayCfg.File)
+	if err != nil {
+		logger.Fatalf("Failed to load recording: %v", err)
+	}
+	logger.Infof("Loaded %d recorded exchanges (match: %s, timing: %s)", len(rec.Exchanges), replayCfg.Match, replayCfg.Timing)
+
+	server, err := replay.NewServer(rec, replay.ServerOptions{
+		Match:  replay.MatchMode(replayCfg.Match),
+		Timing: replay.Timing(replayCfg.Timing),
+		Delay:  replayCfg.Delay,
+	}, logger)
+	if err != nil {
+		logger.Fatalf("Failed to create replay server: %v", err)
+	}
+
+	if err := server.Serve(os.Stdin, os.Stdout); err != nil {
+		logger.Errorf("Replay server stopped: %v", err)
+	}
+}
+
+// runReplayClient replays a recording against a fresh upstream and writes the
+// diff report. It returns false when any response differed from the recording.
+func runReplayClient(cfg *config.Config, logger *logging.Logger) bool {
+	rec, err := replay.Load(cfg.Replay.File)
+	if err != nil {
+		logger.Fatalf("Failed to load recording: %v", err)
+	}
+
+	rules, err := replay.CompileIgnoreRules(cfg.Replay.Ignore, cfg.Replay.IgnorePatterns)
+	if err != nil {
+		logger.Fatalf("Invalid ignore rules: %v", err)
+	}
+	client := replay.NewClient(replay.ClientOptions{Ignore: rules, Timeout: cfg.Replay.Timeout}, logger)
+
+	var up upstream.Upstream
+	if cfg.Replay.URL != "" {
+		logger.Infof("Replaying %d recorded exchanges against %s", len(rec.Exchanges), cfg.Replay.URL)
+		up = upstream.NewHTTP(cfg.Replay.URL, client.HandleServerMessage)
+	} else {
+		logger.Infof("Replaying %d recorded exchanges against %s", len(rec.Exchanges), cfg.ExePath)
+		stdio, err := upstream.StartStdio(upstream.StdioOptions{
+			ExePath: cfg.ExePath,
+			Handler: client.HandleServerMessage,
+			Logger:  logger,
+		})
 		if err != nil {
-			logger.HTTPError(err)
-			w.WriteHeader(http.StatusBadGateway)
-			return
+			logger.Fatalf("Failed to start mcp_sqlpp at '%s': %v", cfg.ExePath, err)
 		}
-		defer resp.Body.Close()
+		up = stdio
+	}
+	defer up.Close()
 
-		respBody, _ := io.ReadAll(resp.Body)
-		logger.HTTPOut(resp.StatusCode, string(respBody))
+	report := client.Run(context.Background(), up, rec)
+	logger.Infof("Replay finished: %d requests, %d matched, %d mismatched, %d failed",
+		report.Total, report.Matched, report.Mismatched, report.Failed)
 
-		for k, v := range resp.Header {
-			for _, vv := range v {
-				w.Header().Add(k, vv)
-			}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		logger.Fatalf("Failed to encode replay report: %v", err)
+	}
+	if cfg.Replay.Report != "" {
+		if err := os.WriteFile(cfg.Replay.Report, append(data, '\n'), 0644); err != nil {
+			logger.Fatalf("Failed to write replay report: %v", err)
 		}
-		w.WriteHeader(resp.StatusCode)
-		w.Write(respBody)
-	})
+	} else {
+		fmt.Println(string(data))
+	}
 
-	logger.Infof("Listening on http://localhost:%d", listenPort)
-	http.ListenAndServe(fmt.Sprintf(":%d", listenPort), nil)
+	return report.OK()
 }